@@ -0,0 +1,237 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrUnsafePath is returned for archive entries that would land
+	// outside the extraction directory.
+	ErrUnsafePath = errors.New("archive entry escapes the target directory")
+	// ErrTooLarge is returned when an archive expands beyond its limit.
+	ErrTooLarge = errors.New("archive is too large")
+	// ErrUnknownFormat is returned by Extract for anything but zip and
+	// gzip compressed tar archives.
+	ErrUnknownFormat = errors.New("archive is neither a zip nor a tar.gz file")
+	// ErrCorrupt is returned for archives that cannot be read.
+	ErrCorrupt = errors.New("archive is corrupt")
+)
+
+// Extract extracts a zip or gzip compressed tar archive of size bytes,
+// telling them apart by their magic bytes.
+func Extract(r io.ReaderAt, size int64, dir string, maxSize int64) error {
+	magic := make([]byte, 4)
+	n, _ := r.ReadAt(magic, 0)
+	switch {
+	case n >= 4 && (string(magic) == "PK\x03\x04" || string(magic) == "PK\x05\x06"):
+		return ExtractZip(r, size, dir, maxSize)
+	case n >= 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		return ExtractTarGz(io.NewSectionReader(r, 0, size), dir, maxSize)
+	}
+	return ErrUnknownFormat
+}
+
+// Ignored reports whether a slash separated relative path has an element
+// matching one of the path.Match patterns.
+func Ignored(rel string, patterns []string) bool {
+	for _, elem := range strings.Split(rel, "/") {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, elem); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// walk calls fn for every file, directory and symlink below root that is
+// not ignored, with its slash separated path relative to root.
+func walk(root *os.Root, ignore []string, fn func(rel string, info fs.FileInfo) error) error {
+	return fs.WalkDir(root.FS(), ".", func(rel string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && rel != "." {
+			// Removed while walking a workspace that is in use.
+			return nil
+		}
+		if err != nil || rel == "." {
+			return err
+		}
+		if Ignored(rel, ignore) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() && info.Mode()&fs.ModeSymlink == 0 {
+			// Sockets, pipes and devices cannot be archived.
+			return nil
+		}
+		return fn(rel, info)
+	})
+}
+
+// target resolves an archive entry name to a path relative to the
+// extraction directory, rejecting absolute names and names that climb out
+// of it.
+func target(name string) (string, error) {
+	name = strings.TrimPrefix(filepath.ToSlash(name), "./")
+	clean := path.Clean(name)
+	if name == "" || path.IsAbs(name) || clean == ".." || strings.HasPrefix(clean, "../") || strings.Contains(name, "\x00") {
+		return "", fmt.Errorf("%w: %s", ErrUnsafePath, name)
+	}
+	return filepath.FromSlash(clean), nil
+}
+
+// checkLink rejects symlinks that point outside the extraction directory,
+// so later entries cannot be written through them.
+func checkLink(at, link string) error {
+	dest := filepath.Clean(filepath.Join(filepath.Dir(at), link))
+	if filepath.IsAbs(link) || dest == ".." || strings.HasPrefix(dest, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%w: symlink %s -> %s", ErrUnsafePath, at, link)
+	}
+	return nil
+}
+
+// checkParents rejects a target below a symlink, which may have been in
+// the directory before extraction started and point anywhere. The root
+// keeps a symlink swapped in later from leading outside; this only turns
+// the common case into ErrUnsafePath.
+func checkParents(root *os.Root, at string) error {
+	dir := filepath.Dir(at)
+	if dir == "." {
+		return nil
+	}
+	cur := ""
+	for _, elem := range strings.Split(dir, string(filepath.Separator)) {
+		cur = filepath.Join(cur, elem)
+		info, err := root.Lstat(cur)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("%w: %s is below a symlink", ErrUnsafePath, at)
+		}
+	}
+	return nil
+}
+
+// extractor writes archive entries below root, rejecting any that would
+// land outside it, and fails once the files add up to more than left
+// bytes. Every path is resolved through root, so nothing changed in the
+// directory meanwhile can redirect a write outside of it.
+type extractor struct {
+	root *os.Root
+	left int64
+}
+
+// extract opens dir as the root of an extractor and calls fn with it.
+func extract(dir string, maxSize int64, fn func(e *extractor) error) error {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	return fn(&extractor{root: root, left: maxSize})
+}
+
+// path resolves an entry name to the path it is written to, relative to
+// the root.
+func (e *extractor) path(name string) (string, error) {
+	at, err := target(name)
+	if err != nil {
+		return "", err
+	}
+	if err := checkParents(e.root, at); err != nil {
+		return "", err
+	}
+	return at, nil
+}
+
+func (e *extractor) mkdir(name string, mode fs.FileMode) error {
+	at, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	return e.root.MkdirAll(at, mode.Perm()|0700)
+}
+
+func (e *extractor) file(name string, mode fs.FileMode, r io.Reader) error {
+	at, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	if err := e.root.MkdirAll(filepath.Dir(at), 0755); err != nil {
+		return err
+	}
+	// Replace rather than write through whatever is there, which may be a
+	// symlink.
+	e.root.Remove(at)
+	f, err := e.root.OpenFile(at, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, io.LimitReader(r, e.left+1))
+	e.left -= n
+	if err == nil && e.left < 0 {
+		err = ErrTooLarge
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (e *extractor) symlink(name, link string) error {
+	at, err := e.path(name)
+	if err != nil {
+		return err
+	}
+	if err := checkLink(at, link); err != nil {
+		return err
+	}
+	if err := e.root.MkdirAll(filepath.Dir(at), 0755); err != nil {
+		return err
+	}
+	e.root.Remove(at)
+	return e.root.Symlink(link, at)
+}
+
+func unsupported(name string, kind any) error {
+	return fmt.Errorf("%w: %s has unsupported type %v", ErrUnsafePath, name, kind)
+}
+
+// Clear removes everything inside dir but dir itself.
+func Clear(dir string) error {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	entries, err := fs.ReadDir(root.FS(), ".")
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := root.RemoveAll(e.Name()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
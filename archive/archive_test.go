@@ -0,0 +1,268 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// tarGz builds an archive out of raw headers, as a hostile client would.
+func tarGz(t *testing.T, hdrs ...*tar.Header) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, hdr := range hdrs {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			tw.Write(bytes.Repeat([]byte("x"), int(hdr.Size)))
+		}
+	}
+	tw.Close()
+	gz.Close()
+	return &buf
+}
+
+func TestTarGzRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"index.js":              "console.log(1)\n",
+		"src/lib/util.js":       "export {}\n",
+		"node_modules/a/pkg.js": "ignored\n",
+	})
+	if err := os.Symlink("src/lib", filepath.Join(src, "lib")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "index.js"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteTarGz(&buf, src, []string{"node_modules"}); err != nil {
+		t.Fatalf("WriteTarGz: %v", err)
+	}
+	dst := t.TempDir()
+	if err := ExtractTarGz(&buf, dst, 1<<20); err != nil {
+		t.Fatalf("ExtractTarGz: %v", err)
+	}
+
+	for name, want := range map[string]string{"index.js": "console.log(1)\n", "lib/util.js": "export {}\n"} {
+		got, err := os.ReadFile(filepath.Join(dst, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if link, err := os.Readlink(filepath.Join(dst, "lib")); err != nil || link != "src/lib" {
+		t.Errorf("lib links to %q, %v, want src/lib", link, err)
+	}
+	if info, err := os.Stat(filepath.Join(dst, "index.js")); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("index.js mode = %v, %v, want 0755", info.Mode(), err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "node_modules")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ignored node_modules was archived: %v", err)
+	}
+}
+
+func TestExtractRejectsUnsafeEntries(t *testing.T) {
+	tests := []struct {
+		name string
+		hdrs []*tar.Header
+	}{
+		{"parent", []*tar.Header{{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 1}}},
+		{"nested parent", []*tar.Header{{Name: "a/../../evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 1}}},
+		{"absolute", []*tar.Header{{Name: "/tmp/evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 1}}},
+		{"escaping symlink", []*tar.Header{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../.."}}},
+		{"absolute symlink", []*tar.Header{{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"}}},
+		{"hard link", []*tar.Header{{Name: "link", Typeflag: tar.TypeLink, Linkname: "/etc/passwd"}}},
+		{"device", []*tar.Header{{Name: "null", Typeflag: tar.TypeChar, Mode: 0666}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dst := filepath.Join(root, "dst")
+			os.Mkdir(dst, 0755)
+			err := ExtractTarGz(tarGz(t, tt.hdrs...), dst, 1<<20)
+			if !errors.Is(err, ErrUnsafePath) {
+				t.Fatalf("ExtractTarGz = %v, want ErrUnsafePath", err)
+			}
+			if _, err := os.Stat(filepath.Join(root, "evil")); err == nil {
+				t.Fatal("file written outside the target directory")
+			}
+		})
+	}
+}
+
+func TestExtractDoesNotFollowExistingSymlinks(t *testing.T) {
+	root := t.TempDir()
+	outside := filepath.Join(root, "outside")
+	dst := filepath.Join(root, "dst")
+	os.Mkdir(outside, 0755)
+	os.Mkdir(dst, 0755)
+	if err := os.Symlink(outside, filepath.Join(dst, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	err := ExtractTarGz(tarGz(t, &tar.Header{Name: "escape/evil", Typeflag: tar.TypeReg, Mode: 0644, Size: 1}), dst, 1<<20)
+	if !errors.Is(err, ErrUnsafePath) {
+		t.Fatalf("ExtractTarGz = %v, want ErrUnsafePath", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "evil")); err == nil {
+		t.Fatal("file written through a symlink")
+	}
+}
+
+func TestExtractRacingSymlinkSwap(t *testing.T) {
+	root := t.TempDir()
+	outside := filepath.Join(root, "outside")
+	dst := filepath.Join(root, "dst")
+	os.Mkdir(outside, 0755)
+	os.Mkdir(dst, 0755)
+	var hdrs []*tar.Header
+	for i := 0; i < 200; i++ {
+		hdrs = append(hdrs, &tar.Header{Name: fmt.Sprintf("a/%d", i), Typeflag: tar.TypeReg, Mode: 0644, Size: 1})
+	}
+	buf := tarGz(t, hdrs...)
+
+	// Something running in the workspace keeps turning a into a symlink
+	// out of it while the archive is extracted.
+	done := make(chan struct{})
+	swapped := make(chan struct{})
+	go func() {
+		defer close(swapped)
+		a := filepath.Join(dst, "a")
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			os.RemoveAll(a)
+			os.Symlink(outside, a)
+		}
+	}()
+	ExtractTarGz(buf, dst, 1<<20)
+	close(done)
+	<-swapped
+
+	if entries, _ := os.ReadDir(outside); len(entries) != 0 {
+		t.Fatalf("%d files written through a symlink", len(entries))
+	}
+}
+
+func TestExtractEnforcesMaxSize(t *testing.T) {
+	buf := tarGz(t,
+		&tar.Header{Name: "a", Typeflag: tar.TypeReg, Mode: 0644, Size: 600},
+		&tar.Header{Name: "b", Typeflag: tar.TypeReg, Mode: 0644, Size: 600},
+	)
+	if err := ExtractTarGz(buf, t.TempDir(), 1000); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("ExtractTarGz = %v, want ErrTooLarge", err)
+	}
+}
+
+func TestClear(t *testing.T) {
+	dir := t.TempDir()
+	writeTree(t, dir, map[string]string{"a.txt": "a", "sub/b.txt": "b"})
+	if err := Clear(dir); err != nil {
+		t.Fatalf("Clear: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("after Clear: %v entries, %v", len(entries), err)
+	}
+}
+
+func TestZipRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	writeTree(t, src, map[string]string{
+		"README.md":    "# demo\n",
+		"src/main.go":  "package main\n",
+		".venv/bin/py": "ignored\n",
+	})
+	if err := os.Symlink("README.md", filepath.Join(src, "readme")); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteZip(&buf, src, []string{".venv"}); err != nil {
+		t.Fatalf("WriteZip: %v", err)
+	}
+	dst := t.TempDir()
+	if err := Extract(bytes.NewReader(buf.Bytes()), int64(buf.Len()), dst, 1<<20); err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+
+	if got, err := os.ReadFile(filepath.Join(dst, "src", "main.go")); err != nil || string(got) != "package main\n" {
+		t.Errorf("src/main.go = %q, %v", got, err)
+	}
+	if link, err := os.Readlink(filepath.Join(dst, "readme")); err != nil || link != "README.md" {
+		t.Errorf("readme links to %q, %v, want README.md", link, err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, ".venv")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("ignored .venv was archived: %v", err)
+	}
+}
+
+func TestExtractZipRejectsUnsafeEntries(t *testing.T) {
+	symlink := &zip.FileHeader{Name: "link"}
+	symlink.SetMode(os.ModeSymlink | 0777)
+	tests := []struct {
+		name    string
+		hdr     *zip.FileHeader
+		content string
+	}{
+		{"parent", &zip.FileHeader{Name: "../evil"}, "x"},
+		{"absolute", &zip.FileHeader{Name: "/tmp/evil"}, "x"},
+		{"escaping symlink", symlink, "../../etc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			fw, err := zw.CreateHeader(tt.hdr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fw.Write([]byte(tt.content))
+			zw.Close()
+
+			root := t.TempDir()
+			dst := filepath.Join(root, "dst")
+			os.Mkdir(dst, 0755)
+			err = ExtractZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()), dst, 1<<20)
+			if !errors.Is(err, ErrUnsafePath) {
+				t.Fatalf("ExtractZip = %v, want ErrUnsafePath", err)
+			}
+			if _, err := os.Stat(filepath.Join(root, "evil")); err == nil {
+				t.Fatal("file written outside the target directory")
+			}
+		})
+	}
+}
+
+func TestExtractUnknownFormat(t *testing.T) {
+	data := []byte("just some text")
+	if err := Extract(bytes.NewReader(data), int64(len(data)), t.TempDir(), 1<<20); !errors.Is(err, ErrUnknownFormat) {
+		t.Fatalf("Extract = %v, want ErrUnknownFormat", err)
+	}
+}
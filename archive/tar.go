@@ -0,0 +1,100 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// WriteTarGz writes a gzip compressed tar archive of the tree below dir to
+// w, leaving out ignored paths.
+func WriteTarGz(w io.Writer, dir string, ignore []string) error {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err = walk(root, ignore, func(rel string, info fs.FileInfo) error {
+		link := ""
+		if info.Mode()&fs.ModeSymlink != 0 {
+			var err error
+			if link, err = root.Readlink(filepath.FromSlash(rel)); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		// Ownership means nothing outside the container it came from.
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := root.Open(filepath.FromSlash(rel))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.CopyN(tw, f, hdr.Size)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// ExtractTarGz extracts a gzip compressed tar archive into dir. Entries
+// escaping dir, links pointing outside it and anything but files,
+// directories and symlinks are rejected; the files may expand to at most
+// maxSize bytes.
+func ExtractTarGz(r io.Reader, dir string, maxSize int64) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	return extract(dir, maxSize, func(e *extractor) error {
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrCorrupt, err)
+			}
+			mode := fs.FileMode(hdr.Mode)
+			switch hdr.Typeflag {
+			case tar.TypeDir:
+				err = e.mkdir(hdr.Name, mode)
+			case tar.TypeReg:
+				err = e.file(hdr.Name, mode, tr)
+			case tar.TypeSymlink:
+				err = e.symlink(hdr.Name, hdr.Linkname)
+			default:
+				err = unsupported(hdr.Name, string(hdr.Typeflag))
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
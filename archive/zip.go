@@ -0,0 +1,111 @@
+package archive
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// maxLinkTarget bounds the target of a symlink stored in a zip archive,
+// where it is the content of the entry.
+const maxLinkTarget = 4096
+
+// WriteZip writes a zip archive of the tree below dir to w, leaving out
+// ignored paths. Symlinks are stored as links, the way Info-ZIP does.
+func WriteZip(w io.Writer, dir string, ignore []string) error {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	zw := zip.NewWriter(w)
+	err = walk(root, ignore, func(rel string, info fs.FileInfo) error {
+		hdr, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		if info.IsDir() {
+			hdr.Name += "/"
+		} else {
+			hdr.Method = zip.Deflate
+		}
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.Mode()&fs.ModeSymlink != 0:
+			link, err := root.Readlink(filepath.FromSlash(rel))
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(fw, link)
+			return err
+		case info.Mode().IsRegular():
+			f, err := root.Open(filepath.FromSlash(rel))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(fw, f)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// ExtractZip extracts the zip archive in r, of size bytes, into dir with
+// the same checks as ExtractTarGz.
+func ExtractZip(r io.ReaderAt, size int64, dir string, maxSize int64) error {
+	zr, err := zip.NewReader(r, size)
+	if errors.Is(err, zip.ErrInsecurePath) {
+		return fmt.Errorf("%w: %w", ErrUnsafePath, err)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+	return extract(dir, maxSize, func(e *extractor) error {
+		for _, f := range zr.File {
+			if err := extractZipFile(e, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func extractZipFile(e *extractor, f *zip.File) error {
+	mode := f.Mode()
+	if mode.IsDir() {
+		return e.mkdir(f.Name, mode)
+	}
+	if mode&fs.ModeType != 0 && mode&fs.ModeSymlink == 0 {
+		return unsupported(f.Name, mode.Type())
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCorrupt, err)
+	}
+	defer rc.Close()
+	if mode&fs.ModeSymlink == 0 {
+		return e.file(f.Name, mode, rc)
+	}
+	link, err := io.ReadAll(io.LimitReader(rc, maxLinkTarget+1))
+	if err != nil {
+		return err
+	}
+	if len(link) > maxLinkTarget {
+		return fmt.Errorf("%w: symlink %s is too long", ErrUnsafePath, f.Name)
+	}
+	return e.symlink(f.Name, string(link))
+}
@@ -0,0 +1,135 @@
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// randomOp returns a random operation on a document of n code points.
+func randomOp(r *rand.Rand, n int) Operation {
+	var o Operation
+	for left := n; left > 0; {
+		k := 1 + r.Intn(left)
+		switch r.Intn(3) {
+		case 0:
+			o = o.Retain(k)
+		case 1:
+			o = o.Delete(k)
+		default:
+			o = o.Insert([]string{"a", "bé", "日本", "\n"}[r.Intn(4)])
+			continue
+		}
+		left -= k
+	}
+	if r.Intn(2) == 0 {
+		o = o.Insert("z")
+	}
+	return o
+}
+
+func TestOperationJSON(t *testing.T) {
+	o := Operation{}.Retain(2).Delete(1).Insert("x").Retain(3)
+	data, err := json.Marshal(o)
+	if err != nil || string(data) != `[2,"x",-1,3]` {
+		t.Fatalf("Marshal = %s, %v", data, err)
+	}
+	var back Operation
+	if err := json.Unmarshal(data, &back); err != nil || len(back) != 4 || back[1].Insert != "x" || back[2].Delete != 1 {
+		t.Fatalf("Unmarshal = %+v, %v", back, err)
+	}
+	for _, in := range []string{`[0]`, `[""]`, `[1.5]`, `[true]`} {
+		if err := json.Unmarshal([]byte(in), &back); !errors.Is(err, ErrInvalidOp) {
+			t.Errorf("Unmarshal(%s) = %v, want ErrInvalidOp", in, err)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	o := Operation{}.Retain(1).Insert("日").Delete(2).Retain(1)
+	out, err := o.Apply([]rune("abcd"))
+	if err != nil || string(out) != "a日d" {
+		t.Errorf("Apply = %q, %v", string(out), err)
+	}
+	if _, err := o.Apply([]rune("abc")); !errors.Is(err, ErrBaseLength) {
+		t.Errorf("Apply to a shorter document = %v, want ErrBaseLength", err)
+	}
+}
+
+func TestTransformConverges(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		doc := []rune("héllo wörld")[:r.Intn(12)]
+		a, b := randomOp(r, len(doc)), randomOp(r, len(doc))
+		a1, b1, err := Transform(a, b)
+		if err != nil {
+			t.Fatalf("Transform(%v, %v): %v", a, b, err)
+		}
+		ab, _ := a.Apply(doc)
+		ab, err1 := b1.Apply(ab)
+		ba, _ := b.Apply(doc)
+		ba, err2 := a1.Apply(ba)
+		if err1 != nil || err2 != nil || string(ab) != string(ba) {
+			t.Fatalf("%q with %v and %v: %q (%v) != %q (%v)", string(doc), a, b, string(ab), err1, string(ba), err2)
+		}
+	}
+
+	// Text of the first operation goes first.
+	a := Operation{}.Retain(1).Insert("a")
+	b := Operation{}.Retain(1).Insert("b")
+	_, b1, _ := Transform(a, b)
+	doc, _ := a.Apply([]rune("x"))
+	doc, _ = b1.Apply(doc)
+	if string(doc) != "xab" {
+		t.Errorf("tie = %q, want xab", string(doc))
+	}
+	if _, _, err := Transform(a, Operation{}.Retain(2)); !errors.Is(err, ErrBaseLength) {
+		t.Errorf("Transform of different documents = %v, want ErrBaseLength", err)
+	}
+}
+
+func TestTransformIndex(t *testing.T) {
+	o := Operation{}.Retain(2).Insert("xyz").Retain(2).Delete(3).Retain(1)
+	for _, tt := range []struct{ in, want int }{{0, 0}, {2, 5}, {3, 6}, {4, 7}, {5, 7}, {7, 7}, {8, 8}} {
+		if got := TransformIndex(tt.in, o); got != tt.want {
+			t.Errorf("TransformIndex(%d) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestDocument(t *testing.T) {
+	d := NewDocument("hello", 2)
+	// Two clients edit revision 0 at once.
+	if _, err := d.Apply(0, Operation{}.Retain(5).Insert(" world")); err != nil {
+		t.Fatal(err)
+	}
+	op, err := d.Apply(0, Operation{}.Delete(1).Insert("J").Retain(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Text() != "Jello world" || d.Revision() != 2 || op.BaseLen() != 11 {
+		t.Errorf("document = %q at %d, applied %v", d.Text(), d.Revision(), op)
+	}
+	if i, err := d.TransformIndex(0, 5); err != nil || i != 11 {
+		t.Errorf("TransformIndex = %d, %v", i, err)
+	}
+	if _, err := d.Apply(3, Operation{}.Retain(11)); !errors.Is(err, ErrRevision) {
+		t.Errorf("Apply to a future revision = %v, want ErrRevision", err)
+	}
+	if _, err := d.Apply(2, Operation{}.Retain(3)); !errors.Is(err, ErrBaseLength) {
+		t.Errorf("Apply of a short operation = %v, want ErrBaseLength", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		if _, err := d.Apply(d.Revision(), Operation{}.Retain(d.Len()).Insert("!")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.Apply(0, Operation{}.Retain(5)); !errors.Is(err, ErrRevision) {
+		t.Errorf("Apply to a forgotten revision = %v, want ErrRevision", err)
+	}
+	if _, err := d.Apply(d.Revision()-2, Operation{}.Retain(d.Len()-2)); err != nil {
+		t.Errorf("Apply to a kept revision: %v", err)
+	}
+}
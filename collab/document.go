@@ -0,0 +1,88 @@
+package collab
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrRevision = errors.New("unknown revision")
+
+// Document is the authoritative copy of a document edited together. It
+// keeps the operations of recent revisions to transform late ones
+// against. A Document is not safe for concurrent use.
+type Document struct {
+	text []rune
+	// history holds the operations that turned revision first into the
+	// current one.
+	history []Operation
+	first   int
+	limit   int
+}
+
+// NewDocument returns a document at revision 0 that keeps at least the
+// operations of the last limit revisions.
+func NewDocument(text string, limit int) *Document {
+	return &Document{text: []rune(text), limit: max(limit, 1)}
+}
+
+func (d *Document) Text() string {
+	return string(d.text)
+}
+
+// Len is the length of the document in code points.
+func (d *Document) Len() int {
+	return len(d.text)
+}
+
+// Revision is the number of operations applied so far.
+func (d *Document) Revision() int {
+	return d.first + len(d.history)
+}
+
+// since returns the operations applied after revision.
+func (d *Document) since(revision int) ([]Operation, error) {
+	if revision < d.first || revision > d.Revision() {
+		return nil, fmt.Errorf("%w %d, the document is at %d and keeps edits since %d", ErrRevision, revision, d.Revision(), d.first)
+	}
+	return d.history[revision-d.first:], nil
+}
+
+// Apply applies op, based on revision, and returns it as applied to the
+// current revision, which it then becomes.
+func (d *Document) Apply(revision int, op Operation) (Operation, error) {
+	concurrent, err := d.since(revision)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range concurrent {
+		if op, _, err = Transform(op, c); err != nil {
+			return nil, err
+		}
+	}
+	text, err := op.Apply(d.text)
+	if err != nil {
+		return nil, err
+	}
+	d.text = text
+	d.history = append(d.history, op)
+	// History is trimmed in batches so it is not copied on every edit.
+	if len(d.history) >= 2*d.limit {
+		drop := len(d.history) - d.limit
+		d.history = append([]Operation(nil), d.history[drop:]...)
+		d.first += drop
+	}
+	return op, nil
+}
+
+// TransformIndex returns where offset i of revision is in the current
+// revision.
+func (d *Document) TransformIndex(revision, i int) (int, error) {
+	concurrent, err := d.since(revision)
+	if err != nil {
+		return 0, err
+	}
+	for _, c := range concurrent {
+		i = TransformIndex(i, c)
+	}
+	return i, nil
+}
@@ -0,0 +1,255 @@
+// Package collab lets several clients edit a plain text document at once
+// by operational transformation. Clients send operations based on the
+// revision they last saw; a Document transforms each against the
+// operations applied since then, so every client converges on the same
+// text once it has applied the operations in the order the Document did.
+//
+// Operations follow ot.js: a list of components that each retain, insert
+// or delete text, walking the whole document from start to end. Lengths
+// and offsets count Unicode code points.
+package collab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+var (
+	ErrBaseLength = errors.New("operation does not span the document")
+	ErrInvalidOp  = errors.New("invalid operation")
+)
+
+// Component is one step of an Operation; exactly one field is set.
+// Retain skips characters, Insert inserts text and Delete deletes
+// characters.
+type Component struct {
+	Retain int
+	Insert string
+	Delete int
+}
+
+// Operation is encoded as ot.js encodes it: a JSON array where a positive
+// number retains, a string inserts and a negative number deletes.
+type Operation []Component
+
+func (c Component) MarshalJSON() ([]byte, error) {
+	switch {
+	case c.Insert != "":
+		return json.Marshal(c.Insert)
+	case c.Delete > 0:
+		return json.Marshal(-c.Delete)
+	}
+	return json.Marshal(c.Retain)
+}
+
+func (c *Component) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case string:
+		if v == "" {
+			return fmt.Errorf("%w: empty insert", ErrInvalidOp)
+		}
+		*c = Component{Insert: v}
+	case float64:
+		n := int(v)
+		if float64(n) != v || n == 0 {
+			return fmt.Errorf("%w: component %s", ErrInvalidOp, data)
+		}
+		if n > 0 {
+			*c = Component{Retain: n}
+		} else {
+			*c = Component{Delete: -n}
+		}
+	default:
+		return fmt.Errorf("%w: component %s", ErrInvalidOp, data)
+	}
+	return nil
+}
+
+// BaseLen is the length of the documents o applies to.
+func (o Operation) BaseLen() int {
+	n := 0
+	for _, c := range o {
+		n += c.Retain + c.Delete
+	}
+	return n
+}
+
+// TargetLen is the length of the documents o produces.
+func (o Operation) TargetLen() int {
+	n := 0
+	for _, c := range o {
+		n += c.Retain + utf8.RuneCountInString(c.Insert)
+	}
+	return n
+}
+
+// IsNoop reports whether o leaves documents as they are.
+func (o Operation) IsNoop() bool {
+	for _, c := range o {
+		if c.Retain == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Retain, Insert and Delete append a component, merging it with the last
+// one where possible and keeping inserts before deletes, so equivalent
+// operations are built the same way.
+func (o Operation) Retain(n int) Operation {
+	if n <= 0 {
+		return o
+	}
+	if last := len(o) - 1; last >= 0 && o[last].Retain > 0 {
+		o[last].Retain += n
+		return o
+	}
+	return append(o, Component{Retain: n})
+}
+
+func (o Operation) Insert(s string) Operation {
+	if s == "" {
+		return o
+	}
+	last := len(o) - 1
+	if last >= 0 && o[last].Insert != "" {
+		o[last].Insert += s
+		return o
+	}
+	if last >= 0 && o[last].Delete > 0 {
+		if last > 0 && o[last-1].Insert != "" {
+			o[last-1].Insert += s
+			return o
+		}
+		o = append(o, o[last])
+		o[last] = Component{Insert: s}
+		return o
+	}
+	return append(o, Component{Insert: s})
+}
+
+func (o Operation) Delete(n int) Operation {
+	if n <= 0 {
+		return o
+	}
+	if last := len(o) - 1; last >= 0 && o[last].Delete > 0 {
+		o[last].Delete += n
+		return o
+	}
+	return append(o, Component{Delete: n})
+}
+
+// Apply returns the document o turns doc into.
+func (o Operation) Apply(doc []rune) ([]rune, error) {
+	if o.BaseLen() != len(doc) {
+		return nil, fmt.Errorf("%w: spans %d characters of %d", ErrBaseLength, o.BaseLen(), len(doc))
+	}
+	out := make([]rune, 0, o.TargetLen())
+	i := 0
+	for _, c := range o {
+		switch {
+		case c.Insert != "":
+			out = append(out, []rune(c.Insert)...)
+		case c.Delete > 0:
+			i += c.Delete
+		default:
+			out = append(out, doc[i:i+c.Retain]...)
+			i += c.Retain
+		}
+	}
+	return out, nil
+}
+
+// Transform transforms two operations applied concurrently to the same
+// document, returning a' and b' such that applying a then b' gives the
+// same document as applying b then a'. Text a inserts goes before text b
+// inserts at the same place.
+func Transform(a, b Operation) (Operation, Operation, error) {
+	if a.BaseLen() != b.BaseLen() {
+		return nil, nil, fmt.Errorf("%w: concurrent operations span %d and %d characters", ErrBaseLength, a.BaseLen(), b.BaseLen())
+	}
+	var a1, b1 Operation
+	// ca and cb are what is left of the current components.
+	var ca, cb *Component
+	next := func(o Operation, i *int) *Component {
+		if *i >= len(o) {
+			return nil
+		}
+		c := o[*i]
+		*i++
+		return &c
+	}
+	ia, ib := 0, 0
+	ca, cb = next(a, &ia), next(b, &ib)
+	for ca != nil || cb != nil {
+		if ca != nil && ca.Insert != "" {
+			a1 = a1.Insert(ca.Insert)
+			b1 = b1.Retain(utf8.RuneCountInString(ca.Insert))
+			ca = next(a, &ia)
+			continue
+		}
+		if cb != nil && cb.Insert != "" {
+			a1 = a1.Retain(utf8.RuneCountInString(cb.Insert))
+			b1 = b1.Insert(cb.Insert)
+			cb = next(b, &ib)
+			continue
+		}
+		// Both have retains or deletes left, as their base lengths match.
+		na, nb := ca.Retain+ca.Delete, cb.Retain+cb.Delete
+		n := min(na, nb)
+		switch {
+		case ca.Retain > 0 && cb.Retain > 0:
+			a1 = a1.Retain(n)
+			b1 = b1.Retain(n)
+		case ca.Delete > 0 && cb.Retain > 0:
+			a1 = a1.Delete(n)
+		case ca.Retain > 0 && cb.Delete > 0:
+			b1 = b1.Delete(n)
+		}
+		// Text both delete is gone already on either side.
+		if ca.Retain > 0 {
+			ca.Retain -= n
+		} else {
+			ca.Delete -= n
+		}
+		if cb.Retain > 0 {
+			cb.Retain -= n
+		} else {
+			cb.Delete -= n
+		}
+		if na == n {
+			ca = next(a, &ia)
+		}
+		if nb == n {
+			cb = next(b, &ib)
+		}
+	}
+	return a1, b1, nil
+}
+
+// TransformIndex returns where offset i of a document is once o is
+// applied to it. Text inserted at i goes before it.
+func TransformIndex(i int, o Operation) int {
+	pos, out := 0, i
+	for _, c := range o {
+		if pos > i {
+			break
+		}
+		switch {
+		case c.Retain > 0:
+			pos += c.Retain
+		case c.Insert != "":
+			out += utf8.RuneCountInString(c.Insert)
+		default:
+			out -= min(c.Delete, i-pos)
+			pos += c.Delete
+		}
+	}
+	return out
+}
@@ -0,0 +1,389 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/joho/godotenv"
+)
+
+// Config is the configuration of the whole server. It is built from the
+// defaults, then a YAML file, then environment variables and finally
+// command line flags, each overriding the previous one.
+type Config struct {
+	Server    Server    `yaml:"server"`
+	Database  Database  `yaml:"database"`
+	Docker    Docker    `yaml:"docker"`
+	Sandbox   Sandbox   `yaml:"sandbox"`
+	Workspace Workspace `yaml:"workspace"`
+	Terminal  Terminal  `yaml:"terminal"`
+	Pool      Pool      `yaml:"pool"`
+	Shutdown  Shutdown  `yaml:"shutdown"`
+	Watch     Watch     `yaml:"watch"`
+	Snapshots Snapshots `yaml:"snapshots"`
+	Transfer  Transfer  `yaml:"transfer"`
+	LSP       LSP       `yaml:"lsp"`
+	Collab    Collab    `yaml:"collab"`
+}
+
+type Server struct {
+	// Addr is the address the HTTP server listens on.
+	Addr string `yaml:"addr"`
+	// ReadBufferSize and WriteBufferSize size the WebSocket I/O buffers.
+	ReadBufferSize  int `yaml:"readBufferSize"`
+	WriteBufferSize int `yaml:"writeBufferSize"`
+}
+
+type Database struct {
+	DSN string `yaml:"dsn"`
+}
+
+type Docker struct {
+	// Host is the Docker daemon address; DOCKER_HOST and the other Docker
+	// client variables are used when it is empty.
+	Host string `yaml:"host"`
+	// ProjectsDir holds the host directory of every workspace.
+	ProjectsDir string `yaml:"projectsDir"`
+	// SlotsDir holds the bind sources of pooled containers.
+	SlotsDir string `yaml:"slotsDir"`
+}
+
+type Sandbox struct {
+	// PolicyFile is a JSON sandbox.Policy; the default policy is used when
+	// it is empty.
+	PolicyFile string `yaml:"policyFile"`
+}
+
+type Workspace struct {
+	// IdleTimeout is how long a workspace may go without activity before
+	// its container is stopped.
+	IdleTimeout time.Duration `yaml:"idleTimeout"`
+	// DeleteAfter is how long a workspace may go without activity before
+	// its container is deleted. It must be longer than IdleTimeout.
+	DeleteAfter time.Duration `yaml:"deleteAfter"`
+	// ReapInterval is how often idle workspaces are looked for.
+	ReapInterval time.Duration `yaml:"reapInterval"`
+}
+
+// Terminal controls how terminal sessions outlive the connection that
+// opened them.
+type Terminal struct {
+	// DetachTimeout is how long a shell keeps running with no connection
+	// attached before it is killed.
+	DetachTimeout time.Duration `yaml:"detachTimeout"`
+	// Scrollback is the number of bytes of recent output kept per session
+	// and replayed when a connection attaches.
+	Scrollback int `yaml:"scrollback"`
+}
+
+type Pool struct {
+	// Size is the number of pre-created containers kept per allowed image;
+	// zero disables the pool.
+	Size            int           `yaml:"size"`
+	RefreshInterval time.Duration `yaml:"refreshInterval"`
+}
+
+// Shutdown controls what happens on SIGTERM.
+type Shutdown struct {
+	// Timeout bounds how long draining may take.
+	Timeout time.Duration `yaml:"timeout"`
+	// StopContainers stops every running workspace container. Otherwise
+	// containers keep running and are adopted again on the next start.
+	StopContainers bool `yaml:"stopContainers"`
+}
+
+// Watch controls the file change notifications of workspaces.
+type Watch struct {
+	// Debounce is how long a workspace has to be quiet before changes are
+	// pushed.
+	Debounce time.Duration `yaml:"debounce"`
+	// Ignore holds path.Match patterns of file and directory names that are
+	// not watched.
+	Ignore []string `yaml:"ignore"`
+}
+
+// Snapshots controls where workspace snapshots are kept.
+type Snapshots struct {
+	// Dir holds the file archives of snapshots, one directory per project.
+	Dir string `yaml:"dir"`
+	// MaxSize bounds the bytes a snapshot may expand to when restored.
+	MaxSize int `yaml:"maxSize"`
+}
+
+// Transfer controls project export and import.
+type Transfer struct {
+	// ExportIgnore holds path.Match patterns of names left out of exports
+	// unless the request gives its own.
+	ExportIgnore []string `yaml:"exportIgnore"`
+	// MaxUpload bounds the size of an uploaded archive and MaxSize the
+	// bytes it may expand to.
+	MaxUpload int `yaml:"maxUpload"`
+	MaxSize   int `yaml:"maxSize"`
+}
+
+// LSP configures the language servers clients can start in workspaces.
+type LSP struct {
+	// Servers maps a language id, as used by LSP, to the command that
+	// starts its server speaking LSP over stdio. The command must exist in
+	// the workspace image. Servers given in a config file replace the
+	// defaults rather than adding to them.
+	Servers map[string][]string `yaml:"servers"`
+}
+
+// Collab controls documents edited together by several connections.
+type Collab struct {
+	// FlushInterval is how long edits to a document may go unsaved before
+	// it is written to the workspace.
+	FlushInterval time.Duration `yaml:"flushInterval"`
+	// History is the number of past edits kept per document. A client
+	// whose edit is based on an older revision must open the document
+	// again.
+	History int `yaml:"history"`
+}
+
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Addr:            ":3000",
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+		},
+		Docker: Docker{
+			ProjectsDir: "/var/repl/projects",
+			SlotsDir:    "/var/repl/slots",
+		},
+		Workspace: Workspace{
+			IdleTimeout:  30 * time.Minute,
+			DeleteAfter:  7 * 24 * time.Hour,
+			ReapInterval: time.Minute,
+		},
+		Terminal: Terminal{
+			DetachTimeout: 5 * time.Minute,
+			Scrollback:    256 << 10,
+		},
+		Pool: Pool{
+			Size:            2,
+			RefreshInterval: 6 * time.Hour,
+		},
+		Shutdown: Shutdown{
+			Timeout: 30 * time.Second,
+		},
+		Watch: Watch{
+			Debounce: 100 * time.Millisecond,
+			Ignore:   []string{"node_modules", ".git", "__pycache__", ".venv"},
+		},
+		Snapshots: Snapshots{
+			Dir:     "/var/repl/snapshots",
+			MaxSize: 4 << 30,
+		},
+		Transfer: Transfer{
+			ExportIgnore: []string{"node_modules", "__pycache__", ".venv"},
+			MaxUpload:    100 << 20,
+			MaxSize:      1 << 30,
+		},
+		LSP: LSP{
+			Servers: map[string][]string{
+				"typescript":      {"typescript-language-server", "--stdio"},
+				"typescriptreact": {"typescript-language-server", "--stdio"},
+				"javascript":      {"typescript-language-server", "--stdio"},
+				"javascriptreact": {"typescript-language-server", "--stdio"},
+				"go":              {"gopls"},
+				"python":          {"pyright-langserver", "--stdio"},
+			},
+		},
+		Collab: Collab{
+			FlushInterval: 2 * time.Second,
+			History:       1000,
+		},
+	}
+}
+
+// Load builds the configuration from args, the command line without the
+// program name, and the environment, which may be extended by a .env file.
+// The config file is named by the -config flag or REPL_CONFIG.
+func Load(args []string) (*Config, error) {
+	_ = godotenv.Load()
+
+	fs := flag.NewFlagSet("repl", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("REPL_CONFIG"), "path to a YAML config file")
+	addr := fs.String("addr", "", "address to listen on")
+	dsn := fs.String("dsn", "", "Postgres DSN")
+	policy := fs.String("policy", "", "path to a JSON sandbox policy")
+	poolSize := fs.Int("pool-size", 0, "pre-created containers per image")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	c := Default()
+	if *path != "" {
+		if err := c.loadFile(*path); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			c.Server.Addr = *addr
+		case "dsn":
+			c.Database.DSN = *dsn
+		case "policy":
+			c.Sandbox.PolicyFile = *policy
+		case "pool-size":
+			c.Pool.Size = *poolSize
+		}
+	})
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+	default:
+		return fmt.Errorf("config: %s: unsupported format, use .yaml or .yml", path)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	if err := yaml.UnmarshalWithOptions(raw, c, yaml.DisallowUnknownField()); err != nil {
+		return fmt.Errorf("config: %s: %w", path, err)
+	}
+	return nil
+}
+
+// envVar overrides a setting from an environment variable.
+type envVar struct {
+	key string
+	set func(string) error
+}
+
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	vars := []envVar{
+		{"LISTEN_ADDR", setString(&c.Server.Addr)},
+		{"WS_READ_BUFFER_SIZE", setInt(&c.Server.ReadBufferSize)},
+		{"WS_WRITE_BUFFER_SIZE", setInt(&c.Server.WriteBufferSize)},
+		{"PG_DSN", setString(&c.Database.DSN)},
+		{"REPL_DOCKER_HOST", setString(&c.Docker.Host)},
+		{"PROJECTS_DIR", setString(&c.Docker.ProjectsDir)},
+		{"SLOTS_DIR", setString(&c.Docker.SlotsDir)},
+		{"SANDBOX_POLICY_FILE", setString(&c.Sandbox.PolicyFile)},
+		{"WORKSPACE_IDLE_TIMEOUT", setDuration(&c.Workspace.IdleTimeout)},
+		{"WORKSPACE_DELETE_AFTER", setDuration(&c.Workspace.DeleteAfter)},
+		{"WORKSPACE_REAP_INTERVAL", setDuration(&c.Workspace.ReapInterval)},
+		{"TERMINAL_DETACH_TIMEOUT", setDuration(&c.Terminal.DetachTimeout)},
+		{"TERMINAL_SCROLLBACK", setInt(&c.Terminal.Scrollback)},
+		{"POOL_SIZE", setInt(&c.Pool.Size)},
+		{"POOL_REFRESH_INTERVAL", setDuration(&c.Pool.RefreshInterval)},
+		{"SHUTDOWN_TIMEOUT", setDuration(&c.Shutdown.Timeout)},
+		{"SHUTDOWN_STOP_CONTAINERS", setBool(&c.Shutdown.StopContainers)},
+		{"WATCH_DEBOUNCE", setDuration(&c.Watch.Debounce)},
+		{"SNAPSHOTS_DIR", setString(&c.Snapshots.Dir)},
+		{"SNAPSHOTS_MAX_SIZE", setInt(&c.Snapshots.MaxSize)},
+		{"IMPORT_MAX_UPLOAD", setInt(&c.Transfer.MaxUpload)},
+		{"IMPORT_MAX_SIZE", setInt(&c.Transfer.MaxSize)},
+		{"COLLAB_FLUSH_INTERVAL", setDuration(&c.Collab.FlushInterval)},
+		{"COLLAB_HISTORY", setInt(&c.Collab.History)},
+	}
+	var errs []error
+	for _, v := range vars {
+		val, ok := lookup(v.key)
+		if !ok || val == "" {
+			continue
+		}
+		if err := v.set(val); err != nil {
+			errs = append(errs, fmt.Errorf("config: %s: %w", v.key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func setString(p *string) func(string) error {
+	return func(s string) error {
+		*p = s
+		return nil
+	}
+}
+
+func setInt(p *int) func(string) error {
+	return func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		*p = n
+		return nil
+	}
+}
+
+func setDuration(p *time.Duration) func(string) error {
+	return func(s string) error {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*p = d
+		return nil
+	}
+}
+
+func setBool(p *bool) func(string) error {
+	return func(s string) error {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		*p = b
+		return nil
+	}
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("config: "+format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr is required")
+	check(c.Server.ReadBufferSize > 0, "server.readBufferSize must be positive")
+	check(c.Server.WriteBufferSize > 0, "server.writeBufferSize must be positive")
+	check(c.Database.DSN != "", "database.dsn is required (PG_DSN)")
+	check(filepath.IsAbs(c.Docker.ProjectsDir), "docker.projectsDir must be an absolute path, got %q", c.Docker.ProjectsDir)
+	check(filepath.IsAbs(c.Docker.SlotsDir), "docker.slotsDir must be an absolute path, got %q", c.Docker.SlotsDir)
+	check(c.Workspace.IdleTimeout > 0, "workspace.idleTimeout must be positive")
+	check(c.Workspace.DeleteAfter > c.Workspace.IdleTimeout, "workspace.deleteAfter must be longer than workspace.idleTimeout")
+	check(c.Workspace.ReapInterval > 0, "workspace.reapInterval must be positive")
+	check(c.Terminal.DetachTimeout > 0, "terminal.detachTimeout must be positive")
+	check(c.Terminal.Scrollback > 0, "terminal.scrollback must be positive")
+	check(c.Pool.Size >= 0, "pool.size must not be negative")
+	check(c.Pool.RefreshInterval >= 0, "pool.refreshInterval must not be negative")
+	check(c.Shutdown.Timeout > 0, "shutdown.timeout must be positive")
+	check(c.Watch.Debounce > 0, "watch.debounce must be positive")
+	check(filepath.IsAbs(c.Snapshots.Dir), "snapshots.dir must be an absolute path, got %q", c.Snapshots.Dir)
+	check(c.Snapshots.MaxSize > 0, "snapshots.maxSize must be positive")
+	check(c.Transfer.MaxUpload > 0, "transfer.maxUpload must be positive")
+	check(c.Transfer.MaxSize > 0, "transfer.maxSize must be positive")
+	for _, language := range slices.Sorted(maps.Keys(c.LSP.Servers)) {
+		check(len(c.LSP.Servers[language]) > 0, "lsp.servers.%s must name a command", language)
+	}
+	check(c.Collab.FlushInterval > 0, "collab.flushInterval must be positive")
+	check(c.Collab.History > 0, "collab.history must be positive")
+	return errors.Join(errs...)
+}
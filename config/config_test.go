@@ -0,0 +1,85 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadLayersFileEnvAndFlags(t *testing.T) {
+	path := writeConfig(t, "repl.yaml", `
+server:
+  addr: ":4000"
+database:
+  dsn: "postgres://file"
+terminal:
+  detachTimeout: 2m
+pool:
+  size: 5
+lsp:
+  servers:
+    rust: [rust-analyzer]
+`)
+	t.Setenv("REPL_CONFIG", "")
+	t.Setenv("PG_DSN", "postgres://env")
+	t.Setenv("POOL_SIZE", "3")
+
+	c, err := Load([]string{"-config", path, "-pool-size", "0"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if c.Server.Addr != ":4000" || c.Terminal.DetachTimeout != 2*time.Minute {
+		t.Errorf("file settings not applied: %+v", c)
+	}
+	if c.Database.DSN != "postgres://env" {
+		t.Errorf("expected the environment to override the file, got %q", c.Database.DSN)
+	}
+	if c.Pool.Size != 0 {
+		t.Errorf("expected the flag to override the environment, got %d", c.Pool.Size)
+	}
+	if c.Workspace.IdleTimeout != Default().Workspace.IdleTimeout {
+		t.Errorf("expected unset settings to keep their default, got %v", c.Workspace.IdleTimeout)
+	}
+	if len(c.LSP.Servers) != 1 || c.LSP.Servers["rust"][0] != "rust-analyzer" {
+		t.Errorf("expected the language servers of the file to replace the defaults, got %v", c.LSP.Servers)
+	}
+}
+
+func TestLoadReportsInvalidSettings(t *testing.T) {
+	t.Setenv("REPL_CONFIG", "")
+	t.Setenv("PG_DSN", "")
+
+	_, err := Load([]string{"-config", writeConfig(t, "repl.yaml", "server:\n  port: 1\n")})
+	if err == nil || !strings.Contains(err.Error(), "port") {
+		t.Errorf("expected an unknown field error, got %v", err)
+	}
+	_, err = Load([]string{"-config", writeConfig(t, "repl.toml", "")})
+	if err == nil || !strings.Contains(err.Error(), "unsupported format") {
+		t.Errorf("expected an unsupported format error, got %v", err)
+	}
+
+	t.Setenv("TERMINAL_SCROLLBACK", "lots")
+	if _, err := Load(nil); err == nil || !strings.Contains(err.Error(), "TERMINAL_SCROLLBACK") {
+		t.Errorf("expected the bad variable to be named, got %v", err)
+	}
+	t.Setenv("TERMINAL_SCROLLBACK", "")
+
+	t.Setenv("WORKSPACE_DELETE_AFTER", "1m")
+	_, err = Load(nil)
+	for _, want := range []string{"database.dsn", "workspace.deleteAfter"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected an error about %s, got %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrSessionNotFound    = errors.New("session not found or expired")
+	ErrProjectNotFound    = errors.New("project not found")
+	ErrProcessNotFound    = errors.New("process not found")
+	ErrSnapshotNotFound   = errors.New("snapshot not found")
+	ErrSnapshotExists     = errors.New("a snapshot with this name already exists")
+)
+
+type Database interface {
+	CreateUser(email string, password string) (*CreatedUser, error)
+	FindUser(userId uint) (*CreatedUser, error)
+	Authenticate(email string, password string) (*CreatedUser, error)
+
+	CreateSession(userId uint, ttl time.Duration) (*CreatedSession, error)
+	FindSession(token string) (*CreatedUser, error)
+	DeleteSession(token string) error
+
+	CreateProject(spec ProjectSpec) (*CreatedProject, error)
+	FindProject(projectId uint) (*CreatedProject, error)
+	ListProjects(userId uint) ([]CreatedProject, error)
+	MarkProjectScaffolded(projectId uint) error
+
+	// SaveProcess creates the process definition or replaces the command
+	// of the one with the same name.
+	SaveProcess(spec ProcessSpec) error
+	ListProcesses(projectId uint) ([]ProcessSpec, error)
+	DeleteProcess(projectId uint, name string) error
+
+	// CreateSnapshot fails with ErrSnapshotExists when the project already
+	// has a snapshot of the same name.
+	CreateSnapshot(spec SnapshotSpec) (*CreatedSnapshot, error)
+	FindSnapshot(projectId, snapshotId uint) (*CreatedSnapshot, error)
+	ListSnapshots(projectId uint) ([]CreatedSnapshot, error)
+	DeleteSnapshot(projectId, snapshotId uint) error
+
+	// Close closes the connection pool once in-flight queries finish.
+	Close() error
+}
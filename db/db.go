@@ -2,11 +2,19 @@ package db
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
 
-	"github.com/chrollo-lucifer-12/repl/env"
+	"github.com/chrollo-lucifer-12/repl/config"
 	"github.com/chrollo-lucifer-12/repl/logger"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+	"golang.org/x/crypto/bcrypt"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type DB struct {
@@ -19,28 +27,107 @@ type CreatedUser struct {
 	Email string
 }
 
+// ProjectSpec holds what a new project is created with.
+type ProjectSpec struct {
+	Slug      string
+	UserId    uint
+	Template  string
+	Image     string
+	Profile   string
+	Resources sandbox.Resources
+}
+
 type CreatedProject struct {
-	Slug string
-	Id   uint
+	Slug       string
+	Id         uint
+	UserId     uint
+	Template   string
+	Scaffolded bool
+	Image      string
+	Profile    string
+	Resources  sandbox.Resources
+}
+
+func createdProject(project Project) CreatedProject {
+	return CreatedProject{
+		Slug:       project.Slug,
+		Id:         project.ID,
+		UserId:     project.UserId,
+		Template:   project.Template,
+		Scaffolded: project.Scaffolded,
+		Image:      project.Image,
+		Profile:    project.Profile,
+		Resources:  project.Resources,
+	}
+}
+
+// ProcessSpec defines a named background process of a project.
+type ProcessSpec struct {
+	ProjectId uint
+	Name      string
+	Command   []string
+}
+
+// SnapshotSpec holds what a new snapshot is recorded with.
+type SnapshotSpec struct {
+	ProjectId uint
+	Name      string
+	Key       string
+	Size      int64
+	Image     string
+}
+
+type CreatedSnapshot struct {
+	Id        uint
+	ProjectId uint
+	Name      string
+	Key       string
+	Size      int64
+	Image     string
+	CreatedAt time.Time
+}
+
+func createdSnapshot(snapshot Snapshot) CreatedSnapshot {
+	return CreatedSnapshot{
+		Id:        snapshot.ID,
+		ProjectId: snapshot.ProjectId,
+		Name:      snapshot.Name,
+		Key:       snapshot.Key,
+		Size:      snapshot.Size,
+		Image:     snapshot.Image,
+		CreatedAt: snapshot.CreatedAt,
+	}
+}
+
+type CreatedSession struct {
+	Token     string
+	UserId    uint
+	ExpiresAt time.Time
 }
 
-func NewDB(env *env.Env, l logger.Logger) *DB {
-	db, err := gorm.Open(postgres.Open(env.DSN), &gorm.Config{})
+var _ Database = (*DB)(nil)
+
+func NewDB(cfg config.Database, l logger.Logger) (*DB, error) {
+	db, err := gorm.Open(postgres.Open(cfg.DSN), &gorm.Config{TranslateError: true})
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
-	if err := db.AutoMigrate(&User{}, &Project{}); err != nil {
-		l.Error("error migrating db", err.Error())
-		return nil
+	if err := db.AutoMigrate(&User{}, &Project{}, &Process{}, &Snapshot{}, &Session{}); err != nil {
+		l.Error("error migrating db", "error", err)
+		return nil, err
 	}
 
 	d := &DB{db: db, l: l}
-	return d
+	return d, nil
 }
 
 func (d *DB) CreateUser(email string, password string) (*CreatedUser, error) {
-	user := User{Email: email, Password: password}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	user := User{Email: email, Password: string(hash)}
 	ctx := context.Background()
 
 	result := gorm.WithResult()
@@ -52,8 +139,15 @@ func (d *DB) CreateUser(email string, password string) (*CreatedUser, error) {
 
 }
 
-func (d *DB) CreateProject(slug string, userId uint) (*CreatedProject, error) {
-	project := Project{Slug: slug, UserId: userId}
+func (d *DB) CreateProject(spec ProjectSpec) (*CreatedProject, error) {
+	project := Project{
+		Slug:      spec.Slug,
+		UserId:    spec.UserId,
+		Template:  spec.Template,
+		Image:     spec.Image,
+		Profile:   spec.Profile,
+		Resources: spec.Resources,
+	}
 	ctx := context.Background()
 
 	result := gorm.WithResult()
@@ -61,14 +155,223 @@ func (d *DB) CreateProject(slug string, userId uint) (*CreatedProject, error) {
 		return nil, err
 	}
 
-	return &CreatedProject{Slug: project.Slug, Id: project.ID}, nil
+	created := createdProject(project)
+	return &created, nil
+}
+
+func (d *DB) FindProject(projectId uint) (*CreatedProject, error) {
+	ctx := context.Background()
+	project, err := gorm.G[Project](d.db).Where("id = ?", projectId).First(ctx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrProjectNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	created := createdProject(project)
+	return &created, nil
+}
+
+func (d *DB) ListProjects(userId uint) ([]CreatedProject, error) {
+	ctx := context.Background()
+	projects, err := gorm.G[Project](d.db).Where("user_id = ?", userId).Order("id").Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	created := make([]CreatedProject, 0, len(projects))
+	for _, project := range projects {
+		created = append(created, createdProject(project))
+	}
+	return created, nil
+}
+
+func (d *DB) MarkProjectScaffolded(projectId uint) error {
+	ctx := context.Background()
+	rows, err := gorm.G[Project](d.db).Where("id = ?", projectId).Update(ctx, "scaffolded", true)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrProjectNotFound
+	}
+	return nil
+}
+
+func (d *DB) SaveProcess(spec ProcessSpec) error {
+	process := Process{ProjectId: spec.ProjectId, Name: spec.Name, Command: spec.Command}
+	ctx := context.Background()
+	upsert := clause.OnConflict{
+		Columns:   []clause.Column{{Name: "project_id"}, {Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"command", "updated_at", "deleted_at"}),
+	}
+	return gorm.G[Process](d.db, upsert).Create(ctx, &process)
+}
+
+func (d *DB) ListProcesses(projectId uint) ([]ProcessSpec, error) {
+	ctx := context.Background()
+	processes, err := gorm.G[Process](d.db).Where("project_id = ?", projectId).Order("name").Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	specs := make([]ProcessSpec, 0, len(processes))
+	for _, process := range processes {
+		specs = append(specs, ProcessSpec{ProjectId: process.ProjectId, Name: process.Name, Command: process.Command})
+	}
+	return specs, nil
+}
+
+func (d *DB) DeleteProcess(projectId uint, name string) error {
+	ctx := context.Background()
+	rows, err := gorm.G[Process](d.db).Where("project_id = ? AND name = ?", projectId, name).Delete(ctx)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrProcessNotFound
+	}
+	return nil
 }
 
 func (d *DB) FindUser(userId uint) (*CreatedUser, error) {
 	ctx := context.Background()
-	user, err := gorm.G[User](d.db).First(ctx)
+	user, err := gorm.G[User](d.db).Where("id = ?", userId).First(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &CreatedUser{Id: user.ID, Email: user.Email}, nil
+}
+
+func (d *DB) Authenticate(email string, password string) (*CreatedUser, error) {
+	ctx := context.Background()
+	user, err := gorm.G[User](d.db).Where("email = ?", email).First(ctx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidCredentials
+	}
 	if err != nil {
 		return nil, err
 	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
 	return &CreatedUser{Id: user.ID, Email: user.Email}, nil
 }
+
+func (d *DB) CreateSession(userId uint, ttl time.Duration) (*CreatedSession, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(raw)
+	session := Session{
+		TokenHash: hashToken(token),
+		UserId:    userId,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	ctx := context.Background()
+
+	if err := gorm.G[Session](d.db).Create(ctx, &session); err != nil {
+		return nil, err
+	}
+
+	return &CreatedSession{Token: token, UserId: userId, ExpiresAt: session.ExpiresAt}, nil
+}
+
+func (d *DB) FindSession(token string) (*CreatedUser, error) {
+	ctx := context.Background()
+	session, err := gorm.G[Session](d.db).
+		Where("token_hash = ? AND expires_at > ?", hashToken(token), time.Now()).
+		First(ctx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d.FindUser(session.UserId)
+}
+
+func (d *DB) DeleteSession(token string) error {
+	ctx := context.Background()
+	_, err := gorm.G[Session](d.db).Where("token_hash = ?", hashToken(token)).Delete(ctx)
+	return err
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (d *DB) Close() error {
+	sqlDB, err := d.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+func (d *DB) CreateSnapshot(spec SnapshotSpec) (*CreatedSnapshot, error) {
+	snapshot := Snapshot{
+		ProjectId: spec.ProjectId,
+		Name:      spec.Name,
+		Key:       spec.Key,
+		Size:      spec.Size,
+		Image:     spec.Image,
+	}
+	ctx := context.Background()
+	_, err := gorm.G[Snapshot](d.db).Where("project_id = ? AND name = ?", spec.ProjectId, spec.Name).First(ctx)
+	if err == nil {
+		return nil, ErrSnapshotExists
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	// A snapshot created with the same name since the check trips the
+	// unique index instead.
+	if err := gorm.G[Snapshot](d.db).Create(ctx, &snapshot); err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return nil, ErrSnapshotExists
+		}
+		return nil, err
+	}
+	created := createdSnapshot(snapshot)
+	return &created, nil
+}
+
+func (d *DB) FindSnapshot(projectId, snapshotId uint) (*CreatedSnapshot, error) {
+	ctx := context.Background()
+	snapshot, err := gorm.G[Snapshot](d.db).Where("id = ? AND project_id = ?", snapshotId, projectId).First(ctx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrSnapshotNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	created := createdSnapshot(snapshot)
+	return &created, nil
+}
+
+func (d *DB) ListSnapshots(projectId uint) ([]CreatedSnapshot, error) {
+	ctx := context.Background()
+	snapshots, err := gorm.G[Snapshot](d.db).Where("project_id = ?", projectId).Order("id").Find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	created := make([]CreatedSnapshot, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		created = append(created, createdSnapshot(snapshot))
+	}
+	return created, nil
+}
+
+// DeleteSnapshot deletes the row for good, so the name can be reused.
+func (d *DB) DeleteSnapshot(projectId, snapshotId uint) error {
+	ctx := context.Background()
+	rows, err := gorm.G[Snapshot](d.db.Unscoped()).Where("id = ? AND project_id = ?", snapshotId, projectId).Delete(ctx)
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrSnapshotNotFound
+	}
+	return nil
+}
@@ -1,6 +1,11 @@
 package db
 
-import "gorm.io/gorm"
+import (
+	"time"
+
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+	"gorm.io/gorm"
+)
 
 type User struct {
 	gorm.Model
@@ -11,6 +16,45 @@ type User struct {
 
 type Project struct {
 	gorm.Model
-	Slug   string `gorm:"unique"`
-	UserId uint
+	Slug   string `gorm:"uniqueIndex:idx_project_user_slug"`
+	UserId uint   `gorm:"uniqueIndex:idx_project_user_slug"`
+	// Template is the id of the template the workspace is scaffolded from.
+	Template   string
+	Scaffolded bool
+	// Image, Profile and Resources are resolved against the sandbox policy
+	// when the project is created, so later policy changes do not affect
+	// existing projects.
+	Image     string
+	Profile   string
+	Resources sandbox.Resources `gorm:"embedded;embeddedPrefix:resource_"`
+}
+
+// Process is the definition of a named background process of a project,
+// such as its dev server.
+type Process struct {
+	gorm.Model
+	ProjectId uint     `gorm:"uniqueIndex:idx_process_project_name"`
+	Name      string   `gorm:"uniqueIndex:idx_process_project_name"`
+	Command   []string `gorm:"serializer:json"`
+}
+
+// Snapshot is a saved state of a project's workspace. Key names its file
+// archive in the snapshot directory; Image is the committed container
+// image, if the container was saved too.
+type Snapshot struct {
+	gorm.Model
+	ProjectId uint   `gorm:"uniqueIndex:idx_snapshot_project_name"`
+	Name      string `gorm:"uniqueIndex:idx_snapshot_project_name"`
+	Key       string `gorm:"unique"`
+	Size      int64
+	Image     string
+}
+
+// Session is a login session. Only the SHA-256 of the bearer token is
+// stored so a leaked table cannot be replayed.
+type Session struct {
+	gorm.Model
+	TokenHash string `gorm:"uniqueIndex"`
+	UserId    uint
+	ExpiresAt time.Time
 }
@@ -2,53 +2,92 @@ package docker
 
 import (
 	"context"
-	"fmt"
+	"os"
+	"path/filepath"
+	"time"
 
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+	"github.com/moby/moby/api/types/container"
 	"github.com/moby/moby/client"
 )
 
-func (d *DockerClient) RemoveContainer(ctx context.Context, containerId string) error {
-	_, ok := d.containers.Load(containerId)
-	if !ok {
-		return fmt.Errorf("container was deleted")
-	}
-	if _, err := d.dockerClient.ContainerStop(ctx, containerId, client.ContainerStopOptions{}); err != nil {
+func (d *DockerClient) RemoveContainer(ctx context.Context, workspaceId string) error {
+	info, err := d.lookup("stop", workspaceId)
+	if err != nil {
 		return err
 	}
-	return nil
+	_, err = d.dockerClient.ContainerStop(ctx, info.id, client.ContainerStopOptions{})
+	return containerError("stop", workspaceId, err)
 }
 
-func (d *DockerClient) RemoveAllContainers(ctx context.Context) {
-	containers, err := d.dockerClient.ContainerList(ctx, client.ContainerListOptions{})
+func (d *DockerClient) DeleteContainer(ctx context.Context, workspaceId string) error {
+	info, err := d.lookup("delete", workspaceId)
+	if err != nil {
+		return err
+	}
+	timeout := 0
+	if _, err := d.dockerClient.ContainerStop(ctx, info.id, client.ContainerStopOptions{
+		Timeout: &timeout,
+	}); err != nil {
+		return containerError("delete", workspaceId, err)
+	}
+
+	_, err = d.dockerClient.ContainerRemove(ctx, info.id, client.ContainerRemoveOptions{
+		Force: true,
+	})
 	if err != nil {
-		panic(err)
+		return containerError("delete", workspaceId, err)
 	}
 
-	for _, container := range containers.Items {
-		fmt.Print("Stopping container ", container.ID[:10], "... ")
-		noWaitTimeout := 0
-		if _, err := d.dockerClient.ContainerStop(ctx, container.ID, client.ContainerStopOptions{Timeout: &noWaitTimeout}); err != nil {
-			panic(err)
-		}
-		fmt.Println("Success")
+	if slot := info.slot; slot != "" {
+		os.Remove(filepath.Join(d.slotsDir, slot))
 	}
+	d.containers.Delete(workspaceId)
+	return nil
 }
 
-func (d *DockerClient) DeleteContainer(ctx context.Context, containerId string) error {
-	_, ok := d.containers.Load(containerId)
-	if !ok {
-		return fmt.Errorf("container was deleted")
+// Reconcile rebuilds the workspace map from the containers Docker actually
+// has, found by their labels and names, so containers created before a restart are
+// managed again and containers removed behind our back are forgotten.
+func (d *DockerClient) Reconcile(ctx context.Context) ([]sandbox.ContainerStatus, error) {
+	containers, err := d.dockerClient.ContainerList(ctx, client.ContainerListOptions{
+		All:     true,
+		Filters: make(client.Filters).Add("label", managedLabel+"=true"),
+	})
+	if err != nil {
+		return nil, containerError("reconcile", "all", err)
 	}
-	timeout := 0
-	if _, err := d.dockerClient.ContainerStop(ctx, containerId, client.ContainerStopOptions{
-		Timeout: &timeout,
-	}); err != nil {
-		return err
+
+	seen := map[string]bool{}
+	statuses := []sandbox.ContainerStatus{}
+	for _, c := range containers.Items {
+		workspaceId := containerWorkspace(c)
+		if workspaceId == "" || seen[workspaceId] {
+			continue
+		}
+		seen[workspaceId] = true
+
+		info := &ContainerInfo{
+			id:          c.ID,
+			createdAt:   time.Unix(c.Created, 0),
+			workspaceId: workspaceId,
+			slot:        c.Labels[slotLabel],
+		}
+		d.containers.Store(workspaceId, info)
+		statuses = append(statuses, sandbox.ContainerStatus{
+			WorkspaceId: workspaceId,
+			ContainerId: c.ID,
+			Running:     c.State == container.StateRunning,
+			CreatedAt:   info.createdAt,
+		})
 	}
 
-	_, err := d.dockerClient.ContainerRemove(ctx, containerId, client.ContainerRemoveOptions{
-		Force: true,
+	d.containers.Range(func(key, value any) bool {
+		if !seen[key.(string)] {
+			d.containers.Delete(key)
+		}
+		return true
 	})
 
-	return err
+	return statuses, nil
 }
@@ -2,85 +2,205 @@ package docker
 
 import (
 	"context"
-	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/chrollo-lucifer-12/repl/config"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
 	"github.com/moby/moby/api/types/container"
 	"github.com/moby/moby/client"
 )
 
-type FileInfo struct {
-	Name string `json:"name"`
-	Type string `json:"type"`
-	Size int64  `json:"size"`
-	Mode string `json:"mode"`
+const (
+	managedLabel   = "repl.managed"
+	workspaceLabel = "repl.workspace"
+	// poolLabel marks containers created for the pool with their image and
+	// slotLabel names the slot directory bound into them.
+	poolLabel = "repl.pool"
+	slotLabel = "repl.slot"
+)
+
+// workspaceNamePrefix starts the name of every container bound to a
+// workspace. Pooled containers only get their workspace through this name,
+// since labels cannot be changed after creation.
+const workspaceNamePrefix = "repl-ws-"
+
+func containerName(workspaceId string) string {
+	return workspaceNamePrefix + workspaceId
 }
 
 type ContainerInfo struct {
-	createdAt time.Time
-	userId    string
+	id          string
+	createdAt   time.Time
+	workspaceId string
+	// slot is the pool slot the container was created for, if any.
+	slot string
 }
 
 type DockerClient struct {
 	dockerClient *client.Client
-	containers   sync.Map
+	// projectsDir holds the host directory of every workspace.
+	projectsDir string
+	// slotsDir holds the bind sources of pooled containers. A slot is
+	// turned into a symlink to the workspace directory when its container
+	// is claimed; the symlink is resolved when the container starts.
+	slotsDir   string
+	containers sync.Map
+	// terminals holds the *dockerTerminal of every interactive shell,
+	// keyed by exec id.
+	terminals sync.Map
+	// processes holds the *dockerProcess of every running background
+	// process, keyed by exec id.
+	processes sync.Map
+
+	poolMu   sync.Mutex
+	pool     map[string][]pooledContainer
+	poolSize int
+	// filling holds the images whose pool is being filled; a fill started
+	// meanwhile leaves the shortfall to it.
+	filling map[string]bool
 }
 
-func NewDockerClient() *DockerClient {
-	apiClient, err := client.New(client.FromEnv)
+var _ sandbox.Sandbox = (*DockerClient)(nil)
+
+func NewDockerClient(cfg config.Docker) (*DockerClient, error) {
+	opts := []client.Opt{client.FromEnv}
+	if cfg.Host != "" {
+		opts = append(opts, client.WithHost(cfg.Host))
+	}
+	apiClient, err := client.New(opts...)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	return &DockerClient{dockerClient: apiClient}
+	return &DockerClient{
+		dockerClient: apiClient,
+		projectsDir:  cfg.ProjectsDir,
+		slotsDir:     cfg.SlotsDir,
+		pool:         map[string][]pooledContainer{},
+		filling:      map[string]bool{},
+	}, nil
 }
 
 func (d *DockerClient) Stop() error {
 	return d.dockerClient.Close()
 }
 
-func (d *DockerClient) StartContainer(ctx context.Context, outputWriter io.Writer, userId string) string {
-	imageName := "node:20-bullseye"
-	out, err := d.dockerClient.ImagePull(ctx, imageName, client.ImagePullOptions{})
-	if err != nil {
-		panic(err)
+// HostDir returns the bind source of the workspace directory. Pooled
+// containers reach it through their slot symlink.
+func (d *DockerClient) HostDir(workspaceId string) (string, error) {
+	if _, err := d.lookup("lookup", workspaceId); err != nil {
+		return "", err
 	}
-	defer out.Close()
-	io.Copy(outputWriter, out)
+	return filepath.Join(d.projectsDir, workspaceId), nil
+}
 
-	hostDir := "/var/repl/users/" + userId
-	os.MkdirAll(hostDir, 0755)
-	containerDir := "/home/" + userId
+// WorkDir is the directory the workspace is mounted at, the working
+// directory of every container.
+func (d *DockerClient) WorkDir(workspaceId string) (string, error) {
+	if _, err := d.lookup("lookup", workspaceId); err != nil {
+		return "", err
+	}
+	return sandbox.WorkspaceDir, nil
+}
 
+// hostConfig binds the workspace directory and applies the resource limits.
+func hostConfig(hostDir, containerDir string, r sandbox.Resources) *container.HostConfig {
+	hc := &container.HostConfig{
+		Binds: []string{
+			hostDir + ":" + containerDir,
+		},
+		Resources: container.Resources{
+			Memory:     r.Memory,
+			MemorySwap: r.MemorySwap,
+			CPUShares:  r.CPUShares,
+			CPUQuota:   r.CPUQuota,
+		},
+	}
+	if r.CPUQuota > 0 {
+		hc.Resources.CPUPeriod = 100000
+	}
+	if r.PidsLimit > 0 {
+		hc.Resources.PidsLimit = &r.PidsLimit
+	}
+	if r.DiskQuota > 0 {
+		hc.StorageOpt = map[string]string{"size": strconv.FormatInt(r.DiskQuota, 10)}
+	}
+	return hc
+}
+
+// StartContainer starts the workspace container, restarting the existing
+// one if the workspace already has a container that was stopped. New
+// workspaces claim a pre-created container from the pool when one is
+// ready for the image.
+func (d *DockerClient) StartContainer(ctx context.Context, outputWriter io.Writer, workspaceId string, spec sandbox.ContainerSpec) (string, error) {
+	if existing, ok := d.containers.Load(workspaceId); ok {
+		containerId := existing.(*ContainerInfo).id
+		if _, err := d.dockerClient.ContainerStart(ctx, containerId, client.ContainerStartOptions{}); err != nil {
+			return "", containerError("start", workspaceId, err)
+		}
+		return containerId, nil
+	}
+
+	imageName := spec.Image
+	if imageName == "" {
+		imageName = sandbox.DefaultImage
+	}
+
+	hostDir := filepath.Join(d.projectsDir, workspaceId)
+	if err := os.MkdirAll(hostDir, 0755); err != nil {
+		return "", &sandbox.ContainerError{Op: "start", WorkspaceId: workspaceId, Err: err}
+	}
+
+	if err := d.ensureImage(ctx, imageName, pullReporter(spec, outputWriter)); err != nil {
+		return "", imageError("pull", workspaceId, err)
+	}
+
+	// Disk quotas are storage options, which cannot be changed after the
+	// container is created.
+	if spec.Resources.DiskQuota == 0 {
+		if info, ok := d.claimContainer(ctx, imageName, workspaceId, hostDir, spec.Resources); ok {
+			d.containers.Store(workspaceId, info)
+			return info.id, nil
+		}
+	}
+
+	containerDir := sandbox.WorkspaceDir
 	resp, err := d.dockerClient.ContainerCreate(ctx, client.ContainerCreateOptions{
-		Image:  imageName,
-		Config: &container.Config{Tty: true, OpenStdin: true, AttachStdin: true, AttachStdout: true, AttachStderr: true, Cmd: []string{"sh"}, WorkingDir: containerDir},
-		HostConfig: &container.HostConfig{
-			Binds: []string{
-				hostDir + ":" + containerDir,
-			},
-			Resources: container.Resources{
-				Memory:     512 * 1024 * 1024,
-				MemorySwap: 512 * 1024 * 1024,
-				CPUShares:  512,
-				CPUQuota:   50000,
-				CPUPeriod:  100000,
+		Name:  containerName(workspaceId),
+		Image: imageName,
+		Config: &container.Config{
+			Tty:          true,
+			OpenStdin:    true,
+			AttachStdin:  true,
+			AttachStdout: true,
+			AttachStderr: true,
+			Cmd:          []string{"sh"},
+			WorkingDir:   containerDir,
+			Labels: map[string]string{
+				managedLabel:   "true",
+				workspaceLabel: workspaceId,
 			},
 		},
+		HostConfig: hostConfig(hostDir, containerDir, spec.Resources),
 	})
 	if err != nil {
-		panic(err)
+		return "", imageError("create", workspaceId, err)
 	}
 
 	if _, err := d.dockerClient.ContainerStart(ctx, resp.ID, client.ContainerStartOptions{}); err != nil {
-		panic(err)
+		d.dockerClient.ContainerRemove(ctx, resp.ID, client.ContainerRemoveOptions{Force: true})
+		return "", containerError("start", workspaceId, err)
 	}
 
-	fmt.Println(resp.ID)
-
-	d.containers.Store(userId, resp.ID)
+	d.containers.Store(workspaceId, &ContainerInfo{
+		id:          resp.ID,
+		createdAt:   time.Now(),
+		workspaceId: workspaceId,
+	})
 
-	return resp.ID
+	return resp.ID, nil
 }
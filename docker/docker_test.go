@@ -3,14 +3,42 @@ package docker
 import (
 	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/chrollo-lucifer-12/repl/config"
+	"github.com/chrollo-lucifer-12/repl/logger"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/api/types/jsonstream"
+	"github.com/moby/moby/client"
 )
 
+// newTestDockerClient skips the calling test when no Docker daemon is reachable.
+func newTestDockerClient(t *testing.T) *DockerClient {
+	t.Helper()
+	d, err := NewDockerClient(config.Default().Docker)
+	if err != nil {
+		t.Fatalf("failed to create docker client: %v", err)
+	}
+	if _, err := d.dockerClient.Ping(context.Background(), client.PingOptions{}); err != nil {
+		d.Stop()
+		t.Skipf("docker daemon unavailable: %v", err)
+	}
+	return d
+}
+
 func TestDockerClientWithNodeJSProject(t *testing.T) {
 	// Create Docker client
-	client := NewDockerClient()
+	client := newTestDockerClient(t)
 	defer client.Stop()
 
 	ctx := context.Background()
@@ -18,9 +46,9 @@ func TestDockerClientWithNodeJSProject(t *testing.T) {
 	// Start container
 	t.Log("Starting container...")
 	var startBuf bytes.Buffer
-	containerID := client.StartContainer(ctx, &startBuf, "123")
-	if containerID == "" {
-		t.Fatal("Failed to start container")
+	containerID, err := client.StartContainer(ctx, &startBuf, "123", sandbox.ContainerSpec{})
+	if err != nil {
+		t.Fatalf("Failed to start container: %v", err)
 	}
 	t.Logf("Container started: %s", containerID)
 	t.Logf("Container start output:\n%s", startBuf.String())
@@ -28,7 +56,7 @@ func TestDockerClientWithNodeJSProject(t *testing.T) {
 	// Cleanup container at the end
 	defer func() {
 		t.Log("Cleaning up container...")
-		if err := client.DeleteContainer(ctx, containerID); err != nil {
+		if err := client.DeleteContainer(ctx, "123"); err != nil {
 			t.Logf("Warning: Failed to delete container: %v", err)
 		}
 	}()
@@ -39,7 +67,7 @@ func TestDockerClientWithNodeJSProject(t *testing.T) {
 	// Test 1: Check Node.js is installed
 	t.Run("CheckNodeInstalled", func(t *testing.T) {
 		var buf bytes.Buffer
-		if err := client.ExecCommand(ctx, containerID, []string{"node", "--version"}, &buf); err != nil {
+		if err := client.ExecCommand(ctx, "123", []string{"node", "--version"}, &buf); err != nil {
 			t.Fatalf("Node.js not installed: %v", err)
 		}
 		version := strings.TrimSpace(buf.String())
@@ -53,7 +81,7 @@ func TestDockerClientWithNodeJSProject(t *testing.T) {
 	// Test 2: Check NPM is installed
 	t.Run("CheckNPMInstalled", func(t *testing.T) {
 		var buf bytes.Buffer
-		if err := client.ExecCommand(ctx, containerID, []string{"npm", "--version"}, &buf); err != nil {
+		if err := client.ExecCommand(ctx, "123", []string{"npm", "--version"}, &buf); err != nil {
 			t.Fatalf("NPM not installed: %v", err)
 		}
 		version := strings.TrimSpace(buf.String())
@@ -65,7 +93,7 @@ func TestDockerClientWithNodeJSProject(t *testing.T) {
 	t.Run("CheckNodeBinaryExists", func(t *testing.T) {
 		var buf bytes.Buffer
 		cmd := []string{"sh", "-c", "test -f /usr/local/bin/node && echo 'exists' || echo 'not found'"}
-		if err := client.ExecCommand(ctx, containerID, cmd, &buf); err != nil {
+		if err := client.ExecCommand(ctx, "123", cmd, &buf); err != nil {
 			t.Fatalf("Failed to check node binary: %v", err)
 		}
 		output := strings.TrimSpace(buf.String())
@@ -79,15 +107,15 @@ func TestDockerClientWithNodeJSProject(t *testing.T) {
 	// Test 4: Check working directory exists
 	t.Run("CheckWorkingDirectory", func(t *testing.T) {
 		var buf bytes.Buffer
-		cmd := []string{"sh", "-c", "test -d /home/hi && echo 'exists' || echo 'not found'"}
-		if err := client.ExecCommand(ctx, containerID, cmd, &buf); err != nil {
+		cmd := []string{"sh", "-c", "test -d /workspace && echo 'exists' || echo 'not found'"}
+		if err := client.ExecCommand(ctx, "123", cmd, &buf); err != nil {
 			t.Fatalf("Failed to check working directory: %v", err)
 		}
 		output := strings.TrimSpace(buf.String())
 		t.Logf("Working directory check result: %s", output)
 		t.Logf("Command output:\n%s", buf.String())
 		if !strings.Contains(output, "exists") {
-			t.Error("Working directory /home/hi not found")
+			t.Error("Working directory /workspace not found")
 		}
 	})
 
@@ -97,7 +125,7 @@ func TestDockerClientWithNodeJSProject(t *testing.T) {
 		for _, cmd := range commands {
 			var buf bytes.Buffer
 			checkCmd := []string{"sh", "-c", "which " + cmd}
-			if err := client.ExecCommand(ctx, containerID, checkCmd, &buf); err != nil {
+			if err := client.ExecCommand(ctx, "123", checkCmd, &buf); err != nil {
 				t.Errorf("Command %s not found in PATH: %v", cmd, err)
 				continue
 			}
@@ -111,7 +139,7 @@ func TestDockerClientWithNodeJSProject(t *testing.T) {
 	t.Run("ExecuteSimpleJavaScript", func(t *testing.T) {
 		var buf bytes.Buffer
 		cmd := []string{"node", "-e", "console.log('Hello from Node.js')"}
-		if err := client.ExecCommand(ctx, containerID, cmd, &buf); err != nil {
+		if err := client.ExecCommand(ctx, "123", cmd, &buf); err != nil {
 			t.Fatalf("Failed to execute JavaScript: %v", err)
 		}
 		output := strings.TrimSpace(buf.String())
@@ -126,7 +154,7 @@ func TestDockerClientWithNodeJSProject(t *testing.T) {
 	t.Run("CheckNodeBuiltinModules", func(t *testing.T) {
 		var buf bytes.Buffer
 		cmd := []string{"node", "-e", "const fs = require('fs'); const path = require('path'); console.log('Modules OK')"}
-		if err := client.ExecCommand(ctx, containerID, cmd, &buf); err != nil {
+		if err := client.ExecCommand(ctx, "123", cmd, &buf); err != nil {
 			t.Fatalf("Failed to load built-in modules: %v", err)
 		}
 		output := strings.TrimSpace(buf.String())
@@ -141,7 +169,7 @@ func TestDockerClientWithNodeJSProject(t *testing.T) {
 	t.Run("CheckShellAvailable", func(t *testing.T) {
 		var buf bytes.Buffer
 		cmd := []string{"sh", "-c", "echo 'Shell works'"}
-		if err := client.ExecCommand(ctx, containerID, cmd, &buf); err != nil {
+		if err := client.ExecCommand(ctx, "123", cmd, &buf); err != nil {
 			t.Fatalf("Shell not available: %v", err)
 		}
 		output := strings.TrimSpace(buf.String())
@@ -158,7 +186,7 @@ func TestDockerClientWithNodeJSProject(t *testing.T) {
 		for _, util := range utilities {
 			var buf bytes.Buffer
 			cmd := []string{"sh", "-c", "which " + util}
-			if err := client.ExecCommand(ctx, containerID, cmd, &buf); err != nil {
+			if err := client.ExecCommand(ctx, "123", cmd, &buf); err != nil {
 				t.Errorf("Utility %s not found: %v", util, err)
 				t.Logf("Command output:\n%s", buf.String())
 				continue
@@ -174,7 +202,7 @@ func TestDockerClientWithNodeJSProject(t *testing.T) {
 		var buf bytes.Buffer
 		script := "const fs = require('fs'); fs.writeFileSync('/tmp/test.txt', 'test content'); const content = fs.readFileSync('/tmp/test.txt', 'utf8'); console.log('File content:', content)"
 		cmd := []string{"node", "-e", script}
-		if err := client.ExecCommand(ctx, containerID, cmd, &buf); err != nil {
+		if err := client.ExecCommand(ctx, "123", cmd, &buf); err != nil {
 			t.Fatalf("Node.js file operations failed: %v", err)
 		}
 		output := buf.String()
@@ -188,7 +216,7 @@ func TestDockerClientWithNodeJSProject(t *testing.T) {
 	t.Run("CheckFileSystemWritable", func(t *testing.T) {
 		var buf bytes.Buffer
 		cmd := []string{"sh", "-c", "touch /tmp/testfile && test -f /tmp/testfile && echo 'File created successfully' || echo 'Failed to create file'"}
-		if err := client.ExecCommand(ctx, containerID, cmd, &buf); err != nil {
+		if err := client.ExecCommand(ctx, "123", cmd, &buf); err != nil {
 			t.Fatalf("Failed to test file system: %v", err)
 		}
 		output := strings.TrimSpace(buf.String())
@@ -203,7 +231,7 @@ func TestDockerClientWithNodeJSProject(t *testing.T) {
 	t.Run("CheckEnvironmentVariables", func(t *testing.T) {
 		var buf bytes.Buffer
 		cmd := []string{"sh", "-c", "echo PATH=$PATH"}
-		if err := client.ExecCommand(ctx, containerID, cmd, &buf); err != nil {
+		if err := client.ExecCommand(ctx, "123", cmd, &buf); err != nil {
 			t.Fatalf("Failed to read environment: %v", err)
 		}
 		path := strings.TrimSpace(buf.String())
@@ -217,8 +245,8 @@ func TestDockerClientWithNodeJSProject(t *testing.T) {
 	// Test 13: List contents of working directory
 	t.Run("ListWorkingDirectory", func(t *testing.T) {
 		var buf bytes.Buffer
-		cmd := []string{"ls", "-la", "/home/hi"}
-		if err := client.ExecCommand(ctx, containerID, cmd, &buf); err != nil {
+		cmd := []string{"ls", "-la", "/workspace"}
+		if err := client.ExecCommand(ctx, "123", cmd, &buf); err != nil {
 			t.Fatalf("Failed to list working directory: %v", err)
 		}
 		t.Logf("Working directory contents:\n%s", buf.String())
@@ -227,8 +255,8 @@ func TestDockerClientWithNodeJSProject(t *testing.T) {
 	// Test 14: Check disk space
 	t.Run("CheckDiskSpace", func(t *testing.T) {
 		var buf bytes.Buffer
-		cmd := []string{"df", "-h", "/home/hi"}
-		if err := client.ExecCommand(ctx, containerID, cmd, &buf); err != nil {
+		cmd := []string{"df", "-h", "/workspace"}
+		if err := client.ExecCommand(ctx, "123", cmd, &buf); err != nil {
 			t.Fatalf("Failed to check disk space: %v", err)
 		}
 		t.Logf("Disk space information:\n%s", buf.String())
@@ -269,7 +297,7 @@ files.forEach(file => {
 console.log('All operations completed successfully!');
 `
 		cmd := []string{"node", "-e", script}
-		if err := client.ExecCommand(ctx, containerID, cmd, &buf); err != nil {
+		if err := client.ExecCommand(ctx, "123", cmd, &buf); err != nil {
 			t.Fatalf("Complex Node.js operation failed: %v", err)
 		}
 		output := buf.String()
@@ -287,16 +315,16 @@ console.log('All operations completed successfully!');
 	t.Run("InstallAndUseNPMPackage", func(t *testing.T) {
 		// Create a project directory
 		var buf bytes.Buffer
-		cmd := []string{"mkdir", "-p", "/home/hi/test-project"}
-		if err := client.ExecCommand(ctx, containerID, cmd, &buf); err != nil {
+		cmd := []string{"mkdir", "-p", "/workspace/test-project"}
+		if err := client.ExecCommand(ctx, "123", cmd, &buf); err != nil {
 			t.Fatalf("Failed to create project directory: %v", err)
 		}
 		t.Logf("Create directory output:\n%s", buf.String())
 
 		// Initialize npm project (create package.json)
 		buf.Reset()
-		initCmd := []string{"sh", "-c", "cd /home/hi/test-project && npm init -y"}
-		if err := client.ExecCommand(ctx, containerID, initCmd, &buf); err != nil {
+		initCmd := []string{"sh", "-c", "cd /workspace/test-project && npm init -y"}
+		if err := client.ExecCommand(ctx, "123", initCmd, &buf); err != nil {
 			t.Fatalf("Failed to initialize npm project: %v", err)
 		}
 		t.Logf("NPM init output:\n%s", buf.String())
@@ -304,16 +332,16 @@ console.log('All operations completed successfully!');
 		// Install a simple package (chalk - for colored console output)
 		buf.Reset()
 		t.Log("Installing 'chalk' package (this may take a moment)...")
-		installCmd := []string{"sh", "-c", "cd /home/hi/test-project && npm install chalk@4.1.2"}
-		if err := client.ExecCommand(ctx, containerID, installCmd, &buf); err != nil {
+		installCmd := []string{"sh", "-c", "cd /workspace/test-project && npm install chalk@4.1.2"}
+		if err := client.ExecCommand(ctx, "123", installCmd, &buf); err != nil {
 			t.Fatalf("Failed to install chalk package: %v", err)
 		}
 		t.Logf("NPM install output:\n%s", buf.String())
 
 		// Check if node_modules directory exists
 		buf.Reset()
-		checkCmd := []string{"sh", "-c", "test -d /home/hi/test-project/node_modules && echo 'node_modules exists' || echo 'node_modules NOT found'"}
-		if err := client.ExecCommand(ctx, containerID, checkCmd, &buf); err != nil {
+		checkCmd := []string{"sh", "-c", "test -d /workspace/test-project/node_modules && echo 'node_modules exists' || echo 'node_modules NOT found'"}
+		if err := client.ExecCommand(ctx, "123", checkCmd, &buf); err != nil {
 			t.Fatalf("Failed to check node_modules: %v", err)
 		}
 		output := buf.String()
@@ -324,16 +352,16 @@ console.log('All operations completed successfully!');
 
 		// List contents of node_modules
 		buf.Reset()
-		listCmd := []string{"ls", "-la", "/home/hi/test-project/node_modules"}
-		if err := client.ExecCommand(ctx, containerID, listCmd, &buf); err != nil {
+		listCmd := []string{"ls", "-la", "/workspace/test-project/node_modules"}
+		if err := client.ExecCommand(ctx, "123", listCmd, &buf); err != nil {
 			t.Fatalf("Failed to list node_modules: %v", err)
 		}
 		t.Logf("node_modules contents:\n%s", buf.String())
 
 		// Check if chalk package exists
 		buf.Reset()
-		chalkCheckCmd := []string{"sh", "-c", "test -d /home/hi/test-project/node_modules/chalk && echo 'chalk package exists' || echo 'chalk NOT found'"}
-		if err := client.ExecCommand(ctx, containerID, chalkCheckCmd, &buf); err != nil {
+		chalkCheckCmd := []string{"sh", "-c", "test -d /workspace/test-project/node_modules/chalk && echo 'chalk package exists' || echo 'chalk NOT found'"}
+		if err := client.ExecCommand(ctx, "123", chalkCheckCmd, &buf); err != nil {
 			t.Fatalf("Failed to check chalk package: %v", err)
 		}
 		t.Logf("Chalk package check:\n%s", buf.String())
@@ -343,16 +371,16 @@ console.log('All operations completed successfully!');
 
 		// Check package.json exists
 		buf.Reset()
-		packageCheckCmd := []string{"sh", "-c", "test -f /home/hi/test-project/package.json && cat /home/hi/test-project/package.json"}
-		if err := client.ExecCommand(ctx, containerID, packageCheckCmd, &buf); err != nil {
+		packageCheckCmd := []string{"sh", "-c", "test -f /workspace/test-project/package.json && cat /workspace/test-project/package.json"}
+		if err := client.ExecCommand(ctx, "123", packageCheckCmd, &buf); err != nil {
 			t.Fatalf("Failed to read package.json: %v", err)
 		}
 		t.Logf("package.json contents:\n%s", buf.String())
 
 		// Check package-lock.json exists
 		buf.Reset()
-		lockCheckCmd := []string{"sh", "-c", "test -f /home/hi/test-project/package-lock.json && echo 'package-lock.json exists' || echo 'package-lock.json NOT found'"}
-		if err := client.ExecCommand(ctx, containerID, lockCheckCmd, &buf); err != nil {
+		lockCheckCmd := []string{"sh", "-c", "test -f /workspace/test-project/package-lock.json && echo 'package-lock.json exists' || echo 'package-lock.json NOT found'"}
+		if err := client.ExecCommand(ctx, "123", lockCheckCmd, &buf); err != nil {
 			t.Fatalf("Failed to check package-lock.json: %v", err)
 		}
 		t.Logf("package-lock.json check:\n%s", buf.String())
@@ -366,8 +394,8 @@ console.log(chalk.green('Chalk is working!'));
 console.log(chalk.red('Success!'));
 console.log('Package test completed');
 `
-		useCmd := []string{"sh", "-c", "cd /home/hi/test-project && node -e \"" + useChalkScript + "\""}
-		if err := client.ExecCommand(ctx, containerID, useCmd, &buf); err != nil {
+		useCmd := []string{"sh", "-c", "cd /workspace/test-project && node -e \"" + useChalkScript + "\""}
+		if err := client.ExecCommand(ctx, "123", useCmd, &buf); err != nil {
 			t.Fatalf("Failed to use chalk package: %v", err)
 		}
 		output = buf.String()
@@ -385,32 +413,32 @@ console.log('Package test completed');
 	t.Run("VerifyNodeModulesStructure", func(t *testing.T) {
 		// Count files in node_modules
 		var buf bytes.Buffer
-		countCmd := []string{"sh", "-c", "find /home/hi/test-project/node_modules -type f | wc -l"}
-		if err := client.ExecCommand(ctx, containerID, countCmd, &buf); err != nil {
+		countCmd := []string{"sh", "-c", "find /workspace/test-project/node_modules -type f | wc -l"}
+		if err := client.ExecCommand(ctx, "123", countCmd, &buf); err != nil {
 			t.Fatalf("Failed to count node_modules files: %v", err)
 		}
 		t.Logf("Total files in node_modules:\n%s", buf.String())
 
 		// Count directories in node_modules
 		buf.Reset()
-		countDirCmd := []string{"sh", "-c", "find /home/hi/test-project/node_modules -type d | wc -l"}
-		if err := client.ExecCommand(ctx, containerID, countDirCmd, &buf); err != nil {
+		countDirCmd := []string{"sh", "-c", "find /workspace/test-project/node_modules -type d | wc -l"}
+		if err := client.ExecCommand(ctx, "123", countDirCmd, &buf); err != nil {
 			t.Fatalf("Failed to count node_modules directories: %v", err)
 		}
 		t.Logf("Total directories in node_modules:\n%s", buf.String())
 
 		// List top-level packages
 		buf.Reset()
-		listPackagesCmd := []string{"sh", "-c", "ls -1 /home/hi/test-project/node_modules"}
-		if err := client.ExecCommand(ctx, containerID, listPackagesCmd, &buf); err != nil {
+		listPackagesCmd := []string{"sh", "-c", "ls -1 /workspace/test-project/node_modules"}
+		if err := client.ExecCommand(ctx, "123", listPackagesCmd, &buf); err != nil {
 			t.Fatalf("Failed to list packages: %v", err)
 		}
 		t.Logf("Top-level packages in node_modules:\n%s", buf.String())
 
 		// Check .bin directory
 		buf.Reset()
-		binCheckCmd := []string{"sh", "-c", "test -d /home/hi/test-project/node_modules/.bin && ls -la /home/hi/test-project/node_modules/.bin || echo '.bin directory not found'"}
-		if err := client.ExecCommand(ctx, containerID, binCheckCmd, &buf); err != nil {
+		binCheckCmd := []string{"sh", "-c", "test -d /workspace/test-project/node_modules/.bin && ls -la /workspace/test-project/node_modules/.bin || echo '.bin directory not found'"}
+		if err := client.ExecCommand(ctx, "123", binCheckCmd, &buf); err != nil {
 			t.Logf("Failed to check .bin directory: %v", err)
 		}
 		t.Logf(".bin directory contents:\n%s", buf.String())
@@ -421,16 +449,16 @@ console.log('Package test completed');
 
 // Additional test for container lifecycle
 func TestContainerLifecycle(t *testing.T) {
-	client := NewDockerClient()
+	client := newTestDockerClient(t)
 	defer client.Stop()
 
 	ctx := context.Background()
 
 	// Start container
 	var buf bytes.Buffer
-	containerID := client.StartContainer(ctx, &buf, "123")
-	if containerID == "" {
-		t.Fatal("Failed to start container")
+	containerID, err := client.StartContainer(ctx, &buf, "123", sandbox.ContainerSpec{})
+	if err != nil {
+		t.Fatalf("Failed to start container: %v", err)
 	}
 	t.Logf("Container started: %s", containerID)
 	t.Logf("Start output:\n%s", buf.String())
@@ -440,7 +468,7 @@ func TestContainerLifecycle(t *testing.T) {
 
 	// Test basic command
 	buf.Reset()
-	if err := client.ExecCommand(ctx, containerID, []string{"echo", "test"}, &buf); err != nil {
+	if err := client.ExecCommand(ctx, "123", []string{"echo", "test"}, &buf); err != nil {
 		t.Fatalf("Failed to execute command: %v", err)
 	}
 
@@ -452,15 +480,244 @@ func TestContainerLifecycle(t *testing.T) {
 
 	// Check container info
 	buf.Reset()
-	if err := client.ExecCommand(ctx, containerID, []string{"sh", "-c", "hostname && pwd"}, &buf); err != nil {
+	if err := client.ExecCommand(ctx, "123", []string{"sh", "-c", "hostname && pwd"}, &buf); err != nil {
 		t.Fatalf("Failed to get container info: %v", err)
 	}
 	t.Logf("Container info:\n%s", buf.String())
 
 	// Stop and remove container
-	if err := client.DeleteContainer(ctx, containerID); err != nil {
+	if err := client.DeleteContainer(ctx, "123"); err != nil {
 		t.Fatalf("Failed to delete container: %v", err)
 	}
 
 	t.Log("Container lifecycle test passed")
 }
+
+func TestHostConfigAppliesResources(t *testing.T) {
+	hc := hostConfig("/var/repl/projects/1", "/workspace", sandbox.Resources{
+		Memory:     256 << 20,
+		MemorySwap: 512 << 20,
+		CPUQuota:   50000,
+		CPUShares:  512,
+		PidsLimit:  128,
+		DiskQuota:  1 << 30,
+	})
+	if hc.Binds[0] != "/var/repl/projects/1:/workspace" {
+		t.Errorf("unexpected binds %v", hc.Binds)
+	}
+	r := hc.Resources
+	if r.Memory != 256<<20 || r.MemorySwap != 512<<20 || r.CPUQuota != 50000 || r.CPUPeriod != 100000 || r.CPUShares != 512 {
+		t.Errorf("unexpected resources %+v", r)
+	}
+	if r.PidsLimit == nil || *r.PidsLimit != 128 {
+		t.Errorf("expected a pids limit of 128, got %v", r.PidsLimit)
+	}
+	if hc.StorageOpt["size"] != "1073741824" {
+		t.Errorf("expected a disk quota, got %v", hc.StorageOpt)
+	}
+
+	hc = hostConfig("/a", "/b", sandbox.Resources{})
+	if hc.Resources.CPUPeriod != 0 || hc.Resources.PidsLimit != nil || hc.StorageOpt != nil {
+		t.Errorf("expected no limits, got %+v", hc)
+	}
+}
+
+func TestContainerWorkspace(t *testing.T) {
+	cases := []struct {
+		c    container.Summary
+		want string
+	}{
+		{container.Summary{Labels: map[string]string{workspaceLabel: "1"}}, "1"},
+		{container.Summary{Names: []string{"/" + containerName("7")}, Labels: map[string]string{poolLabel: "node"}}, "7"},
+		{container.Summary{Names: []string{"/repl-pool-abc"}, Labels: map[string]string{poolLabel: "node"}}, ""},
+	}
+	for _, tc := range cases {
+		if got := containerWorkspace(tc.c); got != tc.want {
+			t.Errorf("containerWorkspace(%v) = %q, want %q", tc.c.Names, got, tc.want)
+		}
+	}
+}
+
+func TestContainerPool(t *testing.T) {
+	d := newTestDockerClient(t)
+	defer d.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d.RunPool(ctx, PoolConfig{Images: []string{sandbox.DefaultImage}, Size: 1}, logger.NewSlogLogger())
+	if _, ok := d.takePooled("unknown:latest"); ok {
+		t.Error("expected no pool for an unconfigured image")
+	}
+	d.poolMu.Lock()
+	pooled := len(d.pool[sandbox.DefaultImage])
+	d.poolMu.Unlock()
+	if pooled != 1 {
+		t.Fatalf("expected one pooled container, got %d", pooled)
+	}
+
+	id, err := d.StartContainer(ctx, io.Discard, "pool-test", sandbox.ContainerSpec{})
+	if err != nil {
+		t.Fatalf("StartContainer: %v", err)
+	}
+	defer d.DeleteContainer(ctx, "pool-test")
+	info, _ := d.containers.Load("pool-test")
+	if info.(*ContainerInfo).slot == "" {
+		t.Fatalf("expected container %s to be claimed from the pool", id)
+	}
+
+	if err := d.WriteFile(ctx, "pool-test", "claimed.txt", []byte("ok")); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(d.projectsDir, "pool-test", "claimed.txt")); err != nil {
+		t.Errorf("expected the workspace directory to be mounted: %v", err)
+	}
+
+	statuses, err := d.Reconcile(ctx)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	found := false
+	for _, s := range statuses {
+		found = found || s.WorkspaceId == "pool-test"
+	}
+	if !found {
+		t.Errorf("claimed container not reconciled: %+v", statuses)
+	}
+}
+
+func TestContainerPoolConcurrentClaims(t *testing.T) {
+	d := newTestDockerClient(t)
+	defer d.Stop()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const size = 2
+	d.RunPool(ctx, PoolConfig{Images: []string{sandbox.DefaultImage}, Size: size}, logger.NewSlogLogger())
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		workspaceId := fmt.Sprintf("pool-claim-%d", i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := d.StartContainer(ctx, io.Discard, workspaceId, sandbox.ContainerSpec{}); err != nil {
+				t.Errorf("StartContainer: %v", err)
+			}
+		}()
+		defer d.DeleteContainer(ctx, workspaceId)
+	}
+	wg.Wait()
+
+	deadline := time.Now().Add(time.Minute)
+	for {
+		d.poolMu.Lock()
+		filling, pooled := d.filling[sandbox.DefaultImage], len(d.pool[sandbox.DefaultImage])
+		d.poolMu.Unlock()
+		if pooled > size {
+			t.Fatalf("pool grew to %d containers, want at most %d", pooled, size)
+		}
+		if !filling && pooled == size {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pool not refilled: %d containers", pooled)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestPullTrackerAggregatesLayers(t *testing.T) {
+	tracker := newPullTracker("node:20")
+	msgs := []struct {
+		msg     jsonstream.Message
+		changed bool
+	}{
+		{jsonstream.Message{Status: "Pulling from library/node", ID: "20"}, false},
+		{jsonstream.Message{Status: "Pulling fs layer", ID: "a"}, true},
+		{jsonstream.Message{Status: "Already exists", ID: "b"}, true},
+		{jsonstream.Message{Status: "Downloading", ID: "a", Progress: &jsonstream.Progress{Current: 50, Total: 100}}, true},
+		{jsonstream.Message{Status: "Downloading", ID: "a", Progress: &jsonstream.Progress{Current: 51, Total: 100}}, false},
+		{jsonstream.Message{Status: "Extracting", ID: "a", Progress: &jsonstream.Progress{Current: 100, Total: 100}}, true},
+		{jsonstream.Message{Status: "Digest: sha256:abc"}, false},
+	}
+	for i, m := range msgs {
+		if changed := tracker.update(m.msg); changed != m.changed {
+			t.Errorf("message %d: changed = %v, want %v", i, changed, m.changed)
+		}
+		if i == 3 {
+			if p := tracker.progress(); p.Percent != (25+100)/2 {
+				t.Errorf("expected %d%% while downloading, got %+v", (25+100)/2, p)
+			}
+		}
+	}
+
+	p := tracker.progress()
+	if p.Status != sandbox.PullPulling || p.Percent != 100 || len(p.Layers) != 2 || p.Layers[0].Id != "a" {
+		t.Errorf("unexpected progress %+v", p)
+	}
+}
+
+func TestPullReporterWritesSummary(t *testing.T) {
+	var buf bytes.Buffer
+	report := pullReporter(sandbox.ContainerSpec{}, &buf)
+	report(sandbox.PullProgress{Image: "node:20", Status: sandbox.PullPulling, Percent: 10})
+	report(sandbox.PullProgress{Image: "node:20", Status: sandbox.PullPulling, Percent: 10})
+	report(sandbox.PullProgress{Image: "node:20", Status: sandbox.PullReady, Percent: 100})
+	if got := buf.String(); got != "pulling node:20: 10%\r\npulled node:20\r\n" {
+		t.Errorf("unexpected summary %q", got)
+	}
+
+	var reports []sandbox.PullProgress
+	report = pullReporter(sandbox.ContainerSpec{Progress: func(p sandbox.PullProgress) { reports = append(reports, p) }}, &buf)
+	report(sandbox.PullProgress{Status: sandbox.PullReady})
+	if len(reports) != 1 {
+		t.Errorf("expected the spec callback to be used, got %v", reports)
+	}
+}
+
+func TestRuntimeErrorsAreClassified(t *testing.T) {
+	cases := []struct {
+		err  error
+		want error
+	}{
+		{containerError("exec", "1", cerrdefs.ErrNotFound), sandbox.ErrContainerGone},
+		{imageError("pull", "1", streamError(&jsonstream.Error{Message: "manifest for nope:latest not found"})), sandbox.ErrImageNotFound},
+		{containerError("start", "1", cerrdefs.ErrUnavailable), sandbox.ErrDaemonUnavailable},
+		{containerError("create", "1", errors.New("disk quota exceeded")), sandbox.ErrQuotaExceeded},
+	}
+	for _, tc := range cases {
+		var containerErr *sandbox.ContainerError
+		if !errors.As(tc.err, &containerErr) || !errors.Is(tc.err, tc.want) {
+			t.Errorf("%v: expected a ContainerError matching %v", tc.err, tc.want)
+		}
+	}
+	if err := containerError("exec", "1", nil); err != nil {
+		t.Errorf("expected nil, got %v", err)
+	}
+}
+
+func TestOutputErrorUsesStderr(t *testing.T) {
+	cases := []struct {
+		res  sandbox.ExecResult
+		want error
+		msg  string
+	}{
+		{sandbox.ExecResult{ExitCode: 1, Stderr: []byte("mv: cannot stat 'a': No such file or directory\n")}, sandbox.ErrNotFound, ""},
+		{sandbox.ExecResult{ExitCode: 1, Stderr: []byte("rm: cannot remove 'src': Is a directory\n")}, sandbox.ErrIsDirectory, ""},
+		{sandbox.ExecResult{ExitCode: 1, Stdout: []byte("ignored"), Stderr: []byte("Permission denied\n")}, nil, "Permission denied"},
+		{sandbox.ExecResult{ExitCode: 137}, nil, "exit status 137"},
+	}
+	for _, tc := range cases {
+		err := outputError("rename", "a", &tc.res)
+		var fileErr *sandbox.FileError
+		if !errors.As(err, &fileErr) {
+			t.Errorf("%+v: expected a FileError, got %v", tc.res, err)
+			continue
+		}
+		if tc.want != nil && !errors.Is(err, tc.want) {
+			t.Errorf("%+v: expected %v, got %v", tc.res, tc.want, err)
+		}
+		if tc.msg != "" && fileErr.Err.Error() != tc.msg {
+			t.Errorf("%+v: expected %q, got %q", tc.res, tc.msg, fileErr.Err.Error())
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/moby/moby/api/types/jsonstream"
+	"github.com/moby/moby/client"
+)
+
+// lookup returns the container of a workspace.
+func (d *DockerClient) lookup(op, workspaceId string) (*ContainerInfo, error) {
+	info, ok := d.containers.Load(workspaceId)
+	if !ok {
+		return nil, &sandbox.ContainerError{Op: op, WorkspaceId: workspaceId, Err: sandbox.ErrContainerGone}
+	}
+	return info.(*ContainerInfo), nil
+}
+
+// containerError wraps an error of the Docker API in a
+// *sandbox.ContainerError. Not-found errors mean the container is gone.
+func containerError(op, workspaceId string, err error) error {
+	return runtimeError(op, workspaceId, sandbox.ErrContainerGone, err)
+}
+
+// imageError is containerError for image operations, where not-found
+// errors mean the image does not exist.
+func imageError(op, workspaceId string, err error) error {
+	return runtimeError(op, workspaceId, sandbox.ErrImageNotFound, err)
+}
+
+func runtimeError(op, workspaceId string, notFound, err error) error {
+	if err == nil {
+		return nil
+	}
+	var containerErr *sandbox.ContainerError
+	var fileErr *sandbox.FileError
+	if errors.As(err, &containerErr) || errors.As(err, &fileErr) {
+		return err
+	}
+	return &sandbox.ContainerError{Op: op, WorkspaceId: workspaceId, Err: classify(err, notFound)}
+}
+
+// classify joins err with the runtime failure it stands for, if any.
+func classify(err, notFound error) error {
+	var cause error
+	switch {
+	case client.IsErrConnectionFailed(err), cerrdefs.IsUnavailable(err):
+		cause = sandbox.ErrDaemonUnavailable
+	case cerrdefs.IsNotFound(err):
+		cause = notFound
+	case cerrdefs.IsResourceExhausted(err), isQuotaMessage(err.Error()):
+		cause = sandbox.ErrQuotaExceeded
+	default:
+		return err
+	}
+	if errors.Is(err, cause) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", cause, err)
+}
+
+func isQuotaMessage(msg string) bool {
+	msg = strings.ToLower(msg)
+	return strings.Contains(msg, "quota") || strings.Contains(msg, "no space left on device")
+}
+
+// streamError turns an error reported inside a pull stream into an error
+// the errdefs helpers understand.
+func streamError(e *jsonstream.Error) error {
+	msg := strings.ToLower(e.Message)
+	if e.Code == 404 || strings.Contains(msg, "not found") || strings.Contains(msg, "does not exist") ||
+		strings.Contains(msg, "manifest unknown") {
+		return fmt.Errorf("%w: %w", cerrdefs.ErrNotFound, e)
+	}
+	return e
+}
@@ -1,164 +1,322 @@
 package docker
 
 import (
+	"archive/tar"
 	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"os"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/chrollo-lucifer-12/repl/utils"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/moby/moby/client"
 )
 
+// fileTarget looks up the workspace container and confines p to the
+// workspace directory.
+func (d *DockerClient) fileTarget(workspaceId, op, p string) (string, string, error) {
+	info, err := d.lookup(op, workspaceId)
+	if err != nil {
+		return "", "", err
+	}
+	target, err := sandbox.ResolvePath(sandbox.WorkspaceDir, p)
+	if err != nil {
+		return "", "", &sandbox.FileError{Op: op, Path: p, Err: sandbox.ErrPathOutsideWorkspace}
+	}
+	return info.id, target, nil
+}
+
+// fileError wraps a Docker API error, translating not-found errors.
+func fileError(op, p string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if cerrdefs.IsNotFound(err) {
+		err = sandbox.ErrNotFound
+	} else {
+		err = classify(err, sandbox.ErrContainerGone)
+	}
+	return &sandbox.FileError{Op: op, Path: p, Err: err}
+}
+
+// execError describes a failed command by its diagnostics, or its exit
+// status when it printed none.
+func execError(res *sandbox.ExecResult) error {
+	if msg := strings.TrimSpace(string(res.Stderr)); msg != "" {
+		return errors.New(msg)
+	}
+	return fmt.Errorf("exit status %d", res.ExitCode)
+}
+
+// outputError turns a failed coreutils command into a file error,
+// translating the diagnostics callers can act on.
+func outputError(op, p string, res *sandbox.ExecResult) error {
+	err := execError(res)
+	switch {
+	case strings.Contains(err.Error(), "No such file or directory"):
+		err = sandbox.ErrNotFound
+	case strings.Contains(err.Error(), "Is a directory"):
+		err = sandbox.ErrIsDirectory
+	}
+	return &sandbox.FileError{Op: op, Path: p, Err: err}
+}
+
+// WriteFile copies content into the container as a tar archive, so neither
+// the path nor the content ever pass through a shell. The parent directory
+// must already exist.
 func (d *DockerClient) WriteFile(
 	ctx context.Context,
-	userId, path, content string,
-	outputWriter io.Writer,
+	workspaceId, p string,
+	content []byte,
 ) error {
-	containerId, ok := d.containers.Load(userId)
-	if !ok {
-		return fmt.Errorf("container was deleted")
+	containerId, target, err := d.fileTarget(workspaceId, "write", p)
+	if err != nil {
+		return err
 	}
-	fmt.Println(containerId)
-	cmd := []string{
-		"sh",
-		"-c",
-		fmt.Sprintf("cat > %s << 'EOF'\n%s\nEOF", path, content),
+	if target == sandbox.WorkspaceDir {
+		return &sandbox.FileError{Op: "write", Path: p, Err: sandbox.ErrIsDirectory}
 	}
-	return d.ExecCommand(ctx, containerId.(string), cmd, outputWriter)
-}
 
-func (d *DockerClient) ReadFile(ctx context.Context, userId, path string, outputWriter io.Writer) error {
-	containerId, ok := d.containers.Load(userId)
-	if !ok {
-		return fmt.Errorf("container was deleted")
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     path.Base(target),
+		Mode:     0644,
+		Size:     int64(len(content)),
+		ModTime:  time.Now(),
+	}); err != nil {
+		return err
 	}
-	cmd := []string{"cat", path}
-	if err := d.ExecCommand(ctx, containerId.(string), cmd, outputWriter); err != nil {
+	if _, err := tw.Write(content); err != nil {
 		return err
 	}
-	return nil
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	_, err = d.dockerClient.CopyToContainer(ctx, containerId, client.CopyToContainerOptions{
+		DestinationPath: path.Dir(target),
+		Content:         &archive,
+	})
+	return fileError("write", p, err)
 }
 
-func (d *DockerClient) CreateDir(ctx context.Context, userId, path string, outputWriter io.Writer) error {
-	containerId, ok := d.containers.Load(userId)
-	if !ok {
-		return fmt.Errorf("container was deleted")
+func (d *DockerClient) ReadFile(ctx context.Context, workspaceId, p string) ([]byte, error) {
+	containerId, target, err := d.fileTarget(workspaceId, "read", p)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := d.dockerClient.ContainerStatPath(ctx, containerId, client.ContainerStatPathOptions{Path: target})
+	if err != nil {
+		return nil, fileError("read", p, err)
+	}
+	if stat.Stat.Mode&os.ModeSymlink != 0 {
+		// Read the link target, which has to stay inside the workspace too.
+		if _, err := sandbox.ResolvePath(sandbox.WorkspaceDir, stat.Stat.LinkTarget); err != nil {
+			return nil, &sandbox.FileError{Op: "read", Path: p, Err: sandbox.ErrPathOutsideWorkspace}
+		}
+		target = stat.Stat.LinkTarget
+	}
+
+	res, err := d.dockerClient.CopyFromContainer(ctx, containerId, client.CopyFromContainerOptions{SourcePath: target})
+	if err != nil {
+		return nil, fileError("read", p, err)
+	}
+	defer res.Content.Close()
+
+	if res.Stat.Mode.IsDir() {
+		return nil, &sandbox.FileError{Op: "read", Path: p, Err: sandbox.ErrIsDirectory}
+	}
+	if res.Stat.Size > sandbox.MaxFileSize {
+		return nil, &sandbox.FileError{Op: "read", Path: p, Err: sandbox.ErrFileTooLarge}
 	}
-	cmd := []string{"sh", "-c", "mkdir -p " + path}
-	return d.ExecCommand(ctx, containerId.(string), cmd, outputWriter)
-}
 
-func (d *DockerClient) RemoveFile(ctx context.Context, path string, userId string, outputWriter io.Writer) error {
-	containerId, ok := d.containers.Load(userId)
-	if !ok {
-		return fmt.Errorf("container was deleted")
+	tr := tar.NewReader(res.Content)
+	if _, err := tr.Next(); err != nil {
+		return nil, fileError("read", p, err)
+	}
+	content, err := io.ReadAll(io.LimitReader(tr, sandbox.MaxFileSize))
+	if err != nil {
+		return nil, fileError("read", p, err)
 	}
-	cmd := []string{"rm", "-f", path}
-	return d.ExecCommand(ctx, containerId.(string), cmd, outputWriter)
+	return content, nil
 }
 
-func (d *DockerClient) ListFiles(ctx context.Context, userId, path string, outputWriter io.Writer) error {
-	containerId, ok := d.containers.Load(userId)
-	if !ok {
-		return fmt.Errorf("container was deleted")
+// CreateDir creates the directory and any missing parents by copying a tar
+// archive of directory entries into the workspace root.
+func (d *DockerClient) CreateDir(ctx context.Context, workspaceId, p string) error {
+	containerId, target, err := d.fileTarget(workspaceId, "mkdir", p)
+	if err != nil {
+		return err
+	}
+	root := sandbox.WorkspaceDir
+	if target == root {
+		return nil
 	}
-	cmd := []string{"ls", "-lA", "--color=never", path}
 
-	var buf bytes.Buffer
-	if err := d.ExecCommand(ctx, containerId.(string), cmd, &buf); err != nil {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	rel := strings.TrimPrefix(target, root+"/")
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     strings.Join(parts[:i+1], "/") + "/",
+			Mode:     0755,
+			ModTime:  time.Now(),
+		}); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
 		return err
 	}
 
-	lines := bytes.Split(buf.Bytes(), []byte("\n"))
-	var files []FileInfo
+	_, err = d.dockerClient.CopyToContainer(ctx, containerId, client.CopyToContainerOptions{
+		DestinationPath: root,
+		Content:         &archive,
+	})
+	return fileError("mkdir", p, err)
+}
 
-	for _, line := range lines {
-		if len(line) == 0 {
-			continue
-		}
+func (d *DockerClient) RemoveFile(ctx context.Context, workspaceId, p string) error {
+	_, target, err := d.fileTarget(workspaceId, "remove", p)
+	if err != nil {
+		return err
+	}
+	res, err := d.Exec(ctx, workspaceId, []string{"rm", "-f", "--", target})
+	if err != nil {
+		return err
+	}
+	if res.ExitCode != 0 {
+		return outputError("remove", p, res)
+	}
+	return nil
+}
+
+func (d *DockerClient) ListFiles(ctx context.Context, workspaceId, p string) ([]sandbox.FileInfo, error) {
+	_, target, err := d.fileTarget(workspaceId, "list", p)
+	if err != nil {
+		return nil, err
+	}
+	res, err := d.Exec(ctx, workspaceId, []string{
+		"find", target, "-mindepth", "1", "-maxdepth", "1", "-printf", `%y\t%s\t%M\t%f\n`,
+	})
+	if err != nil {
+		return nil, err
+	}
 
-		fields := bytes.Fields(line)
-		if len(fields) < 9 {
+	files := []sandbox.FileInfo{}
+	for _, line := range strings.Split(string(res.Stdout), "\n") {
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) < 4 {
 			continue
 		}
 
-		mode := string(fields[0])
-		size := utils.BytesToInt64(fields[4])
-		name := string(fields[8])
+		size, _ := strconv.ParseInt(fields[1], 10, 64)
 		fileType := "file"
-		if mode[0] == 'd' {
+		if fields[0] == "d" {
 			fileType = "dir"
 		}
 
-		files = append(files, FileInfo{
-			Name: name,
+		files = append(files, sandbox.FileInfo{
+			Name: fields[3],
 			Type: fileType,
 			Size: size,
-			Mode: mode,
+			Mode: fields[2],
 		})
 	}
 
-	jsonBytes, _ := json.MarshalIndent(files, "", "  ")
-	if outputWriter != nil {
-		outputWriter.Write(jsonBytes)
+	// find keeps going past unreadable entries, so only fail when nothing
+	// could be listed.
+	if res.ExitCode != 0 && len(files) == 0 {
+		return nil, outputError("list", p, res)
 	}
-	return nil
+	return files, nil
 }
 
-func (d *DockerClient) StatFile(ctx context.Context, userId, path string, outputWriter io.Writer) error {
-	containerId, ok := d.containers.Load(userId)
-	if !ok {
-		return fmt.Errorf("container was deleted")
-	}
-	cmd := []string{"stat", "-c", "%F %s %a", path}
-	var buf bytes.Buffer
-	if err := d.ExecCommand(ctx, containerId.(string), cmd, &buf); err != nil {
-		return err
+func (d *DockerClient) StatFile(ctx context.Context, workspaceId, p string) (*sandbox.FileInfo, error) {
+	containerId, target, err := d.fileTarget(workspaceId, "stat", p)
+	if err != nil {
+		return nil, err
 	}
-	output := strings.TrimSpace(buf.String())
-	parts := strings.Fields(output)
-	if len(parts) < 3 {
-		return fmt.Errorf("unexpected stat output: %q", output)
+	res, err := d.dockerClient.ContainerStatPath(ctx, containerId, client.ContainerStatPathOptions{Path: target})
+	if err != nil {
+		return nil, fileError("stat", p, err)
 	}
 
-	fileType := parts[0]
-	size, err := strconv.ParseInt(parts[1], 10, 64)
-	if err != nil {
-		return fmt.Errorf("invalid file size: %v", err)
+	// Keep the %F wording of stat(1) that clients already rely on.
+	stat := res.Stat
+	fileType := "regular file"
+	switch {
+	case stat.Mode.IsDir():
+		fileType = "directory"
+	case stat.Mode&os.ModeSymlink != 0:
+		fileType = "symbolic link"
+	case stat.Size == 0:
+		fileType = "regular empty file"
 	}
-	mode := parts[2]
 
-	info := &FileInfo{
-		Name: path,
+	return &sandbox.FileInfo{
+		Name: p,
 		Type: fileType,
-		Size: size,
-		Mode: mode,
-	}
+		Size: stat.Size,
+		Mode: fmt.Sprintf("%o", stat.Mode.Perm()),
+	}, nil
+}
 
-	if outputWriter != nil {
-		jsonBytes, _ := json.Marshal(info)
-		outputWriter.Write(jsonBytes)
+func (d *DockerClient) SearchInFile(ctx context.Context, workspaceId, filePath, search string) ([]sandbox.SearchMatch, error) {
+	_, target, err := d.fileTarget(workspaceId, "search", filePath)
+	if err != nil {
+		return nil, err
+	}
+	res, err := d.Exec(ctx, workspaceId, []string{"grep", "-nF", "-e", search, "--", target})
+	if err != nil {
+		return nil, err
+	}
+	// grep exits with 1 when nothing matched and 2 on errors.
+	if res.ExitCode > 1 {
+		return nil, outputError("search", filePath, res)
 	}
-	return nil
-}
 
-func (d *DockerClient) SearchInFile(ctx context.Context, userId, filePath, search string, outputWriter io.Writer) error {
-	containerId, ok := d.containers.Load(userId)
-	if !ok {
-		return fmt.Errorf("container was deleted")
+	matches := []sandbox.SearchMatch{}
+	for _, line := range strings.Split(string(res.Stdout), "\n") {
+		lineNo, text, ok := strings.Cut(line, ":")
+		n, err := strconv.Atoi(lineNo)
+		if !ok || err != nil {
+			continue
+		}
+		matches = append(matches, sandbox.SearchMatch{Line: n, Text: text})
 	}
-	cmd := []string{"grep", "-nF", search, filePath}
-	return d.ExecCommand(ctx, containerId.(string), cmd, outputWriter)
+	return matches, nil
 }
 
-func (d *DockerClient) RenameFileDir(ctx context.Context, userId, path string, newName string, outputWriter io.Writer) error {
-	containerId, ok := d.containers.Load(userId)
-	if !ok {
-		return fmt.Errorf("container was deleted")
+func (d *DockerClient) RenameFileDir(ctx context.Context, workspaceId, p string, newName string) error {
+	_, source, err := d.fileTarget(workspaceId, "rename", p)
+	if err != nil {
+		return err
+	}
+	_, dest, err := d.fileTarget(workspaceId, "rename", newName)
+	if err != nil {
+		return err
+	}
+	if source == sandbox.WorkspaceDir {
+		return &sandbox.FileError{Op: "rename", Path: p, Err: sandbox.ErrPathOutsideWorkspace}
 	}
-	cmd := []string{"mv", path, newName}
-	return d.ExecCommand(ctx, containerId.(string), cmd, outputWriter)
+	res, err := d.Exec(ctx, workspaceId, []string{"mv", "--", source, dest})
+	if err != nil {
+		return err
+	}
+	if res.ExitCode != 0 {
+		return outputError("rename", p, res)
+	}
+	return nil
 }
@@ -0,0 +1,183 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/moby/moby/api/types/jsonstream"
+	"github.com/moby/moby/client"
+)
+
+// imageId returns the local id of an image, or "" when it has not been
+// pulled.
+func (d *DockerClient) imageId(ctx context.Context, image string) (string, error) {
+	res, err := d.dockerClient.ImageInspect(ctx, image)
+	if cerrdefs.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return res.ID, nil
+}
+
+// pullImage pulls image, passing the decoded progress to report.
+func (d *DockerClient) pullImage(ctx context.Context, image string, report func(sandbox.PullProgress)) error {
+	resp, err := d.dockerClient.ImagePull(ctx, image, client.ImagePullOptions{})
+	if err != nil {
+		return err
+	}
+	tracker := newPullTracker(image)
+	for msg, err := range resp.JSONMessages(ctx) {
+		if err != nil {
+			return err
+		}
+		if msg.Error != nil {
+			return streamError(msg.Error)
+		}
+		if tracker.update(msg) && report != nil {
+			report(tracker.progress())
+		}
+	}
+	return nil
+}
+
+// ensureImage pulls image unless it is already present locally. report, if
+// not nil, gets the pull progress and a final ready or failed report.
+func (d *DockerClient) ensureImage(ctx context.Context, image string, report func(sandbox.PullProgress)) error {
+	id, err := d.imageId(ctx, image)
+	if err == nil && id == "" {
+		err = d.pullImage(ctx, image, report)
+	}
+	if report != nil {
+		final := sandbox.PullProgress{Image: image, Status: sandbox.PullReady, Percent: 100}
+		if err != nil {
+			final = sandbox.PullProgress{Image: image, Status: sandbox.PullFailed, Error: err.Error()}
+		}
+		report(final)
+	}
+	return err
+}
+
+// pullReporter returns the progress callback of spec, or one writing a line
+// to out whenever the aggregated percentage changes.
+func pullReporter(spec sandbox.ContainerSpec, out io.Writer) func(sandbox.PullProgress) {
+	if spec.Progress != nil {
+		return spec.Progress
+	}
+	if out == nil {
+		return nil
+	}
+	last := -1
+	return func(p sandbox.PullProgress) {
+		switch {
+		case p.Status == sandbox.PullFailed:
+			fmt.Fprintf(out, "pulling %s failed: %s\r\n", p.Image, p.Error)
+		case p.Status == sandbox.PullReady:
+			if last >= 0 {
+				fmt.Fprintf(out, "pulled %s\r\n", p.Image)
+			}
+		case p.Percent != last:
+			last = p.Percent
+			fmt.Fprintf(out, "pulling %s: %d%%\r\n", p.Image, p.Percent)
+		}
+	}
+}
+
+// pullTracker aggregates the JSON messages of an image pull. Downloading a
+// layer counts for the first half of its progress and extracting it for
+// the second.
+type pullTracker struct {
+	image  string
+	order  []string
+	layers map[string]*sandbox.LayerProgress
+}
+
+func newPullTracker(image string) *pullTracker {
+	return &pullTracker{image: image, layers: map[string]*sandbox.LayerProgress{}}
+}
+
+// update applies msg and reports whether the progress changed in a way
+// worth reporting: a layer changed status or its percentage moved.
+func (t *pullTracker) update(msg jsonstream.Message) bool {
+	percent, ok := layerPercent(msg)
+	if msg.ID == "" || !ok {
+		return false
+	}
+	layer, seen := t.layers[msg.ID]
+	if !seen {
+		layer = &sandbox.LayerProgress{Id: msg.ID}
+		t.layers[msg.ID] = layer
+		t.order = append(t.order, msg.ID)
+	}
+	changed := !seen || layer.Status != msg.Status || layer.Percent != percent
+	layer.Status = msg.Status
+	layer.Percent = percent
+	layer.Current, layer.Total = 0, 0
+	if msg.Progress != nil {
+		layer.Current, layer.Total = msg.Progress.Current, msg.Progress.Total
+	}
+	return changed
+}
+
+func (t *pullTracker) progress() sandbox.PullProgress {
+	p := sandbox.PullProgress{Image: t.image, Status: sandbox.PullPulling}
+	sum := 0
+	for _, id := range t.order {
+		layer := *t.layers[id]
+		sum += layer.Percent
+		p.Layers = append(p.Layers, layer)
+	}
+	if len(t.order) > 0 {
+		p.Percent = sum / len(t.order)
+	}
+	return p
+}
+
+// layerPercent maps a layer message to the progress of its layer. Messages
+// that are not about a layer, such as the digest summary, are ignored.
+func layerPercent(msg jsonstream.Message) (int, bool) {
+	phase := func(base int) int {
+		if msg.Progress == nil || msg.Progress.Total <= 0 {
+			return base
+		}
+		return base + int(min(msg.Progress.Current, msg.Progress.Total)*50/msg.Progress.Total)
+	}
+	switch {
+	case slices.Contains([]string{"Pulling fs layer", "Waiting"}, msg.Status):
+		return 0, true
+	case msg.Status == "Downloading":
+		return phase(0), true
+	case slices.Contains([]string{"Verifying Checksum", "Download complete"}, msg.Status):
+		return 50, true
+	case msg.Status == "Extracting":
+		return phase(50), true
+	case slices.Contains([]string{"Pull complete", "Already exists"}, msg.Status):
+		return 100, true
+	}
+	return 0, false
+}
+
+func (d *DockerClient) CommitContainer(ctx context.Context, workspaceId, ref string) (string, error) {
+	info, err := d.lookup("commit", workspaceId)
+	if err != nil {
+		return "", err
+	}
+	res, err := d.dockerClient.ContainerCommit(ctx, info.id, client.ContainerCommitOptions{
+		Reference: ref,
+		Comment:   "snapshot of workspace " + workspaceId,
+	})
+	if err != nil {
+		return "", containerError("commit", workspaceId, err)
+	}
+	return res.ID, nil
+}
+
+func (d *DockerClient) RemoveImage(ctx context.Context, ref string) error {
+	_, err := d.dockerClient.ImageRemove(ctx, ref, client.ImageRemoveOptions{PruneChildren: true})
+	return imageError("remove image", ref, err)
+}
@@ -0,0 +1,286 @@
+package docker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/chrollo-lucifer-12/repl/logger"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+	"github.com/moby/moby/api/types/container"
+	"github.com/moby/moby/client"
+)
+
+// PoolConfig configures the pre-warmed container pool.
+type PoolConfig struct {
+	// Images are pulled at boot and every RefreshInterval.
+	Images []string
+	// Size is the number of stopped containers kept ready per image; zero
+	// disables the pool but images are still pulled.
+	Size            int
+	RefreshInterval time.Duration
+}
+
+// pooledContainer is a created but never started container waiting to be
+// claimed by a workspace.
+type pooledContainer struct {
+	id      string
+	slot    string
+	imageId string
+}
+
+// RunPool pulls the configured images and keeps the pool filled until ctx
+// is cancelled. Images are pulled again every RefreshInterval; pooled
+// containers of an image that changed are replaced.
+func (d *DockerClient) RunPool(ctx context.Context, cfg PoolConfig, l logger.Logger) {
+	d.poolMu.Lock()
+	d.poolSize = cfg.Size
+	d.poolMu.Unlock()
+
+	if err := d.removeStalePool(ctx); err != nil {
+		l.Error("error removing stale pool containers", "error", err)
+	}
+	for _, image := range cfg.Images {
+		if err := d.ensureImage(ctx, image, nil); err != nil {
+			l.Error("error pulling image", "image", image, "error", err)
+			continue
+		}
+		if err := d.fillPool(ctx, image); err != nil {
+			l.Error("error filling container pool", "image", image, "error", err)
+		}
+	}
+
+	if cfg.RefreshInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(cfg.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, image := range cfg.Images {
+				if err := d.pullImage(ctx, image, nil); err != nil {
+					l.Error("error pulling image", "image", image, "error", err)
+					continue
+				}
+				if err := d.refreshPool(ctx, image); err != nil {
+					l.Error("error refreshing container pool", "image", image, "error", err)
+				}
+			}
+		}
+	}
+}
+
+// removeStalePool removes unclaimed pool containers left by a previous run;
+// their slots are not tracked anymore.
+func (d *DockerClient) removeStalePool(ctx context.Context) error {
+	containers, err := d.dockerClient.ContainerList(ctx, client.ContainerListOptions{
+		All:     true,
+		Filters: make(client.Filters).Add("label", poolLabel),
+	})
+	if err != nil {
+		return err
+	}
+	for _, c := range containers.Items {
+		if containerWorkspace(c) != "" {
+			continue
+		}
+		if _, err := d.dockerClient.ContainerRemove(ctx, c.ID, client.ContainerRemoveOptions{Force: true}); err != nil {
+			return err
+		}
+		if slot := c.Labels[slotLabel]; slot != "" {
+			os.RemoveAll(filepath.Join(d.slotsDir, slot))
+		}
+	}
+	return nil
+}
+
+// fillPool creates containers for image until the pool is full. Only one
+// fill runs per image, so concurrent claims cannot each create the
+// containers missing after them.
+func (d *DockerClient) fillPool(ctx context.Context, image string) error {
+	d.poolMu.Lock()
+	if d.filling[image] {
+		d.poolMu.Unlock()
+		return nil
+	}
+	d.filling[image] = true
+	d.poolMu.Unlock()
+	stop := func() {
+		d.poolMu.Lock()
+		delete(d.filling, image)
+		d.poolMu.Unlock()
+	}
+
+	imageId, err := d.imageId(ctx, image)
+	if err != nil || imageId == "" {
+		stop()
+		return err
+	}
+	for {
+		// The shortfall is checked and the fill ended under one lock, so a
+		// claim in between is always seen by this fill or starts its own.
+		d.poolMu.Lock()
+		if d.poolSize-len(d.pool[image]) <= 0 {
+			delete(d.filling, image)
+			d.poolMu.Unlock()
+			return nil
+		}
+		d.poolMu.Unlock()
+
+		pc, err := d.createPooled(ctx, image, imageId)
+		if err != nil {
+			stop()
+			return err
+		}
+		d.poolMu.Lock()
+		d.pool[image] = append(d.pool[image], pc)
+		d.poolMu.Unlock()
+	}
+}
+
+// refreshPool replaces the pooled containers of image that were created
+// from an older version of it.
+func (d *DockerClient) refreshPool(ctx context.Context, image string) error {
+	imageId, err := d.imageId(ctx, image)
+	if err != nil {
+		return err
+	}
+
+	d.poolMu.Lock()
+	var stale, fresh []pooledContainer
+	for _, pc := range d.pool[image] {
+		if pc.imageId == imageId {
+			fresh = append(fresh, pc)
+		} else {
+			stale = append(stale, pc)
+		}
+	}
+	d.pool[image] = fresh
+	d.poolMu.Unlock()
+
+	for _, pc := range stale {
+		d.removePooled(ctx, pc)
+	}
+	return d.fillPool(ctx, image)
+}
+
+func (d *DockerClient) createPooled(ctx context.Context, image, imageId string) (pooledContainer, error) {
+	slot := newSlot()
+	slotDir := filepath.Join(d.slotsDir, slot)
+	if err := os.MkdirAll(slotDir, 0755); err != nil {
+		return pooledContainer{}, err
+	}
+
+	containerDir := sandbox.WorkspaceDir
+	resp, err := d.dockerClient.ContainerCreate(ctx, client.ContainerCreateOptions{
+		Name:  "repl-pool-" + slot,
+		Image: image,
+		Config: &container.Config{
+			Tty:          true,
+			OpenStdin:    true,
+			AttachStdin:  true,
+			AttachStdout: true,
+			AttachStderr: true,
+			Cmd:          []string{"sh"},
+			WorkingDir:   containerDir,
+			Labels: map[string]string{
+				managedLabel: "true",
+				poolLabel:    image,
+				slotLabel:    slot,
+			},
+		},
+		HostConfig: hostConfig(slotDir, containerDir, sandbox.Resources{}),
+	})
+	if err != nil {
+		os.RemoveAll(slotDir)
+		return pooledContainer{}, err
+	}
+	return pooledContainer{id: resp.ID, slot: slot, imageId: imageId}, nil
+}
+
+func (d *DockerClient) removePooled(ctx context.Context, pc pooledContainer) {
+	d.dockerClient.ContainerRemove(ctx, pc.id, client.ContainerRemoveOptions{Force: true})
+	os.RemoveAll(filepath.Join(d.slotsDir, pc.slot))
+}
+
+// takePooled removes a container for image from the pool.
+func (d *DockerClient) takePooled(image string) (pooledContainer, bool) {
+	d.poolMu.Lock()
+	defer d.poolMu.Unlock()
+	pcs := d.pool[image]
+	if len(pcs) == 0 {
+		return pooledContainer{}, false
+	}
+	pc := pcs[len(pcs)-1]
+	d.pool[image] = pcs[:len(pcs)-1]
+	return pc, true
+}
+
+// claimContainer binds a pooled container of image to the workspace: its
+// slot is pointed at hostDir, the resources are applied and the container
+// is renamed after the workspace and started. The pool is refilled in the
+// background.
+func (d *DockerClient) claimContainer(ctx context.Context, image, workspaceId, hostDir string, r sandbox.Resources) (*ContainerInfo, bool) {
+	pc, ok := d.takePooled(image)
+	if !ok {
+		return nil, false
+	}
+	go d.fillPool(context.WithoutCancel(ctx), image)
+
+	if err := d.bindPooled(ctx, pc, workspaceId, hostDir, r); err != nil {
+		d.removePooled(ctx, pc)
+		return nil, false
+	}
+	return &ContainerInfo{
+		id:          pc.id,
+		createdAt:   time.Now(),
+		workspaceId: workspaceId,
+		slot:        pc.slot,
+	}, true
+}
+
+func (d *DockerClient) bindPooled(ctx context.Context, pc pooledContainer, workspaceId, hostDir string, r sandbox.Resources) error {
+	slotDir := filepath.Join(d.slotsDir, pc.slot)
+	if err := os.Remove(slotDir); err != nil {
+		return err
+	}
+	if err := os.Symlink(hostDir, slotDir); err != nil {
+		return err
+	}
+	resources := hostConfig(hostDir, sandbox.WorkspaceDir, r).Resources
+	if _, err := d.dockerClient.ContainerUpdate(ctx, pc.id, client.ContainerUpdateOptions{Resources: &resources}); err != nil {
+		return err
+	}
+	if _, err := d.dockerClient.ContainerRename(ctx, pc.id, client.ContainerRenameOptions{NewName: containerName(workspaceId)}); err != nil {
+		return err
+	}
+	_, err := d.dockerClient.ContainerStart(ctx, pc.id, client.ContainerStartOptions{})
+	return err
+}
+
+// containerWorkspace returns the workspace a managed container belongs to,
+// from its label or, for claimed pool containers, from its name.
+func containerWorkspace(c container.Summary) string {
+	if workspaceId := c.Labels[workspaceLabel]; workspaceId != "" {
+		return workspaceId
+	}
+	for _, name := range c.Names {
+		if workspaceId, ok := strings.CutPrefix(strings.TrimPrefix(name, "/"), workspaceNamePrefix); ok {
+			return workspaceId
+		}
+	}
+	return ""
+}
+
+func newSlot() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,43 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+	"github.com/moby/moby/client"
+)
+
+// ListPorts reads the listening sockets of the container. Every workspace
+// has a network namespace of its own, so all of them belong to it.
+func (d *DockerClient) ListPorts(ctx context.Context, workspaceId string) ([]sandbox.ListeningPort, error) {
+	res, err := d.Exec(ctx, workspaceId, []string{"sh", "-c", "cat /proc/net/tcp /proc/net/tcp6 2>/dev/null"})
+	if err != nil {
+		return nil, err
+	}
+	return sandbox.ParseListeningPorts(res.Stdout, nil), nil
+}
+
+// PortAddress returns the address of the port on the container's network.
+// It is looked up on every call since the address changes when the
+// container restarts.
+func (d *DockerClient) PortAddress(ctx context.Context, workspaceId string, port int) (string, error) {
+	info, err := d.lookup("inspect", workspaceId)
+	if err != nil {
+		return "", err
+	}
+	res, err := d.dockerClient.ContainerInspect(ctx, info.id, client.ContainerInspectOptions{})
+	if err != nil {
+		return "", containerError("inspect", workspaceId, err)
+	}
+	if settings := res.Container.NetworkSettings; settings != nil {
+		for _, network := range settings.Networks {
+			if network != nil && network.IPAddress.IsValid() {
+				return net.JoinHostPort(network.IPAddress.String(), strconv.Itoa(port)), nil
+			}
+		}
+	}
+	return "", &sandbox.ContainerError{Op: "inspect", WorkspaceId: workspaceId, Err: fmt.Errorf("container has no network address")}
+}
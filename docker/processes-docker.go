@@ -1,31 +1,35 @@
 package docker
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
+	"time"
+
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+	"github.com/chrollo-lucifer-12/repl/utils"
 
 	"github.com/moby/moby/client"
 )
 
-func (d *DockerClient) ExecCommand(ctx context.Context, userId string, cmd []string, outputWriter io.Writer) error {
-	containerId, ok := d.containers.Load(userId)
-	if !ok {
-		return fmt.Errorf("container was deleted")
+func (d *DockerClient) ExecCommand(ctx context.Context, workspaceId string, cmd []string, outputWriter io.Writer) error {
+	info, err := d.lookup("exec", workspaceId)
+	if err != nil {
+		return err
 	}
-	execResp, err := d.dockerClient.ExecCreate(ctx, containerId.(string), client.ExecCreateOptions{
+	execResp, err := d.dockerClient.ExecCreate(ctx, info.id, client.ExecCreateOptions{
 		Cmd:          cmd,
 		AttachStdout: true,
 		AttachStdin:  true,
 		TTY:          true,
 	})
 	if err != nil {
-		return err
+		return containerError("exec", workspaceId, err)
 	}
 	resp, err := d.dockerClient.ExecAttach(ctx, execResp.ID, client.ExecAttachOptions{TTY: true})
 	if err != nil {
-		panic(err)
-		return err
+		return containerError("exec", workspaceId, err)
 	}
 	defer resp.Close()
 	if outputWriter == nil {
@@ -36,80 +40,175 @@ func (d *DockerClient) ExecCommand(ctx context.Context, userId string, cmd []str
 	return err
 }
 
-func (d *DockerClient) StartInteractiveRepl(
-	ctx context.Context,
-	userId string,
-	input io.Reader,
-	output io.Writer,
-) error {
-
-	containerId, ok := d.containers.Load(userId)
-	if !ok {
-		return fmt.Errorf("container was deleted")
-	}
-
-	execResp, err := d.dockerClient.ExecCreate(
-		ctx,
-		containerId.(string),
-		client.ExecCreateOptions{
-			Cmd:          []string{"sh"},
-			AttachStdout: true,
-			AttachStdin:  true,
-			TTY:          true,
-		},
-	)
+// Exec runs cmd without a TTY, so stdout and stderr arrive multiplexed and
+// can be told apart, and reads the exit code with ExecInspect.
+func (d *DockerClient) Exec(ctx context.Context, workspaceId string, cmd []string) (*sandbox.ExecResult, error) {
+	info, err := d.lookup("exec", workspaceId)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	hijackedResp, err := d.dockerClient.ExecAttach(
-		ctx,
-		execResp.ID,
-		client.ExecAttachOptions{
-			TTY: true,
-		},
-	)
+	execResp, err := d.dockerClient.ExecCreate(ctx, info.id, client.ExecCreateOptions{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
 	if err != nil {
-		return err
+		return nil, containerError("exec", workspaceId, err)
+	}
+	resp, err := d.dockerClient.ExecAttach(ctx, execResp.ID, client.ExecAttachOptions{})
+	if err != nil {
+		return nil, containerError("exec", workspaceId, err)
 	}
-	defer hijackedResp.Close()
+	defer resp.Close()
+	// The attached stream outlives ctx unless it is closed.
+	stop := context.AfterFunc(ctx, resp.Close)
+	defer stop()
 
-	go func() {
-		if input != nil {
-			io.Copy(hijackedResp.Conn, input)
+	var stdout, stderr bytes.Buffer
+	if err := utils.ReadDockerOutput(resp.Reader, &stdout, &stderr); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
-	}()
+		return nil, containerError("exec", workspaceId, err)
+	}
+	exitCode, err := d.execExitCode(ctx, execResp.ID)
+	if err != nil {
+		return nil, containerError("exec", workspaceId, err)
+	}
+	return &sandbox.ExecResult{ExitCode: exitCode, Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}, nil
+}
 
-	if output != nil {
-		_, _ = io.Copy(output, hijackedResp.Conn)
+// execExitCode returns the exit code of a finished exec. The daemon may
+// close the output stream slightly before it records the exit, so a
+// running exec is polled for a short while.
+func (d *DockerClient) execExitCode(ctx context.Context, execId string) (int, error) {
+	for delay := 5 * time.Millisecond; ; delay *= 2 {
+		res, err := d.dockerClient.ExecInspect(ctx, execId, client.ExecInspectOptions{})
+		if err != nil {
+			return 0, err
+		}
+		if !res.Running {
+			return res.ExitCode, nil
+		}
+		if delay > time.Second {
+			return 0, fmt.Errorf("exec %s still running after its output closed", execId)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
 	}
+}
 
-	return nil
+// killTagged kills every process of the workspace tagged with the given
+// environment variable.
+func (d *DockerClient) killTagged(ctx context.Context, workspaceId, name, value string) error {
+	_, err := d.Exec(ctx, workspaceId, []string{"sh", "-c", killTaggedScript, "sh", name + "=" + value})
+	return err
 }
 
-func (d *DockerClient) StartLongRunningProcess(ctx context.Context, userId string, cmd []string, outputWriter io.Writer) (string, error) {
-	containerId, ok := d.containers.Load(userId)
-	if !ok {
-		return "", fmt.Errorf("container was deleted")
+// processEnv tags every process started by startProcess, like
+// terminalEnv does for shells, so StopProcess can kill its whole tree.
+const processEnv = "REPL_PROCESS"
+
+type dockerProcess struct {
+	workspaceId string
+	key         string
+}
+
+func (d *DockerClient) StartLongRunningProcess(ctx context.Context, workspaceId string, cmd []string, outputWriter io.Writer) (*sandbox.Process, error) {
+	return d.startProcess(ctx, workspaceId, cmd, nil, outputWriter, outputWriter)
+}
+
+// StartPipedProcess attaches stdin as well. Once input is drained the
+// write side of the connection is closed, so the process reads EOF.
+func (d *DockerClient) StartPipedProcess(ctx context.Context, workspaceId string, cmd []string, input io.Reader, stdout, stderr io.Writer) (*sandbox.Process, error) {
+	return d.startProcess(ctx, workspaceId, cmd, input, stdout, stderr)
+}
+
+func (d *DockerClient) startProcess(ctx context.Context, workspaceId string, cmd []string, input io.Reader, stdout, stderr io.Writer) (*sandbox.Process, error) {
+	info, err := d.lookup("exec", workspaceId)
+	if err != nil {
+		return nil, err
 	}
-	execResp, err := d.dockerClient.ExecCreate(ctx, containerId.(string), client.ExecCreateOptions{
+	key, err := newTerminalKey()
+	if err != nil {
+		return nil, err
+	}
+	execResp, err := d.dockerClient.ExecCreate(ctx, info.id, client.ExecCreateOptions{
 		Cmd:          cmd,
+		Env:          []string{processEnv + "=" + key},
+		AttachStdin:  input != nil,
 		AttachStdout: true,
 		AttachStderr: true,
 		TTY:          false,
 	})
 
 	if err != nil {
-		return "", err
+		return nil, containerError("exec", workspaceId, err)
 	}
 	hijackedResp, err := d.dockerClient.ExecAttach(ctx, execResp.ID, client.ExecAttachOptions{})
+	if err != nil {
+		return nil, containerError("exec", workspaceId, err)
+	}
+	d.processes.Store(execResp.ID, &dockerProcess{workspaceId: workspaceId, key: key})
 
+	if input != nil {
+		go func() {
+			io.Copy(hijackedResp.Conn, input)
+			hijackedResp.CloseWrite()
+		}()
+	}
+
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
+		defer d.processes.Delete(execResp.ID)
 		defer hijackedResp.Close()
-		if outputWriter != nil {
-			io.Copy(outputWriter, hijackedResp.Reader)
+		if stdout == nil {
+			stdout = io.Discard
+		}
+		if stderr == nil {
+			stderr = io.Discard
 		}
+		utils.ReadDockerOutput(hijackedResp.Reader, stdout, stderr)
 	}()
 
-	return execResp.ID, nil
+	return &sandbox.Process{ExecId: execResp.ID, Done: done}, nil
+}
+
+// InspectProcess reports the state of a process from ExecInspect, which
+// keeps working after the process exited until the container is removed.
+func (d *DockerClient) InspectProcess(ctx context.Context, workspaceId, execId string) (*sandbox.ProcessStatus, error) {
+	info, err := d.lookup("inspect", workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	res, err := d.dockerClient.ExecInspect(ctx, execId, client.ExecInspectOptions{})
+	if err != nil {
+		return nil, containerError("inspect", workspaceId, err)
+	}
+	if res.ContainerID != info.id {
+		return nil, &sandbox.ContainerError{Op: "inspect", WorkspaceId: workspaceId, Err: processNotFound(execId)}
+	}
+	return &sandbox.ProcessStatus{Running: res.Running, ExitCode: res.ExitCode, Pid: res.PID}, nil
+}
+
+// StopProcess kills the process and everything it started. Stopping a
+// process that already exited does nothing.
+func (d *DockerClient) StopProcess(ctx context.Context, workspaceId, execId string) error {
+	p, ok := d.processes.Load(execId)
+	if !ok {
+		_, err := d.InspectProcess(ctx, workspaceId, execId)
+		return err
+	}
+	if p.(*dockerProcess).workspaceId != workspaceId {
+		return &sandbox.ContainerError{Op: "stop", WorkspaceId: workspaceId, Err: processNotFound(execId)}
+	}
+	return d.killTagged(ctx, workspaceId, processEnv, p.(*dockerProcess).key)
+}
+
+func processNotFound(execId string) error {
+	return fmt.Errorf("process %s: %w", execId, sandbox.ErrNotFound)
 }
@@ -2,25 +2,148 @@ package docker
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"io"
 
+	"github.com/chrollo-lucifer-12/repl/sandbox"
 	"github.com/moby/moby/client"
 )
 
+// terminalEnv tags every process of an interactive shell so KillTerminal
+// can find the whole process tree; Docker has no API to kill an exec.
+const terminalEnv = "REPL_TERMINAL"
+
+// killTaggedScript kills every process whose environment carries the
+// NAME=value pair passed as $1.
+const killTaggedScript = `for f in /proc/[0-9]*/environ; do
+	if grep -qxzF "$1" "$f" 2>/dev/null; then
+		p=${f#/proc/}
+		kill -9 "${p%/environ}" 2>/dev/null
+	fi
+done`
+
+type dockerTerminal struct {
+	workspaceId string
+	key         string
+	conn        client.HijackedResponse
+}
+
+func newTerminalKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (d *DockerClient) StartInteractiveRepl(
+	ctx context.Context,
+	workspaceId string,
+	size sandbox.TerminalSize,
+	input io.Reader,
+	output io.Writer,
+) (*sandbox.Repl, error) {
+
+	info, err := d.lookup("exec", workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	if !size.Valid() {
+		return nil, sandbox.ErrInvalidTerminalSize
+	}
+
+	key, err := newTerminalKey()
+	if err != nil {
+		return nil, err
+	}
+
+	execResp, err := d.dockerClient.ExecCreate(
+		ctx,
+		info.id,
+		client.ExecCreateOptions{
+			Cmd:          []string{"sh"},
+			Env:          []string{terminalEnv + "=" + key},
+			ConsoleSize:  client.ConsoleSize{Height: uint(size.Rows), Width: uint(size.Cols)},
+			AttachStdout: true,
+			AttachStdin:  true,
+			TTY:          true,
+		},
+	)
+	if err != nil {
+		return nil, containerError("exec", workspaceId, err)
+	}
+
+	hijackedResp, err := d.dockerClient.ExecAttach(
+		ctx,
+		execResp.ID,
+		client.ExecAttachOptions{
+			TTY: true,
+		},
+	)
+	if err != nil {
+		return nil, containerError("exec", workspaceId, err)
+	}
+
+	d.terminals.Store(execResp.ID, &dockerTerminal{
+		workspaceId: workspaceId,
+		key:         key,
+		conn:        hijackedResp.HijackedResponse,
+	})
+
+	go func() {
+		if input != nil {
+			io.Copy(hijackedResp.Conn, input)
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer d.terminals.Delete(execResp.ID)
+		defer hijackedResp.Close()
+		if output == nil {
+			output = io.Discard
+		}
+		_, _ = io.Copy(output, hijackedResp.Reader)
+	}()
+
+	return &sandbox.Repl{ExecId: execResp.ID, Done: done}, nil
+}
+
+func (d *DockerClient) terminal(workspaceId, execId string) (*dockerTerminal, error) {
+	term, ok := d.terminals.Load(execId)
+	if !ok || term.(*dockerTerminal).workspaceId != workspaceId {
+		return nil, fmt.Errorf("terminal not started")
+	}
+	return term.(*dockerTerminal), nil
+}
+
 func (d *DockerClient) ResizeTerminal(ctx context.Context,
-	userId string, rows int, cols int) error {
-	containerId, ok := d.containers.Load(userId)
-	if !ok {
-		return fmt.Errorf("container was deleted")
+	workspaceId, execId string, size sandbox.TerminalSize) error {
+	if _, err := d.terminal(workspaceId, execId); err != nil {
+		return err
+	}
+	if !size.Valid() {
+		return sandbox.ErrInvalidTerminalSize
 	}
 
-	_, err := d.dockerClient.ExecResize(ctx, containerId.(string), client.ExecResizeOptions{
-		Height: uint(rows),
-		Width:  uint(cols),
+	_, err := d.dockerClient.ExecResize(ctx, execId, client.ExecResizeOptions{
+		Height: uint(size.Rows),
+		Width:  uint(size.Cols),
 	})
+	return containerError("resize", workspaceId, err)
+}
+
+// KillTerminal kills the shell and everything started from it, then closes
+// the attached streams.
+func (d *DockerClient) KillTerminal(ctx context.Context, workspaceId, execId string) error {
+	term, err := d.terminal(workspaceId, execId)
 	if err != nil {
-		return nil
+		return err
 	}
+	defer term.conn.Close()
 
-	return nil
+	return d.killTagged(ctx, workspaceId, terminalEnv, term.key)
 }
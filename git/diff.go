@@ -0,0 +1,190 @@
+package git
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// Diff is a diff in unified form and broken down into files and hunks.
+type Diff struct {
+	Unified string     `json:"unified"`
+	Files   []FileDiff `json:"files"`
+}
+
+// FileDiff is the change to one file. Status is one of StateAdded,
+// StateDeleted, StateRenamed, StateCopied or StateModified, which includes
+// mode changes. Binary files have no hunks.
+type FileDiff struct {
+	Path    string `json:"path"`
+	OldPath string `json:"oldPath,omitempty"`
+	Status  string `json:"status"`
+	Binary  bool   `json:"binary,omitempty"`
+	Hunks   []Hunk `json:"hunks"`
+}
+
+// Hunk is a run of changed lines with their context. Lines keep their
+// prefix: a space for context, + and - for added and removed lines and a
+// backslash for the missing newline marker.
+type Hunk struct {
+	OldStart int      `json:"oldStart"`
+	OldLines int      `json:"oldLines"`
+	NewStart int      `json:"newStart"`
+	NewLines int      `json:"newLines"`
+	Header   string   `json:"header,omitempty"`
+	Lines    []string `json:"lines"`
+}
+
+// Diff returns the unstaged changes to paths, or with staged the changes
+// in the index relative to HEAD. No paths diff the whole repository.
+// Untracked files are not part of the unstaged changes.
+func (r *Repo) Diff(ctx context.Context, staged bool, paths []string) (*Diff, error) {
+	args := []string{"diff", "--no-color", "--no-ext-diff", "-M"}
+	if staged {
+		args = append(args, "--cached")
+	}
+	out, err := r.git(ctx, pathArgs(args, paths)...)
+	if err != nil {
+		return nil, err
+	}
+	return &Diff{Unified: out, Files: parseDiff(out)}, nil
+}
+
+// parseDiff splits the output of git diff into files and hunks. Hunk lines
+// are counted against the hunk header, so a removed line that starts with
+// -- is not taken for the header of the next file.
+func parseDiff(out string) []FileDiff {
+	files := []FileDiff{}
+	var f *FileDiff
+	var h *Hunk
+	oldLeft, newLeft := 0, 0
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		if h != nil {
+			inHunk := oldLeft > 0 || newLeft > 0
+			switch {
+			case strings.HasPrefix(line, "\\"):
+				h.Lines = append(h.Lines, line)
+				continue
+			case inHunk && strings.HasPrefix(line, "-"):
+				oldLeft--
+			case inHunk && strings.HasPrefix(line, "+"):
+				newLeft--
+			case inHunk && (line == "" || line[0] == ' '):
+				// Some tools strip the space of empty context lines.
+				oldLeft--
+				newLeft--
+			default:
+				h = nil
+			}
+			if h != nil {
+				h.Lines = append(h.Lines, line)
+				continue
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			files = append(files, FileDiff{Status: StateModified, Hunks: []Hunk{}})
+			f = &files[len(files)-1]
+			f.Path = gitLinePath(strings.TrimPrefix(line, "diff --git "))
+		case f == nil:
+		case strings.HasPrefix(line, "@@ "):
+			hunk, ok := parseHunkHeader(line)
+			if !ok {
+				continue
+			}
+			f.Hunks = append(f.Hunks, hunk)
+			h = &f.Hunks[len(f.Hunks)-1]
+			oldLeft, newLeft = hunk.OldLines, hunk.NewLines
+		case strings.HasPrefix(line, "new file mode"):
+			f.Status = StateAdded
+		case strings.HasPrefix(line, "deleted file mode"):
+			f.Status = StateDeleted
+		case strings.HasPrefix(line, "rename from "):
+			f.Status, f.OldPath = StateRenamed, unquote(strings.TrimPrefix(line, "rename from "))
+		case strings.HasPrefix(line, "rename to "):
+			f.Path = unquote(strings.TrimPrefix(line, "rename to "))
+		case strings.HasPrefix(line, "copy from "):
+			f.Status, f.OldPath = StateCopied, unquote(strings.TrimPrefix(line, "copy from "))
+		case strings.HasPrefix(line, "copy to "):
+			f.Path = unquote(strings.TrimPrefix(line, "copy to "))
+		case strings.HasPrefix(line, "Binary files "):
+			f.Binary = true
+		case strings.HasPrefix(line, "--- "):
+			if p := unquote(strings.TrimPrefix(line, "--- ")); p != "/dev/null" && f.OldPath == "" && f.Status != StateDeleted {
+				if old := strings.TrimPrefix(p, "a/"); old != f.Path {
+					f.OldPath = old
+				}
+			}
+		case strings.HasPrefix(line, "+++ "):
+			if p := unquote(strings.TrimPrefix(line, "+++ ")); p != "/dev/null" {
+				f.Path = strings.TrimPrefix(p, "b/")
+			}
+		}
+	}
+	return files
+}
+
+// gitLinePath takes the path out of the a/path b/path of a diff --git
+// line. That is ambiguous when the paths contain spaces and differ, but
+// then rename or ---/+++ lines follow and name the paths.
+func gitLinePath(names string) string {
+	if strings.HasPrefix(names, `"`) {
+		if end := strings.Index(names, `" `); end > 0 {
+			return strings.TrimPrefix(unquote(names[end+2:]), "b/")
+		}
+	}
+	if len(names) >= 5 && (len(names)-5)%2 == 0 {
+		n := (len(names) - 5) / 2
+		if names[2+n:5+n] == " b/" {
+			return names[5+n:]
+		}
+	}
+	if i := strings.LastIndex(names, " b/"); i >= 0 {
+		return names[i+3:]
+	}
+	return names
+}
+
+// unquote undoes the C-style quoting git applies to paths with unusual
+// characters.
+func unquote(p string) string {
+	p = strings.TrimSuffix(p, "\t")
+	if !strings.HasPrefix(p, `"`) {
+		return p
+	}
+	if s, err := strconv.Unquote(p); err == nil {
+		return s
+	}
+	return p
+}
+
+// parseHunkHeader parses @@ -oldStart[,oldLines] +newStart[,newLines] @@.
+func parseHunkHeader(line string) (Hunk, bool) {
+	ranges, header, ok := strings.Cut(strings.TrimPrefix(line, "@@ "), " @@")
+	if !ok {
+		return Hunk{}, false
+	}
+	oldRange, newRange, ok := strings.Cut(ranges, " ")
+	if !ok || !strings.HasPrefix(oldRange, "-") || !strings.HasPrefix(newRange, "+") {
+		return Hunk{}, false
+	}
+	h := Hunk{Header: strings.TrimPrefix(header, " "), Lines: []string{}}
+	var okOld, okNew bool
+	h.OldStart, h.OldLines, okOld = parseRange(oldRange[1:])
+	h.NewStart, h.NewLines, okNew = parseRange(newRange[1:])
+	return h, okOld && okNew
+}
+
+func parseRange(r string) (int, int, bool) {
+	start, count, hasCount := strings.Cut(r, ",")
+	s, err := strconv.Atoi(start)
+	if err != nil {
+		return 0, 0, false
+	}
+	if !hasCount {
+		return s, 1, true
+	}
+	n, err := strconv.Atoi(count)
+	return s, n, err == nil
+}
@@ -0,0 +1,340 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+)
+
+// DefaultBranch is the branch Init starts the repository on.
+const DefaultBranch = "main"
+
+var (
+	ErrNotRepository = errors.New("not a git repository")
+	ErrInvalidName   = errors.New("invalid ref or remote name")
+)
+
+// Runner runs a command in the directory of the repository, the way
+// sandbox.Sandbox.Exec runs one in a workspace.
+type Runner func(ctx context.Context, cmd []string) (*sandbox.ExecResult, error)
+
+// Repo runs git commands on the repository of a workspace and parses
+// their output. Paths are relative to the repository root.
+type Repo struct {
+	run Runner
+}
+
+func New(run Runner) *Repo {
+	return &Repo{run: run}
+}
+
+// Error is a git command that exited with a non-zero status.
+type Error struct {
+	Command  string
+	ExitCode int
+	Stderr   string
+}
+
+func (e *Error) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("git %s: exit status %d", e.Command, e.ExitCode)
+	}
+	return "git " + e.Command + ": " + e.Stderr
+}
+
+func (e *Error) Is(target error) bool {
+	return target == ErrNotRepository && strings.Contains(e.Stderr, "not a git repository")
+}
+
+// Identity is the author and committer of a commit.
+type Identity struct {
+	Name  string
+	Email string
+}
+
+// Commit is an entry of the log.
+type Commit struct {
+	Hash    string    `json:"hash"`
+	Author  string    `json:"author"`
+	Email   string    `json:"email"`
+	Date    time.Time `json:"date"`
+	Subject string    `json:"subject"`
+}
+
+// Branch is a local branch or, with Remote set, a remote tracking branch.
+type Branch struct {
+	Name     string `json:"name"`
+	Commit   string `json:"commit"`
+	Current  bool   `json:"current,omitempty"`
+	Remote   bool   `json:"remote,omitempty"`
+	Upstream string `json:"upstream,omitempty"`
+}
+
+type Remote struct {
+	Name string `json:"name"`
+	Url  string `json:"url"`
+}
+
+// git runs git with args and returns its stdout. Prompts are disabled, as
+// nobody could answer them, and messages are kept in English so they can
+// be matched. The workspace may be owned by another user than the one git
+// runs as inside the container, so ownership checks are skipped.
+func (r *Repo) git(ctx context.Context, args ...string) (string, error) {
+	cmd := append([]string{
+		"env", "GIT_TERMINAL_PROMPT=0", "LC_ALL=C",
+		"git", "-c", "safe.directory=*", "-c", "core.quotepath=false",
+	}, args...)
+	res, err := r.run(ctx, cmd)
+	if err != nil {
+		return "", err
+	}
+	if res.ExitCode != 0 {
+		return "", &Error{Command: args[0], ExitCode: res.ExitCode, Stderr: strings.TrimSpace(string(res.Stderr))}
+	}
+	return string(res.Stdout), nil
+}
+
+// checkName rejects names git would take for an option.
+func checkName(name string) error {
+	if name == "" || strings.HasPrefix(name, "-") || strings.ContainsAny(name, "\x00\n") {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return nil
+}
+
+// pathArgs ends the options of a command and appends paths, or "." for
+// the whole repository.
+func pathArgs(args []string, paths []string) []string {
+	args = append(args, "--")
+	if len(paths) == 0 {
+		return append(args, ".")
+	}
+	return append(args, paths...)
+}
+
+// Init creates a repository on DefaultBranch. Running it on an existing
+// repository does nothing harmful.
+func (r *Repo) Init(ctx context.Context) error {
+	_, err := r.git(ctx, "init", "-q", "--initial-branch="+DefaultBranch)
+	return err
+}
+
+// hasHead reports whether the current branch has a commit.
+func (r *Repo) hasHead(ctx context.Context) (bool, error) {
+	_, err := r.git(ctx, "rev-parse", "--verify", "-q", "HEAD")
+	var gitErr *Error
+	if errors.As(err, &gitErr) && gitErr.ExitCode == 1 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Stage adds the changes to paths, including deletions, to the index. No
+// paths stage everything.
+func (r *Repo) Stage(ctx context.Context, paths []string) error {
+	_, err := r.git(ctx, pathArgs([]string{"add", "-A"}, paths)...)
+	return err
+}
+
+// Unstage resets the index entries of paths to HEAD, or removes them from
+// the index before the first commit. No paths unstage everything.
+func (r *Repo) Unstage(ctx context.Context, paths []string) error {
+	head, err := r.hasHead(ctx)
+	if err != nil {
+		return err
+	}
+	if !head {
+		_, err = r.git(ctx, pathArgs([]string{"rm", "-r", "-q", "--cached", "--ignore-unmatch"}, paths)...)
+		return err
+	}
+	_, err = r.git(ctx, pathArgs([]string{"reset", "-q", "HEAD"}, paths)...)
+	return err
+}
+
+// Commit commits the index as author and returns the new commit.
+func (r *Repo) Commit(ctx context.Context, message string, author Identity) (*Commit, error) {
+	if strings.TrimSpace(message) == "" {
+		return nil, errors.New("commit message is empty")
+	}
+	_, err := r.git(ctx,
+		"-c", "user.name="+author.Name, "-c", "user.email="+author.Email,
+		"commit", "-q", "-m", message)
+	if err != nil {
+		return nil, err
+	}
+	commits, err := r.Log(ctx, "HEAD", 1)
+	if err != nil {
+		return nil, err
+	}
+	return &commits[0], nil
+}
+
+// logFormat separates fields with the unit separator and commits with the
+// record separator, neither of which appears in names or subjects.
+const logFormat = "--format=%H%x1f%an%x1f%ae%x1f%aI%x1f%s%x1e"
+
+// Log returns up to limit commits reachable from ref, or from HEAD when ref
+// is empty, newest first.
+func (r *Repo) Log(ctx context.Context, ref string, limit int) ([]Commit, error) {
+	if ref == "" {
+		head, err := r.hasHead(ctx)
+		if err != nil || !head {
+			return []Commit{}, err
+		}
+		ref = "HEAD"
+	}
+	if err := checkName(ref); err != nil {
+		return nil, err
+	}
+	out, err := r.git(ctx, "log", logFormat, "-n", strconv.Itoa(limit), ref, "--")
+	if err != nil {
+		return nil, err
+	}
+	return parseLog(out), nil
+}
+
+func parseLog(out string) []Commit {
+	commits := []Commit{}
+	for _, record := range strings.Split(out, "\x1e") {
+		fields := strings.Split(strings.TrimLeft(record, "\n"), "\x1f")
+		if len(fields) != 5 {
+			continue
+		}
+		date, _ := time.Parse(time.RFC3339, fields[3])
+		commits = append(commits, Commit{
+			Hash:    fields[0],
+			Author:  fields[1],
+			Email:   fields[2],
+			Date:    date,
+			Subject: fields[4],
+		})
+	}
+	return commits
+}
+
+// Branches lists the local branches, then the remote tracking ones.
+func (r *Repo) Branches(ctx context.Context) ([]Branch, error) {
+	out, err := r.git(ctx, "for-each-ref",
+		"--format=%(refname)%1f%(objectname)%1f%(HEAD)%1f%(upstream:short)",
+		"refs/heads", "refs/remotes")
+	if err != nil {
+		return nil, err
+	}
+	branches := []Branch{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\x1f")
+		if len(fields) != 4 {
+			continue
+		}
+		b := Branch{Commit: fields[1], Current: fields[2] == "*", Upstream: fields[3]}
+		if name, ok := strings.CutPrefix(fields[0], "refs/heads/"); ok {
+			b.Name = name
+		} else {
+			b.Name = strings.TrimPrefix(fields[0], "refs/remotes/")
+			b.Remote = true
+			if strings.HasSuffix(b.Name, "/HEAD") {
+				continue
+			}
+		}
+		branches = append(branches, b)
+	}
+	return branches, nil
+}
+
+// CreateBranch creates a branch at start, or at HEAD when start is empty,
+// without switching to it.
+func (r *Repo) CreateBranch(ctx context.Context, name, start string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	args := []string{"branch", "--end-of-options", name}
+	if start != "" {
+		args = append(args, start)
+	}
+	_, err := r.git(ctx, args...)
+	return err
+}
+
+// Checkout switches to ref, a branch or commit. With create, ref is
+// created as a new branch at HEAD first.
+func (r *Repo) Checkout(ctx context.Context, ref string, create bool) error {
+	if err := checkName(ref); err != nil {
+		return err
+	}
+	args := []string{"checkout", "-q"}
+	if create {
+		args = append(args, "-b")
+	}
+	_, err := r.git(ctx, append(args, ref, "--")...)
+	return err
+}
+
+func (r *Repo) Remotes(ctx context.Context) ([]Remote, error) {
+	out, err := r.git(ctx, "remote", "-v")
+	if err != nil {
+		return nil, err
+	}
+	remotes := []Remote{}
+	for _, line := range strings.Split(out, "\n") {
+		name, rest, ok := strings.Cut(line, "\t")
+		if !ok || !strings.HasSuffix(rest, " (fetch)") {
+			continue
+		}
+		remotes = append(remotes, Remote{Name: name, Url: strings.TrimSuffix(rest, " (fetch)")})
+	}
+	return remotes, nil
+}
+
+func (r *Repo) AddRemote(ctx context.Context, name, url string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if err := checkName(url); err != nil {
+		return err
+	}
+	_, err := r.git(ctx, "remote", "add", "--", name, url)
+	return err
+}
+
+func (r *Repo) Fetch(ctx context.Context, remote string) error {
+	if err := checkName(remote); err != nil {
+		return err
+	}
+	_, err := r.git(ctx, "fetch", "-q", "--end-of-options", remote)
+	return err
+}
+
+// Push pushes branch to the branch of the same name on remote, making it
+// the upstream of branch with setUpstream.
+func (r *Repo) Push(ctx context.Context, remote, branch string, setUpstream bool) error {
+	if err := checkName(remote); err != nil {
+		return err
+	}
+	if err := checkName(branch); err != nil {
+		return err
+	}
+	args := []string{"push", "-q"}
+	if setUpstream {
+		args = append(args, "-u")
+	}
+	_, err := r.git(ctx, append(args, "--end-of-options", remote, branch)...)
+	return err
+}
+
+// Pull fast-forwards the current branch to branch of remote. Diverged
+// branches are not merged, which could leave conflicts nobody sees.
+func (r *Repo) Pull(ctx context.Context, remote, branch string) error {
+	if err := checkName(remote); err != nil {
+		return err
+	}
+	if err := checkName(branch); err != nil {
+		return err
+	}
+	_, err := r.git(ctx, "pull", "-q", "--ff-only", "--end-of-options", remote, branch)
+	return err
+}
@@ -0,0 +1,324 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+)
+
+// newTestRepo returns a Repo running the host git in a new directory,
+// isolated from the configuration of the user running the tests.
+func newTestRepo(t *testing.T) (*Repo, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	return New(dirRunner(dir)), dir
+}
+
+func dirRunner(dir string) Runner {
+	return func(ctx context.Context, cmd []string) (*sandbox.ExecResult, error) {
+		c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+		c.Dir = dir
+		c.Env = append(os.Environ(), "HOME="+dir, "GIT_CONFIG_NOSYSTEM=1", "XDG_CONFIG_HOME="+dir)
+		var stdout, stderr bytes.Buffer
+		c.Stdout, c.Stderr = &stdout, &stderr
+		err := c.Run()
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) {
+			return nil, err
+		}
+		return &sandbox.ExecResult{ExitCode: c.ProcessState.ExitCode(), Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}, nil
+	}
+}
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func fileStatus(st *Status, path string) *FileStatus {
+	for i := range st.Files {
+		if st.Files[i].Path == path {
+			return &st.Files[i]
+		}
+	}
+	return nil
+}
+
+func TestParseStatus(t *testing.T) {
+	out := "# branch.oid 1234abcd\x00# branch.head main\x00# branch.upstream origin/main\x00# branch.ab +2 -1\x00" +
+		"1 M. N... 100644 100644 100644 aaa bbb staged.txt\x00" +
+		"1 .D N... 100644 100644 000000 aaa aaa gone.txt\x00" +
+		"1 AM N... 000000 100644 100644 000 ccc new file.txt\x00" +
+		"2 R. N... 100644 100644 100644 aaa aaa R100 new.txt\x00old.txt\x00" +
+		"u UU N... 100644 100644 100644 100644 a b c both.txt\x00" +
+		"? untracked.txt\x00"
+	st := parseStatus(out)
+	if st.Branch != "main" || st.Commit != "1234abcd" || st.Upstream != "origin/main" || st.Ahead != 2 || st.Behind != 1 {
+		t.Errorf("branch = %+v", st)
+	}
+	want := []FileStatus{
+		{Path: "staged.txt", Staged: StateModified},
+		{Path: "gone.txt", Unstaged: StateDeleted},
+		{Path: "new file.txt", Staged: StateAdded, Unstaged: StateModified},
+		{Path: "new.txt", OldPath: "old.txt", Staged: StateRenamed},
+		{Path: "both.txt", Staged: StateUnmerged, Unstaged: StateUnmerged, Conflicted: true},
+		{Path: "untracked.txt", Unstaged: StateUntracked},
+	}
+	if len(st.Files) != len(want) {
+		t.Fatalf("files = %+v", st.Files)
+	}
+	for i := range want {
+		if st.Files[i] != want[i] {
+			t.Errorf("file %d = %+v, want %+v", i, st.Files[i], want[i])
+		}
+	}
+
+	st = parseStatus("# branch.oid (initial)\x00# branch.head (detached)\x00")
+	if st.Commit != "" || st.Branch != "" || len(st.Files) != 0 {
+		t.Errorf("initial detached status = %+v", st)
+	}
+}
+
+func TestParseDiff(t *testing.T) {
+	out := `diff --git a/a.txt b/a.txt
+index 1111111..2222222 100644
+--- a/a.txt
++++ b/a.txt
+@@ -1,3 +1,3 @@ func main() {
+ one
+--- two
++two
+ three
+@@ -10 +10,2 @@
+-ten
++ten
++eleven
+\ No newline at end of file
+diff --git a/new.txt b/new.txt
+new file mode 100644
+index 0000000..3333333
+--- /dev/null
++++ b/new.txt
+@@ -0,0 +1 @@
++new
+diff --git a/old name.txt b/new name.txt
+similarity index 90%
+rename from old name.txt
+rename to new name.txt
+diff --git a/logo.png b/logo.png
+deleted file mode 100644
+index 4444444..0000000
+Binary files a/logo.png and /dev/null differ
+`
+	files := parseDiff(out)
+	if len(files) != 4 {
+		t.Fatalf("files = %+v", files)
+	}
+
+	a := files[0]
+	if a.Path != "a.txt" || a.Status != StateModified || len(a.Hunks) != 2 {
+		t.Fatalf("a.txt = %+v", a)
+	}
+	h := a.Hunks[0]
+	if h.OldStart != 1 || h.OldLines != 3 || h.NewStart != 1 || h.NewLines != 3 || h.Header != "func main() {" {
+		t.Errorf("first hunk = %+v", h)
+	}
+	if len(h.Lines) != 4 || h.Lines[1] != "--- two" {
+		t.Errorf("first hunk lines = %q", h.Lines)
+	}
+	h = a.Hunks[1]
+	if h.OldStart != 10 || h.OldLines != 1 || h.NewLines != 2 || len(h.Lines) != 4 {
+		t.Errorf("second hunk = %+v", h)
+	}
+
+	if f := files[1]; f.Path != "new.txt" || f.Status != StateAdded || f.OldPath != "" || len(f.Hunks) != 1 {
+		t.Errorf("new.txt = %+v", f)
+	}
+	if f := files[2]; f.Path != "new name.txt" || f.OldPath != "old name.txt" || f.Status != StateRenamed {
+		t.Errorf("rename = %+v", f)
+	}
+	if f := files[3]; f.Path != "logo.png" || f.Status != StateDeleted || !f.Binary || len(f.Hunks) != 0 {
+		t.Errorf("logo.png = %+v", f)
+	}
+}
+
+func TestRepoWorkflow(t *testing.T) {
+	r, dir := newTestRepo(t)
+	ctx := context.Background()
+	me := Identity{Name: "ada", Email: "ada@example.com"}
+
+	if _, err := r.Status(ctx); !errors.Is(err, ErrNotRepository) {
+		t.Fatalf("Status before Init = %v, want ErrNotRepository", err)
+	}
+	if err := r.Init(ctx); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	if log, err := r.Log(ctx, "", 10); err != nil || len(log) != 0 {
+		t.Fatalf("Log of empty repository = %+v, %v", log, err)
+	}
+
+	writeFile(t, dir, "main.py", "print(1)\n")
+	writeFile(t, dir, "src/util.py", "x = 1\n")
+	if err := r.Stage(ctx, nil); err != nil {
+		t.Fatalf("Stage: %v", err)
+	}
+	if err := r.Unstage(ctx, []string{"src/util.py"}); err != nil {
+		t.Fatalf("Unstage before the first commit: %v", err)
+	}
+	st, err := r.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if st.Branch != DefaultBranch || st.Commit != "" {
+		t.Errorf("branch = %q at %q", st.Branch, st.Commit)
+	}
+	if f := fileStatus(st, "main.py"); f == nil || f.Staged != StateAdded {
+		t.Errorf("main.py = %+v", f)
+	}
+	if f := fileStatus(st, "src/util.py"); f == nil || f.Unstaged != StateUntracked {
+		t.Errorf("src/util.py = %+v", f)
+	}
+
+	c, err := r.Commit(ctx, "first", me)
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if c.Subject != "first" || c.Author != "ada" || c.Email != "ada@example.com" || len(c.Hash) != 40 {
+		t.Errorf("commit = %+v", c)
+	}
+
+	writeFile(t, dir, "main.py", "print(2)\n")
+	d, err := r.Diff(ctx, false, nil)
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if len(d.Files) != 1 || d.Files[0].Path != "main.py" || len(d.Files[0].Hunks) != 1 {
+		t.Fatalf("diff = %+v", d)
+	}
+	if lines := d.Files[0].Hunks[0].Lines; len(lines) != 2 || lines[0] != "-print(1)" || lines[1] != "+print(2)" {
+		t.Errorf("hunk lines = %q", lines)
+	}
+	if d, err := r.Diff(ctx, true, nil); err != nil || len(d.Files) != 0 || d.Unified != "" {
+		t.Errorf("staged diff with nothing staged = %+v, %v", d, err)
+	}
+
+	if err := r.Stage(ctx, []string{"main.py"}); err != nil {
+		t.Fatalf("Stage: %v", err)
+	}
+	if err := r.Unstage(ctx, []string{"main.py"}); err != nil {
+		t.Fatalf("Unstage: %v", err)
+	}
+	st, _ = r.Status(ctx)
+	if f := fileStatus(st, "main.py"); f == nil || f.Staged != "" || f.Unstaged != StateModified {
+		t.Errorf("main.py after Unstage = %+v", f)
+	}
+
+	if err := r.Checkout(ctx, "feature", true); err != nil {
+		t.Fatalf("Checkout -b: %v", err)
+	}
+	r.Stage(ctx, nil)
+	if _, err := r.Commit(ctx, "second", me); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	branches, err := r.Branches(ctx)
+	if err != nil || len(branches) != 2 {
+		t.Fatalf("Branches = %+v, %v", branches, err)
+	}
+	for _, b := range branches {
+		if b.Current != (b.Name == "feature") {
+			t.Errorf("branch %+v", b)
+		}
+	}
+	if err := r.Checkout(ctx, DefaultBranch, false); err != nil {
+		t.Fatalf("Checkout: %v", err)
+	}
+	if log, _ := r.Log(ctx, "feature", 10); len(log) != 2 || log[0].Subject != "second" {
+		t.Errorf("log of feature = %+v", log)
+	}
+	if err := r.Checkout(ctx, "--orphan", false); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Checkout of an option = %v, want ErrInvalidName", err)
+	}
+	if _, err := r.Commit(ctx, "nothing", me); err == nil {
+		t.Error("Commit with nothing staged succeeded")
+	}
+}
+
+func TestRepoRemotes(t *testing.T) {
+	r, dir := newTestRepo(t)
+	ctx := context.Background()
+	me := Identity{Name: "ada", Email: "ada@example.com"}
+
+	bare := t.TempDir()
+	if _, err := New(dirRunner(bare)).git(ctx, "init", "-q", "--bare"); err != nil {
+		t.Fatalf("init --bare: %v", err)
+	}
+	r.Init(ctx)
+	if err := r.AddRemote(ctx, "origin", bare); err != nil {
+		t.Fatalf("AddRemote: %v", err)
+	}
+	if remotes, err := r.Remotes(ctx); err != nil || len(remotes) != 1 || remotes[0].Url != bare {
+		t.Fatalf("Remotes = %+v, %v", remotes, err)
+	}
+	writeFile(t, dir, "a.txt", "a\n")
+	r.Stage(ctx, nil)
+	r.Commit(ctx, "first", me)
+	if err := r.Push(ctx, "origin", DefaultBranch, true); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	// A second clone pushes a commit, which the first then pulls.
+	other, otherDir := newTestRepo(t)
+	other.Init(ctx)
+	other.AddRemote(ctx, "origin", bare)
+	if err := other.Pull(ctx, "origin", DefaultBranch); err != nil {
+		t.Fatalf("Pull into an empty repository: %v", err)
+	}
+	writeFile(t, otherDir, "b.txt", "b\n")
+	other.Stage(ctx, nil)
+	other.Commit(ctx, "second", me)
+	if err := other.Push(ctx, "origin", DefaultBranch, false); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	if err := r.Fetch(ctx, "origin"); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	st, _ := r.Status(ctx)
+	if st.Upstream != "origin/"+DefaultBranch || st.Behind != 1 || st.Ahead != 0 {
+		t.Errorf("status after fetch = %+v", st)
+	}
+	if err := r.Pull(ctx, "origin", DefaultBranch); err != nil {
+		t.Fatalf("Pull: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.txt")); err != nil {
+		t.Errorf("pulled file missing: %v", err)
+	}
+	branches, _ := r.Branches(ctx)
+	var remote bool
+	for _, b := range branches {
+		if b.Name == "origin/"+DefaultBranch && b.Remote {
+			remote = true
+		}
+		if b.Name == DefaultBranch && b.Upstream != "origin/"+DefaultBranch {
+			t.Errorf("upstream of %s = %q", b.Name, b.Upstream)
+		}
+	}
+	if !remote {
+		t.Errorf("no remote tracking branch in %+v", branches)
+	}
+}
@@ -0,0 +1,133 @@
+package git
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// States of a file in the index or the working tree.
+const (
+	StateAdded      = "added"
+	StateModified   = "modified"
+	StateDeleted    = "deleted"
+	StateRenamed    = "renamed"
+	StateCopied     = "copied"
+	StateTypeChange = "typechange"
+	StateUntracked  = "untracked"
+	StateUnmerged   = "unmerged"
+)
+
+// Status is the state of the repository: the current branch and how it
+// compares to its upstream, and every file that differs from HEAD.
+type Status struct {
+	// Branch is empty on a detached HEAD, Commit before the first commit.
+	Branch   string       `json:"branch,omitempty"`
+	Commit   string       `json:"commit,omitempty"`
+	Upstream string       `json:"upstream,omitempty"`
+	Ahead    int          `json:"ahead"`
+	Behind   int          `json:"behind"`
+	Files    []FileStatus `json:"files"`
+}
+
+// FileStatus is a changed file. Staged is its state in the index relative
+// to HEAD, Unstaged that of the working tree relative to the index; either
+// is empty when there is no change. Untracked files are only unstaged.
+type FileStatus struct {
+	Path       string `json:"path"`
+	OldPath    string `json:"oldPath,omitempty"`
+	Staged     string `json:"staged,omitempty"`
+	Unstaged   string `json:"unstaged,omitempty"`
+	Conflicted bool   `json:"conflicted,omitempty"`
+}
+
+func (r *Repo) Status(ctx context.Context) (*Status, error) {
+	out, err := r.git(ctx, "status", "--porcelain=v2", "--branch", "--untracked-files=all", "-z")
+	if err != nil {
+		return nil, err
+	}
+	return parseStatus(out), nil
+}
+
+// states maps the XY letters of porcelain status to states.
+var states = map[byte]string{
+	'A': StateAdded,
+	'M': StateModified,
+	'D': StateDeleted,
+	'R': StateRenamed,
+	'C': StateCopied,
+	'T': StateTypeChange,
+	'U': StateUnmerged,
+}
+
+// parseStatus parses the output of git status --porcelain=v2 --branch -z.
+// Entries end in NUL, and the original path of a rename follows as an
+// entry of its own.
+func parseStatus(out string) *Status {
+	st := &Status{Files: []FileStatus{}}
+	entries := strings.Split(out, "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if entry == "" {
+			continue
+		}
+		switch entry[0] {
+		case '#':
+			parseBranchHeader(st, entry)
+		case '1':
+			// 1 XY sub mH mI mW hH hI path
+			if fields := strings.SplitN(entry, " ", 9); len(fields) == 9 {
+				st.Files = append(st.Files, changedFile(fields[1], fields[8]))
+			}
+		case '2':
+			// 2 XY sub mH mI mW hH hI Xscore path, then origPath
+			if fields := strings.SplitN(entry, " ", 10); len(fields) == 10 {
+				f := changedFile(fields[1], fields[9])
+				if i+1 < len(entries) {
+					i++
+					f.OldPath = entries[i]
+				}
+				st.Files = append(st.Files, f)
+			}
+		case 'u':
+			// u XY sub m1 m2 m3 mW h1 h2 h3 path
+			if fields := strings.SplitN(entry, " ", 11); len(fields) == 11 {
+				f := changedFile(fields[1], fields[10])
+				f.Conflicted = true
+				st.Files = append(st.Files, f)
+			}
+		case '?':
+			st.Files = append(st.Files, FileStatus{Path: entry[2:], Unstaged: StateUntracked})
+		}
+	}
+	return st
+}
+
+func changedFile(xy, path string) FileStatus {
+	f := FileStatus{Path: path}
+	if len(xy) == 2 {
+		f.Staged = states[xy[0]]
+		f.Unstaged = states[xy[1]]
+	}
+	return f
+}
+
+func parseBranchHeader(st *Status, entry string) {
+	key, value, _ := strings.Cut(strings.TrimPrefix(entry, "# "), " ")
+	switch key {
+	case "branch.oid":
+		if value != "(initial)" {
+			st.Commit = value
+		}
+	case "branch.head":
+		if value != "(detached)" {
+			st.Branch = value
+		}
+	case "branch.upstream":
+		st.Upstream = value
+	case "branch.ab":
+		ahead, behind, _ := strings.Cut(value, " ")
+		st.Ahead, _ = strconv.Atoi(strings.TrimPrefix(ahead, "+"))
+		st.Behind, _ = strconv.Atoi(strings.TrimPrefix(behind, "-"))
+	}
+}
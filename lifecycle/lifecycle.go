@@ -0,0 +1,184 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/chrollo-lucifer-12/repl/logger"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+)
+
+type Config struct {
+	// IdleTimeout is how long a workspace may go without activity before
+	// its container is stopped.
+	IdleTimeout time.Duration
+	// DeleteAfter is how long a workspace may go without activity before
+	// its container is deleted. It must be longer than IdleTimeout.
+	DeleteAfter time.Duration
+	// Interval is how often idle workspaces are looked for.
+	Interval time.Duration
+}
+
+type workspaceState struct {
+	lastActivity time.Time
+	running      bool
+}
+
+// Manager stops and deletes workspace containers that have been idle for
+// too long. Callers report activity with Touch; the manager never starts
+// containers itself.
+type Manager struct {
+	sb  sandbox.Sandbox
+	l   logger.Logger
+	cfg Config
+	now func() time.Time
+
+	mu         sync.Mutex
+	workspaces map[string]*workspaceState
+}
+
+func NewManager(sb sandbox.Sandbox, l logger.Logger, cfg Config) *Manager {
+	return &Manager{
+		sb:         sb,
+		l:          l,
+		cfg:        cfg,
+		now:        time.Now,
+		workspaces: map[string]*workspaceState{},
+	}
+}
+
+// Started records that the workspace container is running and active.
+func (m *Manager) Started(workspaceId string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.workspaces[workspaceId] = &workspaceState{lastActivity: m.now(), running: true}
+}
+
+// Touch records activity on a workspace. Workspaces the manager does not
+// know about are ignored.
+func (m *Manager) Touch(workspaceId string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if state, ok := m.workspaces[workspaceId]; ok {
+		state.lastActivity = m.now()
+	}
+}
+
+// Running reports whether the manager believes the workspace is running.
+func (m *Manager) Running(workspaceId string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.workspaces[workspaceId]
+	return ok && state.running
+}
+
+// Reconcile adopts the containers the runtime already has, e.g. after a
+// server restart. Their idle clock starts now.
+func (m *Manager) Reconcile(ctx context.Context) error {
+	statuses, err := m.sb.Reconcile(ctx)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	known := map[string]bool{}
+	for _, status := range statuses {
+		known[status.WorkspaceId] = true
+		if state, ok := m.workspaces[status.WorkspaceId]; ok {
+			state.running = status.Running
+			continue
+		}
+		m.workspaces[status.WorkspaceId] = &workspaceState{
+			lastActivity: m.now(),
+			running:      status.Running,
+		}
+	}
+	for workspaceId := range m.workspaces {
+		if !known[workspaceId] {
+			delete(m.workspaces, workspaceId)
+		}
+	}
+	return nil
+}
+
+// Sweep stops workspaces idle for longer than IdleTimeout and deletes those
+// idle for longer than DeleteAfter.
+func (m *Manager) Sweep(ctx context.Context) {
+	now := m.now()
+	var toStop, toDelete []string
+
+	m.mu.Lock()
+	for workspaceId, state := range m.workspaces {
+		idle := now.Sub(state.lastActivity)
+		switch {
+		case idle >= m.cfg.DeleteAfter:
+			toDelete = append(toDelete, workspaceId)
+			delete(m.workspaces, workspaceId)
+		case state.running && idle >= m.cfg.IdleTimeout:
+			toStop = append(toStop, workspaceId)
+			state.running = false
+		}
+	}
+	m.mu.Unlock()
+
+	for _, workspaceId := range toStop {
+		if err := m.sb.RemoveContainer(ctx, workspaceId); err != nil {
+			m.l.Error("failed to stop idle workspace", "workspace", workspaceId, "error", err)
+			continue
+		}
+		m.l.Info("stopped idle workspace", "workspace", workspaceId)
+	}
+	for _, workspaceId := range toDelete {
+		if err := m.sb.DeleteContainer(ctx, workspaceId); err != nil {
+			m.l.Error("failed to delete idle workspace", "workspace", workspaceId, "error", err)
+			continue
+		}
+		m.l.Info("deleted idle workspace", "workspace", workspaceId)
+	}
+}
+
+// StopAll stops every running workspace, e.g. when the server shuts down.
+// The workspaces stay known so they are deleted once idle for DeleteAfter.
+func (m *Manager) StopAll(ctx context.Context) error {
+	var toStop []string
+	m.mu.Lock()
+	for workspaceId, state := range m.workspaces {
+		if state.running {
+			toStop = append(toStop, workspaceId)
+			state.running = false
+		}
+	}
+	m.mu.Unlock()
+
+	var errs []error
+	for _, workspaceId := range toStop {
+		if err := m.sb.RemoveContainer(ctx, workspaceId); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		m.l.Info("stopped workspace", "workspace", workspaceId)
+	}
+	return errors.Join(errs...)
+}
+
+// Run reconciles with the runtime and then sweeps every Interval until ctx
+// is cancelled.
+func (m *Manager) Run(ctx context.Context) {
+	if err := m.Reconcile(ctx); err != nil {
+		m.l.Error("failed to reconcile workspaces", "error", err)
+	}
+
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Sweep(ctx)
+		}
+	}
+}
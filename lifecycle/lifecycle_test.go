@@ -0,0 +1,103 @@
+package lifecycle
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/chrollo-lucifer-12/repl/local"
+	"github.com/chrollo-lucifer-12/repl/logger"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+)
+
+func TestManagerStopsThenDeletesIdleWorkspaces(t *testing.T) {
+	sb, err := local.NewLocalSandbox("")
+	if err != nil {
+		t.Fatalf("failed to create sandbox: %v", err)
+	}
+	defer sb.Stop()
+	ctx := context.Background()
+
+	m := NewManager(sb, logger.NewSlogLogger(), Config{
+		IdleTimeout: 10 * time.Minute,
+		DeleteAfter: time.Hour,
+		Interval:    time.Minute,
+	})
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	sb.StartContainer(ctx, io.Discard, "busy", sandbox.ContainerSpec{})
+	sb.StartContainer(ctx, io.Discard, "idle", sandbox.ContainerSpec{})
+	m.Started("busy")
+	m.Started("idle")
+
+	now = now.Add(9 * time.Minute)
+	m.Touch("busy")
+	now = now.Add(2 * time.Minute)
+	m.Sweep(ctx)
+
+	if !m.Running("busy") {
+		t.Error("recently used workspace was stopped")
+	}
+	if m.Running("idle") {
+		t.Error("idle workspace was not stopped")
+	}
+	if err := sb.ExecCommand(ctx, "idle", []string{"true"}, nil); err == nil {
+		t.Error("expected exec in a stopped workspace to fail")
+	}
+
+	now = now.Add(time.Hour)
+	m.Sweep(ctx)
+	statuses, _ := sb.Reconcile(ctx)
+	if len(statuses) != 0 {
+		t.Errorf("expected both workspaces to be deleted, got %+v", statuses)
+	}
+}
+
+func TestManagerReconcileAdoptsExistingContainers(t *testing.T) {
+	sb, err := local.NewLocalSandbox("")
+	if err != nil {
+		t.Fatalf("failed to create sandbox: %v", err)
+	}
+	defer sb.Stop()
+	ctx := context.Background()
+
+	sb.StartContainer(ctx, io.Discard, "1", sandbox.ContainerSpec{})
+	m := NewManager(sb, logger.NewSlogLogger(), Config{IdleTimeout: time.Minute, DeleteAfter: time.Hour})
+	m.Started("gone")
+
+	if err := m.Reconcile(ctx); err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	if !m.Running("1") {
+		t.Error("existing container was not adopted")
+	}
+	if m.Running("gone") {
+		t.Error("workspace without a container was kept")
+	}
+}
+
+func TestManagerStopAll(t *testing.T) {
+	sb, err := local.NewLocalSandbox("")
+	if err != nil {
+		t.Fatalf("failed to create sandbox: %v", err)
+	}
+	defer sb.Stop()
+	ctx := context.Background()
+
+	m := NewManager(sb, logger.NewSlogLogger(), Config{IdleTimeout: time.Hour, DeleteAfter: 24 * time.Hour})
+	for _, ws := range []string{"1", "2"} {
+		sb.StartContainer(ctx, io.Discard, ws, sandbox.ContainerSpec{})
+		m.Started(ws)
+	}
+	if err := m.StopAll(ctx); err != nil {
+		t.Fatalf("StopAll: %v", err)
+	}
+	if m.Running("1") || m.Running("2") {
+		t.Error("expected every workspace to be stopped")
+	}
+	if err := sb.ExecCommand(ctx, "1", []string{"true"}, nil); err == nil {
+		t.Error("expected exec in a stopped workspace to fail")
+	}
+}
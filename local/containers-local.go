@@ -0,0 +1,56 @@
+package local
+
+import (
+	"context"
+	"errors"
+
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+)
+
+func (l *LocalSandbox) RemoveContainer(ctx context.Context, workspaceId string) error {
+	w, err := l.lookup(workspaceId)
+	if err != nil {
+		return err
+	}
+	l.killAll(workspaceId)
+	w.running.Store(false)
+	return nil
+}
+
+// DeleteContainer forgets the workspace but, like the bind mount of the
+// Docker runtime, leaves its files on disk.
+func (l *LocalSandbox) DeleteContainer(ctx context.Context, workspaceId string) error {
+	if _, ok := l.workspaces.Load(workspaceId); !ok {
+		return &sandbox.ContainerError{Op: "delete", WorkspaceId: workspaceId, Err: sandbox.ErrContainerGone}
+	}
+	l.killAll(workspaceId)
+	l.workspaces.Delete(workspaceId)
+	return nil
+}
+
+// Reconcile reports the workspaces of this process; there is nothing that
+// outlives it to reconcile against.
+func (l *LocalSandbox) Reconcile(ctx context.Context) ([]sandbox.ContainerStatus, error) {
+	statuses := []sandbox.ContainerStatus{}
+	l.workspaces.Range(func(key, value any) bool {
+		w := value.(*localWorkspace)
+		statuses = append(statuses, sandbox.ContainerStatus{
+			WorkspaceId: key.(string),
+			ContainerId: "local-" + key.(string),
+			Running:     w.running.Load(),
+			CreatedAt:   w.createdAt,
+		})
+		return true
+	})
+	return statuses, nil
+}
+
+// CommitContainer is unsupported: the local sandbox has no container
+// filesystem besides the workspace files.
+func (l *LocalSandbox) CommitContainer(ctx context.Context, workspaceId, ref string) (string, error) {
+	return "", &sandbox.ContainerError{Op: "commit", WorkspaceId: workspaceId, Err: errors.ErrUnsupported}
+}
+
+func (l *LocalSandbox) RemoveImage(ctx context.Context, ref string) error {
+	return &sandbox.ContainerError{Op: "remove image", WorkspaceId: ref, Err: errors.ErrUnsupported}
+}
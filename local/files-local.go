@@ -0,0 +1,190 @@
+package local
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+)
+
+// fileError reports err against the client path rather than the host path
+// so host directories never leak to clients.
+func fileError(op, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	var linkErr *os.LinkError
+	if errors.As(err, &linkErr) {
+		err = linkErr.Err
+	}
+	return &sandbox.FileError{Op: op, Path: path, Err: err}
+}
+
+func (l *LocalSandbox) WriteFile(
+	ctx context.Context,
+	workspaceId, path string,
+	content []byte,
+) error {
+	hostPath, err := l.resolve(workspaceId, "write", path)
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(hostPath); err == nil && fi.IsDir() {
+		return &sandbox.FileError{Op: "write", Path: path, Err: sandbox.ErrIsDirectory}
+	}
+	return fileError("write", path, os.WriteFile(hostPath, content, 0644))
+}
+
+func (l *LocalSandbox) ReadFile(ctx context.Context, workspaceId, path string) ([]byte, error) {
+	hostPath, err := l.resolve(workspaceId, "read", path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(hostPath)
+	if err != nil {
+		return nil, fileError("read", path, err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fileError("read", path, err)
+	}
+	if fi.IsDir() {
+		return nil, &sandbox.FileError{Op: "read", Path: path, Err: sandbox.ErrIsDirectory}
+	}
+	if fi.Size() > sandbox.MaxFileSize {
+		return nil, &sandbox.FileError{Op: "read", Path: path, Err: sandbox.ErrFileTooLarge}
+	}
+	content, err := io.ReadAll(io.LimitReader(f, sandbox.MaxFileSize))
+	return content, fileError("read", path, err)
+}
+
+func (l *LocalSandbox) CreateDir(ctx context.Context, workspaceId, path string) error {
+	hostPath, err := l.resolve(workspaceId, "mkdir", path)
+	if err != nil {
+		return err
+	}
+	return fileError("mkdir", path, os.MkdirAll(hostPath, 0755))
+}
+
+func (l *LocalSandbox) RemoveFile(ctx context.Context, workspaceId, path string) error {
+	hostPath, err := l.resolve(workspaceId, "remove", path)
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(hostPath); err == nil && fi.IsDir() {
+		return &sandbox.FileError{Op: "remove", Path: path, Err: sandbox.ErrIsDirectory}
+	}
+	if err := os.Remove(hostPath); err != nil && !os.IsNotExist(err) {
+		return fileError("remove", path, err)
+	}
+	return nil
+}
+
+func (l *LocalSandbox) ListFiles(ctx context.Context, workspaceId, path string) ([]sandbox.FileInfo, error) {
+	hostPath, err := l.resolve(workspaceId, "list", path)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(hostPath)
+	if err != nil {
+		return nil, fileError("list", path, err)
+	}
+
+	files := []sandbox.FileInfo{}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		fileType := "file"
+		if info.IsDir() {
+			fileType = "dir"
+		}
+		files = append(files, sandbox.FileInfo{
+			Name: info.Name(),
+			Type: fileType,
+			Size: info.Size(),
+			Mode: info.Mode().String(),
+		})
+	}
+	return files, nil
+}
+
+func (l *LocalSandbox) StatFile(ctx context.Context, workspaceId, path string) (*sandbox.FileInfo, error) {
+	hostPath, err := l.resolve(workspaceId, "stat", path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Lstat(hostPath)
+	if err != nil {
+		return nil, fileError("stat", path, err)
+	}
+
+	// Mirror the %F format of stat(1) used by the Docker runtime.
+	fileType := "regular file"
+	switch {
+	case fi.IsDir():
+		fileType = "directory"
+	case fi.Mode()&os.ModeSymlink != 0:
+		fileType = "symbolic link"
+	case fi.Size() == 0:
+		fileType = "regular empty file"
+	}
+
+	return &sandbox.FileInfo{
+		Name: path,
+		Type: fileType,
+		Size: fi.Size(),
+		Mode: fmt.Sprintf("%o", fi.Mode().Perm()),
+	}, nil
+}
+
+func (l *LocalSandbox) SearchInFile(ctx context.Context, workspaceId, filePath, search string) ([]sandbox.SearchMatch, error) {
+	hostPath, err := l.resolve(workspaceId, "search", filePath)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(hostPath)
+	if err != nil {
+		return nil, fileError("search", filePath, err)
+	}
+	defer f.Close()
+
+	matches := []sandbox.SearchMatch{}
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		if strings.Contains(scanner.Text(), search) {
+			matches = append(matches, sandbox.SearchMatch{Line: lineNo, Text: scanner.Text()})
+		}
+	}
+	return matches, fileError("search", filePath, scanner.Err())
+}
+
+func (l *LocalSandbox) RenameFileDir(ctx context.Context, workspaceId, path string, newName string) error {
+	oldPath, err := l.resolve(workspaceId, "rename", path)
+	if err != nil {
+		return err
+	}
+	newPath, err := l.resolve(workspaceId, "rename", newName)
+	if err != nil {
+		return err
+	}
+	if hostDir, _ := l.workspace(workspaceId); oldPath == hostDir {
+		return &sandbox.FileError{Op: "rename", Path: path, Err: sandbox.ErrPathOutsideWorkspace}
+	}
+	return fileError("rename", path, os.Rename(oldPath, newPath))
+}
@@ -0,0 +1,126 @@
+package local
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+)
+
+// LocalSandbox is an in-process Sandbox that backs every workspace with a
+// directory under root and runs commands on the host with os/exec. It is
+// meant for tests and local development without a Docker daemon.
+type LocalSandbox struct {
+	root       string
+	ownsRoot   bool
+	workspaces sync.Map
+	terminals  sync.Map
+	processes  sync.Map
+	nextProc   atomic.Uint64
+}
+
+var _ sandbox.Sandbox = (*LocalSandbox)(nil)
+
+type localWorkspace struct {
+	hostDir   string
+	createdAt time.Time
+	running   atomic.Bool
+}
+
+// NewLocalSandbox creates a sandbox rooted at root. An empty root creates a
+// temporary directory that is removed again by Stop.
+func NewLocalSandbox(root string) (*LocalSandbox, error) {
+	ownsRoot := false
+	if root == "" {
+		dir, err := os.MkdirTemp("", "repl-sandbox-")
+		if err != nil {
+			return nil, err
+		}
+		root = dir
+		ownsRoot = true
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &LocalSandbox{root: root, ownsRoot: ownsRoot}, nil
+}
+
+func (l *LocalSandbox) Root() string {
+	return l.root
+}
+
+func (l *LocalSandbox) Stop() error {
+	if l.ownsRoot {
+		return os.RemoveAll(l.root)
+	}
+	return nil
+}
+
+// StartContainer creates the workspace directory. The image of spec is
+// ignored; commands run on the host, so it is reported ready right away.
+func (l *LocalSandbox) StartContainer(ctx context.Context, outputWriter io.Writer, workspaceId string, spec sandbox.ContainerSpec) (string, error) {
+	if existing, ok := l.workspaces.Load(workspaceId); ok {
+		existing.(*localWorkspace).running.Store(true)
+		return "local-" + workspaceId, nil
+	}
+
+	hostDir := filepath.Join(l.root, workspaceId)
+	if err := os.MkdirAll(hostDir, 0755); err != nil {
+		return "", &sandbox.ContainerError{Op: "start", WorkspaceId: workspaceId, Err: err}
+	}
+	if spec.Progress != nil {
+		spec.Progress(sandbox.PullProgress{Image: spec.Image, Status: sandbox.PullReady, Percent: 100})
+	}
+	w := &localWorkspace{hostDir: hostDir, createdAt: time.Now()}
+	w.running.Store(true)
+	l.workspaces.Store(workspaceId, w)
+	return "local-" + workspaceId, nil
+}
+
+func (l *LocalSandbox) lookup(workspaceId string) (*localWorkspace, error) {
+	w, ok := l.workspaces.Load(workspaceId)
+	if !ok {
+		return nil, &sandbox.ContainerError{Op: "lookup", WorkspaceId: workspaceId, Err: sandbox.ErrContainerGone}
+	}
+	return w.(*localWorkspace), nil
+}
+
+// workspace returns the host directory of a workspace. Like files in a
+// stopped container, it stays reachable while the workspace is stopped.
+func (l *LocalSandbox) workspace(workspaceId string) (string, error) {
+	w, err := l.lookup(workspaceId)
+	if err != nil {
+		return "", err
+	}
+	return w.hostDir, nil
+}
+
+func (l *LocalSandbox) HostDir(workspaceId string) (string, error) {
+	return l.workspace(workspaceId)
+}
+
+// WorkDir is the host directory, as commands run on the host.
+func (l *LocalSandbox) WorkDir(workspaceId string) (string, error) {
+	return l.workspace(workspaceId)
+}
+
+// resolve maps a path as the container would see it onto the host
+// directory of the workspace, rejecting paths outside the workspace.
+func (l *LocalSandbox) resolve(workspaceId, op, path string) (string, error) {
+	hostDir, err := l.workspace(workspaceId)
+	if err != nil {
+		return "", err
+	}
+	containerDir := sandbox.WorkspaceDir
+	resolved, err := sandbox.ResolvePath(containerDir, path)
+	if err != nil {
+		return "", &sandbox.FileError{Op: op, Path: path, Err: sandbox.ErrPathOutsideWorkspace}
+	}
+	return filepath.Join(hostDir, filepath.FromSlash(strings.TrimPrefix(resolved, containerDir))), nil
+}
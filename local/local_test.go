@@ -0,0 +1,319 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+)
+
+func newTestSandbox(t *testing.T) *LocalSandbox {
+	t.Helper()
+	l, err := NewLocalSandbox("")
+	if err != nil {
+		t.Fatalf("failed to create sandbox: %v", err)
+	}
+	t.Cleanup(func() { l.Stop() })
+	if _, err := l.StartContainer(context.Background(), io.Discard, "1", sandbox.ContainerSpec{}); err != nil {
+		t.Fatalf("failed to start container: %v", err)
+	}
+	return l
+}
+
+func TestLocalSandboxFiles(t *testing.T) {
+	l := newTestSandbox(t)
+	ctx := context.Background()
+
+	if err := l.CreateDir(ctx, "1", "/workspace/src"); err != nil {
+		t.Fatalf("CreateDir: %v", err)
+	}
+	if err := l.WriteFile(ctx, "1", "src/index.js", []byte("console.log('hi')\n")); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	content, err := l.ReadFile(ctx, "1", "/workspace/src/index.js")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if string(content) != "console.log('hi')\n" {
+		t.Errorf("unexpected content %q", content)
+	}
+
+	files, err := l.ListFiles(ctx, "1", "src")
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if len(files) != 1 || files[0].Name != "index.js" || files[0].Type != "file" {
+		t.Errorf("unexpected listing %+v", files)
+	}
+
+	matches, err := l.SearchInFile(ctx, "1", "src/index.js", "log")
+	if err != nil {
+		t.Fatalf("SearchInFile: %v", err)
+	}
+	if len(matches) != 1 || matches[0].Line != 1 {
+		t.Errorf("unexpected search result %+v", matches)
+	}
+
+	if err := l.RenameFileDir(ctx, "1", "src/index.js", "src/main.js"); err != nil {
+		t.Fatalf("RenameFileDir: %v", err)
+	}
+	info, err := l.StatFile(ctx, "1", "src/main.js")
+	if err != nil {
+		t.Fatalf("StatFile: %v", err)
+	}
+	if info.Type != "regular file" {
+		t.Errorf("unexpected stat result %+v", info)
+	}
+
+	if err := l.RemoveFile(ctx, "1", "src/main.js"); err != nil {
+		t.Fatalf("RemoveFile: %v", err)
+	}
+	if _, err := l.StatFile(ctx, "1", "src/main.js"); err == nil {
+		t.Error("expected stat of removed file to fail")
+	}
+}
+
+func TestLocalSandboxConfinesPaths(t *testing.T) {
+	l := newTestSandbox(t)
+	ctx := context.Background()
+
+	for _, path := range []string{"../../escape.txt", "/etc/passwd", "/workspacex/x", "a/../../x"} {
+		err := l.WriteFile(ctx, "1", path, []byte("x"))
+		if !errors.Is(err, sandbox.ErrPathOutsideWorkspace) {
+			t.Errorf("WriteFile(%q): expected ErrPathOutsideWorkspace, got %v", path, err)
+		}
+	}
+	files, err := l.ListFiles(ctx, "1", "")
+	if err != nil {
+		t.Fatalf("ListFiles: %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected nothing to be written, got %+v", files)
+	}
+
+	if _, err := l.ReadFile(ctx, "1", "missing.txt"); !errors.Is(err, sandbox.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := l.ReadFile(ctx, "1", "/workspace"); !errors.Is(err, sandbox.ErrIsDirectory) {
+		t.Errorf("expected ErrIsDirectory, got %v", err)
+	}
+	if err := l.WriteFile(ctx, "1", "blob.bin", []byte{0xff, 0x00, 0xfe}); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if content, err := l.ReadFile(ctx, "1", "blob.bin"); err != nil || !bytes.Equal(content, []byte{0xff, 0x00, 0xfe}) {
+		t.Errorf("expected binary content to round-trip, got %v, %v", content, err)
+	}
+}
+
+func TestLocalSandboxExec(t *testing.T) {
+	l := newTestSandbox(t)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	if err := l.ExecCommand(ctx, "1", []string{"sh", "-c", "echo out; echo err >&2"}, &buf); err != nil {
+		t.Fatalf("ExecCommand: %v", err)
+	}
+	if !strings.Contains(buf.String(), "out") || !strings.Contains(buf.String(), "err") {
+		t.Errorf("unexpected exec output %q", buf.String())
+	}
+
+	if err := l.ExecCommand(ctx, "2", []string{"true"}, nil); err == nil {
+		t.Error("expected exec in unknown workspace to fail")
+	}
+
+	res, err := l.Exec(ctx, "1", []string{"sh", "-c", "echo out; echo err >&2; exit 7"})
+	if err != nil {
+		t.Fatalf("Exec: %v", err)
+	}
+	if res.ExitCode != 7 || string(res.Stdout) != "out\n" || string(res.Stderr) != "err\n" {
+		t.Errorf("unexpected exec result %+v", res)
+	}
+}
+
+func TestLocalSandboxInteractiveRepl(t *testing.T) {
+	l := newTestSandbox(t)
+	ctx := context.Background()
+
+	pr, pw := io.Pipe()
+	out := &syncBuffer{}
+	repl, err := l.StartInteractiveRepl(ctx, "1", sandbox.TerminalSize{Rows: 24, Cols: 80}, pr, out)
+	if err != nil {
+		t.Fatalf("StartInteractiveRepl: %v", err)
+	}
+
+	pw.Write([]byte("echo repl-$((40+2))\n"))
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), "repl-42") {
+		if time.Now().After(deadline) {
+			t.Fatalf("repl output never arrived: %q", out.String())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if err := l.ResizeTerminal(ctx, "1", repl.ExecId, sandbox.TerminalSize{Rows: 40, Cols: 120}); err != nil {
+		t.Errorf("ResizeTerminal: %v", err)
+	}
+	if err := l.ResizeTerminal(ctx, "2", repl.ExecId, sandbox.TerminalSize{Rows: 40, Cols: 120}); err == nil {
+		t.Error("expected resize from another workspace to fail")
+	}
+	err = l.ResizeTerminal(ctx, "1", repl.ExecId, sandbox.TerminalSize{Rows: 0, Cols: 120})
+	if !errors.Is(err, sandbox.ErrInvalidTerminalSize) {
+		t.Errorf("expected ErrInvalidTerminalSize, got %v", err)
+	}
+
+	second, err := l.StartInteractiveRepl(ctx, "1", sandbox.TerminalSize{Rows: 24, Cols: 80}, nil, io.Discard)
+	if err != nil {
+		t.Fatalf("StartInteractiveRepl: %v", err)
+	}
+	if err := l.KillTerminal(ctx, "1", second.ExecId); err != nil {
+		t.Fatalf("KillTerminal: %v", err)
+	}
+	select {
+	case <-second.Done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("killed repl did not exit")
+	}
+
+	pw.Write([]byte("exit\n"))
+	select {
+	case <-repl.Done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("repl did not exit")
+	}
+}
+
+func TestLocalSandboxProcesses(t *testing.T) {
+	l := newTestSandbox(t)
+	ctx := context.Background()
+
+	out := &syncBuffer{}
+	proc, err := l.StartLongRunningProcess(ctx, "1", []string{"sh", "-c", "echo started; exit 3"}, out)
+	if err != nil {
+		t.Fatalf("StartLongRunningProcess: %v", err)
+	}
+	select {
+	case <-proc.Done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not exit")
+	}
+	status, err := l.InspectProcess(ctx, "1", proc.ExecId)
+	if err != nil || status.Running || status.ExitCode != 3 {
+		t.Errorf("expected exit code 3, got %+v, %v", status, err)
+	}
+	if !strings.Contains(out.String(), "started") {
+		t.Errorf("unexpected process output %q", out.String())
+	}
+
+	// Stopping kills the children of the process too.
+	proc, err = l.StartLongRunningProcess(ctx, "1", []string{"sh", "-c", "sleep 60 & wait"}, nil)
+	if err != nil {
+		t.Fatalf("StartLongRunningProcess: %v", err)
+	}
+	if status, err := l.InspectProcess(ctx, "1", proc.ExecId); err != nil || !status.Running || status.Pid == 0 {
+		t.Errorf("expected a running process, got %+v, %v", status, err)
+	}
+	if err := l.StopProcess(ctx, "1", proc.ExecId); err != nil {
+		t.Fatalf("StopProcess: %v", err)
+	}
+	select {
+	case <-proc.Done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopped process did not exit")
+	}
+
+	if _, err := l.InspectProcess(ctx, "2", proc.ExecId); !errors.Is(err, sandbox.ErrNotFound) {
+		t.Errorf("expected processes of another workspace to be hidden, got %v", err)
+	}
+}
+
+func TestLocalSandboxPipedProcess(t *testing.T) {
+	l := newTestSandbox(t)
+	ctx := context.Background()
+
+	pr, pw := io.Pipe()
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	proc, err := l.StartPipedProcess(ctx, "1", []string{"sh", "-c", "read line; echo out-$line; echo err >&2; cat >/dev/null"}, pr, stdout, stderr)
+	if err != nil {
+		t.Fatalf("StartPipedProcess: %v", err)
+	}
+	pw.Write([]byte("ping\n"))
+	deadline := time.Now().Add(5 * time.Second)
+	for stdout.String() != "out-ping\n" || stderr.String() != "err\n" {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected output %q, %q", stdout.String(), stderr.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Closing the input ends the process.
+	pw.Close()
+	select {
+	case <-proc.Done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not exit on EOF")
+	}
+
+	hostDir, _ := l.HostDir("1")
+	if dir, err := l.WorkDir("1"); err != nil || dir != hostDir {
+		t.Errorf("WorkDir = %q, %v, want %q", dir, err, hostDir)
+	}
+}
+
+func TestLocalSandboxListPorts(t *testing.T) {
+	if _, err := exec.LookPath("python3"); err != nil {
+		t.Skip("python3 is needed to listen on a port")
+	}
+	l := newTestSandbox(t)
+	ctx := context.Background()
+
+	script := "import socket,time\ns=socket.socket()\ns.bind(('0.0.0.0',0))\ns.listen()\nprint(s.getsockname()[1],flush=True)\ntime.sleep(60)"
+	out := &syncBuffer{}
+	proc, err := l.StartLongRunningProcess(ctx, "1", []string{"python3", "-c", script}, out)
+	if err != nil {
+		t.Fatalf("StartLongRunningProcess: %v", err)
+	}
+	defer l.StopProcess(ctx, "1", proc.ExecId)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), "\n") {
+		if time.Now().After(deadline) {
+			t.Fatal("process never reported its port")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	port, _ := strconv.Atoi(strings.TrimSpace(out.String()))
+
+	ports, err := l.ListPorts(ctx, "1")
+	if err != nil {
+		t.Fatalf("ListPorts: %v", err)
+	}
+	if len(ports) != 1 || ports[0].Port != port || ports[0].Loopback {
+		t.Errorf("expected port %d to be listed, got %+v", port, ports)
+	}
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
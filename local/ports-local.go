@@ -0,0 +1,95 @@
+package local
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+)
+
+// ListPorts finds the listening sockets held by processes of the
+// workspace. Workspaces share the host network, so sockets are matched to
+// processes by inode: terminal shells lead a session of their own and
+// background processes a process group, which everything they start
+// inherits.
+func (l *LocalSandbox) ListPorts(ctx context.Context, workspaceId string) ([]sandbox.ListeningPort, error) {
+	if _, err := l.lookup(workspaceId); err != nil {
+		return nil, err
+	}
+	sessions := map[int]bool{}
+	l.terminals.Range(func(key, value any) bool {
+		if term := value.(*localTerminal); term.workspaceId == workspaceId {
+			sessions[term.cmd.Process.Pid] = true
+		}
+		return true
+	})
+	groups := map[int]bool{}
+	l.processes.Range(func(key, value any) bool {
+		if p := value.(*localProcess); p.workspaceId == workspaceId {
+			groups[p.cmd.Process.Pid] = true
+		}
+		return true
+	})
+
+	inodes := map[string]bool{}
+	pids, _ := filepath.Glob("/proc/[0-9]*")
+	for _, dir := range pids {
+		pgrp, sid, ok := procGroups(dir)
+		if !ok || !(sessions[sid] || groups[pgrp]) {
+			continue
+		}
+		fds, _ := os.ReadDir(filepath.Join(dir, "fd"))
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(dir, "fd", fd.Name()))
+			if err != nil {
+				continue
+			}
+			if inode, ok := strings.CutPrefix(link, "socket:["); ok {
+				inodes[strings.TrimSuffix(inode, "]")] = true
+			}
+		}
+	}
+
+	var data []byte
+	for _, name := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		if b, err := os.ReadFile(name); err == nil {
+			data = append(data, b...)
+		}
+	}
+	return sandbox.ParseListeningPorts(data, inodes), nil
+}
+
+// procGroups reads the process group and session of a process from its
+// /proc/<pid>/stat.
+func procGroups(dir string) (int, int, bool) {
+	stat, err := os.ReadFile(filepath.Join(dir, "stat"))
+	if err != nil {
+		return 0, 0, false
+	}
+	// The command name may contain spaces, so split after its closing
+	// parenthesis: state ppid pgrp session ...
+	i := strings.LastIndexByte(string(stat), ')')
+	if i < 0 {
+		return 0, 0, false
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	if len(fields) < 4 {
+		return 0, 0, false
+	}
+	pgrp, err1 := strconv.Atoi(fields[2])
+	sid, err2 := strconv.Atoi(fields[3])
+	return pgrp, sid, err1 == nil && err2 == nil
+}
+
+// PortAddress returns the loopback address of the port; processes of a
+// local workspace run on the host.
+func (l *LocalSandbox) PortAddress(ctx context.Context, workspaceId string, port int) (string, error) {
+	if _, err := l.lookup(workspaceId); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), nil
+}
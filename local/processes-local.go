@@ -0,0 +1,175 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+)
+
+// localProcess is a background process. It stays known after exiting so
+// its exit code can be inspected, like an exec in Docker.
+type localProcess struct {
+	workspaceId string
+	cmd         *exec.Cmd
+	done        chan struct{}
+	exitCode    int
+}
+
+func (l *LocalSandbox) command(ctx context.Context, workspaceId string, cmd []string) (*exec.Cmd, error) {
+	w, err := l.lookup(workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	if !w.running.Load() {
+		return nil, fmt.Errorf("container is not running")
+	}
+	if len(cmd) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	c := exec.CommandContext(ctx, cmd[0], cmd[1:]...)
+	c.Dir = w.hostDir
+	c.Env = append(os.Environ(), "HOME="+w.hostDir)
+	return c, nil
+}
+
+// ExecCommand runs cmd to completion with stdout and stderr combined, the
+// same way the TTY exec of the Docker runtime does. A non-zero exit status
+// is not an error.
+func (l *LocalSandbox) ExecCommand(ctx context.Context, workspaceId string, cmd []string, outputWriter io.Writer) error {
+	c, err := l.command(ctx, workspaceId, cmd)
+	if err != nil {
+		return err
+	}
+	if outputWriter != nil {
+		c.Stdout = outputWriter
+		c.Stderr = outputWriter
+	}
+	err = c.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return nil
+	}
+	return err
+}
+
+func (l *LocalSandbox) Exec(ctx context.Context, workspaceId string, cmd []string) (*sandbox.ExecResult, error) {
+	c, err := l.command(ctx, workspaceId, cmd)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	err = c.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, err
+	}
+	return &sandbox.ExecResult{ExitCode: c.ProcessState.ExitCode(), Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}, nil
+}
+
+func (l *LocalSandbox) StartLongRunningProcess(ctx context.Context, workspaceId string, cmd []string, outputWriter io.Writer) (*sandbox.Process, error) {
+	return l.startProcess(workspaceId, cmd, nil, outputWriter, outputWriter)
+}
+
+func (l *LocalSandbox) StartPipedProcess(ctx context.Context, workspaceId string, cmd []string, input io.Reader, stdout, stderr io.Writer) (*sandbox.Process, error) {
+	return l.startProcess(workspaceId, cmd, input, stdout, stderr)
+}
+
+func (l *LocalSandbox) startProcess(workspaceId string, cmd []string, input io.Reader, stdout, stderr io.Writer) (*sandbox.Process, error) {
+	c, err := l.command(context.Background(), workspaceId, cmd)
+	if err != nil {
+		return nil, err
+	}
+	c.Stdout = stdout
+	c.Stderr = stderr
+	// Input is copied by hand: exec.Cmd would wait for the copy to end,
+	// which it never does while input stays open after the process exits.
+	var stdin io.WriteCloser
+	if input != nil {
+		if stdin, err = c.StdinPipe(); err != nil {
+			return nil, err
+		}
+	}
+	// A process group of its own lets StopProcess kill everything the
+	// process started.
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	if stdin != nil {
+		go func() {
+			io.Copy(stdin, input)
+			stdin.Close()
+		}()
+	}
+
+	execId := fmt.Sprintf("local-exec-%d", l.nextProc.Add(1))
+	p := &localProcess{workspaceId: workspaceId, cmd: c, done: make(chan struct{})}
+	l.processes.Store(execId, p)
+	go func() {
+		defer close(p.done)
+		c.Wait()
+		p.exitCode = c.ProcessState.ExitCode()
+	}()
+
+	return &sandbox.Process{ExecId: execId, Done: p.done}, nil
+}
+
+func (l *LocalSandbox) process(workspaceId, execId string) (*localProcess, error) {
+	p, ok := l.processes.Load(execId)
+	if !ok || p.(*localProcess).workspaceId != workspaceId {
+		return nil, fmt.Errorf("process %s: %w", execId, sandbox.ErrNotFound)
+	}
+	return p.(*localProcess), nil
+}
+
+func (l *LocalSandbox) InspectProcess(ctx context.Context, workspaceId, execId string) (*sandbox.ProcessStatus, error) {
+	p, err := l.process(workspaceId, execId)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-p.done:
+		return &sandbox.ProcessStatus{ExitCode: p.exitCode, Pid: p.cmd.Process.Pid}, nil
+	default:
+		return &sandbox.ProcessStatus{Running: true, Pid: p.cmd.Process.Pid}, nil
+	}
+}
+
+func (l *LocalSandbox) StopProcess(ctx context.Context, workspaceId, execId string) error {
+	p, err := l.process(workspaceId, execId)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-p.done:
+	default:
+		syscall.Kill(-p.cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return nil
+}
+
+func (l *LocalSandbox) killAll(workspaceId string) {
+	l.terminals.Range(func(key, value any) bool {
+		if term := value.(*localTerminal); term.workspaceId == workspaceId {
+			term.kill()
+		}
+		return true
+	})
+	l.processes.Range(func(key, value any) bool {
+		p := value.(*localProcess)
+		if p.workspaceId == workspaceId {
+			syscall.Kill(-p.cmd.Process.Pid, syscall.SIGKILL)
+			l.processes.Delete(key)
+		}
+		return true
+	})
+}
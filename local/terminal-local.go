@@ -0,0 +1,108 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+	"github.com/creack/pty"
+)
+
+type localTerminal struct {
+	workspaceId string
+	cmd         *exec.Cmd
+	pty         *os.File
+}
+
+// kill signals the process group of the shell; pty.Start runs it in a
+// session of its own, so this reaches everything started from it.
+func (t *localTerminal) kill() {
+	syscall.Kill(-t.cmd.Process.Pid, syscall.SIGKILL)
+}
+
+func (l *LocalSandbox) StartInteractiveRepl(
+	ctx context.Context,
+	workspaceId string,
+	size sandbox.TerminalSize,
+	input io.Reader,
+	output io.Writer,
+) (*sandbox.Repl, error) {
+	if !size.Valid() {
+		return nil, sandbox.ErrInvalidTerminalSize
+	}
+	c, err := l.command(context.Background(), workspaceId, []string{"sh"})
+	if err != nil {
+		return nil, err
+	}
+
+	ptmx, err := pty.StartWithSize(c, &pty.Winsize{
+		Rows: uint16(size.Rows),
+		Cols: uint16(size.Cols),
+	})
+	if err != nil {
+		return nil, err
+	}
+	execId := fmt.Sprintf("local-term-%d", l.nextProc.Add(1))
+	l.terminals.Store(execId, &localTerminal{workspaceId: workspaceId, cmd: c, pty: ptmx})
+
+	go func() {
+		if input != nil {
+			io.Copy(ptmx, input)
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			l.terminals.Delete(execId)
+			ptmx.Close()
+			c.Process.Kill()
+			c.Wait()
+		}()
+		if output == nil {
+			output = io.Discard
+		}
+		// Reading the pty master fails with EIO once the shell exits.
+		_, _ = io.Copy(output, ptmx)
+	}()
+
+	return &sandbox.Repl{ExecId: execId, Done: done}, nil
+}
+
+func (l *LocalSandbox) terminal(workspaceId, execId string) (*localTerminal, error) {
+	term, ok := l.terminals.Load(execId)
+	if !ok || term.(*localTerminal).workspaceId != workspaceId {
+		return nil, fmt.Errorf("terminal not started")
+	}
+	return term.(*localTerminal), nil
+}
+
+func (l *LocalSandbox) ResizeTerminal(ctx context.Context,
+	workspaceId, execId string, size sandbox.TerminalSize) error {
+	term, err := l.terminal(workspaceId, execId)
+	if err != nil {
+		return err
+	}
+	if !size.Valid() {
+		return sandbox.ErrInvalidTerminalSize
+	}
+
+	return pty.Setsize(term.pty, &pty.Winsize{
+		Rows: uint16(size.Rows),
+		Cols: uint16(size.Cols),
+	})
+}
+
+func (l *LocalSandbox) KillTerminal(ctx context.Context, workspaceId, execId string) error {
+	term, err := l.terminal(workspaceId, execId)
+	if err != nil {
+		return err
+	}
+	term.kill()
+	return nil
+}
@@ -0,0 +1,136 @@
+// Package lsp carries Language Server Protocol messages between clients
+// and language servers: the base protocol framing of stdio, enough of
+// JSON-RPC to route messages, and rewriting of file URIs between the
+// paths a client sees and those the server sees.
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// MaxMessageSize bounds the content of a message read by ReadMessage.
+const MaxMessageSize = 32 << 20
+
+var (
+	ErrInvalidHeader   = errors.New("invalid message header")
+	ErrMessageTooLarge = errors.New("message too large")
+)
+
+// ReadMessage reads the content of the next message framed with a
+// Content-Length header.
+func ReadMessage(r *bufio.Reader) ([]byte, error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		if errors.Is(err, io.EOF) && len(header) == 0 {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("%w: %w", ErrInvalidHeader, err)
+	}
+	raw := header.Get("Content-Length")
+	length, err := strconv.Atoi(strings.TrimSpace(raw))
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("%w: Content-Length %q", ErrInvalidHeader, raw)
+	}
+	if length > MaxMessageSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, length)
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// WriteMessage writes msg framed with a Content-Length header in a single
+// Write, so concurrent writers serialised by the caller never interleave.
+func WriteMessage(w io.Writer, msg []byte) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "Content-Length: %d\r\n\r\n", len(msg))
+	buf.Write(msg)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// Message is a JSON-RPC 2.0 request, notification or response. Params,
+// Result and Error are left encoded, as they are only passed on.
+type Message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *ResponseError  `json:"error,omitempty"`
+}
+
+type ResponseError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// Error codes of JSON-RPC and LSP.
+const (
+	CodeInvalidRequest  = -32600
+	CodeMethodNotFound  = -32601
+	CodeInternalError   = -32603
+	CodeRequestFailed   = -32803
+	CodeRequestCanceled = -32800
+)
+
+func (m *Message) hasID() bool {
+	return len(m.ID) > 0 && string(m.ID) != "null"
+}
+
+// IsRequest reports whether m is a request, which expects a response.
+func (m *Message) IsRequest() bool {
+	return m.Method != "" && m.hasID()
+}
+
+func (m *Message) IsNotification() bool {
+	return m.Method != "" && !m.hasID()
+}
+
+func (m *Message) IsResponse() bool {
+	return m.Method == "" && len(m.ID) > 0
+}
+
+// Parse decodes a message, failing for anything that is neither a
+// request, a notification nor a response.
+func Parse(data []byte) (*Message, error) {
+	var m Message
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if !m.IsRequest() && !m.IsNotification() && !m.IsResponse() {
+		return nil, errors.New("not a JSON-RPC message")
+	}
+	return &m, nil
+}
+
+// Encode encodes m. A successful response always carries a result, if
+// only null.
+func (m *Message) Encode() ([]byte, error) {
+	m.JSONRPC = "2.0"
+	if m.IsResponse() && m.Error == nil && len(m.Result) == 0 {
+		m.Result = json.RawMessage("null")
+	}
+	return json.Marshal(m)
+}
+
+// NewResponse returns the successful response to the request with id.
+func NewResponse(id json.RawMessage, result json.RawMessage) *Message {
+	return &Message{ID: id, Result: result}
+}
+
+// NewErrorResponse returns a failed response to the request with id.
+func NewErrorResponse(id json.RawMessage, code int, message string) *Message {
+	return &Message{ID: id, Error: &ResponseError{Code: code, Message: message}}
+}
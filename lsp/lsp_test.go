@@ -0,0 +1,137 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFraming(t *testing.T) {
+	var buf bytes.Buffer
+	msgs := []string{`{"jsonrpc":"2.0","method":"a"}`, `{"jsonrpc":"2.0","id":1,"result":"é"}`}
+	for _, msg := range msgs {
+		if err := WriteMessage(&buf, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.HasPrefix(buf.String(), "Content-Length: 30\r\n\r\n{") {
+		t.Errorf("framing = %q", buf.String())
+	}
+	r := bufio.NewReader(&buf)
+	for _, want := range msgs {
+		got, err := ReadMessage(r)
+		if err != nil || string(got) != want {
+			t.Fatalf("ReadMessage = %q, %v, want %q", got, err, want)
+		}
+	}
+	if _, err := ReadMessage(r); err != io.EOF {
+		t.Errorf("ReadMessage at the end = %v, want io.EOF", err)
+	}
+
+	// Other headers are allowed.
+	in := "Content-Type: application/vscode-jsonrpc; charset=utf-8\r\nContent-Length: 2\r\n\r\n{}"
+	if got, err := ReadMessage(bufio.NewReader(strings.NewReader(in))); err != nil || string(got) != "{}" {
+		t.Errorf("ReadMessage with Content-Type = %q, %v", got, err)
+	}
+	for _, in := range []string{"Content-Length: x\r\n\r\n", "Foo: 1\r\n\r\n{}", "garbage\r\n\r\n"} {
+		if _, err := ReadMessage(bufio.NewReader(strings.NewReader(in))); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("ReadMessage(%q) = %v, want ErrInvalidHeader", in, err)
+		}
+	}
+	in = "Content-Length: 999999999\r\n\r\n"
+	if _, err := ReadMessage(bufio.NewReader(strings.NewReader(in))); !errors.Is(err, ErrMessageTooLarge) {
+		t.Errorf("ReadMessage of a huge message = %v, want ErrMessageTooLarge", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in                              string
+		request, notification, response bool
+	}{
+		{`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`, true, false, false},
+		{`{"jsonrpc":"2.0","id":"a","method":"shutdown"}`, true, false, false},
+		{`{"jsonrpc":"2.0","method":"initialized","params":{}}`, false, true, false},
+		{`{"jsonrpc":"2.0","id":1,"result":null}`, false, false, true},
+		{`{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"parse error"}}`, false, false, true},
+	}
+	for _, tt := range tests {
+		m, err := Parse([]byte(tt.in))
+		if err != nil {
+			t.Errorf("Parse(%s): %v", tt.in, err)
+			continue
+		}
+		if m.IsRequest() != tt.request || m.IsNotification() != tt.notification || m.IsResponse() != tt.response {
+			t.Errorf("Parse(%s) = request %v, notification %v, response %v", tt.in, m.IsRequest(), m.IsNotification(), m.IsResponse())
+		}
+	}
+	if _, err := Parse([]byte(`{"jsonrpc":"2.0"}`)); err == nil {
+		t.Error("Parse of an empty message succeeded")
+	}
+
+	m, _ := Parse([]byte(`{"jsonrpc":"2.0","id":7,"result":null}`))
+	m.ID = json.RawMessage("3")
+	if out, err := m.Encode(); err != nil || string(out) != `{"jsonrpc":"2.0","id":3,"result":null}` {
+		t.Errorf("Encode = %s, %v", out, err)
+	}
+}
+
+func TestRewriter(t *testing.T) {
+	r := NewRewriter("/workspace", "/home/ws 1")
+	in := `{"jsonrpc":"2.0","id":12345678901234567890,"method":"x","params":{` +
+		`"rootUri":"file:///workspace","rootPath":"/workspace","uri":"file:///workspace/src/a.ts",` +
+		`"other":"file:///workspace2/b.ts","text":"file:///workspace/c.ts","html":"<a>",` +
+		`"changes":{"file:///workspace/src/a.ts":[{"newText":"file:///workspace/d.ts"}]},` +
+		`"locations":[{"uri":"file:///workspace/e.ts#L1"}]}}`
+	out, err := r.Rewrite([]byte(in))
+	if err != nil {
+		t.Fatalf("Rewrite: %v", err)
+	}
+	var got struct {
+		ID     json.Number `json:"id"`
+		Params struct {
+			RootUri   string                         `json:"rootUri"`
+			RootPath  string                         `json:"rootPath"`
+			Uri       string                         `json:"uri"`
+			Other     string                         `json:"other"`
+			Text      string                         `json:"text"`
+			Html      string                         `json:"html"`
+			Changes   map[string][]map[string]string `json:"changes"`
+			Locations []struct{ Uri string }         `json:"locations"`
+		} `json:"params"`
+	}
+	d := json.NewDecoder(bytes.NewReader(out))
+	d.UseNumber()
+	if err := d.Decode(&got); err != nil {
+		t.Fatalf("rewritten message %s: %v", out, err)
+	}
+	p := got.Params
+	if got.ID != "12345678901234567890" {
+		t.Errorf("id = %s", got.ID)
+	}
+	if p.RootUri != "file:///home/ws%201" || p.RootPath != "/home/ws 1" || p.Uri != "file:///home/ws%201/src/a.ts" {
+		t.Errorf("root and uri = %q %q %q", p.RootUri, p.RootPath, p.Uri)
+	}
+	if p.Other != "file:///workspace2/b.ts" || p.Text != "file:///workspace/c.ts" || p.Html != "<a>" {
+		t.Errorf("untouched strings = %q %q %q", p.Other, p.Text, p.Html)
+	}
+	edits, ok := p.Changes["file:///home/ws%201/src/a.ts"]
+	if !ok || edits[0]["newText"] != "file:///workspace/d.ts" {
+		t.Errorf("changes = %v", p.Changes)
+	}
+	if p.Locations[0].Uri != "file:///home/ws%201/e.ts#L1" {
+		t.Errorf("location = %q", p.Locations[0].Uri)
+	}
+	if !bytes.Contains(out, []byte(`"html":"<a>"`)) {
+		t.Errorf("HTML was escaped: %s", out)
+	}
+
+	back, err := NewRewriter("/home/ws 1", "/workspace").Rewrite(out)
+	if err != nil || !bytes.Contains(back, []byte(`"uri":"file:///workspace/src/a.ts"`)) {
+		t.Errorf("rewriting back = %s, %v", back, err)
+	}
+}
@@ -0,0 +1,94 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// Rewriter moves file URIs, and the paths of the deprecated rootPath,
+// from one directory to another, such as from the workspace directory a
+// client sees to the one a language server runs in. Only whole strings are
+// rewritten, so URIs mentioned inside text stay as they are, and document
+// contents are never touched, as changing their length would break the
+// positions of later edits.
+type Rewriter struct {
+	from, to       string
+	fromURI, toURI string
+}
+
+// NewRewriter returns a Rewriter from the absolute directory from to to.
+func NewRewriter(from, to string) *Rewriter {
+	return &Rewriter{from: from, to: to, fromURI: FileURI(from), toURI: FileURI(to)}
+}
+
+// FileURI returns the file URI of an absolute path.
+func FileURI(dir string) string {
+	return (&url.URL{Scheme: "file", Path: dir}).String()
+}
+
+// textKeys hold document contents.
+var textKeys = map[string]bool{"text": true, "newText": true}
+
+// Rewrite rewrites the URIs of an encoded message.
+func (r *Rewriter) Rewrite(msg []byte) ([]byte, error) {
+	if r.from == r.to {
+		return msg, nil
+	}
+	d := json.NewDecoder(bytes.NewReader(msg))
+	// Numbers stay exactly as they were sent.
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	e := json.NewEncoder(&buf)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(r.walk("", v)); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func (r *Rewriter) walk(key string, v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		// Objects keyed by URI, such as the changes of a WorkspaceEdit,
+		// get their keys rewritten too.
+		out := make(map[string]any, len(v))
+		for k, elem := range v {
+			out[r.uri(k)] = r.walk(k, elem)
+		}
+		return out
+	case []any:
+		for i, elem := range v {
+			v[i] = r.walk(key, elem)
+		}
+		return v
+	case string:
+		switch {
+		case textKeys[key]:
+			return v
+		case key == "rootPath":
+			return r.path(v)
+		}
+		return r.uri(v)
+	}
+	return v
+}
+
+func (r *Rewriter) uri(s string) string {
+	if rest, ok := strings.CutPrefix(s, r.fromURI); ok && (rest == "" || rest[0] == '/' || rest[0] == '#' || rest[0] == '?') {
+		return r.toURI + rest
+	}
+	return s
+}
+
+func (r *Rewriter) path(s string) string {
+	if rest, ok := strings.CutPrefix(s, r.from); ok && (rest == "" || rest[0] == '/') {
+		return r.to + rest
+	}
+	return s
+}
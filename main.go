@@ -1,24 +1,82 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/chrollo-lucifer-12/repl/config"
 	"github.com/chrollo-lucifer-12/repl/db"
 	"github.com/chrollo-lucifer-12/repl/docker"
-	"github.com/chrollo-lucifer-12/repl/env"
+	"github.com/chrollo-lucifer-12/repl/lifecycle"
 	"github.com/chrollo-lucifer-12/repl/logger"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
 	"github.com/chrollo-lucifer-12/repl/server"
+	"github.com/chrollo-lucifer-12/repl/templates"
 )
 
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	l := logger.NewSlogLogger()
-	d := docker.NewDockerClient()
-	e := env.Load()
-	if e == nil {
-		l.Error("no env")
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		l.Error("error loading config", "error", err)
+		os.Exit(2)
+	}
+	d, err := docker.NewDockerClient(cfg.Docker)
+	if err != nil {
+		l.Error("error creating docker client", "error", err)
+		return
+	}
+	defer d.Stop()
+	db, err := db.NewDB(cfg.Database, l)
+	if err != nil {
+		l.Error("error connecting to the database", "error", err)
+		return
 	}
-	db := db.NewDB(e, l)
-	s := server.NewServer(l, d, db)
-	err := s.Start()
+	lc := lifecycle.NewManager(d, l, lifecycle.Config{
+		IdleTimeout: cfg.Workspace.IdleTimeout,
+		DeleteAfter: cfg.Workspace.DeleteAfter,
+		Interval:    cfg.Workspace.ReapInterval,
+	})
+	go lc.Run(ctx)
+	t, err := templates.Builtin()
 	if err != nil {
-		l.Error("error starting server ", err)
+		l.Error("error loading templates", "error", err)
+		return
+	}
+	p := sandbox.DefaultPolicy()
+	if cfg.Sandbox.PolicyFile != "" {
+		if p, err = sandbox.LoadPolicy(cfg.Sandbox.PolicyFile); err != nil {
+			l.Error("error loading sandbox policy", "file", cfg.Sandbox.PolicyFile, "error", err)
+			return
+		}
+	}
+	go d.RunPool(ctx, docker.PoolConfig{
+		Images:          p.Images,
+		Size:            cfg.Pool.Size,
+		RefreshInterval: cfg.Pool.RefreshInterval,
+	}, l)
+	s := server.NewServer(l, d, db, lc, t, p, cfg)
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.Start() }()
+	select {
+	case err := <-errc:
+		if err != nil {
+			l.Error("error starting server", "addr", cfg.Server.Addr, "error", err)
+		}
+		return
+	case <-ctx.Done():
+	}
+
+	l.Info("shutting down", "timeout", cfg.Shutdown.Timeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Shutdown.Timeout)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		l.Error("error shutting down", "error", err)
 	}
 }
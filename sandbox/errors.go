@@ -0,0 +1,54 @@
+package sandbox
+
+import (
+	"errors"
+	"io/fs"
+)
+
+var (
+	// ErrNotFound is fs.ErrNotExist so callers can match errors from any
+	// runtime, including plain os errors, with errors.Is.
+	ErrNotFound             = fs.ErrNotExist
+	ErrPathOutsideWorkspace = errors.New("path is outside the workspace")
+	ErrIsDirectory          = errors.New("path is a directory")
+	ErrFileTooLarge         = errors.New("file is too large")
+	ErrInvalidTerminalSize  = errors.New("invalid terminal size")
+
+	// Container runtime failures, wrapped in a *ContainerError.
+	ErrContainerGone     = errors.New("container was deleted")
+	ErrImageNotFound     = errors.New("image not found")
+	ErrDaemonUnavailable = errors.New("container runtime is unavailable")
+	ErrQuotaExceeded     = errors.New("resource quota exceeded")
+)
+
+// FileError records a failed file operation on a workspace path.
+type FileError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return e.Op + " " + e.Path + ": " + e.Err.Error()
+}
+
+func (e *FileError) Unwrap() error {
+	return e.Err
+}
+
+// ContainerError records a failed container runtime operation on a
+// workspace. Err matches one of the runtime failure errors with errors.Is
+// when the cause is known.
+type ContainerError struct {
+	Op          string
+	WorkspaceId string
+	Err         error
+}
+
+func (e *ContainerError) Error() string {
+	return e.Op + " " + e.WorkspaceId + ": " + e.Err.Error()
+}
+
+func (e *ContainerError) Unwrap() error {
+	return e.Err
+}
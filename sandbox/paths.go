@@ -0,0 +1,34 @@
+package sandbox
+
+import (
+	"path"
+	"strings"
+)
+
+// MaxFileSize caps the size of a single file read through the file API.
+const MaxFileSize = 16 << 20
+
+// WorkspaceDir is the directory a workspace is mounted at inside its
+// container, and the root every file API path is confined to. It is the
+// same for every workspace so containers can be created before they are
+// bound to one.
+const WorkspaceDir = "/workspace"
+
+// ResolvePath turns a client supplied path into an absolute path inside
+// root. Relative paths are taken relative to root; absolute paths must
+// already lie within it. Anything that would escape root is rejected.
+func ResolvePath(root, p string) (string, error) {
+	if strings.ContainsRune(p, 0) {
+		return "", &FileError{Op: "resolve", Path: p, Err: ErrPathOutsideWorkspace}
+	}
+	root = path.Clean(root)
+	resolved := p
+	if !path.IsAbs(resolved) {
+		resolved = path.Join(root, resolved)
+	}
+	resolved = path.Clean(resolved)
+	if resolved != root && !strings.HasPrefix(resolved, root+"/") {
+		return "", &FileError{Op: "resolve", Path: p, Err: ErrPathOutsideWorkspace}
+	}
+	return resolved, nil
+}
@@ -0,0 +1,33 @@
+package sandbox
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResolvePath(t *testing.T) {
+	cases := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"", "/home/1", true},
+		{".", "/home/1", true},
+		{"src/index.js", "/home/1/src/index.js", true},
+		{"/home/1/src/../index.js", "/home/1/index.js", true},
+		{"my file; rm -rf ~", "/home/1/my file; rm -rf ~", true},
+		{"../2/secret", "", false},
+		{"/home/12", "", false},
+		{"/etc/passwd", "", false},
+		{"a\x00b", "", false},
+	}
+	for _, tc := range cases {
+		got, err := ResolvePath("/home/1", tc.path)
+		if tc.ok && (err != nil || got != tc.want) {
+			t.Errorf("ResolvePath(%q) = %q, %v; want %q", tc.path, got, err, tc.want)
+		}
+		if !tc.ok && !errors.Is(err, ErrPathOutsideWorkspace) {
+			t.Errorf("ResolvePath(%q) = %q, %v; want ErrPathOutsideWorkspace", tc.path, got, err)
+		}
+	}
+}
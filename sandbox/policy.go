@@ -0,0 +1,135 @@
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+)
+
+var (
+	ErrImageNotAllowed = errors.New("image is not allowed")
+	ErrUnknownProfile  = errors.New("unknown resource profile")
+)
+
+// Resources limits what a workspace container may use. Zero fields are
+// left unlimited.
+type Resources struct {
+	// Memory and MemorySwap are in bytes; MemorySwap is the total of memory
+	// and swap, as in docker run --memory-swap.
+	Memory     int64 `json:"memory"`
+	MemorySwap int64 `json:"memorySwap"`
+	// CPUQuota is in microseconds per 100ms period, so 100000 is one CPU.
+	CPUQuota  int64 `json:"cpuQuota"`
+	CPUShares int64 `json:"cpuShares"`
+	PidsLimit int64 `json:"pidsLimit"`
+	// DiskQuota caps the container's writable layer in bytes. It needs a
+	// storage driver that supports size limits.
+	DiskQuota int64 `json:"diskQuota"`
+}
+
+// Policy is the admin-defined allowlist of base images and resource
+// profiles users may pick from when creating a project.
+type Policy struct {
+	Images         []string             `json:"images"`
+	Profiles       map[string]Resources `json:"profiles"`
+	DefaultProfile string               `json:"defaultProfile"`
+}
+
+// DefaultPolicy allows the images of the built-in templates and offers
+// three profiles; small matches the limits containers always had.
+func DefaultPolicy() *Policy {
+	return &Policy{
+		Images: []string{DefaultImage, "python:3.12-bullseye"},
+		Profiles: map[string]Resources{
+			"small": {
+				Memory:     512 << 20,
+				MemorySwap: 512 << 20,
+				CPUQuota:   50000,
+				CPUShares:  512,
+				PidsLimit:  256,
+			},
+			"medium": {
+				Memory:     1 << 30,
+				MemorySwap: 1 << 30,
+				CPUQuota:   100000,
+				CPUShares:  1024,
+				PidsLimit:  512,
+			},
+			"large": {
+				Memory:     2 << 30,
+				MemorySwap: 2 << 30,
+				CPUQuota:   200000,
+				CPUShares:  2048,
+				PidsLimit:  1024,
+			},
+		},
+		DefaultProfile: "small",
+	}
+}
+
+// LoadPolicy reads a policy from a JSON file.
+func LoadPolicy(path string) (*Policy, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Policy
+	if err := json.Unmarshal(raw, &p); err != nil {
+		return nil, fmt.Errorf("parse policy %s: %w", path, err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("policy %s: %w", path, err)
+	}
+	return &p, nil
+}
+
+func (p *Policy) Validate() error {
+	if len(p.Images) == 0 {
+		return errors.New("no images allowed")
+	}
+	if _, ok := p.Profiles[p.DefaultProfile]; !ok {
+		return fmt.Errorf("default profile %q is not defined", p.DefaultProfile)
+	}
+	for name, r := range p.Profiles {
+		if r.Memory < 0 || r.CPUQuota < 0 || r.CPUShares < 0 || r.PidsLimit < 0 || r.DiskQuota < 0 {
+			return fmt.Errorf("profile %q: limits must not be negative", name)
+		}
+		if r.MemorySwap != 0 && r.MemorySwap != -1 && r.MemorySwap < r.Memory {
+			return fmt.Errorf("profile %q: memorySwap must be at least memory", name)
+		}
+		if r.CPUQuota != 0 && r.CPUQuota < 1000 {
+			return fmt.Errorf("profile %q: cpuQuota must be at least 1000", name)
+		}
+	}
+	return nil
+}
+
+func (p *Policy) AllowsImage(image string) bool {
+	return slices.Contains(p.Images, image)
+}
+
+// Profile returns the resources of the named profile; an empty name picks
+// the default profile.
+func (p *Policy) Profile(name string) (string, Resources, error) {
+	if name == "" {
+		name = p.DefaultProfile
+	}
+	r, ok := p.Profiles[name]
+	if !ok {
+		return "", Resources{}, fmt.Errorf("%w %q", ErrUnknownProfile, name)
+	}
+	return name, r, nil
+}
+
+// ProfileNames returns the names of all profiles, sorted.
+func (p *Policy) ProfileNames() []string {
+	names := make([]string, 0, len(p.Profiles))
+	for name := range p.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
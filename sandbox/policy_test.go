@@ -0,0 +1,61 @@
+package sandbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	p := DefaultPolicy()
+	if err := p.Validate(); err != nil {
+		t.Fatalf("default policy is invalid: %v", err)
+	}
+	if !p.AllowsImage(DefaultImage) || p.AllowsImage("alpine:latest") {
+		t.Error("unexpected image allowlist")
+	}
+	name, r, err := p.Profile("")
+	if err != nil || name != "small" || r.Memory != 512<<20 {
+		t.Errorf("expected the small default profile, got %q %+v %v", name, r, err)
+	}
+	if _, _, err := p.Profile("huge"); !errors.Is(err, ErrUnknownProfile) {
+		t.Errorf("expected ErrUnknownProfile, got %v", err)
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	p, err := LoadPolicy(write("ok.json", `{
+		"images": ["node:22"],
+		"profiles": {"tiny": {"memory": 134217728, "memorySwap": 134217728, "pidsLimit": 64}},
+		"defaultProfile": "tiny"
+	}`))
+	if err != nil {
+		t.Fatalf("LoadPolicy: %v", err)
+	}
+	if !p.AllowsImage("node:22") || p.Profiles["tiny"].PidsLimit != 64 {
+		t.Errorf("unexpected policy %+v", p)
+	}
+
+	invalid := []string{
+		`{"images": [], "profiles": {"a": {}}, "defaultProfile": "a"}`,
+		`{"images": ["x"], "profiles": {"a": {}}, "defaultProfile": "b"}`,
+		`{"images": ["x"], "profiles": {"a": {"memory": 100, "memorySwap": 50}}, "defaultProfile": "a"}`,
+		`{"images": ["x"], "profiles": {"a": {"pidsLimit": -1}}, "defaultProfile": "a"}`,
+		`{"images": ["x"], "profiles": {"a": {"cpuQuota": 10}}, "defaultProfile": "a"}`,
+	}
+	for i, content := range invalid {
+		if _, err := LoadPolicy(write("bad.json", content)); err == nil {
+			t.Errorf("expected policy %d to be rejected", i)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package sandbox
+
+import (
+	"bufio"
+	"bytes"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ListeningPort is a TCP port a process in a workspace listens on.
+// Loopback is set when it only listens on the loopback address, where the
+// preview proxy cannot reach it.
+type ListeningPort struct {
+	Port     int  `json:"port"`
+	Loopback bool `json:"loopback"`
+}
+
+// tcpListen is the socket state of a listening socket in /proc/net/tcp.
+const tcpListen = "0A"
+
+// ParseListeningPorts reads the listening sockets out of the contents of
+// /proc/net/tcp and /proc/net/tcp6. When inodes is not nil, only sockets
+// with one of those inodes are kept. Ports are sorted and listed once; a
+// port counts as loopback only if every socket on it is.
+func ParseListeningPorts(data []byte, inodes map[string]bool) []ListeningPort {
+	loopback := map[int]bool{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		// sl local_address rem_address st tx:rx tr:when retrnsmt uid timeout inode
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 10 || fields[3] != tcpListen {
+			continue
+		}
+		if inodes != nil && !inodes[fields[9]] {
+			continue
+		}
+		addr, rawPort, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		port, err := strconv.ParseUint(rawPort, 16, 16)
+		if err != nil {
+			continue
+		}
+		isLoopback := isLoopbackHex(addr)
+		if prev, seen := loopback[int(port)]; seen {
+			isLoopback = prev && isLoopback
+		}
+		loopback[int(port)] = isLoopback
+	}
+
+	ports := make([]ListeningPort, 0, len(loopback))
+	for port, isLoopback := range loopback {
+		ports = append(ports, ListeningPort{Port: port, Loopback: isLoopback})
+	}
+	slices.SortFunc(ports, func(a, b ListeningPort) int { return a.Port - b.Port })
+	return ports
+}
+
+// isLoopbackHex reports whether a hex encoded /proc/net address is a
+// loopback address. The kernel prints each 32 bit word in host byte order,
+// which is little endian on every platform the sandbox runs on.
+func isLoopbackHex(addr string) bool {
+	switch len(addr) {
+	case 8:
+		// 127.0.0.0/8: the first octet is the last byte.
+		return strings.HasSuffix(addr, "7F")
+	case 32:
+		// ::1, or ::ffff:127.x.x.x for IPv4 mapped addresses.
+		return addr == "00000000000000000000000001000000" ||
+			(strings.HasPrefix(addr, "0000000000000000FFFF0000") && strings.HasSuffix(addr, "7F"))
+	}
+	return false
+}
@@ -0,0 +1,33 @@
+package sandbox
+
+import (
+	"reflect"
+	"testing"
+)
+
+const procNetTCP = `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:BC8F 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 914 1 0000000000000000 100 0 0 10 0
+   1: 00000000:1435 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 915 1 0000000000000000 100 0 0 10 0
+   2: 0100007F:1435 0100007F:A403 01 00000000:00000000 00:00000000 00000000  1000        0 916 1 0000000000000000 20 4 30 10 -1
+  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000001000000:0BB8 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 917 1 0000000000000000 100 0 0 10 0
+   1: 00000000000000000000000000000000:BC8F 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 918 1 0000000000000000 100 0 0 10 0
+`
+
+func TestParseListeningPorts(t *testing.T) {
+	got := ParseListeningPorts([]byte(procNetTCP), nil)
+	want := []ListeningPort{
+		{Port: 3000, Loopback: true},
+		{Port: 5173},
+		{Port: 48271},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseListeningPorts = %+v, want %+v", got, want)
+	}
+
+	got = ParseListeningPorts([]byte(procNetTCP), map[string]bool{"914": true, "916": true})
+	want = []ListeningPort{{Port: 48271, Loopback: true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseListeningPorts with inodes = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,29 @@
+package sandbox
+
+// Image pull statuses. A pull reports PullPulling until it ends with
+// exactly one PullReady or PullFailed.
+const (
+	PullPulling = "pulling"
+	PullReady   = "ready"
+	PullFailed  = "failed"
+)
+
+// LayerProgress is the progress of a single image layer. Current and Total
+// are bytes of the current phase, downloading or extracting.
+type LayerProgress struct {
+	Id      string `json:"id"`
+	Status  string `json:"status"`
+	Current int64  `json:"current,omitempty"`
+	Total   int64  `json:"total,omitempty"`
+	Percent int    `json:"percent"`
+}
+
+// PullProgress reports the preparation of the image of a new workspace
+// container. Percent aggregates the progress of every layer.
+type PullProgress struct {
+	Image   string          `json:"image"`
+	Status  string          `json:"status"`
+	Percent int             `json:"percent"`
+	Layers  []LayerProgress `json:"layers,omitempty"`
+	Error   string          `json:"error,omitempty"`
+}
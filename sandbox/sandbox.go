@@ -0,0 +1,147 @@
+package sandbox
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+type FileInfo struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+	Mode string `json:"mode"`
+}
+
+// ContainerStatus describes a workspace container known to the runtime.
+type ContainerStatus struct {
+	WorkspaceId string    `json:"workspaceId"`
+	ContainerId string    `json:"containerId"`
+	Running     bool      `json:"running"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+type SearchMatch struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// DefaultImage is the container image used when a ContainerSpec names none.
+const DefaultImage = "node:20-bullseye"
+
+// ContainerSpec describes the container a workspace gets when it is first
+// started. It is ignored when the workspace already has a container.
+type ContainerSpec struct {
+	Image     string
+	Resources Resources
+	// Progress, if set, receives the progress of preparing Image, ending
+	// with a PullReady or PullFailed report. Without it a short summary
+	// is written to the output writer instead.
+	Progress func(PullProgress)
+}
+
+// MaxTerminalDimension bounds the rows and columns of a terminal.
+const MaxTerminalDimension = 1000
+
+// TerminalSize is the size of a terminal in character cells.
+type TerminalSize struct {
+	Rows int `json:"rows"`
+	Cols int `json:"cols"`
+}
+
+// Valid reports whether both dimensions lie within 1..MaxTerminalDimension.
+func (s TerminalSize) Valid() bool {
+	return s.Rows > 0 && s.Rows <= MaxTerminalDimension &&
+		s.Cols > 0 && s.Cols <= MaxTerminalDimension
+}
+
+// ExecResult is the outcome of a command run to completion by Exec.
+type ExecResult struct {
+	ExitCode int
+	Stdout   []byte
+	Stderr   []byte
+}
+
+// Repl is an interactive shell started by StartInteractiveRepl.
+type Repl struct {
+	// ExecId identifies the shell to ResizeTerminal and KillTerminal.
+	ExecId string
+	// Done is closed once the shell has exited and its output is drained.
+	Done <-chan struct{}
+}
+
+// Process is a background process started by StartLongRunningProcess.
+type Process struct {
+	// ExecId identifies the process to InspectProcess and StopProcess.
+	ExecId string
+	// Done is closed once the process has exited and its output is drained.
+	Done <-chan struct{}
+}
+
+// ProcessStatus is the state of a background process. ExitCode is only
+// meaningful once the process is no longer running.
+type ProcessStatus struct {
+	Running  bool
+	ExitCode int
+	Pid      int
+}
+
+// Sandbox is the container runtime the server talks to. Every operation is
+// keyed by a workspace id, the id of the db.Project the workspace belongs
+// to, so one user can run several isolated projects side by side.
+//
+// File operations take paths relative to WorkspaceDir, or absolute paths
+// inside it, and fail with a *FileError wrapping ErrPathOutsideWorkspace
+// for anything else. Runtime failures come back as a *ContainerError.
+type Sandbox interface {
+	StartContainer(ctx context.Context, outputWriter io.Writer, workspaceId string, spec ContainerSpec) (string, error)
+	RemoveContainer(ctx context.Context, workspaceId string) error
+	DeleteContainer(ctx context.Context, workspaceId string) error
+	Reconcile(ctx context.Context) ([]ContainerStatus, error)
+	// CommitContainer saves the filesystem of the workspace container,
+	// without the workspace files, as the image ref and returns its id.
+	// Runtimes without images fail with errors.ErrUnsupported.
+	CommitContainer(ctx context.Context, workspaceId, ref string) (string, error)
+	// RemoveImage removes an image made by CommitContainer.
+	RemoveImage(ctx context.Context, ref string) error
+
+	// ExecCommand runs cmd with a TTY, streaming its combined output.
+	ExecCommand(ctx context.Context, workspaceId string, cmd []string, outputWriter io.Writer) error
+	// Exec runs cmd without a TTY and collects stdout, stderr and the exit
+	// status separately. A non-zero exit status is not an error.
+	Exec(ctx context.Context, workspaceId string, cmd []string) (*ExecResult, error)
+	StartInteractiveRepl(ctx context.Context, workspaceId string, size TerminalSize, input io.Reader, output io.Writer) (*Repl, error)
+	StartLongRunningProcess(ctx context.Context, workspaceId string, cmd []string, outputWriter io.Writer) (*Process, error)
+	// StartPipedProcess starts cmd without a TTY, feeding it input and
+	// streaming stdout and stderr separately, for programs that speak a
+	// protocol over stdio. It is inspected and stopped like a process of
+	// StartLongRunningProcess.
+	StartPipedProcess(ctx context.Context, workspaceId string, cmd []string, input io.Reader, stdout, stderr io.Writer) (*Process, error)
+	InspectProcess(ctx context.Context, workspaceId, execId string) (*ProcessStatus, error)
+	StopProcess(ctx context.Context, workspaceId, execId string) error
+	ResizeTerminal(ctx context.Context, workspaceId, execId string, size TerminalSize) error
+	KillTerminal(ctx context.Context, workspaceId, execId string) error
+
+	// HostDir returns the directory on the server host the workspace files
+	// live in, for watching them.
+	HostDir(workspaceId string) (string, error)
+	// WorkDir returns the directory commands start in, as they see it:
+	// WorkspaceDir inside a container, the host directory otherwise.
+	WorkDir(workspaceId string) (string, error)
+	// ListPorts returns the TCP ports processes in the workspace listen on.
+	ListPorts(ctx context.Context, workspaceId string) ([]ListeningPort, error)
+	// PortAddress returns the host:port the server dials to reach port
+	// inside the workspace.
+	PortAddress(ctx context.Context, workspaceId string, port int) (string, error)
+
+	WriteFile(ctx context.Context, workspaceId, path string, content []byte) error
+	ReadFile(ctx context.Context, workspaceId, path string) ([]byte, error)
+	CreateDir(ctx context.Context, workspaceId, path string) error
+	RemoveFile(ctx context.Context, workspaceId, path string) error
+	ListFiles(ctx context.Context, workspaceId, path string) ([]FileInfo, error)
+	StatFile(ctx context.Context, workspaceId, path string) (*FileInfo, error)
+	SearchInFile(ctx context.Context, workspaceId, filePath, search string) ([]SearchMatch, error)
+	RenameFileDir(ctx context.Context, workspaceId, path string, newName string) error
+
+	Stop() error
+}
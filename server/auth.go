@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chrollo-lucifer-12/repl/db"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	sessionTTL = 24 * time.Hour
+	userKey    = "user"
+	tokenKey   = "token"
+)
+
+// bearerToken reads the session token from the Authorization header, or
+// from the token query parameter for browser WebSocket clients that cannot
+// set headers.
+func bearerToken(c *gin.Context) string {
+	if header := c.GetHeader("Authorization"); header != "" {
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if ok {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	return c.Query("token")
+}
+
+// accessLogger logs requests to out like gin.Logger, but with the token
+// query parameter redacted so session tokens never reach the log.
+func accessLogger(out io.Writer) gin.HandlerFunc {
+	return gin.LoggerWithConfig(gin.LoggerConfig{
+		Output: out,
+		Formatter: func(param gin.LogFormatterParams) string {
+			var statusColor, methodColor, resetColor string
+			if param.IsOutputColor() {
+				statusColor = param.StatusCodeColor()
+				methodColor = param.MethodColor()
+				resetColor = param.ResetColor()
+			}
+			if param.Latency > time.Minute {
+				param.Latency = param.Latency.Truncate(time.Second)
+			}
+			return fmt.Sprintf("[GIN] %v |%s %3d %s| %13v | %15s |%s %-7s %s %#v\n%s",
+				param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+				statusColor, param.StatusCode, resetColor,
+				param.Latency,
+				param.ClientIP,
+				methodColor, param.Method, resetColor,
+				redactToken(param.Path),
+				param.ErrorMessage,
+			)
+		},
+	})
+}
+
+// redactToken replaces the value of the token query parameter of a
+// request path.
+func redactToken(path string) string {
+	p, rawQuery, ok := strings.Cut(path, "?")
+	if !ok {
+		return path
+	}
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		// A query that does not parse is dropped whole rather than risk
+		// logging a token.
+		return p + "?REDACTED"
+	}
+	if !q.Has("token") {
+		return path
+	}
+	q.Set("token", "REDACTED")
+	return p + "?" + q.Encode()
+}
+
+func (s *Server) authMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.authenticate(c, bearerToken(c)) {
+			c.Next()
+		}
+	}
+}
+
+// authenticate resolves the session of token, aborting with 401 when
+// there is none.
+func (s *Server) authenticate(c *gin.Context, token string) bool {
+	if token == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing session token"})
+		return false
+	}
+	user, err := s.db.FindSession(token)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return false
+	}
+	c.Set(userKey, user)
+	c.Set(tokenKey, token)
+	return true
+}
+
+// currentUser returns the user resolved by authMiddleware.
+func currentUser(c *gin.Context) *db.CreatedUser {
+	return c.MustGet(userKey).(*db.CreatedUser)
+}
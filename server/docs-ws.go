@@ -0,0 +1,66 @@
+package server
+
+// sessionDoc returns the document of path opened by the connection.
+func (sess *wsSession) sessionDoc(p string) (*collabDoc, error) {
+	path, err := docPath(p)
+	if err != nil {
+		return nil, err
+	}
+	d := sess.s.doc(sess.workspaceId, path)
+	if d == nil {
+		return nil, &FrameError{Code: CodeNotFound, Message: path + " is not open"}
+	}
+	return d, nil
+}
+
+func (sess *wsSession) openDoc(req *Request) (any, error) {
+	var payload OpenDocPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	path, err := docPath(payload.Path)
+	if err != nil {
+		return nil, err
+	}
+	return sess.s.openDoc(sess.ctx, sess.workspaceId, path, sess.conn)
+}
+
+func (sess *wsSession) editDoc(req *Request) (any, error) {
+	var payload EditDocPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	d, err := sess.sessionDoc(payload.Path)
+	if err != nil {
+		return nil, err
+	}
+	revision, err := d.edit(sess.conn, payload.Revision, payload.Operation)
+	if err != nil {
+		return nil, err
+	}
+	return EditDocResult{Revision: revision}, nil
+}
+
+func (sess *wsSession) updateCursor(req *Request) (any, error) {
+	var payload UpdateCursorPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	d, err := sess.sessionDoc(payload.Path)
+	if err != nil {
+		return nil, err
+	}
+	return nil, d.moveCursor(sess.conn, payload.Revision, payload.Cursor)
+}
+
+func (sess *wsSession) closeDoc(req *Request) (any, error) {
+	var payload CloseDocPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	path, err := docPath(payload.Path)
+	if err != nil {
+		return nil, err
+	}
+	return nil, sess.s.closeDoc(sess.ctx, sess.workspaceId, path, sess.conn)
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/chrollo-lucifer-12/repl/collab"
+)
+
+// waitDocEvent reads frames until an event of eventType arrives and
+// decodes it into v.
+func (c *testClient) waitDocEvent(eventType string, v any) {
+	c.t.Helper()
+	for {
+		frame := c.read()
+		if frame.Kind == KindError {
+			c.t.Fatalf("doc request failed: %+v", frame.Error)
+		}
+		if frame.Kind == KindEvent && frame.Type == eventType {
+			json.Unmarshal(frame.Payload, v)
+			return
+		}
+	}
+}
+
+func TestWSCollaborativeEditing(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.cfg.Collab.FlushInterval = 20 * time.Millisecond
+	userId, token := newTestUser(t, s)
+	projectId := newTestProject(t, s, userId, "demo")
+	ws := workspaceId(projectId)
+	s.d.WriteFile(context.Background(), ws, "main.txt", []byte("hello"))
+
+	c1 := dialWS(t, ts, token, projectId)
+	c1.hello()
+	c2 := dialWS(t, ts, token, projectId)
+	c2.hello()
+
+	frame := c1.call(MsgOpenDoc, OpenDocPayload{Path: "main.txt"})
+	var opened OpenDocResult
+	json.Unmarshal(frame.Payload, &opened)
+	if frame.Kind != KindResponse || opened.Content != "hello" || opened.Revision != 0 || opened.Peer == "" || len(opened.Peers) != 0 {
+		t.Fatalf("open_doc: %+v %+v", frame.Error, opened)
+	}
+	frame = c2.call(MsgOpenDoc, OpenDocPayload{Path: "/workspace/main.txt"})
+	json.Unmarshal(frame.Payload, &opened)
+	if frame.Kind != KindResponse || len(opened.Peers) != 1 || opened.Peer == opened.Peers[0].Id {
+		t.Fatalf("second open_doc: %+v %+v", frame.Error, opened)
+	}
+	peer2 := opened.Peer
+	var joined DocPeerEvent
+	c1.waitDocEvent(EventDocPeer, &joined)
+	if joined.Peer.Id != peer2 || joined.Left || joined.Path != "/workspace/main.txt" {
+		t.Errorf("doc_peer = %+v", joined)
+	}
+
+	// Both edit revision 0; every peer gets both edits in the same order.
+	c1.send(MsgEditDoc, EditDocPayload{Path: "main.txt", Revision: 0, Operation: collab.Operation{}.Retain(5).Insert(" world")})
+	c2.send(MsgEditDoc, EditDocPayload{Path: "main.txt", Revision: 0, Operation: collab.Operation{}.Delete(1).Insert("J").Retain(4)})
+	var seen [2][]DocEditEvent
+	for i, c := range []*testClient{c1, c2} {
+		doc := []rune("hello")
+		for len(seen[i]) < 2 {
+			var event DocEditEvent
+			c.waitDocEvent(EventDocEdit, &event)
+			var err error
+			if doc, err = event.Operation.Apply(doc); err != nil {
+				t.Fatalf("applying %+v: %v", event, err)
+			}
+			seen[i] = append(seen[i], event)
+		}
+		if string(doc) != "Jello world" || seen[i][1].Revision != 2 {
+			t.Errorf("client %d has %q at %d", i+1, string(doc), seen[i][1].Revision)
+		}
+	}
+	if seen[0][0].Peer != seen[1][0].Peer {
+		t.Errorf("clients got edits in different orders: %+v %+v", seen[0], seen[1])
+	}
+
+	// A cursor given at an old revision is moved past the edits since.
+	if frame := c2.call(MsgUpdateCursor, UpdateCursorPayload{Path: "main.txt", Revision: 0, Cursor: &DocCursor{Anchor: 5, Head: 5}}); frame.Kind != KindResponse {
+		t.Fatalf("update_cursor: %+v", frame.Error)
+	}
+	var cursor DocCursorEvent
+	c1.waitDocEvent(EventDocCursor, &cursor)
+	if cursor.Peer != peer2 || cursor.Revision != 2 || cursor.Cursor == nil || cursor.Cursor.Head != 11 {
+		t.Errorf("doc_cursor = %+v %+v", cursor, cursor.Cursor)
+	}
+
+	if frame := c1.call(MsgEditDoc, EditDocPayload{Path: "main.txt", Revision: 9, Operation: collab.Operation{}.Retain(11)}); frame.Kind != KindError || frame.Error.Code != CodeStaleRevision {
+		t.Errorf("edit of an unknown revision: %+v", frame)
+	}
+	if frame := c1.call(MsgEditDoc, EditDocPayload{Path: "main.txt", Revision: 2, Operation: collab.Operation{}.Retain(3)}); frame.Kind != KindError || frame.Error.Code != CodeInvalidPayload {
+		t.Errorf("edit of the wrong length: %+v", frame)
+	}
+
+	// Edits reach the workspace on their own.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		content, _ := readWorkspaceFile(t, s, projectId, "main.txt")
+		if content == "Jello world" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("workspace file = %q", content)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// write_file replaces the open document rather than racing it.
+	if frame := c1.call(MsgWriteFile, WriteFilePayload{Path: "main.txt", Content: "replaced"}); frame.Kind != KindResponse {
+		t.Fatalf("write_file: %+v", frame.Error)
+	}
+	var replaced DocEditEvent
+	c2.waitDocEvent(EventDocEdit, &replaced)
+	if replaced.Peer != "" || replaced.Revision != 3 {
+		t.Errorf("doc_edit of write_file = %+v", replaced)
+	}
+	if content, _ := readWorkspaceFile(t, s, projectId, "main.txt"); content != "replaced" {
+		t.Errorf("workspace file after write_file = %q", content)
+	}
+
+	if frame := c2.call(MsgCloseDoc, CloseDocPayload{Path: "main.txt"}); frame.Kind != KindResponse {
+		t.Fatalf("close_doc: %+v", frame.Error)
+	}
+	var left DocPeerEvent
+	c1.waitDocEvent(EventDocPeer, &left)
+	if left.Peer.Id != peer2 || !left.Left {
+		t.Errorf("doc_peer on close = %+v", left)
+	}
+	if frame := c2.call(MsgEditDoc, EditDocPayload{Path: "main.txt", Revision: 3, Operation: collab.Operation{}.Retain(8)}); frame.Kind != KindError || frame.Error.Code != CodeNotFound {
+		t.Errorf("edit after close_doc: %+v", frame)
+	}
+
+	// The document is dropped with its last connection.
+	c1.conn.Close()
+	for s.doc(ws, "/workspace/main.txt") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("document kept open without connections")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWSDocClosedByFileChange(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.cfg.Collab.FlushInterval = time.Hour
+	userId, token := newTestUser(t, s)
+	projectId := newTestProject(t, s, userId, "demo")
+	s.d.WriteFile(context.Background(), workspaceId(projectId), "main.txt", []byte("hello"))
+
+	c1 := dialWS(t, ts, token, projectId)
+	c1.hello()
+	c2 := dialWS(t, ts, token, projectId)
+	c2.hello()
+	if frame := c1.call(MsgOpenDoc, OpenDocPayload{Path: "main.txt"}); frame.Kind != KindResponse {
+		t.Fatalf("open_doc: %+v", frame.Error)
+	}
+	if frame := c1.call(MsgEditDoc, EditDocPayload{Path: "main.txt", Revision: 0, Operation: collab.Operation{}.Retain(5).Insert("!")}); frame.Kind != KindResponse {
+		t.Fatalf("edit_doc: %+v", frame.Error)
+	}
+
+	if frame := c2.call(MsgRemoveFile, RemoveFilePayload{Path: "main.txt"}); frame.Kind != KindResponse {
+		t.Fatalf("remove_file: %+v", frame.Error)
+	}
+	var closed DocClosedEvent
+	c1.waitDocEvent(EventDocClosed, &closed)
+	if closed.Path != "/workspace/main.txt" {
+		t.Errorf("doc_closed = %+v", closed)
+	}
+	if frame := c1.call(MsgEditDoc, EditDocPayload{Path: "main.txt", Revision: 1, Operation: collab.Operation{}.Retain(6)}); frame.Kind != KindError || frame.Error.Code != CodeNotFound {
+		t.Errorf("edit of a closed document: %+v", frame)
+	}
+	// The unsaved edit is not written back over the removal.
+	if err := s.flushDocs(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readWorkspaceFile(t, s, projectId, "main.txt"); err == nil {
+		t.Error("removed file was saved again")
+	}
+}
@@ -0,0 +1,418 @@
+package server
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chrollo-lucifer-12/repl/collab"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+)
+
+// docFlushTimeout bounds saving a document outside of a request.
+const docFlushTimeout = 30 * time.Second
+
+// collabDoc is a file edited together by the connections that opened it.
+// The server holds the authoritative copy and writes it to the workspace
+// at most Collab.FlushInterval after an edit, and when the last
+// connection closes it. Changes made to the file by other means while it
+// is open are overwritten.
+type collabDoc struct {
+	s           *Server
+	key         string
+	workspaceId string
+	path        string
+
+	// flushMu serialises writes of the document so an older copy never
+	// overwrites a newer one.
+	flushMu sync.Mutex
+
+	// mu guards the fields below and is held while edits are queued, so
+	// every peer gets them in the order they were applied.
+	mu       sync.Mutex
+	doc      *collab.Document
+	peers    []*docPeer
+	nextPeer int
+	dirty    bool
+	timer    *time.Timer
+	// closed is set once the file changed outside the document, which is
+	// then neither edited nor saved anymore.
+	closed bool
+}
+
+type docPeer struct {
+	conn *wsConn
+	DocPeer
+}
+
+func docKey(workspaceId, path string) string {
+	return workspaceId + ":" + path
+}
+
+// docPath resolves a client path to the absolute workspace path that
+// names its document.
+func docPath(p string) (string, error) {
+	if p == "" {
+		return "", &FrameError{Code: CodeInvalidPayload, Message: "path is required"}
+	}
+	return sandbox.ResolvePath(sandbox.WorkspaceDir, p)
+}
+
+// openDoc attaches conn to the document of path, reading it from the
+// workspace if nobody has it open yet. The file is read outside of docMu,
+// so a slow workspace holds up no other.
+func (s *Server) openDoc(ctx context.Context, workspaceId, path string, conn *wsConn) (*OpenDocResult, error) {
+	for {
+		var loaded *collabDoc
+		if s.doc(workspaceId, path) == nil {
+			var err error
+			if loaded, err = s.loadDoc(ctx, workspaceId, path); err != nil {
+				return nil, err
+			}
+		}
+		if result := s.attachDoc(workspaceId, path, loaded, conn); result != nil {
+			return result, nil
+		}
+		// The document was released after we looked; it is saved, so
+		// read it again.
+	}
+}
+
+// loadDoc reads the document of path from the workspace.
+func (s *Server) loadDoc(ctx context.Context, workspaceId, path string) (*collabDoc, error) {
+	content, err := s.d.ReadFile(ctx, workspaceId, path)
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(content) {
+		return nil, &FrameError{Code: CodeUnsupported, Message: path + " is not a text file"}
+	}
+	return &collabDoc{
+		s:           s,
+		key:         docKey(workspaceId, path),
+		workspaceId: workspaceId,
+		path:        path,
+		doc:         collab.NewDocument(string(content), s.cfg.Collab.History),
+	}, nil
+}
+
+// attachDoc attaches conn to the open document of path, or to loaded if
+// there is none, which another connection may have opened meanwhile. It
+// returns nil if neither is there.
+func (s *Server) attachDoc(workspaceId, path string, loaded *collabDoc, conn *wsConn) *OpenDocResult {
+	s.docMu.Lock()
+	defer s.docMu.Unlock()
+	key := docKey(workspaceId, path)
+	d, ok := s.docs[key]
+	if !ok {
+		if loaded == nil {
+			return nil
+		}
+		d = loaded
+		s.docs[key] = d
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	result := &OpenDocResult{Path: path, Revision: d.doc.Revision(), Content: d.doc.Text(), Peers: []DocPeer{}}
+	for _, p := range d.peers {
+		if p.conn == conn {
+			result.Peer = p.Id
+		} else {
+			result.Peers = append(result.Peers, p.DocPeer)
+		}
+	}
+	if result.Peer != "" {
+		return result
+	}
+	d.nextPeer++
+	peer := &docPeer{conn: conn, DocPeer: DocPeer{Id: strconv.Itoa(d.nextPeer)}}
+	for _, p := range d.peers {
+		p.conn.queue(EventDocPeer, DocPeerEvent{Path: path, Peer: peer.DocPeer})
+	}
+	d.peers = append(d.peers, peer)
+	result.Peer = peer.Id
+	return result
+}
+
+func (s *Server) doc(workspaceId, path string) *collabDoc {
+	s.docMu.Lock()
+	defer s.docMu.Unlock()
+	return s.docs[docKey(workspaceId, path)]
+}
+
+// peer returns the peer of conn. It is called with mu held.
+func (d *collabDoc) peer(conn *wsConn) *docPeer {
+	for _, p := range d.peers {
+		if p.conn == conn {
+			return p
+		}
+	}
+	return nil
+}
+
+func (d *collabDoc) notOpen() error {
+	return &FrameError{Code: CodeNotFound, Message: d.path + " is not open on this connection"}
+}
+
+// edit applies op, based on revision, on behalf of conn.
+func (d *collabDoc) edit(conn *wsConn, revision int, op collab.Operation) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	author := d.peer(conn)
+	if author == nil {
+		return 0, d.notOpen()
+	}
+	return d.apply(author, revision, op)
+}
+
+// replace replaces the whole document the way write_file replaces a file.
+// It reports false if the document was closed meanwhile.
+func (d *collabDoc) replace(content string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return false, nil
+	}
+	_, err := d.apply(nil, d.doc.Revision(), collab.Operation{}.Delete(d.doc.Len()).Insert(content))
+	return true, err
+}
+
+// apply applies op and sends it to every peer, author included. Author is
+// nil for edits that do not come from a peer. It is called with mu held.
+func (d *collabDoc) apply(author *docPeer, revision int, op collab.Operation) (int, error) {
+	op, err := d.doc.Apply(revision, op)
+	if err != nil {
+		return 0, err
+	}
+	event := DocEditEvent{Path: d.path, Revision: d.doc.Revision(), Operation: op}
+	if author != nil {
+		event.Peer = author.Id
+	}
+	for _, p := range d.peers {
+		if p.Cursor != nil {
+			p.Cursor = &DocCursor{
+				Anchor: collab.TransformIndex(p.Cursor.Anchor, op),
+				Head:   collab.TransformIndex(p.Cursor.Head, op),
+			}
+		}
+		p.conn.queue(EventDocEdit, event)
+	}
+	if !d.dirty {
+		d.dirty = true
+		d.timer = time.AfterFunc(d.s.cfg.Collab.FlushInterval, d.flushLater)
+	}
+	return event.Revision, nil
+}
+
+// moveCursor moves the cursor of conn, given at revision, and sends it to
+// the other peers.
+func (d *collabDoc) moveCursor(conn *wsConn, revision int, cursor *DocCursor) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	peer := d.peer(conn)
+	if peer == nil {
+		return d.notOpen()
+	}
+	if cursor != nil {
+		anchor, err := d.doc.TransformIndex(revision, cursor.Anchor)
+		if err != nil {
+			return err
+		}
+		head, _ := d.doc.TransformIndex(revision, cursor.Head)
+		clamp := func(i int) int { return min(max(i, 0), d.doc.Len()) }
+		cursor = &DocCursor{Anchor: clamp(anchor), Head: clamp(head)}
+	}
+	peer.Cursor = cursor
+	event := DocCursorEvent{Path: d.path, Revision: d.doc.Revision(), Peer: peer.Id, Cursor: cursor}
+	for _, p := range d.peers {
+		if p != peer {
+			p.conn.queue(EventDocCursor, event)
+		}
+	}
+	return nil
+}
+
+// flush writes the document to the workspace if it changed since the
+// last write.
+func (d *collabDoc) flush(ctx context.Context) error {
+	d.flushMu.Lock()
+	defer d.flushMu.Unlock()
+	d.mu.Lock()
+	if !d.dirty || d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.dirty = false
+	d.timer.Stop()
+	content := d.doc.Text()
+	d.mu.Unlock()
+
+	if err := d.s.d.WriteFile(ctx, d.workspaceId, d.path, []byte(content)); err != nil {
+		// Try again later rather than lose the edits.
+		d.mu.Lock()
+		if !d.dirty && !d.closed {
+			d.dirty = true
+			d.timer = time.AfterFunc(d.s.cfg.Collab.FlushInterval, d.flushLater)
+		}
+		d.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (d *collabDoc) flushLater() {
+	ctx, cancel := context.WithTimeout(context.Background(), docFlushTimeout)
+	defer cancel()
+	if err := d.flush(ctx); err != nil {
+		d.s.l.Error("failed to save document", "workspace", d.workspaceId, "path", d.path, "error", err)
+	}
+}
+
+// detach removes conn from the document and reports whether it was the
+// last peer.
+func (d *collabDoc) detach(conn *wsConn) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	peer := d.peer(conn)
+	if peer == nil {
+		return false
+	}
+	d.peers = slices.DeleteFunc(d.peers, func(p *docPeer) bool { return p == peer })
+	for _, p := range d.peers {
+		p.conn.queue(EventDocPeer, DocPeerEvent{Path: d.path, Peer: peer.DocPeer, Left: true})
+	}
+	return len(d.peers) == 0
+}
+
+// closeDoc detaches conn from the document of path, saving and dropping
+// it when conn was the last peer.
+func (s *Server) closeDoc(ctx context.Context, workspaceId, path string, conn *wsConn) error {
+	s.docMu.Lock()
+	d, ok := s.docs[docKey(workspaceId, path)]
+	last := ok && d.detach(conn)
+	s.docMu.Unlock()
+	if !last {
+		return nil
+	}
+	return s.releaseDoc(ctx, d)
+}
+
+// closeDocs detaches a closing connection from every document.
+func (s *Server) closeDocs(conn *wsConn) {
+	var released []*collabDoc
+	s.docMu.Lock()
+	for _, d := range s.docs {
+		if d.detach(conn) {
+			released = append(released, d)
+		}
+	}
+	s.docMu.Unlock()
+	for _, d := range released {
+		ctx, cancel := context.WithTimeout(context.Background(), docFlushTimeout)
+		if err := s.releaseDoc(ctx, d); err != nil {
+			s.l.Error("failed to save document", "workspace", d.workspaceId, "path", d.path, "error", err)
+		}
+		cancel()
+	}
+}
+
+// releaseDoc saves a document its last peer left and drops it. The
+// document stays registered while it is saved, so a connection opening
+// it meanwhile gets the copy in memory rather than an old one from the
+// workspace, and keeps it.
+func (s *Server) releaseDoc(ctx context.Context, d *collabDoc) error {
+	err := d.flush(ctx)
+	s.docMu.Lock()
+	defer s.docMu.Unlock()
+	d.mu.Lock()
+	idle := len(d.peers) == 0
+	d.mu.Unlock()
+	if idle && s.docs[d.key] == d {
+		delete(s.docs, d.key)
+	}
+	return err
+}
+
+// replaceDoc replaces the content of the document of path, if it is open,
+// the way write_file would replace the file, and saves it. It reports
+// whether the document was open.
+func (s *Server) replaceDoc(ctx context.Context, workspaceId, path string, content string) (bool, error) {
+	d := s.doc(workspaceId, path)
+	if d == nil {
+		return false, nil
+	}
+	replaced, err := d.replace(content)
+	if !replaced || err != nil {
+		return replaced, err
+	}
+	return true, d.flush(ctx)
+}
+
+// changeFiles runs fn, which changes the files at or below path other
+// than through their documents, closing the documents of those files
+// before and after it: before so no save lands on top of the change,
+// after for any opened while it ran. Their peers get a doc_closed event
+// and unsaved edits are lost.
+func (s *Server) changeFiles(workspaceId, path string, fn func() error) error {
+	s.dropDocs(workspaceId, path)
+	defer s.dropDocs(workspaceId, path)
+	return fn()
+}
+
+// dropDocs closes the documents at or below path.
+func (s *Server) dropDocs(workspaceId, path string) {
+	var dropped []*collabDoc
+	s.docMu.Lock()
+	for key, d := range s.docs {
+		if d.workspaceId == workspaceId && (d.path == path || strings.HasPrefix(d.path, strings.TrimSuffix(path, "/")+"/")) {
+			delete(s.docs, key)
+			dropped = append(dropped, d)
+		}
+	}
+	s.docMu.Unlock()
+	for _, d := range dropped {
+		d.close()
+	}
+}
+
+// close stops editing and saving the document and tells its peers.
+func (d *collabDoc) close() {
+	d.mu.Lock()
+	d.closed = true
+	if d.dirty {
+		d.dirty = false
+		d.timer.Stop()
+	}
+	for _, p := range d.peers {
+		p.conn.queue(EventDocClosed, DocClosedEvent{Path: d.path})
+	}
+	d.peers = nil
+	d.mu.Unlock()
+	// Wait for a save already under way.
+	d.flushMu.Lock()
+	d.flushMu.Unlock()
+}
+
+// flushDocs saves every open document.
+func (s *Server) flushDocs(ctx context.Context) error {
+	s.docMu.Lock()
+	docs := make([]*collabDoc, 0, len(s.docs))
+	for _, d := range s.docs {
+		docs = append(docs, d)
+	}
+	s.docMu.Unlock()
+	for _, d := range docs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := d.flush(ctx); err != nil {
+			s.l.Error("failed to save document", "workspace", d.workspaceId, "path", d.path, "error", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/base64"
+	"unicode/utf8"
+)
+
+func (sess *wsSession) writeFile(req *Request) (any, error) {
+	var payload WriteFilePayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+
+	content := []byte(payload.Content)
+	switch payload.Encoding {
+	case "", EncodingUTF8:
+	case EncodingBase64:
+		decoded, err := base64.StdEncoding.DecodeString(payload.Content)
+		if err != nil {
+			return nil, &FrameError{Code: CodeInvalidPayload, Message: "content is not valid base64"}
+		}
+		content = decoded
+	default:
+		return nil, &FrameError{Code: CodeInvalidPayload, Message: "unknown encoding " + payload.Encoding}
+	}
+	// A file open for collaborative editing is replaced through its
+	// document, so its peers see the change instead of overwriting it.
+	if path, err := docPath(payload.Path); err == nil && utf8.Valid(content) {
+		if open, err := sess.s.replaceDoc(sess.ctx, sess.workspaceId, path, string(content)); open {
+			return nil, err
+		}
+	}
+	return nil, sess.s.d.WriteFile(sess.ctx, sess.workspaceId, payload.Path, content)
+}
+
+func (sess *wsSession) readFile(req *Request) (any, error) {
+	var payload ReadFilePayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	content, err := sess.s.d.ReadFile(sess.ctx, sess.workspaceId, payload.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.Valid(content) {
+		return ReadFileResult{
+			Path:     payload.Path,
+			Content:  base64.StdEncoding.EncodeToString(content),
+			Encoding: EncodingBase64,
+		}, nil
+	}
+	return ReadFileResult{Path: payload.Path, Content: string(content), Encoding: EncodingUTF8}, nil
+}
+
+func (sess *wsSession) listFiles(req *Request) (any, error) {
+	var payload ListFilesPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	files, err := sess.s.d.ListFiles(sess.ctx, sess.workspaceId, payload.Path)
+	if err != nil {
+		return nil, err
+	}
+	return ListFilesResult{Path: payload.Path, Files: files}, nil
+}
+
+func (sess *wsSession) removeFile(req *Request) (any, error) {
+	var payload RemoveFilePayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	path, err := docPath(payload.Path)
+	if err != nil {
+		return nil, err
+	}
+	return nil, sess.s.changeFiles(sess.workspaceId, path, func() error {
+		return sess.s.d.RemoveFile(sess.ctx, sess.workspaceId, payload.Path)
+	})
+}
+
+func (sess *wsSession) statFile(req *Request) (any, error) {
+	var payload StatFilePayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	return sess.s.d.StatFile(sess.ctx, sess.workspaceId, payload.Path)
+}
+
+func (sess *wsSession) searchFile(req *Request) (any, error) {
+	var payload SearchFilePayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	matches, err := sess.s.d.SearchInFile(sess.ctx, sess.workspaceId, payload.Path, payload.Search)
+	if err != nil {
+		return nil, err
+	}
+	return SearchFileResult{Path: payload.Path, Matches: matches}, nil
+}
+
+func (sess *wsSession) renameFile(req *Request) (any, error) {
+	var payload RenameFilePayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	path, err := docPath(payload.Path)
+	if err != nil {
+		return nil, err
+	}
+	return nil, sess.s.changeFiles(sess.workspaceId, path, func() error {
+		return sess.s.d.RenameFileDir(sess.ctx, sess.workspaceId, payload.Path, payload.NewName)
+	})
+}
+
+func (sess *wsSession) createDir(req *Request) (any, error) {
+	var payload CreateDirPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	return nil, sess.s.d.CreateDir(sess.ctx, sess.workspaceId, payload.Path)
+}
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/chrollo-lucifer-12/repl/git"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+)
+
+const (
+	defaultGitLogLimit = 50
+	maxGitLogLimit     = 500
+	defaultGitRemote   = "origin"
+	// gitRemoteTimeout bounds fetch, push and pull, so an unreachable
+	// remote does not hold up the connection until TCP gives up.
+	gitRemoteTimeout = 2 * time.Minute
+)
+
+// repo returns the git repository at the root of the workspace.
+func (sess *wsSession) repo() *git.Repo {
+	return git.New(func(ctx context.Context, cmd []string) (*sandbox.ExecResult, error) {
+		return sess.s.d.Exec(ctx, sess.workspaceId, cmd)
+	})
+}
+
+// gitPaths turns client paths into paths relative to the workspace root,
+// where the repository is.
+func gitPaths(paths []string) ([]string, error) {
+	rel := make([]string, 0, len(paths))
+	for _, p := range paths {
+		resolved, err := sandbox.ResolvePath(sandbox.WorkspaceDir, p)
+		if err != nil {
+			return nil, err
+		}
+		if resolved == sandbox.WorkspaceDir {
+			rel = append(rel, ".")
+			continue
+		}
+		rel = append(rel, strings.TrimPrefix(resolved, sandbox.WorkspaceDir+"/"))
+	}
+	return rel, nil
+}
+
+// gitIdentity is the author of commits made by the connected user. Users
+// have no name, so it is the local part of their email.
+func (sess *wsSession) gitIdentity() git.Identity {
+	name, _, _ := strings.Cut(sess.user.Email, "@")
+	return git.Identity{Name: name, Email: sess.user.Email}
+}
+
+func (sess *wsSession) gitInit(req *Request) (any, error) {
+	repo := sess.repo()
+	if err := repo.Init(sess.ctx); err != nil {
+		return nil, err
+	}
+	return repo.Status(sess.ctx)
+}
+
+func (sess *wsSession) gitStatus(req *Request) (any, error) {
+	return sess.repo().Status(sess.ctx)
+}
+
+func (sess *wsSession) gitDiff(req *Request) (any, error) {
+	var payload GitDiffPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	paths, err := gitPaths(payload.Paths)
+	if err != nil {
+		return nil, err
+	}
+	return sess.repo().Diff(sess.ctx, payload.Staged, paths)
+}
+
+// gitStage and gitUnstage respond with the status after the change.
+func (sess *wsSession) gitStage(req *Request) (any, error) {
+	var payload GitPathsPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	paths, err := gitPaths(payload.Paths)
+	if err != nil {
+		return nil, err
+	}
+	repo := sess.repo()
+	if err := repo.Stage(sess.ctx, paths); err != nil {
+		return nil, err
+	}
+	return repo.Status(sess.ctx)
+}
+
+func (sess *wsSession) gitUnstage(req *Request) (any, error) {
+	var payload GitPathsPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	paths, err := gitPaths(payload.Paths)
+	if err != nil {
+		return nil, err
+	}
+	repo := sess.repo()
+	if err := repo.Unstage(sess.ctx, paths); err != nil {
+		return nil, err
+	}
+	return repo.Status(sess.ctx)
+}
+
+func (sess *wsSession) gitCommit(req *Request) (any, error) {
+	var payload GitCommitPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(payload.Message) == "" {
+		return nil, &FrameError{Code: CodeInvalidPayload, Message: "commit message is required"}
+	}
+	return sess.repo().Commit(sess.ctx, payload.Message, sess.gitIdentity())
+}
+
+func (sess *wsSession) gitLog(req *Request) (any, error) {
+	var payload GitLogPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	limit := payload.Limit
+	if limit <= 0 {
+		limit = defaultGitLogLimit
+	}
+	limit = min(limit, maxGitLogLimit)
+	commits, err := sess.repo().Log(sess.ctx, payload.Ref, limit)
+	if err != nil {
+		return nil, err
+	}
+	return GitLogResult{Commits: commits}, nil
+}
+
+func (sess *wsSession) gitBranches(req *Request) (any, error) {
+	branches, err := sess.repo().Branches(sess.ctx)
+	if err != nil {
+		return nil, err
+	}
+	return GitBranchesResult{Branches: branches}, nil
+}
+
+func (sess *wsSession) gitCheckout(req *Request) (any, error) {
+	var payload GitCheckoutPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Ref == "" {
+		return nil, &FrameError{Code: CodeInvalidPayload, Message: "ref is required"}
+	}
+	repo := sess.repo()
+	err := sess.s.changeFiles(sess.workspaceId, sandbox.WorkspaceDir, func() error {
+		return repo.Checkout(sess.ctx, payload.Ref, payload.Create)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repo.Status(sess.ctx)
+}
+
+func (sess *wsSession) gitRemotes(req *Request) (any, error) {
+	remotes, err := sess.repo().Remotes(sess.ctx)
+	if err != nil {
+		return nil, err
+	}
+	return GitRemotesResult{Remotes: remotes}, nil
+}
+
+func (sess *wsSession) gitAddRemote(req *Request) (any, error) {
+	var payload GitAddRemotePayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Name == "" || payload.Url == "" {
+		return nil, &FrameError{Code: CodeInvalidPayload, Message: "name and url are required"}
+	}
+	return nil, sess.repo().AddRemote(sess.ctx, payload.Name, payload.Url)
+}
+
+// decodeRemotePayload fills in the defaults of a GitRemotePayload. The
+// branch defaults to the current one, so a detached HEAD needs one given.
+func (sess *wsSession) decodeRemotePayload(req *Request, repo *git.Repo) (*GitRemotePayload, error) {
+	var payload GitRemotePayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Remote == "" {
+		payload.Remote = defaultGitRemote
+	}
+	if payload.Branch == "" {
+		st, err := repo.Status(sess.ctx)
+		if err != nil {
+			return nil, err
+		}
+		if st.Branch == "" {
+			return nil, &FrameError{Code: CodeInvalidPayload, Message: "branch is required on a detached HEAD"}
+		}
+		payload.Branch = st.Branch
+	}
+	return &payload, nil
+}
+
+func (sess *wsSession) gitFetch(req *Request) (any, error) {
+	var payload GitRemotePayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Remote == "" {
+		payload.Remote = defaultGitRemote
+	}
+	repo := sess.repo()
+	ctx, cancel := context.WithTimeout(sess.ctx, gitRemoteTimeout)
+	defer cancel()
+	if err := repo.Fetch(ctx, payload.Remote); err != nil {
+		return nil, err
+	}
+	return repo.Status(sess.ctx)
+}
+
+func (sess *wsSession) gitPush(req *Request) (any, error) {
+	repo := sess.repo()
+	payload, err := sess.decodeRemotePayload(req, repo)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(sess.ctx, gitRemoteTimeout)
+	defer cancel()
+	if err := repo.Push(ctx, payload.Remote, payload.Branch, payload.SetUpstream); err != nil {
+		return nil, err
+	}
+	return repo.Status(sess.ctx)
+}
+
+func (sess *wsSession) gitPull(req *Request) (any, error) {
+	repo := sess.repo()
+	payload, err := sess.decodeRemotePayload(req, repo)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(sess.ctx, gitRemoteTimeout)
+	defer cancel()
+	err = sess.s.changeFiles(sess.workspaceId, sandbox.WorkspaceDir, func() error {
+		return repo.Pull(ctx, payload.Remote, payload.Branch)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return repo.Status(sess.ctx)
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"os/exec"
+	"testing"
+
+	"github.com/chrollo-lucifer-12/repl/git"
+)
+
+func TestWSGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	s, ts := newTestServer(t)
+	userId, token := newTestUser(t, s)
+	projectId := newTestProject(t, s, userId, "demo")
+	ctx := context.Background()
+	ws := workspaceId(projectId)
+	user, _ := s.db.FindUser(userId)
+
+	c := dialWS(t, ts, token, projectId)
+	c.hello()
+
+	if frame := c.call(MsgGitStatus, nil); frame.Kind != KindError || frame.Error.Code != CodeNotRepository {
+		t.Fatalf("git_status before git_init: %+v", frame)
+	}
+	if frame := c.call(MsgGitInit, nil); frame.Kind != KindResponse {
+		t.Fatalf("git_init: %+v", frame.Error)
+	}
+
+	s.d.WriteFile(ctx, ws, "main.py", []byte("print(1)\n"))
+	frame := c.call(MsgGitStage, GitPathsPayload{Paths: []string{"/workspace/main.py"}})
+	var st git.Status
+	json.Unmarshal(frame.Payload, &st)
+	if frame.Kind != KindResponse || len(st.Files) != 1 || st.Files[0].Staged != git.StateAdded {
+		t.Fatalf("git_stage: %+v %+v", frame.Error, st)
+	}
+	if frame := c.call(MsgGitStage, GitPathsPayload{Paths: []string{"../etc"}}); frame.Kind != KindError || frame.Error.Code != CodeOutsideWorkspace {
+		t.Errorf("staging outside the workspace: %+v", frame)
+	}
+
+	if frame := c.call(MsgGitCommit, GitCommitPayload{}); frame.Kind != KindError || frame.Error.Code != CodeInvalidPayload {
+		t.Errorf("commit without a message: %+v", frame)
+	}
+	frame = c.call(MsgGitCommit, GitCommitPayload{Message: "first"})
+	var commit git.Commit
+	json.Unmarshal(frame.Payload, &commit)
+	if frame.Kind != KindResponse || commit.Email != user.Email || commit.Subject != "first" {
+		t.Fatalf("git_commit: %+v %+v", frame.Error, commit)
+	}
+	if frame := c.call(MsgGitCommit, GitCommitPayload{Message: "again"}); frame.Kind != KindError || frame.Error.Code != CodeGitFailed {
+		t.Errorf("commit with nothing staged: %+v", frame)
+	}
+
+	s.d.WriteFile(ctx, ws, "main.py", []byte("print(2)\n"))
+	frame = c.call(MsgGitDiff, GitDiffPayload{})
+	var diff git.Diff
+	json.Unmarshal(frame.Payload, &diff)
+	if frame.Kind != KindResponse || len(diff.Files) != 1 || len(diff.Files[0].Hunks) != 1 || diff.Unified == "" {
+		t.Errorf("git_diff: %+v %+v", frame.Error, diff)
+	}
+
+	if frame := c.call(MsgGitCheckout, GitCheckoutPayload{Ref: "feature", Create: true}); frame.Kind != KindResponse {
+		t.Fatalf("git_checkout: %+v", frame.Error)
+	}
+	if frame := c.call(MsgGitCheckout, GitCheckoutPayload{Ref: "-f"}); frame.Kind != KindError || frame.Error.Code != CodeInvalidPayload {
+		t.Errorf("checkout of an option: %+v", frame)
+	}
+	frame = c.call(MsgGitBranches, nil)
+	var branches GitBranchesResult
+	json.Unmarshal(frame.Payload, &branches)
+	if len(branches.Branches) != 2 {
+		t.Errorf("git_branches: %+v", branches)
+	}
+
+	frame = c.call(MsgGitLog, GitLogPayload{})
+	var log GitLogResult
+	json.Unmarshal(frame.Payload, &log)
+	if len(log.Commits) != 1 || log.Commits[0].Hash != commit.Hash {
+		t.Errorf("git_log: %+v", log)
+	}
+
+	bare := t.TempDir()
+	if out, err := exec.Command("git", "init", "-q", "--bare", bare).CombinedOutput(); err != nil {
+		t.Fatalf("git init --bare: %v %s", err, out)
+	}
+	if frame := c.call(MsgGitAddRemote, GitAddRemotePayload{Name: "origin", Url: bare}); frame.Kind != KindResponse {
+		t.Fatalf("git_add_remote: %+v", frame.Error)
+	}
+	frame = c.call(MsgGitPush, GitRemotePayload{SetUpstream: true})
+	st = git.Status{}
+	json.Unmarshal(frame.Payload, &st)
+	if frame.Kind != KindResponse || st.Upstream != "origin/feature" {
+		t.Errorf("git_push: %+v %+v", frame.Error, st)
+	}
+	if frame := c.call(MsgGitPull, GitRemotePayload{Remote: "nowhere"}); frame.Kind != KindError || frame.Error.Code != CodeGitFailed {
+		t.Errorf("pull from an unknown remote: %+v", frame)
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"github.com/chrollo-lucifer-12/repl/lsp"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+)
+
+func (sess *wsSession) lspStart(req *Request) (any, error) {
+	var payload LspStartPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Language == "" {
+		return nil, &FrameError{Code: CodeInvalidPayload, Message: "language is required"}
+	}
+	if _, err := sess.s.attachLanguageServer(sess.workspaceId, payload.Language, sess.conn); err != nil {
+		return nil, err
+	}
+	return LspStartResult{Language: payload.Language, RootUri: lsp.FileURI(sandbox.WorkspaceDir)}, nil
+}
+
+func (sess *wsSession) lspMessage(req *Request) (any, error) {
+	var payload LspMessagePayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Language == "" {
+		return nil, &FrameError{Code: CodeInvalidPayload, Message: "language is required"}
+	}
+	ls := sess.s.languageServer(sess.workspaceId, payload.Language)
+	if ls == nil {
+		return nil, &FrameError{Code: CodeNotFound, Message: "language server " + payload.Language + " is not running"}
+	}
+	return nil, ls.fromClient(sess.conn, payload.Message)
+}
+
+func (sess *wsSession) lspStop(req *Request) (any, error) {
+	var payload LspStartPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Language == "" {
+		return nil, &FrameError{Code: CodeInvalidPayload, Message: "language is required"}
+	}
+	sess.s.detachLanguageServer(sess.workspaceId, payload.Language, sess.conn)
+	return nil, nil
+}
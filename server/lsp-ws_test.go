@@ -0,0 +1,212 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/chrollo-lucifer-12/repl/lsp"
+)
+
+// TestHelperLanguageServer is not a test but a language server started by
+// TestWSLanguageServer. It answers initialize with the rootUri it got,
+// publishes a diagnostic for each document it opens, asks the client for
+// its configuration before answering hover, and exits with status 3 on a
+// crash notification.
+func TestHelperLanguageServer(t *testing.T) {
+	if os.Getenv("GO_WANT_HELPER_LANGUAGE_SERVER") != "1" {
+		t.Skip("helper process")
+	}
+	r := bufio.NewReader(os.Stdin)
+	write := func(v any) {
+		data, _ := json.Marshal(v)
+		lsp.WriteMessage(os.Stdout, data)
+	}
+	var initialized, open int
+	var hover *lsp.Message
+	for {
+		raw, err := lsp.ReadMessage(r)
+		if err != nil {
+			os.Exit(0)
+		}
+		m, _ := lsp.Parse(raw)
+		var params struct {
+			RootUri      string `json:"rootUri"`
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+		}
+		json.Unmarshal(m.Params, &params)
+		switch m.Method {
+		case "initialize":
+			initialized++
+			// Strings under text are never rewritten, so the client sees
+			// the URI the server got.
+			write(map[string]any{"jsonrpc": "2.0", "id": m.ID, "result": map[string]any{"text": params.RootUri, "count": initialized}})
+		case "textDocument/didOpen":
+			open++
+			write(map[string]any{"jsonrpc": "2.0", "method": "textDocument/publishDiagnostics", "params": map[string]any{"uri": params.TextDocument.URI, "diagnostics": []any{}}})
+		case "textDocument/didClose":
+			open--
+		case "textDocument/hover":
+			hover = m
+			write(map[string]any{"jsonrpc": "2.0", "id": "config", "method": "workspace/configuration", "params": map[string]any{}})
+		case "crash":
+			fmt.Fprintln(os.Stderr, "boom")
+			os.Exit(3)
+		case "":
+			if string(m.ID) == `"config"` && hover != nil {
+				var hp struct {
+					TextDocument struct {
+						URI string `json:"uri"`
+					} `json:"textDocument"`
+				}
+				json.Unmarshal(hover.Params, &hp)
+				write(map[string]any{"jsonrpc": "2.0", "id": hover.ID, "result": map[string]any{"uri": hp.TextDocument.URI, "open": open, "config": m.Result}})
+				hover = nil
+			}
+		}
+	}
+}
+
+func (c *testClient) sendLsp(language, msg string) {
+	c.t.Helper()
+	c.send(MsgLspMessage, LspMessagePayload{Language: language, Message: json.RawMessage(msg)})
+}
+
+// waitLspMessage reads frames until a message of a language server
+// arrives.
+func (c *testClient) waitLspMessage() *lsp.Message {
+	c.t.Helper()
+	for {
+		frame := c.read()
+		if frame.Kind == KindError {
+			c.t.Fatalf("lsp request failed: %+v", frame.Error)
+		}
+		if frame.Kind == KindEvent && frame.Type == EventLspMessage {
+			var event LspMessagePayload
+			json.Unmarshal(frame.Payload, &event)
+			m, err := lsp.Parse(event.Message)
+			if err != nil {
+				c.t.Fatalf("invalid lsp message %s: %v", event.Message, err)
+			}
+			return m
+		}
+	}
+}
+
+func TestWSLanguageServer(t *testing.T) {
+	s, ts := newTestServer(t)
+	exe, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	s.cfg.LSP.Servers = map[string][]string{
+		"go": {"env", "GO_WANT_HELPER_LANGUAGE_SERVER=1", exe, "-test.run=^TestHelperLanguageServer$"},
+	}
+	userId, token := newTestUser(t, s)
+	projectId := newTestProject(t, s, userId, "demo")
+	hostDir, _ := s.d.WorkDir(workspaceId(projectId))
+
+	c1 := dialWS(t, ts, token, projectId)
+	c1.hello()
+	c2 := dialWS(t, ts, token, projectId)
+	c2.hello()
+
+	if frame := c1.call(MsgLspStart, LspStartPayload{Language: "cobol"}); frame.Kind != KindError || frame.Error.Code != CodeUnsupported {
+		t.Errorf("lsp_start of an unknown language: %+v", frame)
+	}
+	frame := c1.call(MsgLspMessage, LspMessagePayload{Language: "go", Message: json.RawMessage(`{"jsonrpc":"2.0","method":"initialized"}`)})
+	if frame.Kind != KindError || frame.Error.Code != CodeNotFound {
+		t.Errorf("lsp_message before lsp_start: %+v", frame)
+	}
+
+	for _, c := range []*testClient{c1, c2} {
+		frame := c.call(MsgLspStart, LspStartPayload{Language: "go"})
+		var result LspStartResult
+		json.Unmarshal(frame.Payload, &result)
+		if frame.Kind != KindResponse || result.RootUri != "file:///workspace" {
+			t.Fatalf("lsp_start: %+v %+v", frame.Error, result)
+		}
+	}
+
+	// Both clients initialize, the server only once.
+	initialize := `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"rootUri":"file:///workspace"}}`
+	for _, c := range []*testClient{c1, c2} {
+		c.sendLsp("go", initialize)
+		m := c.waitLspMessage()
+		var result struct {
+			Text  string
+			Count int
+		}
+		json.Unmarshal(m.Result, &result)
+		if string(m.ID) != "1" || result.Text != lsp.FileURI(hostDir) || result.Count != 1 {
+			t.Fatalf("initialize = %s %+v", m.ID, result)
+		}
+		c.sendLsp("go", `{"jsonrpc":"2.0","method":"initialized","params":{}}`)
+	}
+
+	// Notifications of the server reach every client.
+	c1.sendLsp("go", `{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///workspace/main.go","text":"package main"}}}`)
+	for _, c := range []*testClient{c1, c2} {
+		m := c.waitLspMessage()
+		var params struct{ Uri string }
+		json.Unmarshal(m.Params, &params)
+		if m.Method != "textDocument/publishDiagnostics" || params.Uri != "file:///workspace/main.go" {
+			t.Fatalf("diagnostics = %s %s", m.Method, m.Params)
+		}
+	}
+	c2.sendLsp("go", `{"jsonrpc":"2.0","method":"textDocument/didOpen","params":{"textDocument":{"uri":"file:///workspace/main.go","text":"package main"}}}`)
+
+	// The request of the server goes to the oldest client, the response
+	// to hover to the client that asked.
+	c2.sendLsp("go", `{"jsonrpc":"2.0","id":"h","method":"textDocument/hover","params":{"textDocument":{"uri":"file:///workspace/main.go"}}}`)
+	m := c1.waitLspMessage()
+	if m.Method != "workspace/configuration" || !m.IsRequest() {
+		t.Fatalf("server request = %s %s", m.Method, m.ID)
+	}
+	c1.sendLsp("go", `{"jsonrpc":"2.0","id":`+string(m.ID)+`,"result":[{"tabs":true}]}`)
+	m = c2.waitLspMessage()
+	var hover struct {
+		Uri    string
+		Open   int
+		Config []map[string]bool
+	}
+	json.Unmarshal(m.Result, &hover)
+	if string(m.ID) != `"h"` || hover.Uri != "file:///workspace/main.go" || hover.Open != 1 || len(hover.Config) != 1 || !hover.Config[0]["tabs"] {
+		t.Errorf("hover = %s %s", m.ID, m.Result)
+	}
+
+	// A server that crashes reports why to the clients still attached.
+	if frame := c2.call(MsgLspStop, LspStartPayload{Language: "go"}); frame.Kind != KindResponse {
+		t.Fatalf("lsp_stop: %+v", frame.Error)
+	}
+	c1.sendLsp("go", `{"jsonrpc":"2.0","method":"crash"}`)
+	for {
+		frame := c1.read()
+		if frame.Kind == KindEvent && frame.Type == EventLspExit {
+			var event LspExitEvent
+			json.Unmarshal(frame.Payload, &event)
+			if event.ExitCode != 3 || event.Error != "boom" {
+				t.Errorf("lsp_exit = %+v", event)
+			}
+			break
+		}
+	}
+	if frame := c1.call(MsgLspMessage, LspMessagePayload{Language: "go", Message: json.RawMessage(initialize)}); frame.Kind != KindError || frame.Error.Code != CodeNotFound {
+		t.Errorf("lsp_message after exit: %+v", frame)
+	}
+
+	// The server stops once its last connection leaves.
+	c1.call(MsgLspStart, LspStartPayload{Language: "go"})
+	ls := s.languageServer(workspaceId(projectId), "go")
+	c1.conn.Close()
+	select {
+	case <-ls.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("language server kept running without connections")
+	}
+}
@@ -0,0 +1,552 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/chrollo-lucifer-12/repl/lsp"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+)
+
+// lspStderrTail is how much of the stderr of a language server is kept
+// for the lsp_exit event.
+const lspStderrTail = 4 << 10
+
+var errLanguageServerGone = errors.New("language server exited")
+
+// languageServer is a language server running in a workspace. One runs
+// per language and workspace, shared by every connection on the workspace
+// that started it, and it is stopped once the last of them leaves.
+//
+// Each client talks to it as if it were its own: request ids are replaced
+// so responses find their way back, initialize reaches the server once and
+// later clients get its result from memory, shutdown and exit are left to
+// the bridge, and a document is opened and closed once however many
+// clients have it open. Requests from the server go to the connection
+// attached the longest, notifications to all of them.
+type languageServer struct {
+	s           *Server
+	key         string
+	workspaceId string
+	language    string
+	execId      string
+	// toServer and toClient rewrite file URIs between WorkspaceDir, which
+	// clients see, and the directory the server runs in.
+	toServer *lsp.Rewriter
+	toClient *lsp.Rewriter
+	input    *io.PipeWriter
+	// done is closed once the server exited and exit events were sent.
+	done chan struct{}
+
+	// writeMu serialises writes to the server apart from mu, so a server
+	// that is slow to read its input cannot stall the routing of its
+	// output.
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	conns   []*wsConn
+	stopped bool
+	nextId  int
+	// pending maps the ids of requests sent to the server to the client
+	// that sent them, asked the ids of server requests to the client they
+	// were sent to.
+	pending map[string]pendingLspRequest
+	asked   map[string]*wsConn
+	// initResult is the result of the first successful initialize, while
+	// initWaiters wait for one in flight.
+	initResult   json.RawMessage
+	initializing bool
+	initWaiters  []pendingLspRequest
+	initialized  bool
+	// open holds the connections that opened each document.
+	open   map[string]map[*wsConn]bool
+	stderr *ringBuffer
+}
+
+type pendingLspRequest struct {
+	conn *wsConn
+	id   json.RawMessage
+	// initialize marks the initialize request, answered to initWaiters.
+	initialize bool
+}
+
+func languageServerKey(workspaceId, language string) string {
+	return workspaceId + "/" + language
+}
+
+// attachLanguageServer attaches conn to the language server of language
+// in the workspace, starting it first if needed.
+func (s *Server) attachLanguageServer(workspaceId, language string, conn *wsConn) (*languageServer, error) {
+	s.lspMu.Lock()
+	defer s.lspMu.Unlock()
+	key := languageServerKey(workspaceId, language)
+	ls, ok := s.languageServers[key]
+	if !ok {
+		cmd, ok := s.cfg.LSP.Servers[language]
+		if !ok {
+			return nil, &FrameError{Code: CodeUnsupported, Message: "no language server for " + language}
+		}
+		var err error
+		if ls, err = s.startLanguageServer(workspaceId, language, cmd); err != nil {
+			return nil, err
+		}
+		s.languageServers[key] = ls
+	}
+	ls.mu.Lock()
+	if !slices.Contains(ls.conns, conn) {
+		ls.conns = append(ls.conns, conn)
+	}
+	ls.mu.Unlock()
+	return ls, nil
+}
+
+func (s *Server) languageServer(workspaceId, language string) *languageServer {
+	s.lspMu.Lock()
+	defer s.lspMu.Unlock()
+	return s.languageServers[languageServerKey(workspaceId, language)]
+}
+
+func (s *Server) startLanguageServer(workspaceId, language string, cmd []string) (*languageServer, error) {
+	workDir, err := s.d.WorkDir(workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	ls := &languageServer{
+		s:           s,
+		key:         languageServerKey(workspaceId, language),
+		workspaceId: workspaceId,
+		language:    language,
+		toServer:    lsp.NewRewriter(sandbox.WorkspaceDir, workDir),
+		toClient:    lsp.NewRewriter(workDir, sandbox.WorkspaceDir),
+		input:       inW,
+		done:        make(chan struct{}),
+		pending:     map[string]pendingLspRequest{},
+		asked:       map[string]*wsConn{},
+		open:        map[string]map[*wsConn]bool{},
+		stderr:      newRingBuffer(lspStderrTail),
+	}
+	proc, err := s.d.StartPipedProcess(context.Background(), workspaceId, cmd, inR, outW, lspStderr{ls})
+	if err != nil {
+		inW.Close()
+		outW.Close()
+		return nil, err
+	}
+	ls.execId = proc.ExecId
+
+	read := make(chan struct{})
+	go func() {
+		defer close(read)
+		ls.readOutput(outR)
+	}()
+	go func() {
+		<-proc.Done
+		outW.Close()
+		inW.CloseWithError(errLanguageServerGone)
+		<-read
+		ls.exited()
+	}()
+	return ls, nil
+}
+
+// lspStderr records the stderr of a language server.
+type lspStderr struct {
+	ls *languageServer
+}
+
+func (w lspStderr) Write(p []byte) (int, error) {
+	w.ls.mu.Lock()
+	defer w.ls.mu.Unlock()
+	w.ls.stderr.Write(p)
+	return len(p), nil
+}
+
+// readOutput routes the messages of the server until its output ends. A
+// server writing something other than LSP is stopped.
+func (ls *languageServer) readOutput(r io.Reader) {
+	br := bufio.NewReader(r)
+	for {
+		msg, err := lsp.ReadMessage(br)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				ls.s.l.Error("language server output failed", "workspace", ls.workspaceId, "language", ls.language, "error", err)
+				ls.stop()
+			}
+			// Keep draining so the process never blocks on a full pipe.
+			io.Copy(io.Discard, r)
+			return
+		}
+		ls.fromServer(msg)
+	}
+}
+
+// exited drops the server and tells the connections still attached.
+func (ls *languageServer) exited() {
+	s := ls.s
+	s.lspMu.Lock()
+	if s.languageServers[ls.key] == ls {
+		delete(s.languageServers, ls.key)
+	}
+	s.lspMu.Unlock()
+
+	event := LspExitEvent{Language: ls.language}
+	if status, err := s.d.InspectProcess(context.Background(), ls.workspaceId, ls.execId); err == nil {
+		event.ExitCode = status.ExitCode
+	}
+	ls.mu.Lock()
+	conns := ls.conns
+	ls.conns = nil
+	if !ls.stopped && event.ExitCode != 0 {
+		event.Error = string(bytes.TrimSpace(ls.stderr.Bytes()))
+	}
+	ls.mu.Unlock()
+	for _, conn := range conns {
+		conn.queue(EventLspExit, event)
+	}
+	close(ls.done)
+}
+
+// stop kills the server. Closing its input first lets servers that exit
+// on EOF do so by themselves.
+func (ls *languageServer) stop() {
+	ls.mu.Lock()
+	ls.stopped = true
+	ls.mu.Unlock()
+	ls.input.CloseWithError(errLanguageServerGone)
+	if err := ls.s.d.StopProcess(context.Background(), ls.workspaceId, ls.execId); err != nil && !errors.Is(err, sandbox.ErrContainerGone) {
+		ls.s.l.Error("failed to stop language server", "workspace", ls.workspaceId, "language", ls.language, "error", err)
+	}
+}
+
+// write sends an encoded message to the server.
+func (ls *languageServer) write(msg []byte) error {
+	ls.writeMu.Lock()
+	defer ls.writeMu.Unlock()
+	return lsp.WriteMessage(ls.input, msg)
+}
+
+func (ls *languageServer) writeMessage(m *lsp.Message) error {
+	data, err := m.Encode()
+	if err != nil {
+		return err
+	}
+	return ls.write(data)
+}
+
+// send queues a message of the server, already rewritten, for conn, so a
+// stalled connection holds up neither the server nor its other clients.
+func (ls *languageServer) send(conn *wsConn, msg []byte) {
+	conn.queue(EventLspMessage, LspMessagePayload{Language: ls.language, Message: msg})
+}
+
+func (ls *languageServer) sendMessage(conn *wsConn, m *lsp.Message) {
+	data, err := m.Encode()
+	if err != nil {
+		ls.s.l.Error("failed to encode language server message", "error", err)
+		return
+	}
+	ls.send(conn, data)
+}
+
+// newId returns an id for a request to the server. Ids are numbers, so
+// they are encoded the same way by every server.
+func (ls *languageServer) newId() json.RawMessage {
+	ls.nextId++
+	return json.RawMessage(strconv.Itoa(ls.nextId))
+}
+
+// fromServer routes a message of the server.
+func (ls *languageServer) fromServer(raw []byte) {
+	raw, err := ls.toClient.Rewrite(raw)
+	if err != nil {
+		ls.s.l.Error("invalid language server message", "language", ls.language, "error", err)
+		return
+	}
+	m, err := lsp.Parse(raw)
+	if err != nil {
+		ls.s.l.Error("invalid language server message", "language", ls.language, "error", err)
+		return
+	}
+
+	ls.mu.Lock()
+	switch {
+	case m.IsResponse():
+		p, ok := ls.pending[string(m.ID)]
+		delete(ls.pending, string(m.ID))
+		if !ok {
+			ls.mu.Unlock()
+			return
+		}
+		if p.initialize {
+			waiters := ls.initWaiters
+			ls.initWaiters = nil
+			ls.initializing = false
+			if m.Error == nil {
+				ls.initResult = m.Result
+			}
+			ls.mu.Unlock()
+			for _, w := range waiters {
+				ls.sendMessage(w.conn, &lsp.Message{ID: w.id, Result: m.Result, Error: m.Error})
+			}
+			return
+		}
+		attached := slices.Contains(ls.conns, p.conn)
+		ls.mu.Unlock()
+		if attached {
+			m.ID = p.id
+			ls.sendMessage(p.conn, m)
+		}
+
+	case m.IsRequest():
+		if len(ls.conns) == 0 {
+			ls.mu.Unlock()
+			go ls.writeMessage(lsp.NewErrorResponse(m.ID, lsp.CodeRequestFailed, "no client attached"))
+			return
+		}
+		conn := ls.conns[0]
+		ls.asked[string(m.ID)] = conn
+		ls.mu.Unlock()
+		ls.send(conn, raw)
+
+	default:
+		conns := slices.Clone(ls.conns)
+		ls.mu.Unlock()
+		for _, conn := range conns {
+			ls.send(conn, raw)
+		}
+	}
+}
+
+// fromClient routes a message of a client to the server, or answers it
+// right away.
+func (ls *languageServer) fromClient(conn *wsConn, raw []byte) error {
+	raw, err := ls.toServer.Rewrite(raw)
+	if err != nil {
+		return &FrameError{Code: CodeInvalidPayload, Message: err.Error()}
+	}
+	m, err := lsp.Parse(raw)
+	if err != nil {
+		return &FrameError{Code: CodeInvalidPayload, Message: err.Error()}
+	}
+
+	ls.mu.Lock()
+	if !slices.Contains(ls.conns, conn) {
+		ls.mu.Unlock()
+		return &FrameError{Code: CodeNotFound, Message: "language server " + ls.language + " is not started on this connection"}
+	}
+	switch {
+	case m.IsRequest() && m.Method == "initialize":
+		if ls.initResult != nil {
+			result := ls.initResult
+			ls.mu.Unlock()
+			ls.sendMessage(conn, lsp.NewResponse(m.ID, result))
+			return nil
+		}
+		ls.initWaiters = append(ls.initWaiters, pendingLspRequest{conn: conn, id: m.ID})
+		if ls.initializing {
+			ls.mu.Unlock()
+			return nil
+		}
+		ls.initializing = true
+		m.ID = ls.newId()
+		ls.pending[string(m.ID)] = pendingLspRequest{initialize: true}
+
+	case m.IsRequest() && m.Method == "shutdown":
+		ls.mu.Unlock()
+		ls.sendMessage(conn, lsp.NewResponse(m.ID, nil))
+		return nil
+
+	case m.IsRequest():
+		id := ls.newId()
+		ls.pending[string(id)] = pendingLspRequest{conn: conn, id: m.ID}
+		m.ID = id
+
+	case m.IsNotification():
+		if !ls.forwardNotification(conn, m) {
+			ls.mu.Unlock()
+			return nil
+		}
+
+	default:
+		// A response to a request of the server.
+		if ls.asked[string(m.ID)] != conn {
+			ls.mu.Unlock()
+			return nil
+		}
+		delete(ls.asked, string(m.ID))
+	}
+	ls.mu.Unlock()
+	return ls.writeMessage(m)
+}
+
+// forwardNotification reports whether a notification of conn is passed
+// on to the server, adjusting it if needed. It is called with mu held.
+func (ls *languageServer) forwardNotification(conn *wsConn, m *lsp.Message) bool {
+	switch m.Method {
+	case "initialized":
+		if ls.initialized {
+			return false
+		}
+		ls.initialized = true
+	case "exit":
+		return false
+	case "textDocument/didOpen":
+		uri := documentURI(m.Params)
+		by := ls.open[uri]
+		if by == nil {
+			by = map[*wsConn]bool{}
+			ls.open[uri] = by
+		}
+		by[conn] = true
+		return len(by) == 1
+	case "textDocument/didClose":
+		uri := documentURI(m.Params)
+		by := ls.open[uri]
+		if !by[conn] {
+			return false
+		}
+		delete(by, conn)
+		if len(by) > 0 {
+			return false
+		}
+		delete(ls.open, uri)
+	case "$/cancelRequest":
+		var params struct {
+			ID json.RawMessage `json:"id"`
+		}
+		json.Unmarshal(m.Params, &params)
+		for id, p := range ls.pending {
+			if p.conn == conn && bytes.Equal(p.id, params.ID) {
+				m.Params, _ = json.Marshal(map[string]json.RawMessage{"id": json.RawMessage(id)})
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func documentURI(params json.RawMessage) string {
+	var p struct {
+		TextDocument struct {
+			URI string `json:"uri"`
+		} `json:"textDocument"`
+	}
+	json.Unmarshal(params, &p)
+	return p.TextDocument.URI
+}
+
+// detach removes conn from the server and reports whether it was the
+// last connection. Documents only conn had open are closed and requests
+// of the server waiting for conn fail.
+func (ls *languageServer) detach(conn *wsConn) bool {
+	ls.mu.Lock()
+	i := slices.Index(ls.conns, conn)
+	if i < 0 {
+		ls.mu.Unlock()
+		return false
+	}
+	ls.conns = slices.Delete(ls.conns, i, i+1)
+	if len(ls.conns) == 0 {
+		ls.mu.Unlock()
+		return true
+	}
+
+	var closed []string
+	for uri, by := range ls.open {
+		if by[conn] {
+			delete(by, conn)
+			if len(by) == 0 {
+				delete(ls.open, uri)
+				closed = append(closed, uri)
+			}
+		}
+	}
+	var unanswered []json.RawMessage
+	for id, c := range ls.asked {
+		if c == conn {
+			delete(ls.asked, id)
+			unanswered = append(unanswered, json.RawMessage(id))
+		}
+	}
+	for id, p := range ls.pending {
+		if p.conn == conn {
+			delete(ls.pending, id)
+		}
+	}
+	ls.initWaiters = slices.DeleteFunc(ls.initWaiters, func(w pendingLspRequest) bool { return w.conn == conn })
+	ls.mu.Unlock()
+
+	for _, uri := range closed {
+		params, _ := json.Marshal(map[string]any{"textDocument": map[string]string{"uri": uri}})
+		ls.writeMessage(&lsp.Message{Method: "textDocument/didClose", Params: params})
+	}
+	for _, id := range unanswered {
+		ls.writeMessage(lsp.NewErrorResponse(id, lsp.CodeRequestFailed, "client detached"))
+	}
+	return false
+}
+
+// detachLanguageServer detaches conn from the language server of language
+// in the workspace, stopping it if conn was the last connection.
+func (s *Server) detachLanguageServer(workspaceId, language string, conn *wsConn) {
+	s.lspMu.Lock()
+	key := languageServerKey(workspaceId, language)
+	ls := s.languageServers[key]
+	last := ls != nil && ls.detach(conn)
+	if last {
+		delete(s.languageServers, key)
+	}
+	s.lspMu.Unlock()
+	if last {
+		ls.stop()
+	}
+}
+
+// detachLanguageServers detaches a closing connection from every language
+// server.
+func (s *Server) detachLanguageServers(conn *wsConn) {
+	var stop []*languageServer
+	s.lspMu.Lock()
+	for key, ls := range s.languageServers {
+		if ls.detach(conn) {
+			delete(s.languageServers, key)
+			stop = append(stop, ls)
+		}
+	}
+	s.lspMu.Unlock()
+	for _, ls := range stop {
+		ls.stop()
+	}
+}
+
+// closeLanguageServers stops every language server and waits for them to
+// exit.
+func (s *Server) closeLanguageServers(ctx context.Context) error {
+	s.lspMu.Lock()
+	servers := make([]*languageServer, 0, len(s.languageServers))
+	for key, ls := range s.languageServers {
+		servers = append(servers, ls)
+		delete(s.languageServers, key)
+	}
+	s.lspMu.Unlock()
+	for _, ls := range servers {
+		ls.stop()
+	}
+	for _, ls := range servers {
+		select {
+		case <-ls.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// previewCookie carries the session token of preview requests. A page
+// loaded in an iframe or a new tab cannot set the Authorization header, so
+// the token given as query parameter on the first request is kept in a
+// cookie scoped to the previews of that project.
+const previewCookie = "repl_preview"
+
+// previewPath is the path the preview of port is served under.
+func previewPath(projectId uint, port int) string {
+	return fmt.Sprintf("/preview/%d/%d/", projectId, port)
+}
+
+func (s *Server) previewAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := bearerToken(c)
+		if token != "" && c.GetHeader("Authorization") == "" {
+			path := "/preview/" + c.Param("project") + "/"
+			c.SetCookie(previewCookie, token, int(sessionTTL.Seconds()), path, "", c.Request.TLS != nil, true)
+		}
+		if token == "" {
+			token, _ = c.Cookie(previewCookie)
+		}
+		if s.authenticate(c, token) {
+			c.Next()
+		}
+	}
+}
+
+// listPorts reports the ports listening in the workspace and where their
+// previews are served.
+func (sess *wsSession) listPorts(req *Request) (any, error) {
+	ports, err := sess.s.d.ListPorts(sess.ctx, sess.workspaceId)
+	if err != nil {
+		return nil, err
+	}
+	result := ListPortsResult{Ports: make([]PortInfo, 0, len(ports))}
+	for _, p := range ports {
+		result.Ports = append(result.Ports, PortInfo{
+			Port:       p.Port,
+			Loopback:   p.Loopback,
+			PreviewUrl: previewPath(sess.projectId, p.Port),
+		})
+	}
+	return result, nil
+}
+
+// PreviewHandler proxies requests, including WebSocket upgrades for hot
+// reloading, to a port inside the workspace. The path after the port is
+// forwarded and the stripped prefix is passed in X-Forwarded-Prefix.
+func (s *Server) PreviewHandler(c *gin.Context) {
+	project := s.userProject(c, c.Param("project"))
+	if project == nil {
+		return
+	}
+	port, err := strconv.Atoi(c.Param("port"))
+	if err != nil || port < 1 || port > 65535 {
+		c.JSON(400, gin.H{"error": "invalid port"})
+		return
+	}
+	ws := workspaceId(project.Id)
+	if !s.lc.Running(ws) {
+		c.JSON(409, gin.H{"error": "workspace is not running"})
+		return
+	}
+	addr, err := s.d.PortAddress(c.Request.Context(), ws, port)
+	if err != nil {
+		c.JSON(502, gin.H{"error": err.Error()})
+		return
+	}
+	s.lc.Touch(ws)
+
+	prefix := strings.TrimSuffix(previewPath(project.Id, port), "/")
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(&url.URL{Scheme: "http", Host: addr})
+			r.Out.URL.Path = c.Param("path")
+			r.Out.URL.RawPath = ""
+			stripCredentials(r.Out)
+			r.SetXForwarded()
+			r.Out.Header.Set("X-Forwarded-Prefix", prefix)
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(gin.H{"error": fmt.Sprintf("nothing is listening on port %d: %v", port, err)})
+		},
+	}
+	proxy.ServeHTTP(c.Writer, c.Request)
+}
+
+// stripCredentials removes the session token from a proxied request so
+// the workspace never sees it.
+func stripCredentials(r *http.Request) {
+	r.Header.Del("Authorization")
+	if q := r.URL.Query(); q.Has("token") {
+		q.Del("token")
+		r.URL.RawQuery = q.Encode()
+	}
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, cookie := range cookies {
+		if cookie.Name != previewCookie {
+			r.AddCookie(cookie)
+		}
+	}
+}
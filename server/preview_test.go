@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// newDevServer stands in for a dev server in the workspace; the local
+// sandbox reaches workspace ports on the loopback address.
+func newDevServer(t *testing.T) int {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hmr", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			kind, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(kind, msg)
+		}
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"path":   r.URL.Path,
+			"query":  r.URL.RawQuery,
+			"auth":   r.Header.Get("Authorization"),
+			"cookie": r.Header.Get("Cookie"),
+			"prefix": r.Header.Get("X-Forwarded-Prefix"),
+		})
+	})
+	dev := httptest.NewServer(mux)
+	t.Cleanup(dev.Close)
+	u, _ := url.Parse(dev.URL)
+	var port int
+	fmt.Sscanf(u.Port(), "%d", &port)
+	return port
+}
+
+func TestPreviewProxy(t *testing.T) {
+	s, ts := newTestServer(t)
+	userId, token := newTestUser(t, s)
+	projectId := newTestProject(t, s, userId, "demo")
+	port := newDevServer(t)
+	base := ts.URL + previewPath(projectId, port)
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	get := func(url string) (int, map[string]string) {
+		t.Helper()
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("GET %s: %v", url, err)
+		}
+		defer resp.Body.Close()
+		var out map[string]string
+		json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	if code, _ := get(base + "index.html"); code != http.StatusUnauthorized {
+		t.Errorf("expected previews to require a session, got %d", code)
+	}
+
+	code, out := get(base + "src/main.js?token=" + token + "&v=1")
+	if code != http.StatusOK {
+		t.Fatalf("preview failed with %d", code)
+	}
+	if out["path"] != "/src/main.js" || out["query"] != "v=1" || out["prefix"] != strings.TrimSuffix(previewPath(projectId, port), "/") {
+		t.Errorf("unexpected proxied request %+v", out)
+	}
+	if out["auth"] != "" || strings.Contains(out["cookie"], token) {
+		t.Errorf("expected the session token to be stripped, got %+v", out)
+	}
+
+	// Later requests of the page authenticate with the cookie.
+	if code, out := get(base + "assets/logo.svg"); code != http.StatusOK || out["path"] != "/assets/logo.svg" {
+		t.Errorf("expected the preview cookie to authenticate, got %d %+v", code, out)
+	}
+
+	header := http.Header{"Authorization": {"Bearer " + token}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(base, "http")+"hmr", header)
+	if err != nil {
+		t.Fatalf("failed to dial hmr through the proxy: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"ping"}`))
+	if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != `{"type":"ping"}` {
+		t.Errorf("expected the websocket to be proxied, got %q, %v", msg, err)
+	}
+
+	_, otherToken := newTestUser(t, s)
+	req, _ := http.NewRequest(http.MethodGet, base, nil)
+	req.Header.Set("Authorization", "Bearer "+otherToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", base, err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected previews of other users' projects to be hidden, got %d", resp.StatusCode)
+	}
+
+	if code, _ := get(fmt.Sprintf("%s/preview/%d/0/?token=%s", ts.URL, projectId, token)); code != http.StatusBadRequest {
+		t.Errorf("expected an invalid port to be rejected, got %d", code)
+	}
+}
+
+func TestWSListPorts(t *testing.T) {
+	s, ts := newTestServer(t)
+	userId, token := newTestUser(t, s)
+	c := dialWS(t, ts, token, newTestProject(t, s, userId, "demo"))
+	c.hello()
+
+	frame := c.call(MsgListPorts, nil)
+	var result ListPortsResult
+	json.Unmarshal(frame.Payload, &result)
+	if frame.Kind != KindResponse || result.Ports == nil || len(result.Ports) != 0 {
+		t.Errorf("expected no ports in a fresh workspace, got %+v", frame)
+	}
+}
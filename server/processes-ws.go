@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/chrollo-lucifer-12/repl/db"
+)
+
+// processDefinition returns the saved command of a process.
+func (sess *wsSession) processDefinition(name string) ([]string, error) {
+	specs, err := sess.s.db.ListProcesses(sess.projectId)
+	if err != nil {
+		return nil, err
+	}
+	for _, spec := range specs {
+		if spec.Name == name {
+			return spec.Command, nil
+		}
+	}
+	return nil, &FrameError{Code: CodeNotFound, Message: "unknown process " + name}
+}
+
+func decodeProcessPayload(req *Request) (string, error) {
+	var payload ProcessPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return "", err
+	}
+	if payload.Name == "" {
+		return "", &FrameError{Code: CodeInvalidPayload, Message: "process name is required"}
+	}
+	return payload.Name, nil
+}
+
+func (sess *wsSession) startProcess(req *Request) (any, error) {
+	var payload StartProcessPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	if payload.Name == "" {
+		return nil, &FrameError{Code: CodeInvalidPayload, Message: "process name is required"}
+	}
+
+	command := payload.Command
+	var spec *db.ProcessSpec
+	if len(command) == 0 {
+		saved, err := sess.processDefinition(payload.Name)
+		if err != nil {
+			return nil, err
+		}
+		command = saved
+	} else {
+		spec = &db.ProcessSpec{ProjectId: sess.projectId, Name: payload.Name, Command: command}
+	}
+
+	proc, err := sess.s.startProcess(sess.workspaceId, payload.Name, command, spec)
+	if err != nil {
+		return nil, err
+	}
+	return sess.s.processInfo(payload.Name, command, proc), nil
+}
+
+func (sess *wsSession) stopProcess(req *Request) (any, error) {
+	name, err := decodeProcessPayload(req)
+	if err != nil {
+		return nil, err
+	}
+	proc := sess.s.process(sess.workspaceId, name)
+	if proc == nil {
+		return nil, &FrameError{Code: CodeNotFound, Message: "process " + name + " is not running"}
+	}
+	return nil, sess.s.stopProcess(proc)
+}
+
+// restartProcess stops the process if it is running and starts its saved
+// definition again.
+func (sess *wsSession) restartProcess(req *Request) (any, error) {
+	name, err := decodeProcessPayload(req)
+	if err != nil {
+		return nil, err
+	}
+	command, err := sess.processDefinition(name)
+	if err != nil {
+		return nil, err
+	}
+	if proc := sess.s.process(sess.workspaceId, name); proc != nil {
+		if err := sess.s.stopProcess(proc); err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), processStopTimeout)
+		defer cancel()
+		if err := waitProcess(ctx, proc); err != nil {
+			return nil, err
+		}
+	}
+
+	proc, err := sess.s.startProcess(sess.workspaceId, name, command, nil)
+	if err != nil {
+		return nil, err
+	}
+	return sess.s.processInfo(name, command, proc), nil
+}
+
+// removeProcess stops the process and deletes its definition.
+func (sess *wsSession) removeProcess(req *Request) (any, error) {
+	name, err := decodeProcessPayload(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := sess.s.db.DeleteProcess(sess.projectId, name); err != nil {
+		if errors.Is(err, db.ErrProcessNotFound) {
+			return nil, &FrameError{Code: CodeNotFound, Message: "unknown process " + name}
+		}
+		return nil, err
+	}
+	if proc := sess.s.process(sess.workspaceId, name); proc != nil {
+		sess.s.processes.Delete(processKey(sess.workspaceId, name))
+		return nil, sess.s.stopProcess(proc)
+	}
+	return nil, nil
+}
+
+func (sess *wsSession) listProcesses(req *Request) (any, error) {
+	specs, err := sess.s.db.ListProcesses(sess.projectId)
+	if err != nil {
+		return nil, err
+	}
+	processes := make([]ProcessInfo, 0, len(specs))
+	for _, spec := range specs {
+		proc := sess.s.process(sess.workspaceId, spec.Name)
+		processes = append(processes, sess.s.processInfo(spec.Name, spec.Command, proc))
+	}
+	return ListProcessesResult{Processes: processes}, nil
+}
+
+// processLogs returns the scrollback of the last run of a process.
+func (sess *wsSession) processLogs(req *Request) (any, error) {
+	name, err := decodeProcessPayload(req)
+	if err != nil {
+		return nil, err
+	}
+	proc := sess.s.process(sess.workspaceId, name)
+	if proc == nil {
+		if _, err := sess.processDefinition(name); err != nil {
+			return nil, err
+		}
+		return ProcessLogsResult{Name: name}, nil
+	}
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	return ProcessLogsResult{Name: name, Data: string(proc.output.Bytes())}, nil
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// waitProcessExit reads frames until process reports that it exited,
+// collecting its output on the way.
+func (c *testClient) waitProcessExit(process string) (ProcessExitEvent, string) {
+	c.t.Helper()
+	var out strings.Builder
+	for {
+		frame := c.read()
+		if frame.Kind != KindEvent {
+			continue
+		}
+		switch frame.Type {
+		case EventProcessOutput:
+			var event ProcessOutputEvent
+			json.Unmarshal(frame.Payload, &event)
+			if event.Process == process {
+				out.WriteString(event.Data)
+			}
+		case EventProcessExit:
+			var event ProcessExitEvent
+			json.Unmarshal(frame.Payload, &event)
+			if event.Process == process {
+				return event, out.String()
+			}
+		}
+	}
+}
+
+func (c *testClient) listProcesses() map[string]ProcessInfo {
+	c.t.Helper()
+	frame := c.call(MsgListProcesses, nil)
+	var result ListProcessesResult
+	json.Unmarshal(frame.Payload, &result)
+	if frame.Kind != KindResponse {
+		c.t.Fatalf("list_processes failed: %+v", frame)
+	}
+	processes := map[string]ProcessInfo{}
+	for _, p := range result.Processes {
+		processes[p.Name] = p
+	}
+	return processes
+}
+
+func TestWSProcesses(t *testing.T) {
+	s, ts := newTestServer(t)
+	userId, token := newTestUser(t, s)
+	projectId := newTestProject(t, s, userId, "demo")
+	c := dialWS(t, ts, token, projectId)
+	c.hello()
+	// Output goes to every connection on the workspace.
+	other := dialWS(t, ts, token, projectId)
+	other.hello()
+
+	frame := c.call(MsgStartProcess, StartProcessPayload{Name: "build", Command: []string{"sh", "-c", "echo building; exit 2"}})
+	if frame.Kind != KindResponse {
+		t.Fatalf("start_process failed: %+v", frame)
+	}
+	exit, out := other.waitProcessExit("build")
+	if exit.ExitCode != 2 || exit.Stopped || !strings.Contains(out, "building") {
+		t.Errorf("unexpected exit %+v with output %q", exit, out)
+	}
+	if p := c.listProcesses()["build"]; p.Status != ProcessExited || p.ExitCode == nil || *p.ExitCode != 2 {
+		t.Errorf("expected build to have exited with 2, got %+v", p)
+	}
+	frame = c.call(MsgProcessLogs, ProcessPayload{Name: "build"})
+	var logs ProcessLogsResult
+	json.Unmarshal(frame.Payload, &logs)
+	if !strings.Contains(logs.Data, "building") {
+		t.Errorf("expected the output to be kept, got %+v", frame)
+	}
+
+	frame = c.call(MsgStartProcess, StartProcessPayload{Name: "dev server", Command: []string{"sh", "-c", "echo listening; sleep 60"}})
+	if frame.Kind != KindResponse {
+		t.Fatalf("start_process failed: %+v", frame)
+	}
+	frame = c.call(MsgStartProcess, StartProcessPayload{Name: "dev server"})
+	if frame.Kind != KindError || frame.Error.Code != CodeAlreadyRunning {
+		t.Errorf("expected already_running, got %+v", frame)
+	}
+	// A start refused for the running one keeps the saved definition.
+	frame = c.call(MsgStartProcess, StartProcessPayload{Name: "dev server", Command: []string{"true"}})
+	if frame.Kind != KindError || frame.Error.Code != CodeAlreadyRunning {
+		t.Errorf("expected already_running, got %+v", frame)
+	}
+	if specs, _ := s.db.ListProcesses(projectId); len(specs) != 2 || specs[1].Command[0] != "sh" {
+		t.Errorf("saved definitions after a refused start: %+v", specs)
+	}
+	if p := c.listProcesses()["dev server"]; p.Status != ProcessRunning || p.Pid == 0 {
+		t.Errorf("expected dev server to be running, got %+v", p)
+	}
+
+	// The exit of the old run is announced before the restart is answered.
+	id := c.send(MsgRestartProcess, ProcessPayload{Name: "dev server"})
+	exit = ProcessExitEvent{}
+	for {
+		frame = c.read()
+		if frame.Kind == KindEvent && frame.Type == EventProcessExit {
+			json.Unmarshal(frame.Payload, &exit)
+			continue
+		}
+		if frame.ID == id {
+			break
+		}
+	}
+	var restarted ProcessInfo
+	json.Unmarshal(frame.Payload, &restarted)
+	if frame.Kind != KindResponse || restarted.Status != ProcessRunning {
+		t.Fatalf("restart_process failed: %+v", frame)
+	}
+	if !exit.Stopped {
+		t.Errorf("expected the old run to be reported as stopped, got %+v", exit)
+	}
+
+	c.call(MsgStopProcess, ProcessPayload{Name: "dev server"})
+	c.waitProcessExit("dev server")
+	if p := c.listProcesses()["dev server"]; p.Status != ProcessStopped {
+		t.Errorf("expected dev server to be stopped, got %+v", p)
+	}
+
+	// Definitions are saved, so a stopped process starts again by name.
+	frame = c.call(MsgStartProcess, StartProcessPayload{Name: "dev server"})
+	if frame.Kind != KindResponse {
+		t.Fatalf("start_process by name failed: %+v", frame)
+	}
+	c.call(MsgRemoveProcess, ProcessPayload{Name: "dev server"})
+	c.waitProcessExit("dev server")
+	if _, ok := c.listProcesses()["dev server"]; ok {
+		t.Error("expected removed process to be gone")
+	}
+
+	frame = c.call(MsgStartProcess, StartProcessPayload{Name: "missing"})
+	if frame.Kind != KindError || frame.Error.Code != CodeNotFound {
+		t.Errorf("expected not_found for an undefined process, got %+v", frame)
+	}
+	frame = c.call(MsgStartProcess, StartProcessPayload{Command: []string{"true"}})
+	if frame.Kind != KindError || frame.Error.Code != CodeInvalidPayload {
+		t.Errorf("expected invalid_payload without a name, got %+v", frame)
+	}
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/chrollo-lucifer-12/repl/db"
+)
+
+// processStopTimeout bounds how long restart_process waits for the old
+// process to exit before starting the new one.
+const processStopTimeout = 10 * time.Second
+
+// processSession is a run of a named background process of a workspace,
+// such as its dev server. Its output goes to every connection on the
+// workspace and is kept in a scrollback for process_logs. The session stays
+// known after the process exits so its exit code can be listed.
+type processSession struct {
+	s           *Server
+	workspaceId string
+	name        string
+	command     []string
+	execId      string
+	// done is closed once the process has exited, exitCode is set and the
+	// exit has been announced.
+	done <-chan struct{}
+
+	mu       sync.Mutex
+	output   *ringBuffer
+	stopped  bool
+	exitCode *int
+}
+
+func processKey(workspaceId, name string) string {
+	return workspaceId + "/" + name
+}
+
+// Write records process output and broadcasts it. It never fails and the
+// broadcast only queues the output, so a dead connection cannot stall the
+// process.
+func (p *processSession) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	p.output.Write(b)
+	p.mu.Unlock()
+	p.s.broadcast(p.workspaceId, EventProcessOutput, ProcessOutputEvent{Process: p.name, Data: string(b)})
+	return len(b), nil
+}
+
+func (p *processSession) running() bool {
+	select {
+	case <-p.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// broadcast queues an event for every connection on the workspace.
+func (s *Server) broadcast(workspaceId, eventType string, payload any) {
+	s.conns.Range(func(key, value any) bool {
+		if value.(string) == workspaceId {
+			key.(*wsConn).queue(eventType, payload)
+		}
+		return true
+	})
+}
+
+// process looks up the last run of a process, or returns nil.
+func (s *Server) process(workspaceId, name string) *processSession {
+	proc, ok := s.processes.Load(processKey(workspaceId, name))
+	if !ok {
+		return nil
+	}
+	return proc.(*processSession)
+}
+
+// startProcess starts command as the process name of the workspace,
+// replacing its previous run. The exit is announced with a process_exit
+// event. A non-nil spec is saved as the definition of the process once it
+// is known not to be running, so a start that fails for that leaves the
+// saved definition alone.
+func (s *Server) startProcess(workspaceId, name string, command []string, spec *db.ProcessSpec) (*processSession, error) {
+	s.processMu.Lock()
+	defer s.processMu.Unlock()
+	if prev := s.process(workspaceId, name); prev != nil && prev.running() {
+		return nil, &FrameError{Code: CodeAlreadyRunning, Message: "process " + name + " is already running"}
+	}
+	if spec != nil {
+		if err := s.db.SaveProcess(*spec); err != nil {
+			return nil, err
+		}
+	}
+
+	proc := &processSession{
+		s:           s,
+		workspaceId: workspaceId,
+		name:        name,
+		command:     command,
+		output:      newRingBuffer(s.cfg.Terminal.Scrollback),
+	}
+	started, err := s.d.StartLongRunningProcess(context.Background(), workspaceId, command, proc)
+	if err != nil {
+		return nil, err
+	}
+	proc.execId = started.ExecId
+	done := make(chan struct{})
+	proc.done = done
+	s.processes.Store(processKey(workspaceId, name), proc)
+
+	go func() {
+		<-started.Done
+		code := -1
+		if status, err := s.d.InspectProcess(context.Background(), workspaceId, started.ExecId); err == nil {
+			code = status.ExitCode
+		}
+		proc.mu.Lock()
+		proc.exitCode = &code
+		stopped := proc.stopped
+		proc.mu.Unlock()
+		s.broadcast(workspaceId, EventProcessExit, ProcessExitEvent{Process: name, ExitCode: code, Stopped: stopped})
+		close(done)
+	}()
+
+	return proc, nil
+}
+
+// stopProcess kills a running process. Stopping a process that already
+// exited does nothing.
+func (s *Server) stopProcess(proc *processSession) error {
+	if !proc.running() {
+		return nil
+	}
+	proc.mu.Lock()
+	proc.stopped = true
+	proc.mu.Unlock()
+	return s.d.StopProcess(context.Background(), proc.workspaceId, proc.execId)
+}
+
+// waitProcess waits for a stopped process to exit.
+func waitProcess(ctx context.Context, proc *processSession) error {
+	select {
+	case <-proc.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// processInfo describes the process defined as command and its last run,
+// if any.
+func (s *Server) processInfo(name string, command []string, proc *processSession) ProcessInfo {
+	info := ProcessInfo{Name: name, Command: command, Status: ProcessIdle}
+	if proc == nil {
+		return info
+	}
+	if proc.running() {
+		info.Status = ProcessRunning
+		if status, err := s.d.InspectProcess(context.Background(), proc.workspaceId, proc.execId); err == nil {
+			info.Pid = status.Pid
+		}
+		return info
+	}
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	info.Status = ProcessExited
+	if proc.stopped {
+		info.Status = ProcessStopped
+	}
+	info.ExitCode = proc.exitCode
+	return info
+}
+
+// closeProcesses stops every background process and waits for them to
+// exit.
+func (s *Server) closeProcesses(ctx context.Context) error {
+	var procs []*processSession
+	s.processes.Range(func(key, value any) bool {
+		procs = append(procs, value.(*processSession))
+		return true
+	})
+
+	for _, proc := range procs {
+		if err := s.stopProcess(proc); err != nil {
+			s.l.Error("failed to stop process", "process", proc.name, "workspace", proc.workspaceId, "error", err)
+		}
+	}
+	for _, proc := range procs {
+		if err := waitProcess(ctx, proc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/chrollo-lucifer-12/repl/db"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+	"github.com/chrollo-lucifer-12/repl/templates"
+)
+
+// projectTemplate loads the connection's project and its template.
+func (sess *wsSession) projectTemplate() (*db.CreatedProject, *templates.Template, error) {
+	project, err := sess.s.db.FindProject(sess.projectId)
+	if err != nil {
+		return nil, nil, err
+	}
+	tpl, err := sess.s.template(project)
+	if err != nil {
+		return nil, nil, err
+	}
+	return project, tpl, nil
+}
+
+// template returns the template of a project, falling back to the default
+// template for templates that no longer exist.
+func (s *Server) template(project *db.CreatedProject) (*templates.Template, error) {
+	tpl, ok := s.t.Get(project.Template)
+	if !ok {
+		tpl, _ = s.t.Get(templates.DefaultTemplate)
+	}
+	if tpl == nil {
+		return nil, &FrameError{Code: CodeNotFound, Message: "unknown template " + project.Template}
+	}
+	return tpl, nil
+}
+
+func (sess *wsSession) initProject(req *Request) (any, error) {
+	var payload InitProjectPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	project, tpl, err := sess.projectTemplate()
+	if err != nil {
+		return nil, err
+	}
+
+	spec := sess.s.containerSpec(project, tpl)
+	spec.Progress = func(p sandbox.PullProgress) {
+		sess.conn.emit(EventImagePull, p)
+	}
+	containerId, err := sess.s.d.StartContainer(sess.ctx, sess.writer, sess.workspaceId, spec)
+	if err != nil {
+		return nil, err
+	}
+	sess.s.lc.Started(sess.workspaceId)
+
+	result := InitProjectResult{ContainerId: containerId, Template: tpl.Id}
+	if !project.Scaffolded {
+		if _, busy := sess.s.scaffolding.LoadOrStore(project.Id, true); !busy {
+			result.Scaffolding = true
+			// Progress is reported after the response announcing it.
+			sess.afterResponse = func() {
+				go func() {
+					defer sess.s.scaffolding.Delete(project.Id)
+					sess.s.scaffold(context.Background(), sess.conn, project.Id, tpl)
+				}()
+			}
+		}
+	}
+	return result, nil
+}
+
+// containerSpec is the container a project's workspace is created with.
+// Projects created before images and profiles were stored on them fall
+// back to the template image and the default profile.
+func (s *Server) containerSpec(project *db.CreatedProject, tpl *templates.Template) sandbox.ContainerSpec {
+	spec := sandbox.ContainerSpec{Image: project.Image, Resources: project.Resources}
+	if spec.Image == "" {
+		spec.Image = tpl.Image
+	}
+	if project.Profile == "" {
+		_, spec.Resources, _ = s.p.Profile("")
+	}
+	return spec
+}
+
+// scaffold writes the template files into a fresh workspace and runs the
+// template commands, reporting progress to conn. The project is only
+// marked as scaffolded when every step succeeded.
+func (s *Server) scaffold(ctx context.Context, conn *wsConn, projectId uint, tpl *templates.Template) {
+	ws := workspaceId(projectId)
+	files := make([]string, 0, len(tpl.Files))
+	for p := range tpl.Files {
+		files = append(files, p)
+	}
+	sort.Strings(files)
+
+	event := ScaffoldEvent{Template: tpl.Id, Status: ScaffoldRunning, Total: len(files) + len(tpl.Commands)}
+	fail := func(err error) {
+		s.l.Error("scaffold failed", "workspace", ws, "template", tpl.Id, "error", err)
+		event.Status = ScaffoldFailed
+		event.Error = err.Error()
+		conn.emit(EventScaffold, event)
+	}
+	progress := func(message string) {
+		event.Step++
+		event.Message = message
+		conn.emit(EventScaffold, event)
+	}
+
+	for _, p := range files {
+		progress("writing " + p)
+		if dir := path.Dir(p); dir != "." {
+			if err := s.d.CreateDir(ctx, ws, dir); err != nil {
+				fail(err)
+				return
+			}
+		}
+		if err := s.d.WriteFile(ctx, ws, p, []byte(tpl.Files[p])); err != nil {
+			fail(err)
+			return
+		}
+	}
+	for _, cmd := range tpl.Commands {
+		progress("running " + strings.Join(cmd, " "))
+		if err := s.runCommand(ctx, ws, cmd, &wsWriter{conn: conn}); err != nil {
+			fail(err)
+			return
+		}
+	}
+
+	if err := s.db.MarkProjectScaffolded(projectId); err != nil {
+		fail(err)
+		return
+	}
+	event.Status = ScaffoldDone
+	event.Message = ""
+	conn.emit(EventScaffold, event)
+}
+
+// runCommand runs cmd in the workspace, streaming its output to out, and
+// fails unless it exits with status 0.
+func (s *Server) runCommand(ctx context.Context, ws string, cmd []string, out io.Writer) error {
+	proc, err := s.d.StartPipedProcess(ctx, ws, cmd, nil, out, out)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-proc.Done:
+	case <-ctx.Done():
+		s.d.StopProcess(context.Background(), ws, proc.ExecId)
+		return ctx.Err()
+	}
+	status, err := s.d.InspectProcess(ctx, ws, proc.ExecId)
+	if err != nil {
+		return err
+	}
+	if status.ExitCode != 0 {
+		return fmt.Errorf("%s exited with status %d", strings.Join(cmd, " "), status.ExitCode)
+	}
+	return nil
+}
+
+// runProject opens a terminal running the template's run command.
+func (sess *wsSession) runProject(req *Request) (any, error) {
+	var payload RunProjectPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	_, tpl, err := sess.projectTemplate()
+	if err != nil {
+		return nil, err
+	}
+	if len(tpl.RunCommand) == 0 {
+		return nil, &FrameError{Code: CodeNotFound, Message: "template " + tpl.Id + " has no run command"}
+	}
+	size, err := terminalSize(payload.Rows, payload.Cols, defaultTerminalSize)
+	if err != nil {
+		return nil, err
+	}
+
+	term, start, err := sess.s.startTerminal(sess.workspaceId, size, sess.conn)
+	if err != nil {
+		return nil, err
+	}
+	sess.afterResponse = start
+	if err := term.sendInput([]byte(shellJoin(tpl.RunCommand) + "\n")); err != nil {
+		return nil, err
+	}
+	return term.result(), nil
+}
+
+// shellJoin quotes args for sh so they are typed into a shell verbatim.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/chrollo-lucifer-12/repl/db"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+	"github.com/chrollo-lucifer-12/repl/templates"
+)
+
+// waitScaffold reads frames until scaffolding finishes and returns the
+// final scaffold event along with the number of progress events seen.
+func (c *testClient) waitScaffold() (ScaffoldEvent, int) {
+	c.t.Helper()
+	steps := 0
+	for {
+		frame := c.read()
+		if frame.Kind != KindEvent || frame.Type != EventScaffold {
+			continue
+		}
+		var event ScaffoldEvent
+		json.Unmarshal(frame.Payload, &event)
+		if event.Status != ScaffoldRunning {
+			return event, steps
+		}
+		steps++
+	}
+}
+
+func TestWSScaffoldsProjectFromTemplate(t *testing.T) {
+	s, ts := newTestServer(t)
+	userId, token := newTestUser(t, s)
+	project, err := s.db.CreateProject(db.ProjectSpec{Slug: "demo", UserId: userId, Template: "test"})
+	if err != nil {
+		t.Fatalf("failed to create project: %v", err)
+	}
+
+	c := dialWS(t, ts, token, project.Id)
+	c.hello()
+
+	frame := c.call(MsgInitProject, nil)
+	var result InitProjectResult
+	json.Unmarshal(frame.Payload, &result)
+	if frame.Kind != KindResponse || !result.Scaffolding || result.Template != "test" {
+		t.Fatalf("expected init_project to start scaffolding, got %+v", frame)
+	}
+	event, steps := c.waitScaffold()
+	if event.Status != ScaffoldDone || steps != 2 || event.Total != 2 {
+		t.Fatalf("expected scaffolding to finish in two steps, got %+v after %d steps", event, steps)
+	}
+
+	frame = c.call(MsgReadFile, ReadFilePayload{Path: "build.txt"})
+	var read ReadFileResult
+	json.Unmarshal(frame.Payload, &read)
+	if read.Content != "built\n" {
+		t.Errorf("expected the template command to have run, got %+v", frame)
+	}
+
+	frame = c.call(MsgInitProject, nil)
+	json.Unmarshal(frame.Payload, &result)
+	if frame.Kind != KindResponse || result.Scaffolding {
+		t.Errorf("expected a scaffolded project not to be scaffolded again, got %+v", frame)
+	}
+
+	frame = c.call(MsgRunProject, nil)
+	var term TerminalResult
+	json.Unmarshal(frame.Payload, &term)
+	if frame.Kind != KindResponse {
+		t.Fatalf("run_project failed: %+v", frame.Error)
+	}
+	c.waitOutput(term.Terminal, "hello")
+}
+
+func TestWSScaffoldFailsOnCommandError(t *testing.T) {
+	s, ts := newTestServer(t)
+	var err error
+	s.t, err = templates.NewRegistry(templates.Template{
+		Id:       "broken",
+		Image:    sandbox.DefaultImage,
+		Commands: [][]string{{"sh", "-c", "echo install failed; exit 1"}, {"touch", "never.txt"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	userId, token := newTestUser(t, s)
+	project, err := s.db.CreateProject(db.ProjectSpec{Slug: "demo", UserId: userId, Template: "broken"})
+	if err != nil {
+		t.Fatalf("failed to create project: %v", err)
+	}
+
+	c := dialWS(t, ts, token, project.Id)
+	c.hello()
+	c.send(MsgInitProject, nil)
+	var output strings.Builder
+	var event ScaffoldEvent
+	for {
+		frame := c.read()
+		if frame.Kind != KindEvent {
+			continue
+		}
+		if frame.Type == EventOutput {
+			var out OutputEvent
+			json.Unmarshal(frame.Payload, &out)
+			output.WriteString(out.Data)
+			continue
+		}
+		if frame.Type == EventScaffold {
+			json.Unmarshal(frame.Payload, &event)
+			if event.Status != ScaffoldRunning {
+				break
+			}
+		}
+	}
+	if event.Status != ScaffoldFailed || event.Step != 1 || !strings.Contains(event.Error, "status 1") {
+		t.Errorf("expected scaffolding to fail at the first command, got %+v", event)
+	}
+	if !strings.Contains(output.String(), "install failed") {
+		t.Errorf("expected the command output to be streamed, got %q", output.String())
+	}
+	if p, _ := s.db.FindProject(project.Id); p.Scaffolded {
+		t.Error("expected a failed scaffold not to mark the project scaffolded")
+	}
+	if _, err := readWorkspaceFile(t, s, project.Id, "never.txt"); err == nil {
+		t.Error("expected the commands after the failed one not to run")
+	}
+}
+
+func TestWSInitProjectReportsImageReady(t *testing.T) {
+	s, ts := newTestServer(t)
+	userId, token := newTestUser(t, s)
+	project, err := s.db.CreateProject(db.ProjectSpec{Slug: "demo", UserId: userId, Template: "blank"})
+	if err != nil {
+		t.Fatalf("failed to create project: %v", err)
+	}
+
+	c := dialWS(t, ts, token, project.Id)
+	c.hello()
+	id := c.send(MsgInitProject, nil)
+	var pull *sandbox.PullProgress
+	for {
+		frame := c.read()
+		if frame.Kind == KindEvent && frame.Type == EventImagePull {
+			pull = &sandbox.PullProgress{}
+			json.Unmarshal(frame.Payload, pull)
+			continue
+		}
+		if frame.ID == id {
+			break
+		}
+	}
+	if pull == nil || pull.Status != sandbox.PullReady || pull.Percent != 100 || pull.Image != sandbox.DefaultImage {
+		t.Errorf("expected an image ready event before the response, got %+v", pull)
+	}
+}
+
+func TestShellJoin(t *testing.T) {
+	got := shellJoin([]string{"echo", "it's", "$HOME"})
+	want := `'echo' 'it'\''s' '$HOME'`
+	if got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
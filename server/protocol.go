@@ -0,0 +1,652 @@
+package server
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/chrollo-lucifer-12/repl/collab"
+	"github.com/chrollo-lucifer-12/repl/git"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+)
+
+// ProtocolVersion is the newest WebSocket protocol version the server speaks.
+const ProtocolVersion = 1
+
+var supportedVersions = []int{1}
+
+// Message types a client can send.
+const (
+	MsgHello           = "hello"
+	MsgInitProject     = "init_project"
+	MsgRunProject      = "run_project"
+	MsgInput           = "input"
+	MsgWriteFile       = "write_file"
+	MsgReadFile        = "read_file"
+	MsgListFiles       = "list_files"
+	MsgRemoveFile      = "remove_file"
+	MsgStatFile        = "stat_file"
+	MsgSearchFile      = "search_file"
+	MsgRenameFile      = "rename_file"
+	MsgCreateDir       = "create_dir"
+	MsgResizeTerminal  = "resize_terminal"
+	MsgOpenTerminal    = "open_terminal"
+	MsgCloseTerminal   = "close_terminal"
+	MsgAttachTerminal  = "attach_terminal"
+	MsgListTerminals   = "list_terminals"
+	MsgStartProcess    = "start_process"
+	MsgStopProcess     = "stop_process"
+	MsgRestartProcess  = "restart_process"
+	MsgRemoveProcess   = "remove_process"
+	MsgListProcesses   = "list_processes"
+	MsgProcessLogs     = "process_logs"
+	MsgListPorts       = "list_ports"
+	MsgWatchFiles      = "watch_files"
+	MsgUnwatchFiles    = "unwatch_files"
+	MsgCreateSnapshot  = "create_snapshot"
+	MsgListSnapshots   = "list_snapshots"
+	MsgRestoreSnapshot = "restore_snapshot"
+	MsgDeleteSnapshot  = "delete_snapshot"
+	MsgGitInit         = "git_init"
+	MsgGitStatus       = "git_status"
+	MsgGitDiff         = "git_diff"
+	MsgGitStage        = "git_stage"
+	MsgGitUnstage      = "git_unstage"
+	MsgGitCommit       = "git_commit"
+	MsgGitLog          = "git_log"
+	MsgGitBranches     = "git_branches"
+	MsgGitCheckout     = "git_checkout"
+	MsgGitRemotes      = "git_remotes"
+	MsgGitAddRemote    = "git_add_remote"
+	MsgGitFetch        = "git_fetch"
+	MsgGitPush         = "git_push"
+	MsgGitPull         = "git_pull"
+	MsgLspStart        = "lsp_start"
+	MsgLspMessage      = "lsp_message"
+	MsgLspStop         = "lsp_stop"
+	MsgOpenDoc         = "open_doc"
+	MsgEditDoc         = "edit_doc"
+	MsgUpdateCursor    = "update_cursor"
+	MsgCloseDoc        = "close_doc"
+)
+
+// Events the server pushes without a matching request.
+const (
+	EventOutput       = "output"
+	EventTerminalExit = "terminal_exit"
+	EventScaffold     = "scaffold"
+	// EventTerminalDetached tells a connection that fell too far behind
+	// the output of a terminal that it was detached from it. It can attach
+	// again to get the scrollback.
+	EventTerminalDetached = "terminal_detached"
+	// EventImagePull carries a sandbox.PullProgress while init_project
+	// prepares the image of a new workspace container.
+	EventImagePull = "image_pull"
+	// EventShutdown is sent to every connection right before the server
+	// closes it on shutdown.
+	EventShutdown = "shutdown"
+	// EventProcessOutput and EventProcessExit are sent to every connection
+	// on the workspace of the process.
+	EventProcessOutput = "process_output"
+	EventProcessExit   = "process_exit"
+	// EventFileChanges is sent to connections that sent watch_files.
+	EventFileChanges = "file_changes"
+	// EventSnapshotRestored is sent to every connection on a workspace
+	// once a snapshot was restored into it.
+	EventSnapshotRestored = "snapshot_restored"
+	// EventLspMessage carries a message from a language server to the
+	// connections that started it; EventLspExit reports that it exited.
+	EventLspMessage = "lsp_message"
+	EventLspExit    = "lsp_exit"
+	// EventDocEdit, EventDocCursor and EventDocPeer are sent to every
+	// connection that opened the document, in the order the server
+	// applied the edits.
+	EventDocEdit   = "doc_edit"
+	EventDocCursor = "doc_cursor"
+	EventDocPeer   = "doc_peer"
+	// EventDocClosed tells the peers of a document that its file changed
+	// outside of it, by a restore, an import, git or a rename or removal,
+	// and that they have to open it again.
+	EventDocClosed = "doc_closed"
+)
+
+// Frame kinds sent by the server.
+const (
+	KindResponse = "response"
+	KindError    = "error"
+	KindEvent    = "event"
+)
+
+// Error codes carried by error frames.
+const (
+	CodeInvalidJSON        = "invalid_json"
+	CodeInvalidPayload     = "invalid_payload"
+	CodeUnknownType        = "unknown_type"
+	CodeHandshakeRequired  = "handshake_required"
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeNotFound           = "not_found"
+	CodeOutsideWorkspace   = "outside_workspace"
+	CodeIsDirectory        = "is_directory"
+	CodeFileTooLarge       = "file_too_large"
+	CodeContainerGone      = "container_gone"
+	CodeImageNotFound      = "image_not_found"
+	CodeRuntimeUnavailable = "runtime_unavailable"
+	CodeQuotaExceeded      = "quota_exceeded"
+	CodeAlreadyRunning     = "already_running"
+	CodeAlreadyExists      = "already_exists"
+	CodeBusy               = "busy"
+	CodeUnsupported        = "unsupported"
+	CodeNotRepository      = "not_repository"
+	CodeGitFailed          = "git_failed"
+	CodeStaleRevision      = "stale_revision"
+	CodeInternal           = "internal"
+)
+
+// File contents travel as UTF-8 text unless they are not valid UTF-8, in
+// which case they are base64 encoded and marked as such.
+const (
+	EncodingUTF8   = "utf8"
+	EncodingBase64 = "base64"
+)
+
+// Request is the envelope of every client message. ID is chosen by the
+// client and echoed back on the response or error frame it causes; requests
+// without an ID only hear back when they fail. The first request on a
+// connection must be a hello that negotiates the protocol version.
+type Request struct {
+	Version int             `json:"v,omitempty"`
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Frame is the envelope of every server message.
+type Frame struct {
+	Kind    string      `json:"kind"`
+	Version int         `json:"v"`
+	ID      string      `json:"id,omitempty"`
+	Type    string      `json:"type"`
+	Payload any         `json:"payload,omitempty"`
+	Error   *FrameError `json:"error,omitempty"`
+}
+
+type FrameError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *FrameError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+type HelloPayload struct {
+	Versions []int `json:"versions"`
+}
+
+type HelloResult struct {
+	Version int `json:"version"`
+}
+
+type InitProjectPayload struct{}
+
+// InitProjectResult reports the started container. Scaffolding is set
+// when the workspace is being scaffolded from its template; progress is
+// reported with scaffold events.
+type InitProjectResult struct {
+	ContainerId string `json:"containerId"`
+	Template    string `json:"template"`
+	Scaffolding bool   `json:"scaffolding"`
+}
+
+// RunProjectPayload opens a terminal of the given size, like
+// open_terminal, running the template's run command.
+type RunProjectPayload struct {
+	Rows int `json:"rows,omitempty"`
+	Cols int `json:"cols,omitempty"`
+}
+
+// Scaffold statuses.
+const (
+	ScaffoldRunning = "running"
+	ScaffoldDone    = "done"
+	ScaffoldFailed  = "failed"
+)
+
+// ScaffoldEvent reports progress while a workspace is scaffolded. Step
+// counts from 1 to Total while running; output of scaffold commands
+// arrives as output events in between.
+type ScaffoldEvent struct {
+	Template string `json:"template"`
+	Status   string `json:"status"`
+	Step     int    `json:"step"`
+	Total    int    `json:"total"`
+	Message  string `json:"message,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type ShutdownEvent struct {
+	Reason string `json:"reason"`
+}
+
+type InputPayload struct {
+	Terminal string `json:"terminal"`
+	Data     string `json:"data"`
+}
+
+type WriteFilePayload struct {
+	Path     string `json:"path"`
+	Content  string `json:"content"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type ReadFilePayload struct {
+	Path string `json:"path"`
+}
+
+type ReadFileResult struct {
+	Path     string `json:"path"`
+	Content  string `json:"content"`
+	Encoding string `json:"encoding"`
+}
+
+type ListFilesPayload struct {
+	Path string `json:"path"`
+}
+
+type ListFilesResult struct {
+	Path  string             `json:"path"`
+	Files []sandbox.FileInfo `json:"files"`
+}
+
+type RemoveFilePayload struct {
+	Path string `json:"path"`
+}
+
+type StatFilePayload struct {
+	Path string `json:"path"`
+}
+
+type SearchFilePayload struct {
+	Path   string `json:"path"`
+	Search string `json:"search"`
+}
+
+type SearchFileResult struct {
+	Path    string                `json:"path"`
+	Matches []sandbox.SearchMatch `json:"matches"`
+}
+
+type RenameFilePayload struct {
+	Path    string `json:"path"`
+	NewName string `json:"newName"`
+}
+
+type CreateDirPayload struct {
+	Path string `json:"path"`
+}
+
+// OpenTerminalPayload carries the initial size of the terminal. Leaving
+// both dimensions out opens a 24x80 terminal.
+type OpenTerminalPayload struct {
+	Rows int `json:"rows,omitempty"`
+	Cols int `json:"cols,omitempty"`
+}
+
+// TerminalResult answers open_terminal, attach_terminal and
+// resize_terminal with the size the terminal now has.
+type TerminalResult struct {
+	Terminal string `json:"terminal"`
+	Rows     int    `json:"rows"`
+	Cols     int    `json:"cols"`
+}
+
+// AttachTerminalPayload optionally resizes the terminal to the size of the
+// attaching client.
+type AttachTerminalPayload struct {
+	Terminal string `json:"terminal"`
+	Rows     int    `json:"rows,omitempty"`
+	Cols     int    `json:"cols,omitempty"`
+}
+
+type TerminalInfo struct {
+	Terminal string `json:"terminal"`
+	Attached bool   `json:"attached"`
+	Rows     int    `json:"rows"`
+	Cols     int    `json:"cols"`
+}
+
+type ListTerminalsResult struct {
+	Terminals []TerminalInfo `json:"terminals"`
+}
+
+type CloseTerminalPayload struct {
+	Terminal string `json:"terminal"`
+}
+
+type ResizeTerminalPayload struct {
+	Terminal string `json:"terminal"`
+	Rows     int    `json:"rows"`
+	Cols     int    `json:"cols"`
+}
+
+// Process statuses. A process is idle when it is defined but has not been
+// started since the server started.
+const (
+	ProcessIdle    = "idle"
+	ProcessRunning = "running"
+	ProcessExited  = "exited"
+	ProcessStopped = "stopped"
+)
+
+// StartProcessPayload starts a named background process. The command is
+// saved as the definition of the process; leaving it out starts the saved
+// definition.
+type StartProcessPayload struct {
+	Name    string   `json:"name"`
+	Command []string `json:"command,omitempty"`
+}
+
+type ProcessPayload struct {
+	Name string `json:"name"`
+}
+
+// ProcessInfo describes a process definition and the state of its last
+// run. ExitCode is set once the process has exited or was stopped.
+type ProcessInfo struct {
+	Name     string   `json:"name"`
+	Command  []string `json:"command"`
+	Status   string   `json:"status"`
+	ExitCode *int     `json:"exitCode,omitempty"`
+	Pid      int      `json:"pid,omitempty"`
+}
+
+type ListProcessesResult struct {
+	Processes []ProcessInfo `json:"processes"`
+}
+
+// ProcessLogsResult carries the recent output of a process.
+type ProcessLogsResult struct {
+	Name string `json:"name"`
+	Data string `json:"data"`
+}
+
+type ProcessOutputEvent struct {
+	Process string `json:"process"`
+	Data    string `json:"data"`
+}
+
+// ProcessExitEvent reports that a process exited; Stopped is set when it
+// was stopped by a client.
+type ProcessExitEvent struct {
+	Process  string `json:"process"`
+	ExitCode int    `json:"exitCode"`
+	Stopped  bool   `json:"stopped,omitempty"`
+}
+
+// PortInfo is a port listening in the workspace. PreviewUrl is the path
+// the server proxies to it; ports listening on loopback only cannot be
+// previewed until the dev server binds 0.0.0.0.
+type PortInfo struct {
+	Port       int    `json:"port"`
+	Loopback   bool   `json:"loopback"`
+	PreviewUrl string `json:"previewUrl"`
+}
+
+type ListPortsResult struct {
+	Ports []PortInfo `json:"ports"`
+}
+
+// FileChange is a change to a file or directory of the workspace. Kind is
+// created, modified, deleted or renamed; OldPath is set for renames.
+type FileChange struct {
+	Kind    string `json:"kind"`
+	Path    string `json:"path"`
+	OldPath string `json:"oldPath,omitempty"`
+}
+
+// FileChangesEvent carries the changes of one debounce period, in the
+// order the paths first changed. Ignored paths such as node_modules are
+// never reported.
+type FileChangesEvent struct {
+	Changes []FileChange `json:"changes"`
+}
+
+// CreateSnapshotPayload takes a snapshot of the workspace files and, with
+// Container, of the container.
+type CreateSnapshotPayload struct {
+	Name      string `json:"name"`
+	Container bool   `json:"container,omitempty"`
+}
+
+type SnapshotPayload struct {
+	Id uint `json:"id"`
+}
+
+// SnapshotInfo describes a snapshot. Size is the size of its compressed
+// file archive; Container is set when the container was saved too.
+type SnapshotInfo struct {
+	Id        uint      `json:"id"`
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	Container bool      `json:"container"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type ListSnapshotsResult struct {
+	Snapshots []SnapshotInfo `json:"snapshots"`
+}
+
+type RestoreSnapshotResult struct {
+	ContainerId string `json:"containerId"`
+}
+
+// SnapshotRestoredEvent reports that the workspace was replaced with a
+// snapshot. Terminals and processes of the previous container are gone.
+type SnapshotRestoredEvent struct {
+	Snapshot    SnapshotInfo `json:"snapshot"`
+	ContainerId string       `json:"containerId"`
+}
+
+// GitPathsPayload names the files git_stage and git_unstage act on, all
+// of them when Paths is empty.
+type GitPathsPayload struct {
+	Paths []string `json:"paths,omitempty"`
+}
+
+// GitDiffPayload asks for the unstaged changes to Paths, or with Staged
+// the staged ones. The result is a git.Diff.
+type GitDiffPayload struct {
+	Staged bool     `json:"staged,omitempty"`
+	Paths  []string `json:"paths,omitempty"`
+}
+
+// GitCommitPayload commits the staged changes as the connected user. The
+// result is the new git.Commit.
+type GitCommitPayload struct {
+	Message string `json:"message"`
+}
+
+// GitLogPayload lists the commits reachable from Ref, HEAD by default.
+type GitLogPayload struct {
+	Ref   string `json:"ref,omitempty"`
+	Limit int    `json:"limit,omitempty"`
+}
+
+type GitLogResult struct {
+	Commits []git.Commit `json:"commits"`
+}
+
+type GitBranchesResult struct {
+	Branches []git.Branch `json:"branches"`
+}
+
+// GitCheckoutPayload switches to Ref, creating it as a new branch at HEAD
+// with Create.
+type GitCheckoutPayload struct {
+	Ref    string `json:"ref"`
+	Create bool   `json:"create,omitempty"`
+}
+
+type GitRemotesResult struct {
+	Remotes []git.Remote `json:"remotes"`
+}
+
+type GitAddRemotePayload struct {
+	Name string `json:"name"`
+	Url  string `json:"url"`
+}
+
+// GitRemotePayload names the remote of git_fetch, git_push and git_pull,
+// origin by default, and the branch pushed or pulled, by default the
+// current one. SetUpstream makes a pushed branch track the remote one.
+type GitRemotePayload struct {
+	Remote      string `json:"remote,omitempty"`
+	Branch      string `json:"branch,omitempty"`
+	SetUpstream bool   `json:"setUpstream,omitempty"`
+}
+
+// LspStartPayload attaches the connection to the language server of
+// Language in the workspace, starting it if no connection did yet.
+type LspStartPayload struct {
+	Language string `json:"language"`
+}
+
+// LspStartResult tells the client the URI of the workspace root, the
+// rootUri of its initialize request. File URIs of messages are relative
+// to it, whatever directory the server runs in.
+type LspStartResult struct {
+	Language string `json:"language"`
+	RootUri  string `json:"rootUri"`
+}
+
+// LspMessagePayload carries a JSON-RPC message for the language server of
+// Language, without the Content-Length framing. It is also the payload of
+// lsp_message events, carrying messages from the server.
+type LspMessagePayload struct {
+	Language string          `json:"language"`
+	Message  json.RawMessage `json:"message"`
+}
+
+// LspExitEvent reports that a language server exited. Error holds the end
+// of its stderr when it exited on its own with a non-zero status.
+type LspExitEvent struct {
+	Language string `json:"language"`
+	ExitCode int    `json:"exitCode"`
+	Error    string `json:"error,omitempty"`
+}
+
+// OpenDocPayload opens Path for collaborative editing, attaching the
+// connection to the document if another one opened it already.
+type OpenDocPayload struct {
+	Path string `json:"path"`
+}
+
+// OpenDocResult holds the document at Revision. Peer is the id of this
+// connection on the document and Peers the other connections on it.
+type OpenDocResult struct {
+	Path     string    `json:"path"`
+	Revision int       `json:"revision"`
+	Content  string    `json:"content"`
+	Peer     string    `json:"peer"`
+	Peers    []DocPeer `json:"peers"`
+}
+
+// DocPeer is a connection editing a document. Projects belong to a
+// single user, so peers are that user's connections.
+type DocPeer struct {
+	Id     string     `json:"id"`
+	Cursor *DocCursor `json:"cursor,omitempty"`
+}
+
+// DocCursor is a selection from Anchor to Head, offsets in code points.
+// Both are equal for a plain cursor.
+type DocCursor struct {
+	Anchor int `json:"anchor"`
+	Head   int `json:"head"`
+}
+
+// EditDocPayload applies Operation, based on Revision, to the document at
+// Path. The server transforms it against the edits applied since.
+type EditDocPayload struct {
+	Path      string           `json:"path"`
+	Revision  int              `json:"revision"`
+	Operation collab.Operation `json:"operation"`
+}
+
+// EditDocResult holds the revision the edit became.
+type EditDocResult struct {
+	Revision int `json:"revision"`
+}
+
+// DocEditEvent carries an edit as applied by the server, turning the
+// document into Revision. It is sent to its author too, as the
+// acknowledgement that precedes any later edit. Peer is empty for
+// write_file calls replacing the document.
+type DocEditEvent struct {
+	Path      string           `json:"path"`
+	Revision  int              `json:"revision"`
+	Peer      string           `json:"peer"`
+	Operation collab.Operation `json:"operation"`
+}
+
+// UpdateCursorPayload moves the cursor of the connection, given at
+// Revision. A nil Cursor hides it.
+type UpdateCursorPayload struct {
+	Path     string     `json:"path"`
+	Revision int        `json:"revision"`
+	Cursor   *DocCursor `json:"cursor"`
+}
+
+// DocCursorEvent carries the cursor of Peer at Revision.
+type DocCursorEvent struct {
+	Path     string     `json:"path"`
+	Revision int        `json:"revision"`
+	Peer     string     `json:"peer"`
+	Cursor   *DocCursor `json:"cursor"`
+}
+
+// DocPeerEvent reports that Peer opened the document, or closed it when
+// Left is set.
+type DocPeerEvent struct {
+	Path string  `json:"path"`
+	Peer DocPeer `json:"peer"`
+	Left bool    `json:"left,omitempty"`
+}
+
+type DocClosedEvent struct {
+	Path string `json:"path"`
+}
+
+// CloseDocPayload detaches the connection from the document at Path.
+type CloseDocPayload struct {
+	Path string `json:"path"`
+}
+
+// OutputEvent carries raw output. Terminal names the terminal session it
+// came from and is empty for output of other operations, such as the image
+// pull of init_project. Replay marks the scrollback sent on attach.
+type OutputEvent struct {
+	Terminal string `json:"terminal,omitempty"`
+	Data     string `json:"data"`
+	Replay   bool   `json:"replay,omitempty"`
+}
+
+type TerminalExitEvent struct {
+	Terminal string `json:"terminal"`
+}
+
+type TerminalDetachedEvent struct {
+	Terminal string `json:"terminal"`
+}
+
+// negotiateVersion picks the highest protocol version both sides support.
+func negotiateVersion(offered []int) (int, bool) {
+	best := 0
+	for _, v := range offered {
+		for _, supported := range supportedVersions {
+			if v == supported && v > best {
+				best = v
+			}
+		}
+	}
+	return best, best != 0
+}
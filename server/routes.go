@@ -1,21 +1,112 @@
 package server
 
-import "github.com/gin-gonic/gin"
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/chrollo-lucifer-12/repl/archive"
+	"github.com/chrollo-lucifer-12/repl/db"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+	"github.com/chrollo-lucifer-12/repl/templates"
+	"github.com/gin-gonic/gin"
+)
 
 type RegisterRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,min=8"`
+}
+
+type LoginRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
 }
 
 type CreateProjectHandleRequest struct {
-	Slug   string `json:"slug"`
-	UserId uint   `json:"userId"`
+	Slug string `json:"slug" binding:"required"`
+	// Template defaults to templates.DefaultTemplate.
+	Template string `json:"template"`
+	// Image defaults to the image of the template and Profile to the
+	// default profile of the sandbox policy.
+	Image   string `json:"image"`
+	Profile string `json:"profile"`
+}
+
+type ProjectResponse struct {
+	Id        uint              `json:"id"`
+	Slug      string            `json:"slug"`
+	Template  string            `json:"template"`
+	Image     string            `json:"image"`
+	Profile   string            `json:"profile"`
+	Resources sandbox.Resources `json:"resources"`
+}
+
+type ProfileResponse struct {
+	Name      string            `json:"name"`
+	Resources sandbox.Resources `json:"resources"`
+}
+
+type TemplateResponse struct {
+	Id          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Image       string   `json:"image"`
+	RunCommand  []string `json:"runCommand,omitempty"`
+	Port        int      `json:"port,omitempty"`
+}
+
+// workspaceId is the sandbox key of a project's workspace.
+func workspaceId(projectId uint) string {
+	return strconv.FormatUint(uint64(projectId), 10)
+}
+
+// userProject loads the project with the given id if the current user owns
+// it, writing an error response and returning nil otherwise.
+func (s *Server) userProject(c *gin.Context, rawId string) *db.CreatedProject {
+	projectId, err := strconv.ParseUint(rawId, 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(400, gin.H{"error": "invalid project id"})
+		return nil
+	}
+	project, err := s.db.FindProject(uint(projectId))
+	if errors.Is(err, db.ErrProjectNotFound) || (err == nil && project.UserId != currentUser(c).Id) {
+		c.AbortWithStatusJSON(404, gin.H{"error": db.ErrProjectNotFound.Error()})
+		return nil
+	}
+	if err != nil {
+		c.AbortWithStatusJSON(500, gin.H{"error": err.Error()})
+		return nil
+	}
+	return project
+}
+
+// errorStatus is the HTTP status of a failed workspace operation.
+func errorStatus(err error) int {
+	var frameErr *FrameError
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &frameErr) && frameErr.Code == CodeInvalidPayload,
+		errors.Is(err, archive.ErrUnsafePath), errors.Is(err, archive.ErrUnknownFormat), errors.Is(err, archive.ErrCorrupt):
+		return 400
+	case errors.Is(err, db.ErrSnapshotNotFound):
+		return 404
+	case errors.Is(err, db.ErrSnapshotExists), errors.Is(err, errArchiveBusy), errors.Is(err, sandbox.ErrContainerGone):
+		return 409
+	case errors.Is(err, archive.ErrTooLarge), errors.As(err, &maxBytesErr):
+		return 413
+	case errors.Is(err, errors.ErrUnsupported):
+		return 501
+	case errors.Is(err, sandbox.ErrDaemonUnavailable):
+		return 503
+	}
+	return 500
 }
 
 func (s *Server) RegisterHandler(c *gin.Context) {
 	var body RegisterRequest
 	if err := c.ShouldBindBodyWithJSON(&body); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
+		return
 	}
 
 	email := body.Email
@@ -24,24 +115,144 @@ func (s *Server) RegisterHandler(c *gin.Context) {
 	createdUser, err := s.db.CreateUser(email, password)
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
+		return
 	}
 
 	c.JSON(201, gin.H{"message": "user created", "id": createdUser.Id})
 }
 
+func (s *Server) LoginHandler(c *gin.Context) {
+	var body LoginRequest
+	if err := c.ShouldBindBodyWithJSON(&body); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := s.db.Authenticate(body.Email, body.Password)
+	if errors.Is(err, db.ErrInvalidCredentials) {
+		c.JSON(401, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, err := s.db.CreateSession(user.Id, sessionTTL)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"token": session.Token, "expiresAt": session.ExpiresAt, "id": user.Id})
+}
+
+func (s *Server) LogoutHandler(c *gin.Context) {
+	if err := s.db.DeleteSession(c.GetString(tokenKey)); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "logged out"})
+}
+
 func (s *Server) CreateProjectHandler(c *gin.Context) {
 	var body CreateProjectHandleRequest
 	if err := c.ShouldBindBodyWithJSON(&body); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
+		return
 	}
 
 	slug := body.Slug
-	userId := body.UserId
+	userId := currentUser(c).Id
 
-	createdProject, err := s.db.CreateProject(slug, userId)
+	template := body.Template
+	if template == "" {
+		template = templates.DefaultTemplate
+	}
+	tpl, ok := s.t.Get(template)
+	if !ok {
+		c.JSON(400, gin.H{"error": "unknown template " + template})
+		return
+	}
+
+	image := body.Image
+	if image == "" {
+		image = tpl.Image
+	}
+	if !s.p.AllowsImage(image) {
+		c.JSON(400, gin.H{"error": sandbox.ErrImageNotAllowed.Error() + ": " + image})
+		return
+	}
+	profile, resources, err := s.p.Profile(body.Profile)
 	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	createdProject, err := s.db.CreateProject(db.ProjectSpec{
+		Slug:      slug,
+		UserId:    userId,
+		Template:  template,
+		Image:     image,
+		Profile:   profile,
+		Resources: resources,
+	})
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
 	}
 
 	c.JSON(201, gin.H{"message": "project created", "id": createdProject.Id})
 }
+
+func (s *Server) ListProjectsHandler(c *gin.Context) {
+	projects, err := s.db.ListProjects(currentUser(c).Id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	resp := make([]ProjectResponse, 0, len(projects))
+	for _, project := range projects {
+		resp = append(resp, ProjectResponse{
+			Id:        project.Id,
+			Slug:      project.Slug,
+			Template:  project.Template,
+			Image:     project.Image,
+			Profile:   project.Profile,
+			Resources: project.Resources,
+		})
+	}
+	c.JSON(200, gin.H{"projects": resp})
+}
+
+func (s *Server) ListTemplatesHandler(c *gin.Context) {
+	list := s.t.List()
+	resp := make([]TemplateResponse, 0, len(list))
+	for _, t := range list {
+		resp = append(resp, TemplateResponse{
+			Id:          t.Id,
+			Name:        t.Name,
+			Description: t.Description,
+			Image:       t.Image,
+			RunCommand:  t.RunCommand,
+			Port:        t.Port,
+		})
+	}
+	c.JSON(200, gin.H{"templates": resp})
+}
+
+// ListProfilesHandler returns the images and resource profiles projects
+// can be created with.
+func (s *Server) ListProfilesHandler(c *gin.Context) {
+	profiles := make([]ProfileResponse, 0, len(s.p.Profiles))
+	for _, name := range s.p.ProfileNames() {
+		profiles = append(profiles, ProfileResponse{Name: name, Resources: s.p.Profiles[name]})
+	}
+	c.JSON(200, gin.H{
+		"images":         s.p.Images,
+		"profiles":       profiles,
+		"defaultProfile": s.p.DefaultProfile,
+	})
+}
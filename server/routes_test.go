@@ -0,0 +1,170 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func postJSON(t *testing.T, ts *httptest.Server, path, token string, body any) (*http.Response, map[string]any) {
+	t.Helper()
+	raw, _ := json.Marshal(body)
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+path, bytes.NewReader(raw))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	var out map[string]any
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp, out
+}
+
+func getJSON(t *testing.T, ts *httptest.Server, path, token string) (*http.Response, map[string]any) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	var out map[string]any
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp, out
+}
+
+func TestRegisterLoginAndCreateProject(t *testing.T) {
+	_, ts := newTestServer(t)
+
+	resp, _ := postJSON(t, ts, "/register", "", map[string]string{"email": "a@example.com", "password": "short"})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected short password to be rejected, got %d", resp.StatusCode)
+	}
+
+	resp, _ = postJSON(t, ts, "/register", "", map[string]string{"email": "a@example.com", "password": "password123"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("register failed with %d", resp.StatusCode)
+	}
+
+	resp, _ = postJSON(t, ts, "/login", "", map[string]string{"email": "a@example.com", "password": "wrong-password"})
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected wrong password to be rejected, got %d", resp.StatusCode)
+	}
+
+	resp, login := postJSON(t, ts, "/login", "", map[string]string{"email": "a@example.com", "password": "password123"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("login failed with %d", resp.StatusCode)
+	}
+	token, _ := login["token"].(string)
+	if token == "" {
+		t.Fatalf("login returned no token: %v", login)
+	}
+
+	resp, _ = postJSON(t, ts, "/create-project", "", map[string]string{"slug": "demo"})
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected create-project without a session to fail, got %d", resp.StatusCode)
+	}
+
+	resp, _ = postJSON(t, ts, "/create-project", token, map[string]string{"slug": "demo"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("create-project failed with %d", resp.StatusCode)
+	}
+
+	resp, _ = postJSON(t, ts, "/create-project", token, map[string]string{"slug": "other", "template": "bogus"})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected an unknown template to be rejected, got %d", resp.StatusCode)
+	}
+
+	resp, list := getJSON(t, ts, "/projects", token)
+	projects, _ := list["projects"].([]any)
+	if resp.StatusCode != http.StatusOK || len(projects) != 1 {
+		t.Errorf("expected one project, got %d %v", resp.StatusCode, list)
+	} else if project := projects[0].(map[string]any); project["template"] != "blank" || project["profile"] != "small" {
+		t.Errorf("expected the default template and profile, got %v", project)
+	}
+
+	resp, _ = postJSON(t, ts, "/logout", token, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("logout failed with %d", resp.StatusCode)
+	}
+	resp, _ = postJSON(t, ts, "/create-project", token, map[string]string{"slug": "demo2"})
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected token to be revoked after logout, got %d", resp.StatusCode)
+	}
+}
+
+func TestListTemplates(t *testing.T) {
+	_, ts := newTestServer(t)
+
+	resp, out := getJSON(t, ts, "/templates", "")
+	list, _ := out["templates"].([]any)
+	if resp.StatusCode != http.StatusOK || len(list) != 2 {
+		t.Fatalf("expected two templates, got %d %v", resp.StatusCode, out)
+	}
+	if tpl := list[1].(map[string]any); tpl["id"] != "test" || tpl["runCommand"] == nil {
+		t.Errorf("unexpected template %v", tpl)
+	}
+}
+
+func TestCreateProjectWithImageAndProfile(t *testing.T) {
+	s, ts := newTestServer(t)
+	_, token := newTestUser(t, s)
+
+	resp, _ := postJSON(t, ts, "/create-project", token, map[string]string{"slug": "a", "image": "alpine:latest"})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected an image outside the allowlist to be rejected, got %d", resp.StatusCode)
+	}
+	resp, _ = postJSON(t, ts, "/create-project", token, map[string]string{"slug": "a", "profile": "huge"})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected an unknown profile to be rejected, got %d", resp.StatusCode)
+	}
+
+	resp, _ = postJSON(t, ts, "/create-project", token, map[string]string{
+		"slug":    "a",
+		"image":   "python:3.12-bullseye",
+		"profile": "large",
+	})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create-project failed with %d", resp.StatusCode)
+	}
+	_, list := getJSON(t, ts, "/projects", token)
+	project := list["projects"].([]any)[0].(map[string]any)
+	resources, _ := project["resources"].(map[string]any)
+	if project["image"] != "python:3.12-bullseye" || project["profile"] != "large" || resources["memory"] != float64(2<<30) {
+		t.Errorf("unexpected project %v", project)
+	}
+
+	resp, out := getJSON(t, ts, "/profiles", "")
+	profiles, _ := out["profiles"].([]any)
+	if resp.StatusCode != http.StatusOK || len(profiles) != 3 || out["defaultProfile"] != "small" {
+		t.Errorf("unexpected profiles %d %v", resp.StatusCode, out)
+	}
+}
+
+func TestAccessLogRedactsToken(t *testing.T) {
+	var log bytes.Buffer
+	r := gin.New()
+	r.Use(accessLogger(&log))
+	r.GET("/ws", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, target := range []string{"/ws?project=p1&token=s3cret", "/ws?token=s3cret%zz"} {
+		log.Reset()
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+		if strings.Contains(log.String(), "s3cret") || !strings.Contains(log.String(), "REDACTED") {
+			t.Errorf("%s logged as %q", target, log.String())
+		}
+	}
+	if got := redactToken("/ws?project=p1"); got != "/ws?project=p1" {
+		t.Errorf("redactToken without a token = %q", got)
+	}
+}
@@ -1,30 +1,105 @@
 package server
 
 import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/chrollo-lucifer-12/repl/config"
 	"github.com/chrollo-lucifer-12/repl/db"
-	"github.com/chrollo-lucifer-12/repl/docker"
+	"github.com/chrollo-lucifer-12/repl/lifecycle"
 	"github.com/chrollo-lucifer-12/repl/logger"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+	"github.com/chrollo-lucifer-12/repl/templates"
 	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
 type Server struct {
-	r  *gin.Engine
-	l  logger.Logger
-	d  *docker.DockerClient
-	db *db.DB
+	r   *gin.Engine
+	srv *http.Server
+	l   logger.Logger
+	d   sandbox.Sandbox
+	db  db.Database
+	lc  *lifecycle.Manager
+	t   *templates.Registry
+	p   *sandbox.Policy
+	cfg *config.Config
+
+	upgrader websocket.Upgrader
+
+	// terminals holds the *terminalSession of every open shell, keyed by
+	// terminal id.
+	terminals sync.Map
+	// scaffolding holds the ids of projects being scaffolded.
+	scaffolding sync.Map
+	// archiving holds the ids of projects whose files are being archived
+	// or replaced, see lockArchive.
+	archiving sync.Map
+	// processes holds the *processSession of every background process
+	// started since the server started, keyed by processKey.
+	processes sync.Map
+	// processMu serialises starting processes so a name runs only once.
+	processMu sync.Mutex
+	// watches holds the *fileWatch of every watched workspace.
+	watchMu sync.Mutex
+	watches map[string]*fileWatch
+	// languageServers holds the *languageServer of every running language
+	// server, keyed by languageServerKey.
+	lspMu           sync.Mutex
+	languageServers map[string]*languageServer
+	// docs holds the *collabDoc of every open document, keyed by docKey.
+	docMu sync.Mutex
+	docs  map[string]*collabDoc
+	// conns maps every open *wsConn to the workspace it operates on.
+	conns sync.Map
+	// draining is set once Shutdown has started.
+	draining atomic.Bool
 }
 
-func NewServer(l logger.Logger, d *docker.DockerClient, db *db.DB) ServerManager {
-	r := gin.Default()
+func NewServer(l logger.Logger, d sandbox.Sandbox, db db.Database, lc *lifecycle.Manager, t *templates.Registry, p *sandbox.Policy, cfg *config.Config) ServerManager {
+	r := gin.New()
+	r.Use(accessLogger(gin.DefaultWriter), gin.Recovery())
+	srv := &http.Server{Addr: cfg.Server.Addr, Handler: r}
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:  cfg.Server.ReadBufferSize,
+		WriteBufferSize: cfg.Server.WriteBufferSize,
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
 
-	return &Server{r: r, l: l, d: d, db: db}
+	return &Server{r: r, srv: srv, l: l, d: d, db: db, lc: lc, t: t, p: p, cfg: cfg, upgrader: upgrader, watches: map[string]*fileWatch{}, languageServers: map[string]*languageServer{}, docs: map[string]*collabDoc{}}
 }
 
-func (s *Server) Start() error {
-	s.r.POST("/create-project", s.CreateProjectHandler)
+func (s *Server) routes() {
 	s.r.POST("/register", s.RegisterHandler)
-	s.r.GET("/ws", s.wsHandler)
-	s.l.Info("server running on port :", "3000")
-	err := s.r.Run(":3000")
+	s.r.POST("/login", s.LoginHandler)
+	s.r.GET("/templates", s.ListTemplatesHandler)
+	s.r.GET("/profiles", s.ListProfilesHandler)
+
+	authed := s.r.Group("/", s.authMiddleware())
+	authed.POST("/logout", s.LogoutHandler)
+	authed.POST("/create-project", s.CreateProjectHandler)
+	authed.GET("/projects", s.ListProjectsHandler)
+	authed.GET("/ws", s.wsHandler)
+	authed.POST("/projects/:id/snapshots", s.CreateSnapshotHandler)
+	authed.GET("/projects/:id/snapshots", s.ListSnapshotsHandler)
+	authed.POST("/projects/:id/snapshots/:snapshot/restore", s.RestoreSnapshotHandler)
+	authed.DELETE("/projects/:id/snapshots/:snapshot", s.DeleteSnapshotHandler)
+	authed.GET("/projects/:id/export", s.ExportProjectHandler)
+	authed.POST("/projects/:id/import", s.ImportProjectHandler)
+
+	s.r.Any("/preview/:project/:port/*path", s.previewAuthMiddleware(), s.PreviewHandler)
+}
+
+func (s *Server) Start() error {
+	s.routes()
+	s.l.Info("server running on", "addr", s.cfg.Server.Addr)
+	err := s.srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
 	return err
 }
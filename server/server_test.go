@@ -0,0 +1,328 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chrollo-lucifer-12/repl/config"
+	"github.com/chrollo-lucifer-12/repl/db"
+	"github.com/chrollo-lucifer-12/repl/lifecycle"
+	"github.com/chrollo-lucifer-12/repl/local"
+	"github.com/chrollo-lucifer-12/repl/logger"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+	"github.com/chrollo-lucifer-12/repl/templates"
+	"github.com/gin-gonic/gin"
+)
+
+// memDB is an in-memory db.Database for tests.
+type memDB struct {
+	mu       sync.Mutex
+	nextId   uint
+	users    map[string]memUser
+	sessions map[string]uint
+	projects map[uint]db.CreatedProject
+	// processes maps a project id to its process definitions by name.
+	processes map[uint]map[string]db.ProcessSpec
+	snapshots []db.CreatedSnapshot
+	closed    bool
+}
+
+type memUser struct {
+	id       uint
+	password string
+}
+
+var _ db.Database = (*memDB)(nil)
+
+func newMemDB() *memDB {
+	return &memDB{
+		users:     map[string]memUser{},
+		sessions:  map[string]uint{},
+		projects:  map[uint]db.CreatedProject{},
+		processes: map[uint]map[string]db.ProcessSpec{},
+	}
+}
+
+func (m *memDB) CreateUser(email string, password string) (*db.CreatedUser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[email]; ok {
+		return nil, fmt.Errorf("duplicate email")
+	}
+	m.nextId++
+	m.users[email] = memUser{id: m.nextId, password: password}
+	return &db.CreatedUser{Id: m.nextId, Email: email}, nil
+}
+
+func (m *memDB) FindUser(userId uint) (*db.CreatedUser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for email, u := range m.users {
+		if u.id == userId {
+			return &db.CreatedUser{Id: u.id, Email: email}, nil
+		}
+	}
+	return nil, fmt.Errorf("record not found")
+}
+
+func (m *memDB) Authenticate(email string, password string) (*db.CreatedUser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[email]
+	if !ok || u.password != password {
+		return nil, db.ErrInvalidCredentials
+	}
+	return &db.CreatedUser{Id: u.id, Email: email}, nil
+}
+
+func (m *memDB) CreateSession(userId uint, ttl time.Duration) (*db.CreatedSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextId++
+	token := fmt.Sprintf("token-%d", m.nextId)
+	m.sessions[token] = userId
+	return &db.CreatedSession{Token: token, UserId: userId, ExpiresAt: time.Now().Add(ttl)}, nil
+}
+
+func (m *memDB) FindSession(token string) (*db.CreatedUser, error) {
+	m.mu.Lock()
+	userId, ok := m.sessions[token]
+	m.mu.Unlock()
+	if !ok {
+		return nil, db.ErrSessionNotFound
+	}
+	return m.FindUser(userId)
+}
+
+func (m *memDB) DeleteSession(token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, token)
+	return nil
+}
+
+func (m *memDB) CreateProject(spec db.ProjectSpec) (*db.CreatedProject, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, project := range m.projects {
+		if project.UserId == spec.UserId && project.Slug == spec.Slug {
+			return nil, fmt.Errorf("duplicate project")
+		}
+	}
+	m.nextId++
+	project := db.CreatedProject{
+		Slug:      spec.Slug,
+		Id:        m.nextId,
+		UserId:    spec.UserId,
+		Template:  spec.Template,
+		Image:     spec.Image,
+		Profile:   spec.Profile,
+		Resources: spec.Resources,
+	}
+	m.projects[project.Id] = project
+	return &project, nil
+}
+
+func (m *memDB) FindProject(projectId uint) (*db.CreatedProject, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	project, ok := m.projects[projectId]
+	if !ok {
+		return nil, db.ErrProjectNotFound
+	}
+	return &project, nil
+}
+
+func (m *memDB) ListProjects(userId uint) ([]db.CreatedProject, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	projects := []db.CreatedProject{}
+	for _, project := range m.projects {
+		if project.UserId == userId {
+			projects = append(projects, project)
+		}
+	}
+	sort.Slice(projects, func(i, j int) bool { return projects[i].Id < projects[j].Id })
+	return projects, nil
+}
+
+func (m *memDB) MarkProjectScaffolded(projectId uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	project, ok := m.projects[projectId]
+	if !ok {
+		return db.ErrProjectNotFound
+	}
+	project.Scaffolded = true
+	m.projects[projectId] = project
+	return nil
+}
+
+func (m *memDB) SaveProcess(spec db.ProcessSpec) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.processes[spec.ProjectId] == nil {
+		m.processes[spec.ProjectId] = map[string]db.ProcessSpec{}
+	}
+	m.processes[spec.ProjectId][spec.Name] = spec
+	return nil
+}
+
+func (m *memDB) ListProcesses(projectId uint) ([]db.ProcessSpec, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	specs := []db.ProcessSpec{}
+	for _, spec := range m.processes[projectId] {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs, nil
+}
+
+func (m *memDB) DeleteProcess(projectId uint, name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.processes[projectId][name]; !ok {
+		return db.ErrProcessNotFound
+	}
+	delete(m.processes[projectId], name)
+	return nil
+}
+
+func (m *memDB) CreateSnapshot(spec db.SnapshotSpec) (*db.CreatedSnapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, snapshot := range m.snapshots {
+		if snapshot.ProjectId == spec.ProjectId && snapshot.Name == spec.Name {
+			return nil, db.ErrSnapshotExists
+		}
+	}
+	m.nextId++
+	snapshot := db.CreatedSnapshot{
+		Id:        m.nextId,
+		ProjectId: spec.ProjectId,
+		Name:      spec.Name,
+		Key:       spec.Key,
+		Size:      spec.Size,
+		Image:     spec.Image,
+		CreatedAt: time.Now(),
+	}
+	m.snapshots = append(m.snapshots, snapshot)
+	return &snapshot, nil
+}
+
+func (m *memDB) FindSnapshot(projectId, snapshotId uint) (*db.CreatedSnapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, snapshot := range m.snapshots {
+		if snapshot.Id == snapshotId && snapshot.ProjectId == projectId {
+			return &snapshot, nil
+		}
+	}
+	return nil, db.ErrSnapshotNotFound
+}
+
+func (m *memDB) ListSnapshots(projectId uint) ([]db.CreatedSnapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshots := []db.CreatedSnapshot{}
+	for _, snapshot := range m.snapshots {
+		if snapshot.ProjectId == projectId {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots, nil
+}
+
+func (m *memDB) DeleteSnapshot(projectId, snapshotId uint) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, snapshot := range m.snapshots {
+		if snapshot.Id == snapshotId && snapshot.ProjectId == projectId {
+			m.snapshots = append(m.snapshots[:i], m.snapshots[i+1:]...)
+			return nil
+		}
+	}
+	return db.ErrSnapshotNotFound
+}
+
+func (m *memDB) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	sb, err := local.NewLocalSandbox("")
+	if err != nil {
+		t.Fatalf("failed to create sandbox: %v", err)
+	}
+	t.Cleanup(func() { sb.Stop() })
+
+	l := logger.NewSlogLogger()
+	lc := lifecycle.NewManager(sb, l, lifecycle.Config{
+		IdleTimeout: time.Hour,
+		DeleteAfter: 24 * time.Hour,
+		Interval:    time.Minute,
+	})
+	tr, err := templates.NewRegistry(
+		templates.Template{Id: templates.DefaultTemplate, Image: sandbox.DefaultImage},
+		templates.Template{
+			Id:         "test",
+			Image:      sandbox.DefaultImage,
+			Files:      map[string]string{"src/app.txt": "hello\n"},
+			Commands:   [][]string{{"sh", "-c", "echo built > build.txt"}},
+			RunCommand: []string{"cat", "src/app.txt"},
+		},
+	)
+	if err != nil {
+		t.Fatalf("failed to create templates: %v", err)
+	}
+	cfg := config.Default()
+	cfg.Terminal.DetachTimeout = time.Minute
+	cfg.Terminal.Scrollback = 64 << 10
+	cfg.Snapshots.Dir = t.TempDir()
+	s := NewServer(l, sb, newMemDB(), lc, tr, sandbox.DefaultPolicy(), cfg).(*Server)
+	s.routes()
+	ts := httptest.NewServer(s.r)
+	t.Cleanup(ts.Close)
+	return s, ts
+}
+
+// newTestUser registers a user and returns its id and a session token.
+func newTestUser(t *testing.T, s *Server) (uint, string) {
+	t.Helper()
+	user, err := s.db.CreateUser(fmt.Sprintf("user%d@example.com", time.Now().UnixNano()), "password123")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	session, err := s.db.CreateSession(user.Id, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	return user.Id, session.Token
+}
+
+// newTestProject creates a project for userId and starts its workspace.
+func newTestProject(t *testing.T, s *Server, userId uint, slug string) uint {
+	t.Helper()
+	project, err := s.db.CreateProject(db.ProjectSpec{Slug: slug, UserId: userId, Template: templates.DefaultTemplate})
+	if err != nil {
+		t.Fatalf("failed to create project: %v", err)
+	}
+	if _, err := s.d.StartContainer(context.Background(), io.Discard, workspaceId(project.Id), sandbox.ContainerSpec{}); err != nil {
+		t.Fatalf("failed to start workspace: %v", err)
+	}
+	s.lc.Started(workspaceId(project.Id))
+	return project.Id
+}
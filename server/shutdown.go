@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Shutdown drains the server: it stops accepting requests, tells every
+// connected client the server is going away, closes all terminal sessions,
+// background processes and language servers, saves the documents being
+// edited, optionally stops the workspace containers and closes the
+// database. If ctx ends first, the remaining steps are skipped and its
+// error returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.draining.Store(true)
+
+	var errs []error
+	if err := s.srv.Shutdown(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	s.conns.Range(func(key, value any) bool {
+		conn := key.(*wsConn)
+		conn.emit(EventShutdown, ShutdownEvent{Reason: "server is shutting down"})
+		conn.close(websocket.CloseGoingAway, "server is shutting down")
+		return true
+	})
+
+	if err := s.closeTerminals(ctx); err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	if err := s.closeProcesses(ctx); err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	if err := s.closeLanguageServers(ctx); err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	if err := s.flushDocs(ctx); err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+
+	if s.cfg.Shutdown.StopContainers {
+		if err := s.lc.StopAll(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+
+	if err := s.db.Close(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// closeTerminals kills every terminal session and waits for the shells to
+// exit.
+func (s *Server) closeTerminals(ctx context.Context) error {
+	var terms []*terminalSession
+	s.terminals.Range(func(key, value any) bool {
+		term := value.(*terminalSession)
+		term.mu.Lock()
+		if term.detachTimer != nil {
+			term.detachTimer.Stop()
+			term.detachTimer = nil
+		}
+		term.mu.Unlock()
+		terms = append(terms, term)
+		return true
+	})
+
+	for _, term := range terms {
+		if err := s.killTerminal(term); err != nil {
+			s.l.Error("failed to close terminal", "terminal", term.id, "error", err)
+		}
+	}
+	for _, term := range terms {
+		select {
+		case <-term.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// close sends a close frame and closes the connection, which ends its read
+// loop.
+func (c *wsConn) close(code int, text string) {
+	deadline := time.Now().Add(time.Second)
+	c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), deadline)
+	c.conn.Close()
+}
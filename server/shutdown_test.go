@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestShutdownDrainsSessions(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.cfg.Shutdown.StopContainers = true
+	userId, token := newTestUser(t, s)
+	projectId := newTestProject(t, s, userId, "demo")
+	c := dialWS(t, ts, token, projectId)
+	c.hello()
+	c.openTerminal()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	notified := false
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var frame testFrame
+		if err := c.conn.ReadJSON(&frame); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				t.Errorf("expected a going away close, got %v", err)
+			}
+			break
+		}
+		notified = notified || frame.Type == EventShutdown
+	}
+	if !notified {
+		t.Error("expected a shutdown event before the connection was closed")
+	}
+
+	if terms := s.workspaceTerminals(workspaceId(projectId)); len(terms) != 0 {
+		t.Errorf("expected every terminal to be closed, got %d", len(terms))
+	}
+	if s.lc.Running(workspaceId(projectId)) {
+		t.Error("expected the workspace to be stopped")
+	}
+	if !s.db.(*memDB).closed {
+		t.Error("expected the database to be closed")
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL(ts, token, projectId), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected new connections to be refused while draining, got %v", err)
+	}
+}
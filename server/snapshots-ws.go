@@ -0,0 +1,58 @@
+package server
+
+func (sess *wsSession) createSnapshot(req *Request) (any, error) {
+	var payload CreateSnapshotPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	snapshot, err := sess.s.createSnapshot(sess.ctx, sess.projectId, payload.Name, payload.Container)
+	if err != nil {
+		return nil, err
+	}
+	return snapshotInfo(*snapshot), nil
+}
+
+func (sess *wsSession) listSnapshots(req *Request) (any, error) {
+	snapshots, err := sess.s.listSnapshots(sess.projectId)
+	if err != nil {
+		return nil, err
+	}
+	return ListSnapshotsResult{Snapshots: snapshots}, nil
+}
+
+func decodeSnapshotPayload(req *Request) (uint, error) {
+	var payload SnapshotPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return 0, err
+	}
+	if payload.Id == 0 {
+		return 0, &FrameError{Code: CodeInvalidPayload, Message: "snapshot id is required"}
+	}
+	return payload.Id, nil
+}
+
+// restoreSnapshot replaces the workspace with a snapshot. Terminals and
+// processes of the connection end with the old container.
+func (sess *wsSession) restoreSnapshot(req *Request) (any, error) {
+	id, err := decodeSnapshotPayload(req)
+	if err != nil {
+		return nil, err
+	}
+	project, err := sess.s.db.FindProject(sess.projectId)
+	if err != nil {
+		return nil, err
+	}
+	containerId, err := sess.s.restoreSnapshot(sess.ctx, project, id)
+	if err != nil {
+		return nil, err
+	}
+	return RestoreSnapshotResult{ContainerId: containerId}, nil
+}
+
+func (sess *wsSession) deleteSnapshot(req *Request) (any, error) {
+	id, err := decodeSnapshotPayload(req)
+	if err != nil {
+		return nil, err
+	}
+	return nil, sess.s.deleteSnapshot(sess.ctx, sess.projectId, id)
+}
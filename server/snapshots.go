@@ -0,0 +1,341 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chrollo-lucifer-12/repl/archive"
+	"github.com/chrollo-lucifer-12/repl/db"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+	"github.com/gin-gonic/gin"
+)
+
+// maxSnapshotName bounds the length of snapshot names.
+const maxSnapshotName = 100
+
+// snapshotImageRepo is the repository committed snapshot containers are
+// tagged in, with the snapshot key as tag.
+const snapshotImageRepo = "repl-snapshot"
+
+// snapshotRestoreTimeout bounds a restore, which runs to the end even when
+// the client that asked for it goes away.
+const snapshotRestoreTimeout = 10 * time.Minute
+
+var errArchiveBusy = errors.New("the workspace files are being archived or replaced")
+
+// snapshotPath is the file archive of a snapshot.
+func (s *Server) snapshotPath(projectId uint, key string) string {
+	return filepath.Join(s.cfg.Snapshots.Dir, workspaceId(projectId), key+".tar.gz")
+}
+
+func newSnapshotKey() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func snapshotInfo(snapshot db.CreatedSnapshot) SnapshotInfo {
+	return SnapshotInfo{
+		Id:        snapshot.Id,
+		Name:      snapshot.Name,
+		Size:      snapshot.Size,
+		Container: snapshot.Image != "",
+		CreatedAt: snapshot.CreatedAt,
+	}
+}
+
+func validSnapshotName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return &FrameError{Code: CodeInvalidPayload, Message: "snapshot name is required"}
+	}
+	if len(name) > maxSnapshotName {
+		return &FrameError{Code: CodeInvalidPayload, Message: fmt.Sprintf("snapshot name is longer than %d bytes", maxSnapshotName)}
+	}
+	return nil
+}
+
+// lockArchive marks the workspace files as busy with a snapshot or an
+// import, so a restore cannot clear them while they are archived.
+func (s *Server) lockArchive(projectId uint) (func(), error) {
+	if _, busy := s.archiving.LoadOrStore(projectId, true); busy {
+		return nil, errArchiveBusy
+	}
+	return func() { s.archiving.Delete(projectId) }, nil
+}
+
+// createSnapshot archives the files of the workspace and, with
+// withContainer, commits its container as an image.
+func (s *Server) createSnapshot(ctx context.Context, projectId uint, name string, withContainer bool) (*db.CreatedSnapshot, error) {
+	if err := validSnapshotName(name); err != nil {
+		return nil, err
+	}
+	unlock, err := s.lockArchive(projectId)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	ws := workspaceId(projectId)
+	hostDir, err := s.d.HostDir(ws)
+	if err != nil {
+		return nil, err
+	}
+	key, err := newSnapshotKey()
+	if err != nil {
+		return nil, err
+	}
+	size, err := s.writeSnapshotArchive(s.snapshotPath(projectId, key), hostDir)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := db.SnapshotSpec{ProjectId: projectId, Name: name, Key: key, Size: size}
+	if withContainer {
+		ref := snapshotImageRepo + ":" + key
+		if _, err := s.d.CommitContainer(ctx, ws, ref); err != nil {
+			os.Remove(s.snapshotPath(projectId, key))
+			return nil, err
+		}
+		spec.Image = ref
+	}
+
+	snapshot, err := s.db.CreateSnapshot(spec)
+	if err != nil {
+		s.removeSnapshotData(ctx, projectId, key, spec.Image)
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// writeSnapshotArchive writes the archive of dir to p, returning its size.
+// The archive only appears under p once it is complete.
+func (s *Server) writeSnapshotArchive(p, dir string) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return 0, err
+	}
+	tmp := p + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp)
+	if err := archive.WriteTarGz(f, dir, nil); err != nil {
+		f.Close()
+		return 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		return 0, err
+	}
+	return info.Size(), os.Rename(tmp, p)
+}
+
+// removeSnapshotData removes the archive and image of a snapshot. An image
+// still used by a restored container cannot be removed; that is logged
+// rather than failing, so the snapshot can always be deleted.
+func (s *Server) removeSnapshotData(ctx context.Context, projectId uint, key, image string) {
+	if err := os.Remove(s.snapshotPath(projectId, key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.l.Error("failed to remove snapshot archive", "project", projectId, "key", key, "error", err)
+	}
+	if image == "" {
+		return
+	}
+	if err := s.d.RemoveImage(ctx, image); err != nil {
+		s.l.Error("failed to remove snapshot image", "project", projectId, "image", image, "error", err)
+	}
+}
+
+// restoreSnapshot replaces the workspace with a fresh container, started
+// from the snapshot image if there is one, and replaces its files with the
+// archived ones. Every connection on the workspace is told afterwards.
+//
+// The old container is gone once the restore starts, so it is not tied to
+// ctx being cancelled. When the new container cannot be started, the
+// project's own container is started in its place; the workspace then
+// keeps its files but not the snapshot image, and the error is returned.
+func (s *Server) restoreSnapshot(ctx context.Context, project *db.CreatedProject, snapshotId uint) (string, error) {
+	snapshot, err := s.db.FindSnapshot(project.Id, snapshotId)
+	if err != nil {
+		return "", err
+	}
+	tpl, err := s.template(project)
+	if err != nil {
+		return "", err
+	}
+	unlock, err := s.lockArchive(project.Id)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	f, err := os.Open(s.snapshotPath(project.Id, snapshot.Key))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), snapshotRestoreTimeout)
+	defer cancel()
+
+	ws := workspaceId(project.Id)
+	var containerId string
+	err = s.changeFiles(ws, sandbox.WorkspaceDir, func() error {
+		if err := s.d.DeleteContainer(ctx, ws); err != nil && !errors.Is(err, sandbox.ErrContainerGone) {
+			return err
+		}
+		spec := s.containerSpec(project, tpl)
+		if snapshot.Image != "" {
+			spec.Image = snapshot.Image
+		}
+		var err error
+		if containerId, err = s.d.StartContainer(ctx, nil, ws, spec); err != nil {
+			if _, restartErr := s.d.StartContainer(ctx, nil, ws, s.containerSpec(project, tpl)); restartErr != nil {
+				s.l.Error("failed to restart container after a failed restore", "workspace", ws, "error", restartErr)
+			} else {
+				s.lc.Started(ws)
+			}
+			return err
+		}
+		s.lc.Started(ws)
+
+		hostDir, err := s.d.HostDir(ws)
+		if err != nil {
+			return err
+		}
+		if err := archive.Clear(hostDir); err != nil {
+			return err
+		}
+		return archive.ExtractTarGz(f, hostDir, int64(s.cfg.Snapshots.MaxSize))
+	})
+	if err != nil {
+		return "", err
+	}
+
+	s.broadcast(ws, EventSnapshotRestored, SnapshotRestoredEvent{Snapshot: snapshotInfo(*snapshot), ContainerId: containerId})
+	return containerId, nil
+}
+
+// deleteSnapshot deletes a snapshot with its archive and image.
+func (s *Server) deleteSnapshot(ctx context.Context, projectId, snapshotId uint) error {
+	snapshot, err := s.db.FindSnapshot(projectId, snapshotId)
+	if err != nil {
+		return err
+	}
+	if err := s.db.DeleteSnapshot(projectId, snapshotId); err != nil {
+		return err
+	}
+	s.removeSnapshotData(ctx, projectId, snapshot.Key, snapshot.Image)
+	return nil
+}
+
+// listSnapshots lists the snapshots of a project, oldest first.
+func (s *Server) listSnapshots(projectId uint) ([]SnapshotInfo, error) {
+	snapshots, err := s.db.ListSnapshots(projectId)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]SnapshotInfo, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		infos = append(infos, snapshotInfo(snapshot))
+	}
+	return infos, nil
+}
+
+type CreateSnapshotRequest struct {
+	Name string `json:"name" binding:"required"`
+	// Container also commits the container, keeping packages installed
+	// outside the workspace.
+	Container bool `json:"container"`
+}
+
+// snapshotId parses the snapshot id of a request, writing an error response
+// and returning false if it is invalid.
+func snapshotId(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("snapshot"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid snapshot id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func (s *Server) CreateSnapshotHandler(c *gin.Context) {
+	project := s.userProject(c, c.Param("id"))
+	if project == nil {
+		return
+	}
+	var body CreateSnapshotRequest
+	if err := c.ShouldBindBodyWithJSON(&body); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	snapshot, err := s.createSnapshot(c.Request.Context(), project.Id, body.Name, body.Container)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, snapshotInfo(*snapshot))
+}
+
+func (s *Server) ListSnapshotsHandler(c *gin.Context) {
+	project := s.userProject(c, c.Param("id"))
+	if project == nil {
+		return
+	}
+	snapshots, err := s.listSnapshots(project.Id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"snapshots": snapshots})
+}
+
+func (s *Server) RestoreSnapshotHandler(c *gin.Context) {
+	project := s.userProject(c, c.Param("id"))
+	if project == nil {
+		return
+	}
+	id, ok := snapshotId(c)
+	if !ok {
+		return
+	}
+
+	containerId, err := s.restoreSnapshot(c.Request.Context(), project, id)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "snapshot restored", "containerId": containerId})
+}
+
+func (s *Server) DeleteSnapshotHandler(c *gin.Context) {
+	project := s.userProject(c, c.Param("id"))
+	if project == nil {
+		return
+	}
+	id, ok := snapshotId(c)
+	if !ok {
+		return
+	}
+
+	if err := s.deleteSnapshot(c.Request.Context(), project.Id, id); err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "snapshot deleted"})
+}
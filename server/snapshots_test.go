@@ -0,0 +1,175 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func deleteJSON(t *testing.T, ts *httptest.Server, path, token string) (*http.Response, map[string]any) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodDelete, ts.URL+path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("DELETE %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	var out map[string]any
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp, out
+}
+
+func readWorkspaceFile(t *testing.T, s *Server, projectId uint, p string) (string, error) {
+	t.Helper()
+	data, err := s.d.ReadFile(context.Background(), workspaceId(projectId), p)
+	return string(data), err
+}
+
+func TestSnapshotCreateRestoreDelete(t *testing.T) {
+	s, ts := newTestServer(t)
+	userId, token := newTestUser(t, s)
+	projectId := newTestProject(t, s, userId, "demo")
+	ctx := context.Background()
+	ws := workspaceId(projectId)
+	base := fmt.Sprintf("/projects/%d/snapshots", projectId)
+
+	s.d.CreateDir(ctx, ws, "src")
+	if err := s.d.WriteFile(ctx, ws, "src/app.js", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	resp, body := postJSON(t, ts, base, token, map[string]any{"name": "first"})
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("create snapshot: %d %v", resp.StatusCode, body)
+	}
+	id := uint(body["id"].(float64))
+	if body["size"].(float64) <= 0 || body["container"] != false {
+		t.Errorf("unexpected snapshot %v", body)
+	}
+
+	resp, _ = postJSON(t, ts, base, token, map[string]any{"name": "first"})
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("duplicate name: got %d, want 409", resp.StatusCode)
+	}
+	resp, _ = postJSON(t, ts, base, token, map[string]any{"name": "image", "container": true})
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("container snapshot on the local sandbox: got %d, want 501", resp.StatusCode)
+	}
+
+	resp, body = getJSON(t, ts, base, token)
+	if snapshots := body["snapshots"].([]any); resp.StatusCode != http.StatusOK || len(snapshots) != 1 {
+		t.Fatalf("list snapshots: %d %v", resp.StatusCode, body)
+	}
+
+	s.d.WriteFile(ctx, ws, "src/app.js", []byte("v2"))
+	s.d.WriteFile(ctx, ws, "new.txt", []byte("new"))
+	resp, body = postJSON(t, ts, fmt.Sprintf("%s/%d/restore", base, id), token, nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("restore snapshot: %d %v", resp.StatusCode, body)
+	}
+	if got, err := readWorkspaceFile(t, s, projectId, "src/app.js"); err != nil || got != "v1" {
+		t.Errorf("src/app.js after restore = %q, %v, want v1", got, err)
+	}
+	if _, err := readWorkspaceFile(t, s, projectId, "new.txt"); err == nil {
+		t.Error("new.txt survived the restore")
+	}
+	if !s.lc.Running(ws) {
+		t.Error("workspace is not running after the restore")
+	}
+
+	snapshot, err := s.db.FindSnapshot(projectId, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archivePath := s.snapshotPath(projectId, snapshot.Key)
+	resp, _ = deleteJSON(t, ts, fmt.Sprintf("%s/%d", base, id), token)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("delete snapshot: %d", resp.StatusCode)
+	}
+	if _, err := os.Stat(archivePath); !os.IsNotExist(err) {
+		t.Errorf("archive of deleted snapshot still exists: %v", err)
+	}
+	resp, _ = postJSON(t, ts, fmt.Sprintf("%s/%d/restore", base, id), token, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("restore deleted snapshot: got %d, want 404", resp.StatusCode)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(archivePath)); len(entries) != 0 {
+		t.Errorf("snapshot directory not empty: %v", entries)
+	}
+}
+
+func TestSnapshotsOfOtherUsersAreHidden(t *testing.T) {
+	s, ts := newTestServer(t)
+	owner, _ := newTestUser(t, s)
+	_, other := newTestUser(t, s)
+	projectId := newTestProject(t, s, owner, "demo")
+
+	resp, _ := getJSON(t, ts, fmt.Sprintf("/projects/%d/snapshots", projectId), other)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("listing another user's snapshots: got %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestWSSnapshots(t *testing.T) {
+	s, ts := newTestServer(t)
+	userId, token := newTestUser(t, s)
+	projectId := newTestProject(t, s, userId, "demo")
+	s.d.WriteFile(context.Background(), workspaceId(projectId), "main.py", []byte("print(1)"))
+
+	c := dialWS(t, ts, token, projectId)
+	c.hello()
+
+	frame := c.call(MsgCreateSnapshot, CreateSnapshotPayload{})
+	if frame.Kind != KindError || frame.Error.Code != CodeInvalidPayload {
+		t.Errorf("snapshot without a name: %+v", frame)
+	}
+	frame = c.call(MsgCreateSnapshot, CreateSnapshotPayload{Name: "before"})
+	var created SnapshotInfo
+	json.Unmarshal(frame.Payload, &created)
+	if frame.Kind != KindResponse || created.Id == 0 || created.Name != "before" {
+		t.Fatalf("create_snapshot: %+v", frame)
+	}
+
+	s.d.WriteFile(context.Background(), workspaceId(projectId), "main.py", []byte("print(2)"))
+	id := c.send(MsgRestoreSnapshot, SnapshotPayload{Id: created.Id})
+	var restored *SnapshotRestoredEvent
+	for responded := false; !responded || restored == nil; {
+		frame := c.read()
+		switch {
+		case frame.Kind == KindEvent && frame.Type == EventSnapshotRestored:
+			restored = &SnapshotRestoredEvent{}
+			json.Unmarshal(frame.Payload, restored)
+		case frame.ID == id:
+			if frame.Kind != KindResponse {
+				t.Fatalf("restore_snapshot: %+v", frame.Error)
+			}
+			responded = true
+		}
+	}
+	if restored.Snapshot.Id != created.Id || restored.ContainerId == "" {
+		t.Errorf("unexpected snapshot_restored event %+v", restored)
+	}
+	if got, _ := readWorkspaceFile(t, s, projectId, "main.py"); got != "print(1)" {
+		t.Errorf("main.py after restore = %q", got)
+	}
+
+	frame = c.call(MsgListSnapshots, nil)
+	var list ListSnapshotsResult
+	json.Unmarshal(frame.Payload, &list)
+	if len(list.Snapshots) != 1 || list.Snapshots[0].Id != created.Id {
+		t.Errorf("list_snapshots = %+v", list)
+	}
+
+	if frame := c.call(MsgDeleteSnapshot, SnapshotPayload{Id: created.Id}); frame.Kind != KindResponse {
+		t.Fatalf("delete_snapshot: %+v", frame.Error)
+	}
+	frame = c.call(MsgDeleteSnapshot, SnapshotPayload{Id: created.Id})
+	if frame.Kind != KindError || frame.Error.Code != CodeNotFound {
+		t.Errorf("deleting a deleted snapshot: %+v", frame)
+	}
+}
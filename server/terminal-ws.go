@@ -0,0 +1,100 @@
+package server
+
+import "github.com/chrollo-lucifer-12/repl/sandbox"
+
+func (sess *wsSession) openTerminal(req *Request) (any, error) {
+	var payload OpenTerminalPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	size, err := terminalSize(payload.Rows, payload.Cols, defaultTerminalSize)
+	if err != nil {
+		return nil, err
+	}
+	term, start, err := sess.s.startTerminal(sess.workspaceId, size, sess.conn)
+	if err != nil {
+		return nil, err
+	}
+	sess.afterResponse = start
+	return term.result(), nil
+}
+
+func (sess *wsSession) attachTerminal(req *Request) (any, error) {
+	var payload AttachTerminalPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	term, err := sess.s.terminal(sess.workspaceId, payload.Terminal)
+	if err != nil {
+		return nil, err
+	}
+	if payload.Rows != 0 || payload.Cols != 0 {
+		size, err := terminalSize(payload.Rows, payload.Cols, defaultTerminalSize)
+		if err != nil {
+			return nil, err
+		}
+		if err := sess.s.resizeTerminal(term, size); err != nil {
+			return nil, err
+		}
+	}
+	sess.s.attachTerminal(term, sess.conn)
+	return term.result(), nil
+}
+
+func (sess *wsSession) listTerminals(req *Request) (any, error) {
+	terminals := []TerminalInfo{}
+	for _, term := range sess.s.workspaceTerminals(sess.workspaceId) {
+		term.mu.Lock()
+		terminals = append(terminals, TerminalInfo{
+			Terminal: term.id,
+			Attached: term.conn != nil,
+			Rows:     term.size.Rows,
+			Cols:     term.size.Cols,
+		})
+		term.mu.Unlock()
+	}
+	return ListTerminalsResult{Terminals: terminals}, nil
+}
+
+func (sess *wsSession) closeTerminal(req *Request) (any, error) {
+	var payload CloseTerminalPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	term, err := sess.s.terminal(sess.workspaceId, payload.Terminal)
+	if err != nil {
+		return nil, err
+	}
+	return nil, sess.s.killTerminal(term)
+}
+
+func (sess *wsSession) input(req *Request) (any, error) {
+	var payload InputPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	term, err := sess.s.terminal(sess.workspaceId, payload.Terminal)
+	if err != nil {
+		return nil, err
+	}
+	return nil, term.sendInput([]byte(payload.Data))
+}
+
+func (sess *wsSession) resizeTerminal(req *Request) (any, error) {
+	var payload ResizeTerminalPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	term, err := sess.s.terminal(sess.workspaceId, payload.Terminal)
+	if err != nil {
+		return nil, err
+	}
+	size, err := terminalSize(payload.Rows, payload.Cols, sandbox.TerminalSize{})
+	if err != nil {
+		return nil, err
+	}
+	if err := sess.s.resizeTerminal(term, size); err != nil {
+		return nil, err
+	}
+	return term.result(), nil
+}
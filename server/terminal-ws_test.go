@@ -0,0 +1,204 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitOutput reads frames until the output of terminal contains want.
+func (c *testClient) waitOutput(terminal, want string) {
+	c.t.Helper()
+	var out strings.Builder
+	for !strings.Contains(out.String(), want) {
+		frame := c.read()
+		if frame.Kind != KindEvent || frame.Type != EventOutput {
+			continue
+		}
+		var event OutputEvent
+		json.Unmarshal(frame.Payload, &event)
+		if event.Terminal == terminal {
+			out.WriteString(event.Data)
+		}
+	}
+}
+
+// waitExit reads frames until terminal reports that it exited.
+func (c *testClient) waitExit(terminal string) {
+	c.t.Helper()
+	for {
+		frame := c.read()
+		if frame.Kind != KindEvent || frame.Type != EventTerminalExit {
+			continue
+		}
+		var event TerminalExitEvent
+		json.Unmarshal(frame.Payload, &event)
+		if event.Terminal == terminal {
+			return
+		}
+	}
+}
+
+func (c *testClient) openTerminal() string {
+	c.t.Helper()
+	frame := c.call(MsgOpenTerminal, OpenTerminalPayload{})
+	var result TerminalResult
+	json.Unmarshal(frame.Payload, &result)
+	if frame.Kind != KindResponse || result.Terminal == "" {
+		c.t.Fatalf("open_terminal failed: %+v", frame)
+	}
+	return result.Terminal
+}
+
+func TestWSTerminalSessions(t *testing.T) {
+	s, ts := newTestServer(t)
+	userId, token := newTestUser(t, s)
+	c := dialWS(t, ts, token, newTestProject(t, s, userId, "demo"))
+	c.hello()
+
+	first := c.openTerminal()
+	second := c.openTerminal()
+	if first == second {
+		t.Fatalf("expected distinct terminal ids, got %q twice", first)
+	}
+
+	c.send(MsgInput, InputPayload{Terminal: second, Data: "echo second-$((1+1))\n"})
+	c.waitOutput(second, "second-2")
+	c.send(MsgInput, InputPayload{Terminal: first, Data: "echo first-$((2+2))\n"})
+	c.waitOutput(first, "first-4")
+
+	frame := c.call(MsgResizeTerminal, ResizeTerminalPayload{Terminal: first, Rows: 30, Cols: 100})
+	var resized TerminalResult
+	json.Unmarshal(frame.Payload, &resized)
+	if frame.Kind != KindResponse || resized.Rows != 30 || resized.Cols != 100 {
+		t.Errorf("expected resize to be acknowledged, got %+v", frame)
+	}
+	c.send(MsgInput, InputPayload{Terminal: first, Data: "stty size\n"})
+	c.waitOutput(first, "30 100")
+
+	for _, size := range []ResizeTerminalPayload{{Rows: 0, Cols: 80}, {Rows: -1, Cols: 80}, {Rows: 24, Cols: 100000}, {}} {
+		size.Terminal = first
+		frame = c.call(MsgResizeTerminal, size)
+		if frame.Kind != KindError || frame.Error.Code != CodeInvalidPayload {
+			t.Errorf("expected invalid_payload for %dx%d, got %+v", size.Rows, size.Cols, frame)
+		}
+	}
+	frame = c.call(MsgResizeTerminal, ResizeTerminalPayload{Terminal: "bogus", Rows: 24, Cols: 80})
+	if frame.Kind != KindError || frame.Error.Code != CodeNotFound {
+		t.Errorf("expected not_found for an unknown terminal, got %+v", frame)
+	}
+
+	c.send(MsgCloseTerminal, CloseTerminalPayload{Terminal: second})
+	c.waitExit(second)
+
+	frame = c.call(MsgInput, InputPayload{Terminal: second, Data: "ls\n"})
+	if frame.Kind != KindError || frame.Error.Code != CodeNotFound {
+		t.Errorf("expected not_found for a closed terminal, got %+v", frame)
+	}
+
+	c.send(MsgInput, InputPayload{Terminal: first, Data: "exit\n"})
+	c.waitExit(first)
+}
+
+func TestWSTerminalInitialSize(t *testing.T) {
+	s, ts := newTestServer(t)
+	userId, token := newTestUser(t, s)
+	c := dialWS(t, ts, token, newTestProject(t, s, userId, "demo"))
+	c.hello()
+
+	frame := c.call(MsgOpenTerminal, OpenTerminalPayload{Rows: 40, Cols: 132})
+	var result TerminalResult
+	json.Unmarshal(frame.Payload, &result)
+	if frame.Kind != KindResponse || result.Rows != 40 || result.Cols != 132 {
+		t.Fatalf("expected the initial size to be acknowledged, got %+v", frame)
+	}
+	c.send(MsgInput, InputPayload{Terminal: result.Terminal, Data: "stty size\n"})
+	c.waitOutput(result.Terminal, "40 132")
+
+	if term := c.openTerminal(); term == "" {
+		t.Fatal("expected a terminal with the default size")
+	}
+
+	frame = c.call(MsgOpenTerminal, OpenTerminalPayload{Rows: 5000, Cols: 80})
+	if frame.Kind != KindError || frame.Error.Code != CodeInvalidPayload {
+		t.Errorf("expected invalid_payload, got %+v", frame)
+	}
+}
+
+func TestWSTerminalReattach(t *testing.T) {
+	s, ts := newTestServer(t)
+	userId, token := newTestUser(t, s)
+	projectId := newTestProject(t, s, userId, "demo")
+
+	c := dialWS(t, ts, token, projectId)
+	c.hello()
+	term := c.openTerminal()
+	c.send(MsgInput, InputPayload{Terminal: term, Data: "echo before-$((3+3))\n"})
+	c.waitOutput(term, "before-6")
+	c.conn.Close()
+
+	c = dialWS(t, ts, token, projectId)
+	c.hello()
+	frame := c.call(MsgListTerminals, nil)
+	var list ListTerminalsResult
+	json.Unmarshal(frame.Payload, &list)
+	if len(list.Terminals) != 1 || list.Terminals[0].Terminal != term {
+		t.Fatalf("expected the detached terminal to be listed, got %+v", list)
+	}
+
+	// The scrollback is replayed before the attach response.
+	id := c.send(MsgAttachTerminal, AttachTerminalPayload{Terminal: term, Rows: 50, Cols: 150})
+	var replay OutputEvent
+	for {
+		frame := c.read()
+		if frame.Kind == KindEvent && frame.Type == EventOutput {
+			json.Unmarshal(frame.Payload, &replay)
+			continue
+		}
+		if frame.ID == id {
+			var result TerminalResult
+			json.Unmarshal(frame.Payload, &result)
+			if frame.Kind != KindResponse || result.Rows != 50 || result.Cols != 150 {
+				t.Fatalf("attach_terminal failed: %+v", frame)
+			}
+			break
+		}
+	}
+	if !replay.Replay || !strings.Contains(replay.Data, "before-6") {
+		t.Errorf("expected scrollback to be replayed, got %+v", replay)
+	}
+
+	c.send(MsgInput, InputPayload{Terminal: term, Data: "echo after-$((4+4))\n"})
+	c.waitOutput(term, "after-8")
+
+	other := dialWS(t, ts, token, newTestProject(t, s, userId, "other"))
+	other.hello()
+	frame = other.call(MsgAttachTerminal, AttachTerminalPayload{Terminal: term})
+	if frame.Kind != KindError || frame.Error.Code != CodeNotFound {
+		t.Errorf("expected terminals of another project to be hidden, got %+v", frame)
+	}
+}
+
+func TestWSTerminalDetachTimeout(t *testing.T) {
+	s, ts := newTestServer(t)
+	s.cfg.Terminal.DetachTimeout = 50 * time.Millisecond
+	userId, token := newTestUser(t, s)
+	projectId := newTestProject(t, s, userId, "demo")
+
+	c := dialWS(t, ts, token, projectId)
+	c.hello()
+	term := c.openTerminal()
+	c.conn.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := s.terminal(workspaceId(projectId), term); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("detached terminal was never killed")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,364 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+)
+
+// terminalSession is an interactive shell in a workspace. Sessions belong
+// to the server rather than a connection: when the connection goes away the
+// session is detached, keeps recording output into its scrollback and can
+// be attached to by another connection until DetachTimeout runs out.
+type terminalSession struct {
+	id          string
+	workspaceId string
+	execId      string
+	input       *io.PipeWriter
+	// keys holds input waiting for writeInput to pass it to the shell.
+	keys chan []byte
+	// done is closed once the shell has exited and the session is dropped.
+	done <-chan struct{}
+
+	mu         sync.Mutex
+	size       sandbox.TerminalSize
+	scrollback *ringBuffer
+	conn       *wsConn
+	// sink sends events to conn.
+	sink        *terminalSink
+	detachTimer *time.Timer
+	// detachTimeout is how long the session outlives its connection.
+	detachTimeout time.Duration
+	kill          func()
+}
+
+// Write records shell output and queues it for the attached connection.
+// It never blocks on the connection, so a slow or dead client cannot
+// stall the shell; a client that falls too far behind is detached.
+func (t *terminalSession) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.scrollback.Write(p)
+	if t.sink != nil && !t.sink.send(EventOutput, OutputEvent{Terminal: t.id, Data: string(p)}) {
+		t.sink.overflowed = true
+		t.detach()
+	}
+	return len(p), nil
+}
+
+// attach routes events to conn from now on, once the returned sink is
+// started. It is called with mu held.
+func (t *terminalSession) attach(conn *wsConn) *terminalSink {
+	if t.sink != nil {
+		t.sink.close()
+	}
+	if t.detachTimer != nil {
+		t.detachTimer.Stop()
+		t.detachTimer = nil
+	}
+	t.conn = conn
+	t.sink = newTerminalSink(conn, t.id)
+	return t.sink
+}
+
+// detach drops the attached connection and arms the detach timeout. It is
+// called with mu held.
+func (t *terminalSession) detach() {
+	if t.sink == nil {
+		// The shell exited already.
+		return
+	}
+	t.sink.close()
+	t.sink = nil
+	t.conn = nil
+	t.detachTimer = time.AfterFunc(t.detachTimeout, t.kill)
+}
+
+// terminalInputSize is how many inputs may wait for a shell that does not
+// read them before more are refused.
+const terminalInputSize = 64
+
+// sendInput queues data for the shell. It never blocks, so a shell that
+// stopped reading holds up no other request of the connection.
+func (t *terminalSession) sendInput(data []byte) error {
+	select {
+	case <-t.done:
+		return &FrameError{Code: CodeNotFound, Message: "terminal " + t.id + " exited"}
+	case t.keys <- data:
+		return nil
+	default:
+		return &FrameError{Code: CodeBusy, Message: "terminal " + t.id + " is not reading its input"}
+	}
+}
+
+// writeInput passes queued input to the shell until it exits.
+func (t *terminalSession) writeInput() {
+	for {
+		select {
+		case data := <-t.keys:
+			if _, err := t.input.Write(data); err != nil {
+				return
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
+
+// terminalSinkSize is how many events a connection may have queued
+// before it counts as fallen behind.
+const terminalSinkSize = 256
+
+// terminalSink sends the events of a session to a connection from a
+// goroutine of its own.
+type terminalSink struct {
+	events chan terminalEvent
+	ready  chan struct{}
+	// overflowed is set when the sink is closed for falling behind, which
+	// the connection is told once it caught up.
+	overflowed bool
+}
+
+type terminalEvent struct {
+	eventType string
+	payload   any
+}
+
+func newTerminalSink(conn *wsConn, terminal string) *terminalSink {
+	k := &terminalSink{events: make(chan terminalEvent, terminalSinkSize), ready: make(chan struct{})}
+	go func() {
+		<-k.ready
+		for e := range k.events {
+			conn.emit(e.eventType, e.payload)
+		}
+		if k.overflowed {
+			conn.emit(EventTerminalDetached, TerminalDetachedEvent{Terminal: terminal})
+		}
+	}()
+	return k
+}
+
+// send queues an event, reporting false if the queue is full. It is
+// called with the mu of the session held, as is close.
+func (k *terminalSink) send(eventType string, payload any) bool {
+	select {
+	case k.events <- terminalEvent{eventType, payload}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (k *terminalSink) close() {
+	close(k.events)
+}
+
+// start lets the sink send what it queued.
+func (k *terminalSink) start() {
+	close(k.ready)
+}
+
+func newTerminalId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// defaultTerminalSize is used when a client opens a terminal without
+// giving its size.
+var defaultTerminalSize = sandbox.TerminalSize{Rows: 24, Cols: 80}
+
+// terminalSize validates the size requested by a client; an all zero size
+// stands for def.
+func terminalSize(rows, cols int, def sandbox.TerminalSize) (sandbox.TerminalSize, error) {
+	if rows == 0 && cols == 0 {
+		return def, nil
+	}
+	size := sandbox.TerminalSize{Rows: rows, Cols: cols}
+	if !size.Valid() {
+		return size, &FrameError{
+			Code:    CodeInvalidPayload,
+			Message: fmt.Sprintf("terminal size must be between 1x1 and %[1]dx%[1]d", sandbox.MaxTerminalDimension),
+		}
+	}
+	return size, nil
+}
+
+// startTerminal starts a shell in the workspace attached to conn. The
+// session is dropped and announced with a terminal_exit event once the
+// shell exits. Its output is held back until start is called, so it can
+// follow the response announcing the terminal.
+func (s *Server) startTerminal(workspaceId string, size sandbox.TerminalSize, conn *wsConn) (term *terminalSession, start func(), err error) {
+	id, err := newTerminalId()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pr, pw := io.Pipe()
+	term = &terminalSession{
+		id:            id,
+		workspaceId:   workspaceId,
+		input:         pw,
+		keys:          make(chan []byte, terminalInputSize),
+		size:          size,
+		scrollback:    newRingBuffer(s.cfg.Terminal.Scrollback),
+		detachTimeout: s.cfg.Terminal.DetachTimeout,
+	}
+	term.kill = func() { s.killTerminal(term) }
+	sink := term.attach(conn)
+	repl, err := s.d.StartInteractiveRepl(context.Background(), workspaceId, size, pr, term)
+	if err != nil {
+		pw.Close()
+		sink.close()
+		sink.start()
+		return nil, nil, err
+	}
+	term.execId = repl.ExecId
+	done := make(chan struct{})
+	term.done = done
+	go term.writeInput()
+	s.terminals.Store(id, term)
+
+	go func() {
+		defer close(done)
+		<-repl.Done
+		pw.Close()
+		s.terminals.Delete(id)
+
+		term.mu.Lock()
+		defer term.mu.Unlock()
+		if term.detachTimer != nil {
+			term.detachTimer.Stop()
+		}
+		if term.sink != nil {
+			// The exit is queued after the last output; a sink that is
+			// full is closed anyway.
+			term.sink.send(EventTerminalExit, TerminalExitEvent{Terminal: id})
+			term.sink.close()
+			term.sink = nil
+		}
+		term.conn = nil
+	}()
+
+	return term, sink.start, nil
+}
+
+// terminal looks up a session of the given workspace.
+func (s *Server) terminal(workspaceId, id string) (*terminalSession, error) {
+	term, ok := s.terminals.Load(id)
+	if !ok || term.(*terminalSession).workspaceId != workspaceId {
+		return nil, &FrameError{Code: CodeNotFound, Message: "unknown terminal " + id}
+	}
+	return term.(*terminalSession), nil
+}
+
+// workspaceTerminals lists the sessions of a workspace.
+func (s *Server) workspaceTerminals(workspaceId string) []*terminalSession {
+	var terminals []*terminalSession
+	s.terminals.Range(func(key, value any) bool {
+		if term := value.(*terminalSession); term.workspaceId == workspaceId {
+			terminals = append(terminals, term)
+		}
+		return true
+	})
+	return terminals
+}
+
+// attachTerminal replays the scrollback of term to conn and routes its
+// output there from now on, taking it over from any other connection.
+// Output written meanwhile waits in the sink until the replay was sent.
+func (s *Server) attachTerminal(term *terminalSession, conn *wsConn) {
+	term.mu.Lock()
+	sink := term.attach(conn)
+	scrollback := term.scrollback.Bytes()
+	term.mu.Unlock()
+	if len(scrollback) > 0 {
+		conn.emit(EventOutput, OutputEvent{Terminal: term.id, Data: string(scrollback), Replay: true})
+	}
+	sink.start()
+}
+
+// detachTerminals detaches every session attached to conn and arms their
+// detach timeout.
+func (s *Server) detachTerminals(conn *wsConn) {
+	s.terminals.Range(func(key, value any) bool {
+		term := value.(*terminalSession)
+		term.mu.Lock()
+		if term.conn == conn {
+			term.detach()
+		}
+		term.mu.Unlock()
+		return true
+	})
+}
+
+// resizeTerminal resizes the shell and records its new size.
+func (s *Server) resizeTerminal(term *terminalSession, size sandbox.TerminalSize) error {
+	term.mu.Lock()
+	defer term.mu.Unlock()
+	if err := s.d.ResizeTerminal(context.Background(), term.workspaceId, term.execId, size); err != nil {
+		return err
+	}
+	term.size = size
+	return nil
+}
+
+// result describes the session to the client.
+func (t *terminalSession) result() TerminalResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return TerminalResult{Terminal: t.id, Rows: t.size.Rows, Cols: t.size.Cols}
+}
+
+func (s *Server) killTerminal(term *terminalSession) error {
+	term.input.Close()
+	return s.d.KillTerminal(context.Background(), term.workspaceId, term.execId)
+}
+
+// ringBuffer keeps the last len(data) bytes written to it.
+type ringBuffer struct {
+	data  []byte
+	start int
+	n     int
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{data: make([]byte, size)}
+}
+
+func (r *ringBuffer) Write(p []byte) {
+	size := len(r.data)
+	if size == 0 {
+		return
+	}
+	if len(p) >= size {
+		copy(r.data, p[len(p)-size:])
+		r.start, r.n = 0, size
+		return
+	}
+
+	end := (r.start + r.n) % size
+	k := copy(r.data[end:], p)
+	copy(r.data, p[k:])
+	r.n += len(p)
+	if r.n > size {
+		r.start = (r.start + r.n - size) % size
+		r.n = size
+	}
+}
+
+// Bytes returns a copy of the buffered bytes, oldest first.
+func (r *ringBuffer) Bytes() []byte {
+	out := make([]byte, r.n)
+	k := copy(out, r.data[r.start:min(r.start+r.n, len(r.data))])
+	copy(out[k:], r.data[:r.n-k])
+	return out
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRingBuffer(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		writes []string
+		want   string
+	}{
+		{"empty", 8, nil, ""},
+		{"fits", 8, []string{"abc", "de"}, "abcde"},
+		{"exactly full", 4, []string{"ab", "cd"}, "abcd"},
+		{"wraps", 5, []string{"abc", "def", "g"}, "cdefg"},
+		{"oversized write", 3, []string{"ab", "cdefgh"}, "fgh"},
+		{"disabled", 0, []string{"abc"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newRingBuffer(tt.size)
+			for _, w := range tt.writes {
+				r.Write([]byte(w))
+			}
+			if got := string(r.Bytes()); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTerminalDetachesSlowClient(t *testing.T) {
+	// A connection whose writes never finish.
+	conn := &wsConn{}
+	conn.mu.Lock()
+
+	term := &terminalSession{id: "t", scrollback: newRingBuffer(16), detachTimeout: time.Hour, kill: func() {}}
+	term.mu.Lock()
+	term.attach(conn).start()
+	term.mu.Unlock()
+	for i := 0; i < 2*terminalSinkSize; i++ {
+		term.Write([]byte("x"))
+	}
+
+	term.mu.Lock()
+	defer term.mu.Unlock()
+	if term.conn != nil || term.sink != nil {
+		t.Error("slow client is still attached")
+	}
+	if term.detachTimer == nil {
+		t.Fatal("detach timeout not armed")
+	}
+	term.detachTimer.Stop()
+	if got := len(term.scrollback.Bytes()); got != 16 {
+		t.Errorf("scrollback holds %d bytes, want 16", got)
+	}
+}
+
+func TestTerminalDetachAfterExit(t *testing.T) {
+	// A shell that exited has no sink left; detaching must not touch it.
+	term := &terminalSession{id: "t", conn: &wsConn{}, detachTimeout: time.Hour, kill: func() {}}
+	term.mu.Lock()
+	defer term.mu.Unlock()
+	term.detach()
+	if term.detachTimer != nil {
+		t.Error("detach timeout armed for an exited shell")
+	}
+}
+
+func TestTerminalInputDoesNotBlock(t *testing.T) {
+	// A shell that never reads its input.
+	_, pw := io.Pipe()
+	done := make(chan struct{})
+	defer close(done)
+	term := &terminalSession{id: "t", input: pw, keys: make(chan []byte, terminalInputSize), done: done}
+	go term.writeInput()
+
+	var err error
+	for i := 0; i < terminalInputSize+2 && err == nil; i++ {
+		err = term.sendInput([]byte("x"))
+	}
+	var frameErr *FrameError
+	if !errors.As(err, &frameErr) || frameErr.Code != CodeBusy {
+		t.Fatalf("sendInput to a stuck shell = %v, want busy", err)
+	}
+}
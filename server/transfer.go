@@ -0,0 +1,172 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/chrollo-lucifer-12/repl/archive"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+	"github.com/gin-gonic/gin"
+)
+
+// Export formats.
+const (
+	FormatZip   = "zip"
+	FormatTarGz = "tar.gz"
+)
+
+// exportIgnore returns the ignore patterns of an export request: the ignore
+// query parameters if there are any, so ?ignore= exports everything, and
+// the configured defaults otherwise.
+func (s *Server) exportIgnore(c *gin.Context) ([]string, error) {
+	patterns, ok := c.GetQueryArray("ignore")
+	if !ok {
+		return s.cfg.Transfer.ExportIgnore, nil
+	}
+	ignore := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.New("invalid ignore pattern " + pattern)
+		}
+		ignore = append(ignore, pattern)
+	}
+	return ignore, nil
+}
+
+// ExportProjectHandler streams an archive of the workspace files, a zip
+// file unless ?format=tar.gz is given.
+func (s *Server) ExportProjectHandler(c *gin.Context) {
+	project := s.userProject(c, c.Param("id"))
+	if project == nil {
+		return
+	}
+	format := c.DefaultQuery("format", FormatZip)
+	write, contentType := archive.WriteZip, "application/zip"
+	switch format {
+	case FormatZip:
+	case FormatTarGz:
+		write, contentType = archive.WriteTarGz, "application/gzip"
+	default:
+		c.JSON(400, gin.H{"error": "unknown format " + format})
+		return
+	}
+	ignore, err := s.exportIgnore(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	ws := workspaceId(project.Id)
+	hostDir, err := s.d.HostDir(ws)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	s.lc.Touch(ws)
+
+	filename := project.Slug + "." + format
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Status(200)
+	if err := write(c.Writer, hostDir, ignore); err != nil {
+		// The status is sent already; the client sees a truncated archive.
+		s.l.Error("export failed", "project", project.Id, "error", err)
+	}
+}
+
+// uploadedArchive spools the archive of an import request to a temporary
+// file. The archive is the body of the request, or the archive field of a
+// multipart form.
+func (s *Server) uploadedArchive(c *gin.Context) (*os.File, int64, error) {
+	var src io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/form-data") {
+		file, _, err := c.Request.FormFile("archive")
+		if err != nil {
+			return nil, 0, err
+		}
+		defer file.Close()
+		src = file
+	}
+
+	f, err := os.CreateTemp("", "repl-import-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	os.Remove(f.Name())
+	size, err := io.Copy(f, src)
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, size, nil
+}
+
+// ImportProjectHandler extracts an uploaded zip or tar.gz archive into the
+// workspace, overwriting files of the same name. With ?replace=true the
+// workspace is emptied first. Entries that would land outside the
+// workspace fail the import, which may then have written some files.
+func (s *Server) ImportProjectHandler(c *gin.Context) {
+	project := s.userProject(c, c.Param("id"))
+	if project == nil {
+		return
+	}
+	replace := false
+	if raw := c.Query("replace"); raw != "" {
+		var err error
+		if replace, err = strconv.ParseBool(raw); err != nil {
+			c.JSON(400, gin.H{"error": "invalid replace parameter"})
+			return
+		}
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(s.cfg.Transfer.MaxUpload))
+	f, size, err := s.uploadedArchive(c)
+	if err != nil {
+		// Short of the disk filling up, failing to read the upload is the
+		// client's fault.
+		status := errorStatus(err)
+		if status == 500 {
+			status = 400
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	defer f.Close()
+
+	unlock, err := s.lockArchive(project.Id)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	defer unlock()
+
+	ws := workspaceId(project.Id)
+	hostDir, err := s.d.HostDir(ws)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	s.lc.Touch(ws)
+	err = s.changeFiles(ws, sandbox.WorkspaceDir, func() error {
+		if replace {
+			if err := archive.Clear(hostDir); err != nil {
+				return err
+			}
+		}
+		return archive.Extract(f, size, hostDir, int64(s.cfg.Transfer.MaxSize))
+	})
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "project imported"})
+}
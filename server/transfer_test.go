@@ -0,0 +1,198 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// download fetches an export and returns the response and its body.
+func download(t *testing.T, ts *httptest.Server, path, token string) (*http.Response, []byte) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp, body
+}
+
+func zipNames(t *testing.T, data []byte) []string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("invalid zip: %v", err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func upload(t *testing.T, ts *httptest.Server, path, token, contentType string, body io.Reader) (*http.Response, map[string]any) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+path, body)
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	var out map[string]any
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp, out
+}
+
+func buildZip(t *testing.T, files map[string]string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		fw, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(fw, content)
+	}
+	zw.Close()
+	return &buf
+}
+
+func TestExportProject(t *testing.T) {
+	s, ts := newTestServer(t)
+	userId, token := newTestUser(t, s)
+	projectId := newTestProject(t, s, userId, "demo")
+	ctx := context.Background()
+	ws := workspaceId(projectId)
+	s.d.WriteFile(ctx, ws, "index.js", []byte("console.log(1)"))
+	s.d.CreateDir(ctx, ws, "node_modules/dep")
+	s.d.WriteFile(ctx, ws, "node_modules/dep/index.js", []byte("dep"))
+	base := fmt.Sprintf("/projects/%d/export", projectId)
+
+	resp, body := download(t, ts, base, token)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/zip" {
+		t.Fatalf("export: %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if got := resp.Header.Get("Content-Disposition"); got != `attachment; filename=demo.zip` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+	if names := zipNames(t, body); fmt.Sprint(names) != "[index.js]" {
+		t.Errorf("default export = %v, want only index.js", names)
+	}
+
+	_, body = download(t, ts, base+"?ignore=", token)
+	if names := zipNames(t, body); len(names) != 4 {
+		t.Errorf("export without ignore patterns = %v", names)
+	}
+
+	resp, body = download(t, ts, base+"?format=tar.gz&ignore=*.js", token)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("tar.gz export: %d", resp.StatusCode)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("invalid gzip: %v", err)
+	}
+	var names []string
+	for tr := tar.NewReader(gz); ; {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, hdr.Name)
+	}
+	if fmt.Sprint(names) != "[node_modules/ node_modules/dep/]" {
+		t.Errorf("tar.gz export ignoring *.js = %v", names)
+	}
+
+	if resp, _ := download(t, ts, base+"?format=rar", token); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown format: got %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestImportProject(t *testing.T) {
+	s, ts := newTestServer(t)
+	userId, token := newTestUser(t, s)
+	projectId := newTestProject(t, s, userId, "demo")
+	ctx := context.Background()
+	ws := workspaceId(projectId)
+	s.d.WriteFile(ctx, ws, "old.txt", []byte("old"))
+	base := fmt.Sprintf("/projects/%d/import", projectId)
+
+	archive := buildZip(t, map[string]string{"src/main.py": "print(1)", "README.md": "# demo"})
+	resp, body := upload(t, ts, base, token, "application/zip", archive)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("import: %d %v", resp.StatusCode, body)
+	}
+	if got, err := readWorkspaceFile(t, s, projectId, "src/main.py"); err != nil || got != "print(1)" {
+		t.Errorf("src/main.py = %q, %v", got, err)
+	}
+	if _, err := readWorkspaceFile(t, s, projectId, "old.txt"); err != nil {
+		t.Errorf("merging import removed old.txt: %v", err)
+	}
+
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, _ := mw.CreateFormFile("archive", "project.zip")
+	io.Copy(fw, buildZip(t, map[string]string{"app.js": "1"}))
+	mw.Close()
+	resp, body = upload(t, ts, base+"?replace=true", token, mw.FormDataContentType(), &form)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("multipart import: %d %v", resp.StatusCode, body)
+	}
+	files, err := s.d.ListFiles(ctx, ws, ".")
+	if err != nil || len(files) != 1 || files[0].Name != "app.js" {
+		t.Errorf("workspace after replacing import = %+v, %v", files, err)
+	}
+}
+
+func TestImportRejectsUnsafeArchives(t *testing.T) {
+	s, ts := newTestServer(t)
+	userId, token := newTestUser(t, s)
+	projectId := newTestProject(t, s, userId, "demo")
+	base := fmt.Sprintf("/projects/%d/import", projectId)
+	hostDir, _ := s.d.HostDir(workspaceId(projectId))
+
+	resp, _ := upload(t, ts, base, token, "application/zip", buildZip(t, map[string]string{"../evil": "x"}))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("path traversal: got %d, want 400", resp.StatusCode)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(hostDir), "evil")); err == nil {
+		t.Error("import wrote outside the workspace")
+	}
+
+	resp, _ = upload(t, ts, base, token, "application/zip", bytes.NewReader([]byte("not an archive")))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown format: got %d, want 400", resp.StatusCode)
+	}
+
+	s.cfg.Transfer.MaxSize = 10
+	resp, _ = upload(t, ts, base, token, "application/zip", buildZip(t, map[string]string{"big.txt": "0123456789abcdef"}))
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("archive expanding beyond the limit: got %d, want 413", resp.StatusCode)
+	}
+
+	s.cfg.Transfer.MaxUpload = 16
+	resp, _ = upload(t, ts, base, token, "application/zip", buildZip(t, map[string]string{"a": "b"}))
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("upload beyond the limit: got %d, want 413", resp.StatusCode)
+	}
+}
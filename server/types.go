@@ -1,8 +1,13 @@
 package server
 
-import "github.com/gin-gonic/gin"
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+)
 
 type ServerManager interface {
 	Start() error
+	Shutdown(ctx context.Context) error
 	wsHandler(c *gin.Context)
 }
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"path"
+
+	"github.com/chrollo-lucifer-12/repl/sandbox"
+	"github.com/chrollo-lucifer-12/repl/watcher"
+)
+
+// fileWatch watches the files of a workspace on behalf of the connections
+// subscribed to it. It is started by the first subscriber and closed when
+// the last one leaves.
+type fileWatch struct {
+	w    *watcher.Watcher
+	subs map[*wsConn]struct{}
+}
+
+// watchFiles subscribes conn to file_changes events of the workspace.
+func (s *Server) watchFiles(workspaceId string, conn *wsConn) error {
+	s.watchMu.Lock()
+	defer s.watchMu.Unlock()
+	if fw, ok := s.watches[workspaceId]; ok {
+		fw.subs[conn] = struct{}{}
+		return nil
+	}
+
+	hostDir, err := s.d.HostDir(workspaceId)
+	if err != nil {
+		return err
+	}
+	fw := &fileWatch{subs: map[*wsConn]struct{}{conn: {}}}
+	cfg := watcher.Config{Debounce: s.cfg.Watch.Debounce, Ignore: s.cfg.Watch.Ignore}
+	fw.w, err = watcher.New(hostDir, cfg, func(changes []watcher.Change) {
+		s.reportFileChanges(fw, changes)
+	})
+	if err != nil {
+		return err
+	}
+	s.watches[workspaceId] = fw
+	return nil
+}
+
+func (s *Server) reportFileChanges(fw *fileWatch, changes []watcher.Change) {
+	event := FileChangesEvent{Changes: make([]FileChange, 0, len(changes))}
+	for _, c := range changes {
+		change := FileChange{Kind: c.Kind, Path: path.Join(sandbox.WorkspaceDir, c.Path)}
+		if c.OldPath != "" {
+			change.OldPath = path.Join(sandbox.WorkspaceDir, c.OldPath)
+		}
+		event.Changes = append(event.Changes, change)
+	}
+
+	s.watchMu.Lock()
+	conns := make([]*wsConn, 0, len(fw.subs))
+	for conn := range fw.subs {
+		conns = append(conns, conn)
+	}
+	s.watchMu.Unlock()
+	for _, conn := range conns {
+		conn.emit(EventFileChanges, event)
+	}
+}
+
+// unwatchFiles unsubscribes conn, closing the watch when nobody else is
+// subscribed.
+func (s *Server) unwatchFiles(workspaceId string, conn *wsConn) {
+	s.watchMu.Lock()
+	fw, ok := s.watches[workspaceId]
+	if !ok {
+		s.watchMu.Unlock()
+		return
+	}
+	delete(fw.subs, conn)
+	if len(fw.subs) > 0 {
+		s.watchMu.Unlock()
+		return
+	}
+	delete(s.watches, workspaceId)
+	s.watchMu.Unlock()
+
+	// Close waits for a running report, which takes watchMu.
+	if err := fw.w.Close(); err != nil {
+		s.l.Error("failed to close file watch", "workspace", workspaceId, "error", err)
+	}
+}
+
+func (sess *wsSession) watchFiles(req *Request) (any, error) {
+	return nil, sess.s.watchFiles(sess.workspaceId, sess.conn)
+}
+
+func (sess *wsSession) unwatchFiles(req *Request) (any, error) {
+	sess.s.unwatchFiles(sess.workspaceId, sess.conn)
+	return nil, nil
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// waitFileChanges reads frames until a file_changes event arrives.
+func (c *testClient) waitFileChanges() []FileChange {
+	c.t.Helper()
+	for {
+		frame := c.read()
+		if frame.Kind == KindEvent && frame.Type == EventFileChanges {
+			var event FileChangesEvent
+			json.Unmarshal(frame.Payload, &event)
+			return event.Changes
+		}
+	}
+}
+
+func TestWSWatchFiles(t *testing.T) {
+	s, ts := newTestServer(t)
+	userId, token := newTestUser(t, s)
+	projectId := newTestProject(t, s, userId, "demo")
+	c := dialWS(t, ts, token, projectId)
+	c.hello()
+
+	if frame := c.call(MsgWatchFiles, nil); frame.Kind != KindResponse {
+		t.Fatalf("watch_files failed: %+v", frame)
+	}
+	c.call(MsgCreateDir, CreateDirPayload{Path: "node_modules/dep"})
+	c.call(MsgWriteFile, WriteFilePayload{Path: "node_modules/dep/index.js", Content: "x"})
+	c.call(MsgWriteFile, WriteFilePayload{Path: "index.js", Content: "console.log(1)\n"})
+
+	changes := c.waitFileChanges()
+	if len(changes) != 1 || changes[0] != (FileChange{Kind: "created", Path: "/workspace/index.js"}) {
+		t.Errorf("expected only index.js to be reported, got %+v", changes)
+	}
+
+	c.call(MsgRenameFile, RenameFilePayload{Path: "index.js", NewName: "main.js"})
+	changes = c.waitFileChanges()
+	want := FileChange{Kind: "renamed", Path: "/workspace/main.js", OldPath: "/workspace/index.js"}
+	if len(changes) != 1 || changes[0] != want {
+		t.Errorf("expected a rename, got %+v", changes)
+	}
+
+	c.call(MsgUnwatchFiles, nil)
+	s.watchMu.Lock()
+	watching := len(s.watches)
+	s.watchMu.Unlock()
+	if watching != 0 {
+		t.Errorf("expected the watch to be closed with its last subscriber, got %d", watching)
+	}
+}
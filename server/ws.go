@@ -3,171 +3,397 @@ package server
 import (
 	"context"
 	"encoding/json"
-	"io"
-	"net/http"
-	"strconv"
+	"errors"
+	"fmt"
+	"io/fs"
+	"runtime/debug"
+	"sync"
 
+	"github.com/chrollo-lucifer-12/repl/archive"
+	"github.com/chrollo-lucifer-12/repl/collab"
+	"github.com/chrollo-lucifer-12/repl/db"
+	"github.com/chrollo-lucifer-12/repl/git"
+	"github.com/chrollo-lucifer-12/repl/sandbox"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 )
 
+// eventQueueSize is how many queued events may wait for a connection
+// before it counts as fallen behind.
+const eventQueueSize = 1024
+
+// wsConn serialises writes to a websocket connection and stamps every
+// frame with the negotiated protocol version.
+type wsConn struct {
+	conn    *websocket.Conn
+	mu      sync.Mutex
+	version int
+
+	// events holds the frames queued for sendQueued.
+	events   chan queuedFrame
+	overflow sync.Once
+}
+
+// queuedFrame is a queued event, or a marker whose sent channel is closed
+// once the events queued before it were sent.
+type queuedFrame struct {
+	frame Frame
+	sent  chan struct{}
+}
+
+func newWSConn(conn *websocket.Conn) *wsConn {
+	return &wsConn{conn: conn, events: make(chan queuedFrame, eventQueueSize)}
+}
+
+func (c *wsConn) send(frame Frame) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	frame.Version = c.version
+	return c.conn.WriteJSON(frame)
+}
+
+func (c *wsConn) setVersion(version int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.version = version
+}
+
+func (c *wsConn) negotiatedVersion() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.version
+}
+
+func (c *wsConn) respond(id, msgType string, payload any) error {
+	return c.send(Frame{Kind: KindResponse, ID: id, Type: msgType, Payload: payload})
+}
+
+func (c *wsConn) fail(id, msgType string, err *FrameError) error {
+	return c.send(Frame{Kind: KindError, ID: id, Type: msgType, Error: err})
+}
+
+func (c *wsConn) emit(eventType string, payload any) error {
+	return c.send(Frame{Kind: KindEvent, Type: eventType, Payload: payload})
+}
+
+// queue sends an event from the goroutine of sendQueued, so events about
+// others' work never wait for a slow connection. A connection that falls
+// eventQueueSize events behind is closed; its client catches up the way
+// it does after any other disconnect.
+func (c *wsConn) queue(eventType string, payload any) {
+	select {
+	case c.events <- queuedFrame{frame: Frame{Kind: KindEvent, Type: eventType, Payload: payload}}:
+	default:
+		c.overflow.Do(func() { c.conn.Close() })
+	}
+}
+
+// sendQueued sends queued events until done is closed.
+func (c *wsConn) sendQueued(done <-chan struct{}) {
+	for {
+		select {
+		case q := <-c.events:
+			if q.sent != nil {
+				close(q.sent)
+			} else {
+				c.send(q.frame)
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// flushQueued waits until the events queued so far were sent, so the
+// response to a request never overtakes the events of its work.
+func (c *wsConn) flushQueued() {
+	sent := make(chan struct{})
+	c.events <- queuedFrame{sent: sent}
+	<-sent
+}
+
+// wsWriter turns raw process output into output events.
 type wsWriter struct {
-	conn *websocket.Conn
+	conn *wsConn
 }
 
 func (w *wsWriter) Write(p []byte) (int, error) {
-	message := map[string]interface{}{
-		"type": "output",
-		"data": string(p),
-	}
-	err := w.conn.WriteJSON(message)
+	err := w.conn.emit(EventOutput, OutputEvent{Data: string(p)})
 	if err != nil {
 		return 0, err
 	}
 	return len(p), nil
 }
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+// wsSession is the per-connection state shared by the message handlers.
+type wsSession struct {
+	s      *Server
+	conn   *wsConn
+	ctx    context.Context
+	writer *wsWriter
+
+	// projectId and workspaceId name the project, and its workspace, this
+	// connection operates on.
+	projectId   uint
+	workspaceId string
+
+	// user is the authenticated user of the connection, the author of
+	// its git commits.
+	user *db.CreatedUser
+
+	// afterResponse is run once the response to the current request was
+	// sent, for work whose events must follow it.
+	afterResponse func()
+}
+
+type wsHandlerFunc func(sess *wsSession, req *Request) (any, error)
+
+var wsHandlers = map[string]wsHandlerFunc{
+	MsgHello:           (*wsSession).hello,
+	MsgInitProject:     (*wsSession).initProject,
+	MsgRunProject:      (*wsSession).runProject,
+	MsgInput:           (*wsSession).input,
+	MsgResizeTerminal:  (*wsSession).resizeTerminal,
+	MsgOpenTerminal:    (*wsSession).openTerminal,
+	MsgCloseTerminal:   (*wsSession).closeTerminal,
+	MsgAttachTerminal:  (*wsSession).attachTerminal,
+	MsgListTerminals:   (*wsSession).listTerminals,
+	MsgWriteFile:       (*wsSession).writeFile,
+	MsgReadFile:        (*wsSession).readFile,
+	MsgListFiles:       (*wsSession).listFiles,
+	MsgRemoveFile:      (*wsSession).removeFile,
+	MsgStatFile:        (*wsSession).statFile,
+	MsgSearchFile:      (*wsSession).searchFile,
+	MsgRenameFile:      (*wsSession).renameFile,
+	MsgCreateDir:       (*wsSession).createDir,
+	MsgStartProcess:    (*wsSession).startProcess,
+	MsgStopProcess:     (*wsSession).stopProcess,
+	MsgRestartProcess:  (*wsSession).restartProcess,
+	MsgRemoveProcess:   (*wsSession).removeProcess,
+	MsgListProcesses:   (*wsSession).listProcesses,
+	MsgProcessLogs:     (*wsSession).processLogs,
+	MsgListPorts:       (*wsSession).listPorts,
+	MsgWatchFiles:      (*wsSession).watchFiles,
+	MsgUnwatchFiles:    (*wsSession).unwatchFiles,
+	MsgCreateSnapshot:  (*wsSession).createSnapshot,
+	MsgListSnapshots:   (*wsSession).listSnapshots,
+	MsgRestoreSnapshot: (*wsSession).restoreSnapshot,
+	MsgDeleteSnapshot:  (*wsSession).deleteSnapshot,
+	MsgGitInit:         (*wsSession).gitInit,
+	MsgGitStatus:       (*wsSession).gitStatus,
+	MsgGitDiff:         (*wsSession).gitDiff,
+	MsgGitStage:        (*wsSession).gitStage,
+	MsgGitUnstage:      (*wsSession).gitUnstage,
+	MsgGitCommit:       (*wsSession).gitCommit,
+	MsgGitLog:          (*wsSession).gitLog,
+	MsgGitBranches:     (*wsSession).gitBranches,
+	MsgGitCheckout:     (*wsSession).gitCheckout,
+	MsgGitRemotes:      (*wsSession).gitRemotes,
+	MsgGitAddRemote:    (*wsSession).gitAddRemote,
+	MsgGitFetch:        (*wsSession).gitFetch,
+	MsgGitPush:         (*wsSession).gitPush,
+	MsgGitPull:         (*wsSession).gitPull,
+	MsgLspStart:        (*wsSession).lspStart,
+	MsgLspMessage:      (*wsSession).lspMessage,
+	MsgLspStop:         (*wsSession).lspStop,
+	MsgOpenDoc:         (*wsSession).openDoc,
+	MsgEditDoc:         (*wsSession).editDoc,
+	MsgUpdateCursor:    (*wsSession).updateCursor,
+	MsgCloseDoc:        (*wsSession).closeDoc,
 }
 
 func (s *Server) wsHandler(c *gin.Context) {
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
-	if err != nil {
-		s.l.Error("ws upgrade failed:", err)
+	project := s.userProject(c, c.Query("project"))
+	if project == nil {
 		return
 	}
 
-	defer conn.Close()
+	if s.draining.Load() {
+		c.JSON(503, gin.H{"error": "server is shutting down"})
+		return
+	}
 
-	ctx := context.Background()
+	conn, err := s.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		s.l.Error("ws upgrade failed", "project", project.Id, "error", err)
+		return
+	}
 
-	writer := &wsWriter{conn: conn}
+	defer conn.Close()
 
-	pr, pw := io.Pipe()
-	defer pw.Close()
+	wc := newWSConn(conn)
+	done := make(chan struct{})
+	defer close(done)
+	go wc.sendQueued(done)
+	s.conns.Store(wc, workspaceId(project.Id))
+	defer s.conns.Delete(wc)
+	defer s.unwatchFiles(workspaceId(project.Id), wc)
+	defer s.detachLanguageServers(wc)
+	defer s.closeDocs(wc)
+	sess := &wsSession{
+		s:      s,
+		conn:   wc,
+		ctx:    context.Background(),
+		writer: &wsWriter{conn: wc},
 
-	var replStarted bool
+		projectId:   project.Id,
+		workspaceId: workspaceId(project.Id),
+		user:        currentUser(c),
+	}
+	defer func() {
+		if !s.draining.Load() {
+			s.detachTerminals(wc)
+		}
+	}()
 
 	for {
 		_, msg, err := conn.ReadMessage()
 		if err != nil {
-			s.l.Error("ws read error:", err)
+			s.l.Error("ws read failed", "workspace", sess.workspaceId, "error", err)
 			return
 		}
 
-		var msgData map[string]string
-		if err := json.Unmarshal(msg, &msgData); err != nil {
-			writer.Write([]byte("invalid JSON\n"))
+		var req Request
+		if err := json.Unmarshal(msg, &req); err != nil {
+			wc.fail("", "", &FrameError{Code: CodeInvalidJSON, Message: err.Error()})
 			continue
 		}
 
-		userId := msgData["userId"]
+		sess.dispatch(&req)
+	}
+}
 
-		switch msgData["type"] {
+// recoverRequest turns a panic in a message handler into an internal
+// error frame, so one bad request cannot take the server down.
+func (sess *wsSession) recoverRequest(req *Request) {
+	if r := recover(); r != nil {
+		sess.s.l.Error("ws handler panicked", "panic", r, "type", req.Type, "stack", string(debug.Stack()))
+		sess.conn.fail(req.ID, req.Type, &FrameError{Code: CodeInternal, Message: "internal error"})
+	}
+}
 
-		case "init_project":
-			{
-				userIdUint, _ := strconv.ParseUint(userId, 10, 64)
-				user, err := s.db.FindUser(uint(userIdUint))
-				if err != nil || user == nil {
-					writer.Write([]byte("user not found"))
-					return
-				}
-				s.d.StartContainer(ctx, writer, msgData["userId"])
-			}
+func (sess *wsSession) dispatch(req *Request) {
+	defer sess.recoverRequest(req)
 
-		case "react_project":
-			{
-				if replStarted {
-					continue
-				}
-				replStarted = true
-				go func() {
-					err := s.d.StartInteractiveRepl(ctx, userId, pr, writer)
-					if err != nil {
-						writer.Write([]byte(err.Error()))
-					}
-				}()
-
-				pw.Write([]byte("npm create vite@latest my-app -- --template react\n"))
-			}
+	version := sess.conn.negotiatedVersion()
+	if req.Type != MsgHello && version == 0 {
+		sess.conn.fail(req.ID, req.Type, &FrameError{
+			Code:    CodeHandshakeRequired,
+			Message: "send a hello message first",
+		})
+		return
+	}
+	if req.Version != 0 && version != 0 && req.Version != version {
+		sess.conn.fail(req.ID, req.Type, &FrameError{
+			Code:    CodeUnsupportedVersion,
+			Message: fmt.Sprintf("connection negotiated protocol version %d", version),
+		})
+		return
+	}
 
-		case "input":
-			if data, ok := msgData["data"]; ok {
-				pw.Write([]byte(data))
-			}
+	handler, ok := wsHandlers[req.Type]
+	if !ok {
+		sess.conn.fail(req.ID, req.Type, &FrameError{
+			Code:    CodeUnknownType,
+			Message: "unknown message type " + req.Type,
+		})
+		return
+	}
+
+	if req.Type != MsgHello {
+		sess.s.lc.Touch(sess.workspaceId)
+	}
+
+	sess.afterResponse = nil
+	result, err := handler(sess, req)
+	sess.conn.flushQueued()
+	if err != nil {
+		sess.conn.fail(req.ID, req.Type, sess.frameError(err))
+		return
+	}
+	if req.ID != "" {
+		if result == nil {
+			result = struct{}{}
+		}
+		sess.conn.respond(req.ID, req.Type, result)
+	}
+	if sess.afterResponse != nil {
+		sess.afterResponse()
+	}
+}
+
+func (sess *wsSession) frameError(err error) *FrameError {
+	var frameErr *FrameError
+	if errors.As(err, &frameErr) {
+		return frameErr
+	}
+	switch {
+	case errors.Is(err, sandbox.ErrContainerGone):
+		return &FrameError{Code: CodeContainerGone, Message: err.Error()}
+	case errors.Is(err, sandbox.ErrImageNotFound):
+		return &FrameError{Code: CodeImageNotFound, Message: err.Error()}
+	case errors.Is(err, sandbox.ErrDaemonUnavailable):
+		sess.s.l.Error("ws request failed", "workspace", sess.workspaceId, "error", err)
+		return &FrameError{Code: CodeRuntimeUnavailable, Message: err.Error()}
+	case errors.Is(err, sandbox.ErrQuotaExceeded):
+		return &FrameError{Code: CodeQuotaExceeded, Message: err.Error()}
+	case errors.Is(err, fs.ErrNotExist):
+		return &FrameError{Code: CodeNotFound, Message: err.Error()}
+	case errors.Is(err, sandbox.ErrPathOutsideWorkspace):
+		return &FrameError{Code: CodeOutsideWorkspace, Message: err.Error()}
+	case errors.Is(err, sandbox.ErrIsDirectory):
+		return &FrameError{Code: CodeIsDirectory, Message: err.Error()}
+	case errors.Is(err, sandbox.ErrFileTooLarge):
+		return &FrameError{Code: CodeFileTooLarge, Message: err.Error()}
+	case errors.Is(err, sandbox.ErrInvalidTerminalSize):
+		return &FrameError{Code: CodeInvalidPayload, Message: err.Error()}
+	case errors.Is(err, db.ErrSnapshotNotFound):
+		return &FrameError{Code: CodeNotFound, Message: err.Error()}
+	case errors.Is(err, db.ErrSnapshotExists):
+		return &FrameError{Code: CodeAlreadyExists, Message: err.Error()}
+	case errors.Is(err, errArchiveBusy):
+		return &FrameError{Code: CodeBusy, Message: err.Error()}
+	case errors.Is(err, archive.ErrTooLarge):
+		return &FrameError{Code: CodeFileTooLarge, Message: err.Error()}
+	case errors.Is(err, errors.ErrUnsupported):
+		return &FrameError{Code: CodeUnsupported, Message: err.Error()}
+	case errors.Is(err, git.ErrNotRepository):
+		return &FrameError{Code: CodeNotRepository, Message: err.Error()}
+	case errors.Is(err, git.ErrInvalidName):
+		return &FrameError{Code: CodeInvalidPayload, Message: err.Error()}
+	case errors.As(err, new(*git.Error)):
+		return &FrameError{Code: CodeGitFailed, Message: err.Error()}
+	case errors.Is(err, collab.ErrRevision):
+		return &FrameError{Code: CodeStaleRevision, Message: err.Error()}
+	case errors.Is(err, collab.ErrBaseLength):
+		return &FrameError{Code: CodeInvalidPayload, Message: err.Error()}
+	}
+	sess.s.l.Error("ws request failed", "workspace", sess.workspaceId, "error", err)
+	return &FrameError{Code: CodeInternal, Message: err.Error()}
+}
 
-		case "write_file":
-			_ = s.d.WriteFile(
-				ctx,
-				userId,
-				msgData["path"],
-				msgData["content"],
-				writer,
-			)
-
-		case "read_file":
-			_ = s.d.ReadFile(
-				ctx,
-				userId,
-				msgData["path"],
-				writer,
-			)
-
-		case "list_files":
-			_ = s.d.ListFiles(
-				ctx,
-				userId,
-				msgData["path"],
-				writer,
-			)
-
-		case "remove_file":
-			_ = s.d.RemoveFile(
-				ctx,
-				msgData["path"],
-				userId,
-				writer,
-			)
-
-		case "stat_file":
-			_ = s.d.StatFile(
-				ctx,
-				userId,
-				msgData["path"],
-				writer,
-			)
-
-		case "search_file":
-			_ = s.d.SearchInFile(
-				ctx,
-				userId,
-				msgData["path"],
-				msgData["search"],
-				writer,
-			)
-
-		case "rename_file":
-			_ = s.d.RenameFileDir(
-				ctx,
-				userId,
-				msgData["path"],
-				msgData["new_name"],
-				writer,
-			)
-		case "create_dir":
-			_ = s.d.CreateDir(ctx, userId, msgData["path"], writer)
-
-		case "resize_terminal":
-			_ = s.d.ResizeTerminal(ctx, userId, msgData["rows"], msgData["cols"])
-
-		default:
-			writer.Write([]byte("unknown message type\n"))
+func decodePayload(req *Request, v any) error {
+	if len(req.Payload) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(req.Payload, v); err != nil {
+		return &FrameError{Code: CodeInvalidPayload, Message: err.Error()}
+	}
+	return nil
+}
+
+func (sess *wsSession) hello(req *Request) (any, error) {
+	var payload HelloPayload
+	if err := decodePayload(req, &payload); err != nil {
+		return nil, err
+	}
+	version, ok := negotiateVersion(payload.Versions)
+	if !ok {
+		return nil, &FrameError{
+			Code:    CodeUnsupportedVersion,
+			Message: "no supported protocol version offered",
 		}
 	}
+	sess.conn.setVersion(version)
+	return HelloResult{Version: version}, nil
 }
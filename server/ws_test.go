@@ -0,0 +1,257 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testClient drives the WebSocket protocol from the client side.
+type testClient struct {
+	t      *testing.T
+	conn   *websocket.Conn
+	nextId int
+}
+
+type testFrame struct {
+	Kind    string          `json:"kind"`
+	Version int             `json:"v"`
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload"`
+	Error   *FrameError     `json:"error"`
+}
+
+func wsURL(ts *httptest.Server, token string, projectId uint) string {
+	return fmt.Sprintf("ws%s/ws?token=%s&project=%d", strings.TrimPrefix(ts.URL, "http"), token, projectId)
+}
+
+func dialWS(t *testing.T, ts *httptest.Server, token string, projectId uint) *testClient {
+	t.Helper()
+	url := wsURL(ts, token, projectId)
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to dial ws: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn}
+}
+
+func (c *testClient) send(msgType string, payload any) string {
+	c.t.Helper()
+	c.nextId++
+	id := "req-" + strconv.Itoa(c.nextId)
+	raw, _ := json.Marshal(payload)
+	req := Request{ID: id, Type: msgType, Payload: raw}
+	if err := c.conn.WriteJSON(req); err != nil {
+		c.t.Fatalf("failed to write ws message: %v", err)
+	}
+	return id
+}
+
+func (c *testClient) read() testFrame {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var frame testFrame
+	if err := c.conn.ReadJSON(&frame); err != nil {
+		c.t.Fatalf("failed to read ws message: %v", err)
+	}
+	return frame
+}
+
+// call sends a request and returns the response or error frame for it,
+// skipping any events that arrive in between.
+func (c *testClient) call(msgType string, payload any) testFrame {
+	c.t.Helper()
+	id := c.send(msgType, payload)
+	for {
+		frame := c.read()
+		if frame.Kind != KindEvent && frame.ID == id {
+			return frame
+		}
+	}
+}
+
+func (c *testClient) hello() {
+	c.t.Helper()
+	frame := c.call(MsgHello, HelloPayload{Versions: []int{ProtocolVersion}})
+	if frame.Kind != KindResponse {
+		c.t.Fatalf("handshake failed: %+v", frame.Error)
+	}
+}
+
+func TestWSRequiresSession(t *testing.T) {
+	s, ts := newTestServer(t)
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL(ts, "bogus", 1), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an unknown session, got %v", err)
+	}
+
+	owner, _ := newTestUser(t, s)
+	projectId := newTestProject(t, s, owner, "demo")
+	_, token := newTestUser(t, s)
+	_, resp, err = websocket.DefaultDialer.Dial(wsURL(ts, token, projectId), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for another user's project, got %v", err)
+	}
+}
+
+func TestWSHandshake(t *testing.T) {
+	s, ts := newTestServer(t)
+	userId, token := newTestUser(t, s)
+	c := dialWS(t, ts, token, newTestProject(t, s, userId, "demo"))
+
+	frame := c.call(MsgListFiles, ListFilesPayload{Path: "."})
+	if frame.Kind != KindError || frame.Error.Code != CodeHandshakeRequired {
+		t.Fatalf("expected handshake_required, got %+v", frame)
+	}
+
+	frame = c.call(MsgHello, HelloPayload{Versions: []int{99}})
+	if frame.Kind != KindError || frame.Error.Code != CodeUnsupportedVersion {
+		t.Fatalf("expected unsupported_version, got %+v", frame)
+	}
+
+	frame = c.call(MsgHello, HelloPayload{Versions: []int{99, ProtocolVersion}})
+	var result HelloResult
+	json.Unmarshal(frame.Payload, &result)
+	if frame.Kind != KindResponse || result.Version != ProtocolVersion || frame.Version != ProtocolVersion {
+		t.Fatalf("unexpected hello reply %+v", frame)
+	}
+}
+
+func TestWSFileOperations(t *testing.T) {
+	s, ts := newTestServer(t)
+	userId, token := newTestUser(t, s)
+	c := dialWS(t, ts, token, newTestProject(t, s, userId, "demo"))
+	c.hello()
+
+	frame := c.call(MsgWriteFile, WriteFilePayload{Path: "index.js", Content: "console.log(1)"})
+	if frame.Kind != KindResponse {
+		t.Fatalf("write_file failed: %+v", frame.Error)
+	}
+
+	frame = c.call(MsgReadFile, ReadFilePayload{Path: "index.js"})
+	var read ReadFileResult
+	json.Unmarshal(frame.Payload, &read)
+	if read.Content != "console.log(1)" {
+		t.Errorf("read_file returned %q", read.Content)
+	}
+
+	frame = c.call(MsgListFiles, ListFilesPayload{Path: "."})
+	var list ListFilesResult
+	json.Unmarshal(frame.Payload, &list)
+	if len(list.Files) != 1 || list.Files[0].Name != "index.js" {
+		t.Errorf("unexpected listing %+v", list.Files)
+	}
+
+	frame = c.call(MsgReadFile, ReadFilePayload{Path: "missing.js"})
+	if frame.Kind != KindError || frame.Error.Code != CodeNotFound {
+		t.Errorf("expected not_found, got %+v", frame)
+	}
+
+	frame = c.call(MsgWriteFile, WriteFilePayload{Path: "../../etc/passwd", Content: "x"})
+	if frame.Kind != KindError || frame.Error.Code != CodeOutsideWorkspace {
+		t.Errorf("expected outside_workspace, got %+v", frame)
+	}
+
+	blob := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}
+	frame = c.call(MsgWriteFile, WriteFilePayload{
+		Path:     "logo.png",
+		Content:  base64.StdEncoding.EncodeToString(blob),
+		Encoding: EncodingBase64,
+	})
+	if frame.Kind != KindResponse {
+		t.Fatalf("binary write_file failed: %+v", frame.Error)
+	}
+	frame = c.call(MsgReadFile, ReadFilePayload{Path: "logo.png"})
+	json.Unmarshal(frame.Payload, &read)
+	decoded, _ := base64.StdEncoding.DecodeString(read.Content)
+	if read.Encoding != EncodingBase64 || !bytes.Equal(decoded, blob) {
+		t.Errorf("expected binary content to round-trip as base64, got %+v", read)
+	}
+	c.call(MsgRemoveFile, RemoveFilePayload{Path: "logo.png"})
+
+	frame = c.call(MsgResizeTerminal, json.RawMessage(`{"rows":"24"}`))
+	if frame.Kind != KindError || frame.Error.Code != CodeInvalidPayload {
+		t.Errorf("expected invalid_payload, got %+v", frame)
+	}
+
+	other := dialWS(t, ts, token, newTestProject(t, s, userId, "other"))
+	other.hello()
+	frame = other.call(MsgListFiles, ListFilesPayload{Path: "."})
+	var otherList ListFilesResult
+	json.Unmarshal(frame.Payload, &otherList)
+	if frame.Kind != KindResponse || len(otherList.Files) != 0 {
+		t.Errorf("expected a second project to have its own empty workspace, got %+v", frame)
+	}
+
+	frame = c.call("bogus", nil)
+	if frame.Kind != KindError || frame.Error.Code != CodeUnknownType {
+		t.Errorf("expected unknown_type, got %+v", frame)
+	}
+}
+
+func TestWSRuntimeErrors(t *testing.T) {
+	s, ts := newTestServer(t)
+	userId, token := newTestUser(t, s)
+	projectId := newTestProject(t, s, userId, "demo")
+	c := dialWS(t, ts, token, projectId)
+	c.hello()
+
+	wsHandlers["test_panic"] = func(*wsSession, *Request) (any, error) { panic("boom") }
+	defer delete(wsHandlers, "test_panic")
+	frame := c.call("test_panic", nil)
+	if frame.Kind != KindError || frame.Error.Code != CodeInternal {
+		t.Errorf("expected a panicking handler to fail with internal, got %+v", frame)
+	}
+
+	if err := s.d.DeleteContainer(context.Background(), workspaceId(projectId)); err != nil {
+		t.Fatalf("DeleteContainer: %v", err)
+	}
+	frame = c.call(MsgWriteFile, WriteFilePayload{Path: "a.txt", Content: "x"})
+	if frame.Kind != KindError || frame.Error.Code != CodeContainerGone {
+		t.Errorf("expected container_gone after the workspace was deleted, got %+v", frame)
+	}
+}
+
+func TestWSSlowConnectionIsClosed(t *testing.T) {
+	s, ts := newTestServer(t)
+	userId, token := newTestUser(t, s)
+	projectId := newTestProject(t, s, userId, "demo")
+	c := dialWS(t, ts, token, projectId)
+	c.hello()
+
+	var wc *wsConn
+	s.conns.Range(func(key, value any) bool {
+		wc = key.(*wsConn)
+		return false
+	})
+	// Hold the writer as a connection that stopped reading would.
+	wc.mu.Lock()
+	for i := 0; i <= eventQueueSize+1; i++ {
+		wc.queue(EventOutput, OutputEvent{Data: "x"})
+	}
+	wc.mu.Unlock()
+
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := c.conn.ReadMessage(); err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Fatal("slow connection was not closed")
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package templates
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// DefaultTemplate is used for projects created without a template.
+const DefaultTemplate = "blank"
+
+// Template describes how a new workspace is set up. Templates are data:
+// the built-in ones live in templates.json.
+type Template struct {
+	Id          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+
+	// Image is the base image of the workspace container.
+	Image string `json:"image"`
+	// Files are written into the workspace first, keyed by path relative
+	// to the workspace directory.
+	Files map[string]string `json:"files,omitempty"`
+	// Commands run in the workspace directory after the files are written,
+	// in order.
+	Commands [][]string `json:"commands,omitempty"`
+
+	// RunCommand starts the project once it is scaffolded.
+	RunCommand []string `json:"runCommand,omitempty"`
+	// Port is the port RunCommand serves on, if any.
+	Port int `json:"port,omitempty"`
+}
+
+//go:embed templates.json
+var builtin []byte
+
+// Registry holds the templates projects can be created from.
+type Registry struct {
+	templates map[string]*Template
+}
+
+func NewRegistry(templates ...Template) (*Registry, error) {
+	r := &Registry{templates: make(map[string]*Template, len(templates))}
+	for i := range templates {
+		t := &templates[i]
+		if t.Id == "" || t.Image == "" {
+			return nil, fmt.Errorf("template %q needs an id and an image", t.Id)
+		}
+		if _, ok := r.templates[t.Id]; ok {
+			return nil, fmt.Errorf("duplicate template %q", t.Id)
+		}
+		r.templates[t.Id] = t
+	}
+	return r, nil
+}
+
+// Builtin returns a registry of the templates shipped with the server.
+func Builtin() (*Registry, error) {
+	var templates []Template
+	if err := json.Unmarshal(builtin, &templates); err != nil {
+		return nil, fmt.Errorf("parse built-in templates: %w", err)
+	}
+	return NewRegistry(templates...)
+}
+
+func (r *Registry) Get(id string) (*Template, bool) {
+	t, ok := r.templates[id]
+	return t, ok
+}
+
+// List returns every template ordered by id.
+func (r *Registry) List() []*Template {
+	list := make([]*Template, 0, len(r.templates))
+	for _, t := range r.templates {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Id < list[j].Id })
+	return list
+}
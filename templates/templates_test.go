@@ -0,0 +1,28 @@
+package templates
+
+import "testing"
+
+func TestBuiltin(t *testing.T) {
+	r, err := Builtin()
+	if err != nil {
+		t.Fatalf("Builtin: %v", err)
+	}
+	if _, ok := r.Get(DefaultTemplate); !ok {
+		t.Errorf("default template %q is missing", DefaultTemplate)
+	}
+	list := r.List()
+	for i := 1; i < len(list); i++ {
+		if list[i-1].Id >= list[i].Id {
+			t.Errorf("templates not ordered by id: %q before %q", list[i-1].Id, list[i].Id)
+		}
+	}
+}
+
+func TestNewRegistryRejectsInvalidTemplates(t *testing.T) {
+	if _, err := NewRegistry(Template{Id: "a"}); err == nil {
+		t.Error("expected a template without an image to be rejected")
+	}
+	if _, err := NewRegistry(Template{Id: "a", Image: "x"}, Template{Id: "a", Image: "y"}); err == nil {
+		t.Error("expected duplicate templates to be rejected")
+	}
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"io"
 )
@@ -11,7 +12,25 @@ func BytesToInt64(b []byte) int64 {
 	return val
 }
 
-func ReadDockerOutput(reader io.Reader, w io.Writer) error {
+// Stream types of the multiplexed output of a non-TTY exec.
+const (
+	streamStdin  = 0
+	streamStdout = 1
+	streamStderr = 2
+	streamSystem = 3
+)
+
+// ReadDockerOutput demultiplexes the output of a non-TTY exec, where every
+// frame starts with an 8 byte header naming the stream and the frame size,
+// into stdout and stderr. Either writer may be nil to discard the stream.
+// A frame on the system stream carries an error of the daemon.
+func ReadDockerOutput(reader io.Reader, stdout, stderr io.Writer) error {
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
 	header := make([]byte, 8)
 	for {
 		_, err := io.ReadFull(reader, header)
@@ -23,14 +42,22 @@ func ReadDockerOutput(reader io.Reader, w io.Writer) error {
 		}
 
 		size := int(header[4])<<24 | int(header[5])<<16 | int(header[6])<<8 | int(header[7])
-		if size == 0 {
-			continue
-		}
 		buf := make([]byte, size)
-		_, err = io.ReadFull(reader, buf)
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return err
+		}
+		switch header[0] {
+		case streamStdin, streamStdout:
+			_, err = stdout.Write(buf)
+		case streamStderr:
+			_, err = stderr.Write(buf)
+		case streamSystem:
+			return errors.New(string(buf))
+		default:
+			return fmt.Errorf("unknown stream type %d", header[0])
+		}
 		if err != nil {
 			return err
 		}
-		w.Write(buf)
 	}
 }
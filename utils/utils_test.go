@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func frame(stream byte, payload string) []byte {
+	header := make([]byte, 8)
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	return append(header, payload...)
+}
+
+func TestReadDockerOutputDemultiplexes(t *testing.T) {
+	var in bytes.Buffer
+	in.Write(frame(streamStdout, "out 1\n"))
+	in.Write(frame(streamStderr, "err\n"))
+	in.Write(frame(streamStdout, ""))
+	in.Write(frame(streamStdout, "out 2\n"))
+
+	var stdout, stderr bytes.Buffer
+	if err := ReadDockerOutput(&in, &stdout, &stderr); err != nil {
+		t.Fatalf("ReadDockerOutput: %v", err)
+	}
+	if stdout.String() != "out 1\nout 2\n" || stderr.String() != "err\n" {
+		t.Errorf("unexpected streams %q and %q", stdout.String(), stderr.String())
+	}
+
+	in.Reset()
+	in.Write(frame(streamSystem, "exec failed"))
+	if err := ReadDockerOutput(&in, nil, nil); err == nil || err.Error() != "exec failed" {
+		t.Errorf("expected the system stream to fail, got %v", err)
+	}
+
+	in.Reset()
+	in.Write(frame(streamStdout, "truncated")[:12])
+	if err := ReadDockerOutput(&in, nil, nil); err == nil {
+		t.Error("expected a truncated frame to fail")
+	}
+}
@@ -0,0 +1,303 @@
+package watcher
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Kinds of change.
+const (
+	Created  = "created"
+	Modified = "modified"
+	Deleted  = "deleted"
+	Renamed  = "renamed"
+)
+
+// Change is a change to a file or directory. Paths are slash separated and
+// relative to the watched root; OldPath is only set for renames.
+type Change struct {
+	Kind    string `json:"kind"`
+	Path    string `json:"path"`
+	OldPath string `json:"oldPath,omitempty"`
+}
+
+type Config struct {
+	// Debounce is how long the tree has to be quiet before the collected
+	// changes are reported. Changes are reported at least every ten
+	// debounce periods while the tree keeps changing.
+	Debounce time.Duration
+	// Ignore holds path.Match patterns; a path with a matching element,
+	// such as node_modules, is neither watched nor reported.
+	Ignore []string
+}
+
+// Watcher reports changes below a directory. fsnotify only watches single
+// directories, so every directory of the tree is watched on its own and
+// new ones are added as they appear.
+type Watcher struct {
+	root   string
+	cfg    Config
+	report func([]Change)
+	fs     *fsnotify.Watcher
+
+	closeOnce sync.Once
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+// New watches the tree below root and calls report with every batch of
+// changes, from a single goroutine.
+func New(root string, cfg Config, report func([]Change)) (*Watcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		root:    filepath.Clean(root),
+		cfg:     cfg,
+		report:  report,
+		fs:      fw,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if err := w.addTree(w.root, nil); err != nil {
+		fw.Close()
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// Close stops watching and waits for the last report to return.
+func (w *Watcher) Close() error {
+	var err error
+	w.closeOnce.Do(func() {
+		close(w.done)
+		<-w.stopped
+		err = w.fs.Close()
+	})
+	return err
+}
+
+func (w *Watcher) ignored(rel string) bool {
+	for _, elem := range strings.Split(rel, "/") {
+		for _, pattern := range w.cfg.Ignore {
+			if ok, _ := path.Match(pattern, elem); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rel returns the path of name relative to the root.
+func (w *Watcher) rel(name string) (string, bool) {
+	rel, err := filepath.Rel(w.root, name)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// addTree watches dir and every directory below it. When found is not nil,
+// everything below dir is passed to it; files created together with a new
+// directory would otherwise go unnoticed.
+func (w *Watcher) addTree(dir string, found func(string)) error {
+	return filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// The entry vanished or is unreadable; skip it.
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, ok := w.rel(name)
+		if ok && w.ignored(rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ok && found != nil && name != dir {
+			found(rel)
+		}
+		if d.IsDir() {
+			if err := w.fs.Add(name); err != nil && name == w.root {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (w *Watcher) run() {
+	defer close(w.stopped)
+
+	b := newBatch()
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	var deadline time.Time
+	// renamed is the old name of a rename, kept until the next event.
+	var renamed *renameSource
+
+	for {
+		select {
+		case <-w.done:
+			return
+
+		case ev, ok := <-w.fs.Events:
+			if !ok {
+				return
+			}
+			rel, ok := w.rel(ev.Name)
+			if !ok || w.ignored(rel) {
+				continue
+			}
+
+			// A rename shows up as a rename of the old name directly
+			// followed by a create of the new one.
+			from := renamed
+			renamed = nil
+			switch {
+			case ev.Has(fsnotify.Create):
+				if from != nil {
+					b.rename(from, rel)
+				} else {
+					b.create(rel)
+				}
+				if info, err := os.Lstat(ev.Name); err == nil && info.IsDir() {
+					// The contents of a moved directory did not change.
+					found := b.create
+					if from != nil {
+						found = nil
+					}
+					w.addTree(ev.Name, found)
+				}
+			case ev.Has(fsnotify.Write):
+				b.modify(rel)
+			case ev.Has(fsnotify.Remove):
+				b.remove(rel)
+			case ev.Has(fsnotify.Rename):
+				renamed = &renameSource{path: rel, prev: b.changes[rel]}
+				b.remove(rel)
+				// Watches follow the inode, so drop the one of a moved
+				// directory; its new name is watched on create.
+				w.fs.Remove(ev.Name)
+			default:
+				continue
+			}
+
+			now := time.Now()
+			if deadline.IsZero() {
+				deadline = now.Add(10 * w.cfg.Debounce)
+			}
+			timer.Reset(min(w.cfg.Debounce, deadline.Sub(now)))
+
+		case <-w.fs.Errors:
+			// Overflows and the like; the next batch is still reported.
+
+		case <-timer.C:
+			deadline = time.Time{}
+			renamed = nil
+			if changes := b.flush(); len(changes) > 0 {
+				w.report(changes)
+			}
+		}
+	}
+}
+
+// batch coalesces the changes to each path between two reports.
+type batch struct {
+	order   []string
+	changes map[string]*Change
+}
+
+func newBatch() *batch {
+	return &batch{changes: map[string]*Change{}}
+}
+
+func (b *batch) set(p string, c *Change) {
+	if _, ok := b.changes[p]; !ok {
+		b.order = append(b.order, p)
+	}
+	b.changes[p] = c
+}
+
+func (b *batch) create(p string) {
+	if prev, ok := b.changes[p]; ok && prev.Kind == Deleted {
+		b.set(p, &Change{Kind: Modified, Path: p})
+		return
+	}
+	if _, ok := b.changes[p]; !ok {
+		b.set(p, &Change{Kind: Created, Path: p})
+	}
+}
+
+func (b *batch) modify(p string) {
+	if _, ok := b.changes[p]; !ok {
+		b.set(p, &Change{Kind: Modified, Path: p})
+	}
+}
+
+func (b *batch) remove(p string) {
+	prev, ok := b.changes[p]
+	switch {
+	case ok && prev.Kind == Created:
+		// Created and gone again within one batch.
+		delete(b.changes, p)
+	case ok && prev.Kind == Renamed:
+		// What is gone is the file under its old name.
+		delete(b.changes, p)
+		b.set(prev.OldPath, &Change{Kind: Deleted, Path: prev.OldPath})
+	default:
+		b.set(p, &Change{Kind: Deleted, Path: p})
+	}
+}
+
+// renameSource is the old name of a rename and its change in the batch
+// before the rename, if any.
+type renameSource struct {
+	path string
+	prev *Change
+}
+
+// rename turns the deletion recorded by remove for the old name into a
+// rename to the new one.
+func (b *batch) rename(from *renameSource, to string) {
+	oldPath := from.path
+	switch {
+	case from.prev != nil && from.prev.Kind == Created:
+		// New in this batch, so only the new name is.
+		b.create(to)
+		return
+	case from.prev != nil && from.prev.Kind == Renamed:
+		oldPath = from.prev.OldPath
+	}
+	delete(b.changes, oldPath)
+	if oldPath == to {
+		b.modify(to)
+		return
+	}
+	b.set(to, &Change{Kind: Renamed, Path: to, OldPath: oldPath})
+}
+
+// flush returns the changes in the order their paths first changed.
+func (b *batch) flush() []Change {
+	var changes []Change
+	for _, p := range b.order {
+		if c, ok := b.changes[p]; ok {
+			changes = append(changes, *c)
+			delete(b.changes, p)
+		}
+	}
+	b.order = nil
+	b.changes = map[string]*Change{}
+	return changes
+}
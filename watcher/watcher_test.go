@@ -0,0 +1,114 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func newTestWatcher(t *testing.T) (string, <-chan []Change) {
+	t.Helper()
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "node_modules", "dep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(root, "old.txt"), []byte("old"), 0644)
+
+	batches := make(chan []Change, 16)
+	w, err := New(root, Config{Debounce: 50 * time.Millisecond, Ignore: []string{"node_modules", "*.swp"}}, func(c []Change) {
+		batches <- c
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return root, batches
+}
+
+func next(t *testing.T, batches <-chan []Change) []Change {
+	t.Helper()
+	select {
+	case c := <-batches:
+		return c
+	case <-time.After(5 * time.Second):
+		t.Fatal("no changes reported")
+		return nil
+	}
+}
+
+func TestWatcherReportsChanges(t *testing.T) {
+	root, batches := newTestWatcher(t)
+
+	// Writes in quick succession are debounced into one batch, and files
+	// in a new directory are found even if they beat its watch.
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("1"), 0644)
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("2"), 0644)
+	os.MkdirAll(filepath.Join(root, "src", "lib"), 0755)
+	os.WriteFile(filepath.Join(root, "src", "lib", "b.js"), nil, 0644)
+	os.WriteFile(filepath.Join(root, "node_modules", "dep", "index.js"), nil, 0644)
+	os.WriteFile(filepath.Join(root, ".a.txt.swp"), nil, 0644)
+	os.WriteFile(filepath.Join(root, "old.txt"), []byte("new"), 0644)
+	os.WriteFile(filepath.Join(root, "tmp"), nil, 0644)
+	os.Remove(filepath.Join(root, "tmp"))
+
+	got := map[string]Change{}
+	for _, c := range next(t, batches) {
+		got[c.Path] = c
+	}
+	want := map[string]Change{
+		"a.txt":        {Kind: Created, Path: "a.txt"},
+		"src":          {Kind: Created, Path: "src"},
+		"src/lib":      {Kind: Created, Path: "src/lib"},
+		"src/lib/b.js": {Kind: Created, Path: "src/lib/b.js"},
+		"old.txt":      {Kind: Modified, Path: "old.txt"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	os.Rename(filepath.Join(root, "old.txt"), filepath.Join(root, "src", "renamed.txt"))
+	if got := next(t, batches); !reflect.DeepEqual(got, []Change{{Kind: Renamed, Path: "src/renamed.txt", OldPath: "old.txt"}}) {
+		t.Errorf("expected a rename, got %+v", got)
+	}
+
+	os.RemoveAll(filepath.Join(root, "src", "lib"))
+	got = map[string]Change{}
+	for _, c := range next(t, batches) {
+		got[c.Path] = c
+	}
+	if got["src/lib"].Kind != Deleted || got["src/lib/b.js"].Kind != Deleted {
+		t.Errorf("expected the directory and its file to be deleted, got %+v", got)
+	}
+}
+
+func TestBatchCoalesces(t *testing.T) {
+	b := newBatch()
+	b.create("a")
+	b.modify("a")
+	b.modify("b")
+	b.remove("b")
+	b.remove("c")
+	b.create("c")
+
+	// d is renamed twice: d -> e -> f.
+	b.remove("d")
+	b.rename(&renameSource{path: "d"}, "e")
+	prev := b.changes["e"]
+	b.remove("e")
+	b.rename(&renameSource{path: "e", prev: prev}, "f")
+
+	want := []Change{
+		{Kind: Created, Path: "a"},
+		{Kind: Deleted, Path: "b"},
+		{Kind: Modified, Path: "c"},
+		{Kind: Renamed, Path: "f", OldPath: "d"},
+	}
+	if got := b.flush(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got := b.flush(); len(got) != 0 {
+		t.Errorf("expected flush to empty the batch, got %+v", got)
+	}
+}